@@ -8,16 +8,20 @@ import (
 	"os"
 	"time"
 
-	"github.com/Thanus-Kumaar/controller_microservice_v2/db" 
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/culler"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
 	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/warmpool"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/routes"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
-
 	// "google.golang.org/grpc"
 	// "google.golang.org/grpc/credentials/insecure"
 )
@@ -133,34 +137,55 @@ func main() {
 
 	// === Jupyter Gateway Initialization =================================
 
-	jupyterGatewayURL := os.Getenv("JUPYTER_GATEWAY_URL")
-	jupyterAuthToken := os.Getenv("JUPYTER_AUTH_TOKEN")
-
-	jupyterGateway, err := jupyterclient.NewClient(jupyterGatewayURL, jupyterAuthToken)
+	poolConfig, err := jupyterclient.PoolConfigFromEnv()
+	if err != nil {
+		pkg.Logger.Fatal().Err(err).Msg("[CRASH]: Invalid Jupyter gateway configuration")
+		return
+	}
+	jupyterGateway, err := jupyterclient.NewPool(poolConfig, repository.NewKernelGatewayRepository(db.Pool))
 	if err != nil {
-		pkg.Logger.Fatal().Err(err).Msg("[CRASH]: Could not create Jupyter client / connection check failed")
+		pkg.Logger.Fatal().Err(err).Msg("[CRASH]: Could not create Jupyter gateway pool")
 		return
 	}
-	pkg.Logger.Info().Msg("[MSG]: Connection with Jupyter kernel gateway initialized successfully!")
+	jupyterGateway.Start(context.Background())
+	pkg.Logger.Info().Msgf("[MSG]: Jupyter gateway pool initialized with %d gateway(s), strategy = %s",
+		len(poolConfig.Gateways), poolConfig.Strategy)
+
+	// Lifecycle events of sessions and kernels, streamed to the UI
+	eventBus := events.NewBus()
 
-	// Start kernel culler
-	go culler.StartCuller(context.Background(), jupyterGateway)
+	// Start the warm kernel pool and the kernel culler, which must leave the pool's idle kernels alone
+	warmPool := warmpool.New(jupyterGateway, warmpool.ConfigFromEnv())
+	warmPool.Start(context.Background())
+
+	kernelCuller := culler.New(jupyterGateway, repository.NewSessionRepository(db.Pool), culler.ConfigFromEnv())
+	kernelCuller.SkipKernels(warmPool.Owns)
+	kernelCuller.PublishEvents(eventBus)
+	kernelCuller.Start(context.Background())
+
+	// === Blob Storage ====================================================
+
+	blobs, err := blobstore.NewFromEnv()
+	if err != nil {
+		pkg.Logger.Fatal().Err(err).Msg("[CRASH]: Could not initialize blob storage")
+		return
+	}
 
 	// === HTTP Server =====================================================
 
 	mux := http.NewServeMux()
-	routes.RegisterAPIRoutes(mux, jupyterGateway)
+	routes.RegisterAPIRoutes(mux, jupyterGateway, kernelCuller, warmPool, eventBus, blobs, modules.KernelRecoveryConfigFromEnv())
 	loggedMux := middleware.RequestLogger(mux)
 
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   []string{
-			"http://localhost:3000", 
-			"http://localhost:5173", 
+		AllowedOrigins: []string{
+			"http://localhost:3000",
+			"http://localhost:5173",
 			"http://172.17.9.12:3001",
 			"https://172.17.9.12:3001",
 			"http://172.17.9.12:3000",
 			"https://172.17.9.12:3000",
-			},
+		},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
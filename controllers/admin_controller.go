@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/culler"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/warmpool"
+	"github.com/rs/zerolog"
+)
+
+// AdminController exposes the state of the controller's background components to admins.
+type AdminController struct {
+	Culler   *culler.Culler
+	WarmPool *warmpool.Pool
+	Gateways *jupyterclient.Pool
+	Logger   zerolog.Logger
+}
+
+// NewAdminController creates and returns a new AdminController.
+func NewAdminController(kernelCuller *culler.Culler, warmPool *warmpool.Pool, gateways *jupyterclient.Pool, logger zerolog.Logger) *AdminController {
+	return &AdminController{
+		Culler:   kernelCuller,
+		WarmPool: warmPool,
+		Gateways: gateways,
+		Logger:   logger,
+	}
+}
+
+// GetCullerStatsHandler handles GET /api/v1/admin/culler/stats
+func (c *AdminController) GetCullerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	c.writeStatus(w, r, func() any { return c.Culler.Stats() })
+}
+
+// GetWarmPoolStatusHandler handles GET /api/v1/admin/warm-pool
+func (c *AdminController) GetWarmPoolStatusHandler(w http.ResponseWriter, r *http.Request) {
+	c.writeStatus(w, r, func() any { return c.WarmPool.Status() })
+}
+
+// GetGatewayStatusHandler handles GET /api/v1/admin/gateways
+func (c *AdminController) GetGatewayStatusHandler(w http.ResponseWriter, r *http.Request) {
+	c.writeStatus(w, r, func() any { return c.Gateways.Status() })
+}
+
+// writeStatus writes the status returned by status if the user is an admin.
+func (c *AdminController) writeStatus(w http.ResponseWriter, r *http.Request, status func() any) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.IsAdmin() {
+		http.Error(w, "user not authorized", http.StatusForbidden)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, status(), &c.Logger)
+}
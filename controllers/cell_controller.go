@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -387,6 +388,53 @@ func (c *CellController) GetCellOutputsByCellIDHandler(w http.ResponseWriter, r
 	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, outputs, &c.Logger)
 }
 
+// GetCellOutputDataHandler streams one mime type of an output's data, such as "image/png", decoded
+// from the mime bundle or read from blob storage when it was offloaded there.
+func (c *CellController) GetCellOutputDataHandler(w http.ResponseWriter, r *http.Request) {
+	outputIDStr := r.PathValue("output_id")
+	outputID, err := uuid.Parse(outputIDStr)
+	if err != nil {
+		pkg.WriteJSONResponseWithLogger(w, http.StatusBadRequest, map[string]string{"error": "Invalid output ID"}, &c.Logger)
+		return
+	}
+	mime := r.PathValue("mime")
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		c.Logger.Error().Msg("user not found in context for getting cell output data")
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	output, err := c.Module.GetCellOutputByID(r.Context(), outputID, user.ID)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("output_id", outputIDStr).Msg("Output not found or not owned by user")
+		pkg.WriteJSONResponseWithLogger(w, http.StatusNotFound, map[string]string{"error": "Output not found or not owned by user"}, &c.Logger)
+		return
+	}
+
+	data, err := c.Module.OpenCellOutputData(r.Context(), output, mime)
+	if errors.Is(err, modules.ErrOutputDataNotFound) {
+		pkg.WriteJSONResponseWithLogger(w, http.StatusNotFound, map[string]string{"error": err.Error()}, &c.Logger)
+		return
+	}
+	if err != nil {
+		c.Logger.Error().Err(err).Str("output_id", outputIDStr).Str("mime", mime).Msg("Failed to read cell output data")
+		pkg.WriteJSONResponseWithLogger(w, http.StatusInternalServerError, map[string]string{"error": "Failed to read cell output data"}, &c.Logger)
+		return
+	}
+	defer data.Close()
+
+	w.Header().Set("Content-Type", mime)
+	// Outputs are user content, e.g. HTML; keep browsers from running it with the API's origin.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if _, err := io.Copy(w, data); err != nil {
+		c.Logger.Warn().Err(err).Str("output_id", outputIDStr).Msg("Failed to stream cell output data")
+	}
+}
+
 func (c *CellController) DeleteCellOutputHandler(w http.ResponseWriter, r *http.Request) {
 	outputIDStr := r.PathValue("output_id")
 	outputID, err := uuid.Parse(outputIDStr)
@@ -418,4 +466,4 @@ func (c *CellController) DeleteCellOutputHandler(w http.ResponseWriter, r *http.
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
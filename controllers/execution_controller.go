@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// defaultExecutionTimeout bounds a server-side execution when the caller does not ask for a timeout.
+const defaultExecutionTimeout = 10 * time.Minute
+
+// ExecutionController holds the dependencies for the server-side execution handlers.
+type ExecutionController struct {
+	Module *modules.ExecutionModule
+	Logger zerolog.Logger
+}
+
+// NewExecutionController creates and returns a new ExecutionController.
+func NewExecutionController(module *modules.ExecutionModule, logger zerolog.Logger) *ExecutionController {
+	return &ExecutionController{
+		Module: module,
+		Logger: logger,
+	}
+}
+
+// ExecuteCellHandler handles POST /api/v1/sessions/{id}/cells/{cell_id}/execute
+func (c *ExecutionController) ExecuteCellHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID format", http.StatusBadRequest)
+		return
+	}
+	cellID, err := uuid.Parse(r.PathValue("cell_id"))
+	if err != nil {
+		http.Error(w, "invalid cell ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.ExecuteCellRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	timeout := defaultExecutionTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	result, err := c.Module.ExecuteCell(ctx, sessionID, cellID, user.ID, req.Inputs)
+	if err != nil {
+		c.Logger.Error().Err(err).
+			Str("session_id", sessionID.String()).
+			Str("cell_id", cellID.String()).
+			Msg("failed to execute cell")
+		c.writeExecutionError(w, err)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, result, &c.Logger)
+}
+
+// writeExecutionError maps execution errors onto HTTP status codes.
+func (c *ExecutionController) writeExecutionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "session not found", http.StatusNotFound)
+	case errors.Is(err, modules.ErrCellNotInSession), errors.Is(err, modules.ErrNotCodeCell):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, modules.ErrInputRequired):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "execution timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, modules.ErrCellNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "failed to execute cell", http.StatusBadGateway)
+	}
+}
+
+// defaultRunTimeout bounds a whole notebook run, which keeps going after the client disconnects.
+const defaultRunTimeout = 2 * time.Hour
+
+// RunNotebookHandler handles POST /api/v1/sessions/{id}/run and streams progress as Server-Sent Events.
+func (c *ExecutionController) RunNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RunNotebookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := c.Module.PlanRun(r.Context(), sessionID, user.ID, &req)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to plan notebook run")
+		if errors.Is(err, modules.ErrInvalidRunRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.writeExecutionError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The run is detached from the request so it completes even if the client goes away;
+	// events are only delivered while the client is still listening.
+	events := make(chan models.RunEvent, 16)
+	clientGone := make(chan struct{})
+	defer close(clientGone)
+
+	go func() {
+		defer close(events)
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRunTimeout)
+		defer cancel()
+
+		emit := func(event models.RunEvent) {
+			select {
+			case events <- event:
+			case <-clientGone:
+			}
+		}
+		if err := c.Module.Run(ctx, plan, emit); err != nil {
+			c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("notebook run failed")
+		}
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			c.Logger.Info().Str("session_id", sessionID.String()).Msg("client disconnected from notebook run stream, run continues")
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSEEvent(w, event.Type, event); err != nil {
+				c.Logger.Error().Err(err).Msg("failed to write run event")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent writes a single named Server-Sent Event with a JSON payload.
+func writeSSEEvent(w io.Writer, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// ListCellExecutionsHandler handles GET /api/v1/cells/{cell_id}/executions
+func (c *ExecutionController) ListCellExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	cellID, err := uuid.Parse(r.PathValue("cell_id"))
+	if err != nil {
+		http.Error(w, "invalid cell ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	executions, err := c.Module.ListCellExecutions(ctx, cellID, user.ID, user.IsAdmin())
+	if err != nil {
+		c.Logger.Error().Err(err).Str("cell_id", cellID.String()).Msg("failed to list cell executions")
+		if errors.Is(err, modules.ErrCellNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to retrieve executions", http.StatusInternalServerError)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, executions, &c.Logger)
+}
+
+// ListExecutionMessagesHandler handles GET /api/v1/executions/{execution_id}/messages
+func (c *ExecutionController) ListExecutionMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	executionID, err := uuid.Parse(r.PathValue("execution_id"))
+	if err != nil {
+		http.Error(w, "invalid execution ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	messages, err := c.Module.ListExecutionMessages(ctx, executionID, user.ID, user.IsAdmin())
+	if err != nil {
+		c.Logger.Error().Err(err).Str("execution_id", executionID.String()).Msg("failed to list execution messages")
+		if errors.Is(err, modules.ErrExecutionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to retrieve execution messages", http.StatusInternalServerError)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, messages, &c.Logger)
+}
+
+// ListNotebookExecutionsHandler handles GET /api/v1/notebooks/{id}/executions
+func (c *ExecutionController) ListNotebookExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	notebookID := r.PathValue("id")
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	executions, err := c.Module.ListNotebookExecutions(ctx, notebookID, user.ID, user.IsAdmin())
+	if err != nil {
+		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("failed to list notebook executions")
+		switch {
+		case errors.Is(err, modules.ErrInvalidNotebookID):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, modules.ErrNotebookNotFound):
+			http.Error(w, "notebook not found", http.StatusNotFound)
+		default:
+			http.Error(w, "failed to retrieve executions", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, executions, &c.Logger)
+}
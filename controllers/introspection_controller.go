@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// introspectionTimeout bounds a completion or inspection; a kernel busy running a cell answers only
+// once the cell is done, and an editor has no use for a late answer.
+const introspectionTimeout = 5 * time.Second
+
+// variablesTimeout bounds a variable explorer request, which has to describe every user variable.
+const variablesTimeout = 15 * time.Second
+
+// IntrospectionController holds the dependencies for the code completion, inspection and variable explorer handlers.
+type IntrospectionController struct {
+	Module *modules.IntrospectionModule
+	Logger zerolog.Logger
+}
+
+// NewIntrospectionController creates and returns a new IntrospectionController.
+func NewIntrospectionController(module *modules.IntrospectionModule, logger zerolog.Logger) *IntrospectionController {
+	return &IntrospectionController{
+		Module: module,
+		Logger: logger,
+	}
+}
+
+// CompleteHandler handles POST /api/v1/sessions/{id}/complete
+func (c *IntrospectionController) CompleteHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, user, ok := c.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), introspectionTimeout)
+	defer cancel()
+
+	reply, err := c.Module.Complete(ctx, sessionID, user.ID, &req)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to complete code")
+		c.writeIntrospectionError(w, err)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, reply, &c.Logger)
+}
+
+// InspectHandler handles POST /api/v1/sessions/{id}/inspect
+func (c *IntrospectionController) InspectHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, user, ok := c.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.InspectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), introspectionTimeout)
+	defer cancel()
+
+	reply, err := c.Module.Inspect(ctx, sessionID, user.ID, &req)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to inspect code")
+		c.writeIntrospectionError(w, err)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, reply, &c.Logger)
+}
+
+// ListVariablesHandler handles GET /api/v1/sessions/{id}/variables
+func (c *IntrospectionController) ListVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, user, ok := c.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), variablesTimeout)
+	defer cancel()
+
+	variables, err := c.Module.ListVariables(ctx, sessionID, user.ID)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to list kernel variables")
+		c.writeIntrospectionError(w, err)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, variables, &c.Logger)
+}
+
+// GetVariableHandler handles GET /api/v1/sessions/{id}/variables/{name}?offset=&limit=
+func (c *IntrospectionController) GetVariableHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, user, ok := c.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	offset, limit := 0, 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), variablesTimeout)
+	defer cancel()
+
+	preview, err := c.Module.PreviewVariable(ctx, sessionID, user.ID, r.PathValue("name"), offset, limit)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to preview kernel variable")
+		c.writeIntrospectionError(w, err)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, preview, &c.Logger)
+}
+
+// parseRequest reads the session ID and the authenticated user, writing the error response when either is missing.
+func (c *IntrospectionController) parseRequest(w http.ResponseWriter, r *http.Request) (uuid.UUID, *middleware.User, bool) {
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID format", http.StatusBadRequest)
+		return uuid.Nil, nil, false
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return uuid.Nil, nil, false
+	}
+	return sessionID, user, true
+}
+
+// writeIntrospectionError maps introspection errors onto HTTP status codes.
+func (c *IntrospectionController) writeIntrospectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, "session not found", http.StatusNotFound)
+	case errors.Is(err, modules.ErrVariableNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, modules.ErrInvalidCursorPos), errors.Is(err, modules.ErrInvalidVariableName), errors.Is(err, modules.ErrUnsupportedKernel):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "kernel did not reply in time", http.StatusGatewayTimeout)
+	case errors.Is(err, jupyterclient.ErrConnectionClosed):
+		http.Error(w, "kernel connection closed", http.StatusBadGateway)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -3,47 +3,110 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
-	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	kernelhub "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/kernel_hub"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all connections for now. In production, you'd want to restrict this.
-		return true
-	},
+// newUpgrader builds the websocket upgrader for the kernel channels proxy.
+// With no allowed origins only same-origin requests are accepted; "*" allows any origin.
+func newUpgrader(allowedOrigins []string) websocket.Upgrader {
+	origins := make(map[string]struct{}, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins[strings.ToLower(origin)] = struct{}{}
+		}
+	}
+
+	// Frontends that offer the v1 kernel protocol get it, so binary buffers reach them unchanged.
+	upgrader := websocket.Upgrader{Subprotocols: []string{jupyterclient.ProtocolV1}}
+	if len(origins) == 0 {
+		// A nil CheckOrigin makes gorilla reject cross-origin requests.
+		return upgrader
+	}
+	if _, ok := origins["*"]; ok {
+		upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+		return upgrader
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Non-browser clients do not send an Origin header.
+			return true
+		}
+		_, ok := origins[strings.ToLower(origin)]
+		return ok
+	}
+	return upgrader
 }
 
 // KernelController holds the dependencies required by the handlers.
 type KernelController struct {
-	JupyterClient  *jupyterclient.Client
-	Logger         zerolog.Logger
-	CellRepo       repository.CellRepository
-	msgIDCellIDMap map[string]uuid.UUID
-	mapMutex       sync.RWMutex
+	JupyterClient jupyterclient.Gateway
+	Logger        zerolog.Logger
+	Hubs          *kernelhub.Manager
+	SessionModule *modules.SessionModule
+	upgrader      websocket.Upgrader
 }
 
 // NewKernelController creates and returns a new KernelController instance.
-func NewKernelController(client *jupyterclient.Client, logger zerolog.Logger, cellRepo repository.CellRepository) *KernelController {
+// allowedOrigins is the allow-list of browser origins for the websocket proxy.
+func NewKernelController(
+	client jupyterclient.Gateway,
+	logger zerolog.Logger,
+	hubs *kernelhub.Manager,
+	sessionModule *modules.SessionModule,
+	allowedOrigins []string,
+) *KernelController {
 	return &KernelController{
-		JupyterClient:  client,
-		Logger:         logger,
-		CellRepo:       cellRepo,
-		msgIDCellIDMap: make(map[string]uuid.UUID),
+		JupyterClient: client,
+		Logger:        logger,
+		Hubs:          hubs,
+		SessionModule: sessionModule,
+		upgrader:      newUpgrader(allowedOrigins),
 	}
 }
 
+// authorizeKernel writes an error response and returns false unless the user in the request
+// context owns a session running the kernel or is an admin.
+func (c *KernelController) authorizeKernel(w http.ResponseWriter, r *http.Request, kernelID string) bool {
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if user.IsAdmin() {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := c.SessionModule.AuthorizeKernel(ctx, kernelID, user.ID); err != nil {
+		if errors.Is(err, modules.ErrKernelAccessDenied) {
+			c.Logger.Warn().Str("kernel_id", kernelID).Str("userID", user.ID).Msg("denied access to kernel")
+			http.Error(w, "user not authorized to access this kernel", http.StatusForbidden)
+			return false
+		}
+		c.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("failed to authorize kernel access")
+		http.Error(w, "failed to authorize kernel access", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
 // writeJSONResponse is a helper function to format and write a JSON response.
 func writeJSONResponse(w http.ResponseWriter, statusCode int, data any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -60,17 +123,72 @@ func (c *KernelController) ListKernelsHandler(w http.ResponseWriter, r *http.Req
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	kernels, err := c.JupyterClient.GetKernels(ctx)
 	if err != nil {
 		c.Logger.Error().Err(err).Msg("Failed to retrieve running kernels list")
 		http.Error(w, "Error retrieving kernel list", http.StatusInternalServerError)
 		return
 	}
-	writeJSONResponse(w, http.StatusOK, kernels)
+	if user.IsAdmin() {
+		writeJSONResponse(w, http.StatusOK, kernels)
+		return
+	}
+
+	userID, err := uuid.Parse(user.ID)
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	owned, err := c.SessionModule.ListKernelIDs(ctx, userID)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("userID", user.ID).Msg("Failed to list the user's session kernels")
+		http.Error(w, "Error retrieving kernel list", http.StatusInternalServerError)
+		return
+	}
+
+	visible := make([]jupyterclient.Kernel, 0, len(owned))
+	for _, kernel := range *kernels {
+		if _, ok := owned[kernel.ID]; ok {
+			visible = append(visible, kernel)
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, visible)
+}
+
+// ListKernelSpecsHandler handles GET /api/v1/kernelspecs to list the kernelspecs offered by the gateway.
+func (c *KernelController) ListKernelSpecsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	specs, err := c.JupyterClient.GetKernelSpecs(ctx)
+	if err != nil {
+		c.Logger.Error().Err(err).Msg("Failed to retrieve kernelspecs")
+		http.Error(w, "Error retrieving kernelspecs", http.StatusBadGateway)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, specs)
 }
 
 // StartKernelHandler handles POST /api/v1/kernels to start a new kernel.
+// Kernels started here are not attached to a session, so only admins may use it;
+// users get kernels by creating sessions.
 func (c *KernelController) StartKernelHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.IsAdmin() {
+		http.Error(w, "only admins can start standalone kernels", http.StatusForbidden)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -86,7 +204,7 @@ func (c *KernelController) StartKernelHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	kernel, err := c.JupyterClient.StartKernel(ctx, reqBody.Language)
+	kernel, err := c.JupyterClient.StartKernel(ctx, reqBody.Language, nil)
 	if err != nil {
 		c.Logger.Error().Err(err).Str("language", reqBody.Language).Msg("Failed to start kernel")
 		http.Error(w, fmt.Sprintf("Error starting kernel: %v", err), http.StatusInternalServerError)
@@ -99,6 +217,9 @@ func (c *KernelController) StartKernelHandler(w http.ResponseWriter, r *http.Req
 // GetKernelInfoHandler handles GET /api/v1/kernels/{id} to get info on a single kernel.
 func (c *KernelController) GetKernelInfoHandler(w http.ResponseWriter, r *http.Request) {
 	kernelID := r.PathValue("id")
+	if !c.authorizeKernel(w, r, kernelID) {
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -114,6 +235,9 @@ func (c *KernelController) GetKernelInfoHandler(w http.ResponseWriter, r *http.R
 // DeleteKernelHandler handles DELETE /api/v1/kernels/{id} to delete a kernel.
 func (c *KernelController) DeleteKernelHandler(w http.ResponseWriter, r *http.Request) {
 	kernelID := r.PathValue("id")
+	if !c.authorizeKernel(w, r, kernelID) {
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -129,6 +253,9 @@ func (c *KernelController) DeleteKernelHandler(w http.ResponseWriter, r *http.Re
 // InterruptKernelHandler handles POST /api/v1/kernels/{id}/interrupt.
 func (c *KernelController) InterruptKernelHandler(w http.ResponseWriter, r *http.Request) {
 	kernelID := r.PathValue("id")
+	if !c.authorizeKernel(w, r, kernelID) {
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -143,6 +270,9 @@ func (c *KernelController) InterruptKernelHandler(w http.ResponseWriter, r *http
 // RestartKernelHandler handles POST /api/v1/kernels/{id}/restart.
 func (c *KernelController) RestartKernelHandler(w http.ResponseWriter, r *http.Request) {
 	kernelID := r.PathValue("id")
+	if !c.authorizeKernel(w, r, kernelID) {
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -152,213 +282,33 @@ func (c *KernelController) RestartKernelHandler(w http.ResponseWriter, r *http.R
 		http.Error(w, "Error restarting kernel", http.StatusInternalServerError)
 		return
 	}
+	c.SessionModule.KernelRestarted(ctx, kernelID, "user")
 	writeJSONResponse(w, http.StatusOK, info)
 }
 
 // KernelChannelsHandler handles GET /api/v1/kernels/{id}/channels
+// Every frontend of a kernel shares one gateway connection through the kernel hub.
 func (c *KernelController) KernelChannelsHandler(w http.ResponseWriter, r *http.Request) {
 	kernelID := r.PathValue("id")
+	if !c.authorizeKernel(w, r, kernelID) {
+		return
+	}
 
 	// Upgrade the client's connection
-	feConn, err := upgrader.Upgrade(w, r, nil)
+	feConn, err := c.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		c.Logger.Error().Err(err).Msg("failed to upgrade connection")
 		return
 	}
 	defer feConn.Close()
 
-	// Get the Jupyter Gateway URL and token from the client
-	gatewayURL := c.JupyterClient.GetGatewayURL()
-	gatewayToken := c.JupyterClient.GetAuthToken()
-
-	// Construct the target websocket URL
-	wsURL := "ws" + strings.TrimPrefix(gatewayURL, "http")
-	targetURL := fmt.Sprintf("%s/api/kernels/%s/channels", wsURL, kernelID)
-
-	// Create the request headers, including the auth token
-	headers := http.Header{}
-	headers.Set("Authorization", "token "+gatewayToken)
-
-	c.Logger.Debug().Str("targetURL", targetURL).Interface("headers", headers).Msg("Attempting to dial kernel gateway")
-	// Connect to the Jupyter Kernel Gateway
-	kgConn, _, err := websocket.DefaultDialer.Dial(targetURL, headers)
-	if err != nil {
-		c.Logger.Error().Err(err).Str("target", targetURL).Msg("failed to dial kernel gateway")
+	c.Logger.Info().Str("kernel_id", kernelID).Msg("websocket proxy established")
+	if err := c.Hubs.Serve(r.Context(), kernelID, feConn); err != nil {
+		c.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("failed to dial kernel gateway")
 		if writeErr := feConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "could not connect to kernel")); writeErr != nil {
 			c.Logger.Error().Err(writeErr).Msg("failed to write close message to frontend")
 		}
 		return
 	}
-	defer kgConn.Close()
-
-	c.Logger.Info().Str("kernel_id", kernelID).Msg("websocket proxy established")
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Goroutine to proxy messages from Frontend to Kernel Gateway
-	go func() {
-		defer wg.Done()
-		for {
-			messageType, p, err := feConn.ReadMessage()
-			if err != nil {
-				if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-					c.Logger.Warn().Err(err).Msg("error reading from frontend, closing proxy")
-				}
-				kgConn.Close()
-				return
-			}
-
-			// If it's an execute_request, store the msg_id -> cell_id mapping
-			var msg jupyterclient.Message
-			if err := json.Unmarshal(p, &msg); err == nil {
-				c.Logger.Debug().Str("msg_type", msg.Header.MsgType).Msg("Received message from frontend")
-				if msg.Header.MsgType == "execute_request" {
-					c.Logger.Debug().RawJSON("execute_request_metadata", msg.Metadata).Msg("Inspecting metadata for execute_request")
-					var metadata map[string]any
-					if err := json.Unmarshal(msg.Metadata, &metadata); err == nil {
-						if cellIDStr, ok := metadata["cell_id"].(string); ok {
-							if cellID, err := uuid.Parse(cellIDStr); err == nil {
-								c.mapMutex.Lock()
-								c.msgIDCellIDMap[msg.Header.MsgID] = cellID
-								c.mapMutex.Unlock()
-								c.Logger.Debug().Str("msg_id", msg.Header.MsgID).Str("cell_id", cellID.String()).Msg("Stored msg_id to cell_id mapping")
-							} else {
-								c.Logger.Warn().Err(err).Str("cell_id_str", cellIDStr).Msg("Failed to parse cell_id from metadata")
-							}
-						} else {
-							c.Logger.Warn().Interface("metadata", metadata).Msg("cell_id not found in execute_request metadata or is not a string")
-						}
-					} else {
-						c.Logger.Warn().Err(err).Msg("Failed to unmarshal execute_request metadata")
-					}
-				}
-			}
-
-			c.Logger.Trace().Str("direction", "FE->KG").Int("size", len(p)).Bytes("raw_message", p).Msg("proxied message")
-			if err := kgConn.WriteMessage(messageType, p); err != nil {
-				c.Logger.Warn().Err(err).Msg("error writing to kernel gateway, closing proxy")
-				return
-			}
-		}
-	}()
-
-	// Goroutine to proxy messages from Kernel Gateway to Frontend
-	go func() {
-		defer wg.Done()
-		for {
-			messageType, p, err := kgConn.ReadMessage()
-			if err != nil {
-				if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-					c.Logger.Warn().Err(err).Msg("error reading from kernel gateway, closing proxy")
-				}
-				// Closing the frontend connection will cause the other goroutine's ReadMessage to error out.
-				feConn.Close()
-				return
-			}
-
-			// Asynchronously save the output
-			go c.saveCellOutput(p)
-
-			if err := feConn.WriteMessage(messageType, p); err != nil {
-				c.Logger.Warn().Err(err).Msg("error writing to frontend, closing proxy")
-				return
-			}
-			c.Logger.Trace().Str("direction", "KG->FE").Int("size", len(p)).Msg("proxied message")
-		}
-	}()
-
-	// Wait for both goroutines to finish
-	wg.Wait()
 	c.Logger.Info().Str("kernel_id", kernelID).Msg("websocket proxy closed")
 }
-
-func (c *KernelController) saveCellOutput(p []byte) {
-	c.Logger.Debug().RawJSON("raw_kernel_message", p).Msg("Attempting to save cell output")
-
-	var msg jupyterclient.Message
-	if err := json.Unmarshal(p, &msg); err != nil {
-		c.Logger.Error().Err(err).Msg("failed to unmarshal jupyter message")
-		return
-	}
-
-	c.Logger.Debug().Interface("parsed_message", msg).Msg("Successfully parsed Jupyter message")
-
-	outputTypes := map[string]struct{}{
-		"stream":         {},
-		"display_data":   {},
-		"execute_result": {},
-		"error":          {},
-		"execute_reply":  {},
-	}
-	if _, ok := outputTypes[msg.Header.MsgType]; !ok {
-		c.Logger.Trace().Str("msg_type", msg.Header.MsgType).Msg("Ignoring message type for output saving")
-		return
-	}
-
-	c.mapMutex.RLock()
-	cellID, ok := c.msgIDCellIDMap[msg.ParentHeader.MsgID]
-	c.mapMutex.RUnlock()
-
-	if !ok {
-		c.Logger.Warn().Str("parent_msg_id", msg.ParentHeader.MsgID).Msg("cell_id not found for parent message")
-		return
-	}
-	c.Logger.Debug().Str("cell_id", cellID.String()).Str("parent_msg_id", msg.ParentHeader.MsgID).Msg("Found cell_id for parent message")
-
-	// Clean up the map when the execution is done
-	if msg.Header.MsgType == "execute_reply" {
-		c.Logger.Info().Str("parent_msg_id", msg.ParentHeader.MsgID).Msg("Execution reply received, cleaning up cell_id map")
-		c.mapMutex.Lock()
-		delete(c.msgIDCellIDMap, msg.ParentHeader.MsgID)
-		c.mapMutex.Unlock()
-		return // No need to save execute_reply as an output
-	}
-
-	var outputData models.CellOutput
-	outputData.ID = uuid.New()
-	outputData.CellID = models.StringUUID(cellID)
-	outputData.Type = msg.Header.MsgType
-
-	switch msg.Header.MsgType {
-	case "stream":
-		var content jupyterclient.StreamContent
-		if err := json.Unmarshal(msg.Content, &content); err == nil {
-			outputData.DataJSON, _ = json.Marshal(content)
-		}
-	case "display_data":
-		var content jupyterclient.DisplayDataContent
-		if err := json.Unmarshal(msg.Content, &content); err == nil {
-			outputData.DataJSON, _ = json.Marshal(content.Data)
-		}
-	case "execute_result":
-		var content jupyterclient.ExecuteResultContent
-		if err := json.Unmarshal(msg.Content, &content); err == nil {
-			outputData.ExecutionCount = content.ExecutionCount
-			outputData.DataJSON, _ = json.Marshal(content.Data)
-		}
-	case "error":
-		var content jupyterclient.ErrorContent
-		if err := json.Unmarshal(msg.Content, &content); err == nil {
-			outputData.DataJSON, _ = json.Marshal(content)
-		}
-	}
-
-	outputs, err := c.CellRepo.GetCellOutputsByCellID(context.Background(), cellID)
-	if err != nil {
-		c.Logger.Error().Err(err).Msg("failed to get cell outputs to determine next index")
-		// Continue anyway, index might be 0
-	}
-	outputData.OutputIndex = len(outputs)
-	
-	c.Logger.Debug().Interface("output_data", outputData).Msg("Constructed output data object")
-
-
-	if _, err := c.CellRepo.CreateCellOutput(context.Background(), &outputData); err != nil {
-		c.Logger.Error().Err(err).Msg("failed to save cell output")
-	} else {
-		c.Logger.Info().Str("cell_id", cellID.String()).Str("type", outputData.Type).Msg("successfully saved cell output")
-	}
-}
-
-
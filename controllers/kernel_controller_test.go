@@ -0,0 +1,311 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/controllers"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	kernelhub "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/kernel_hub"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// memorySessionRepo holds the sessions the kernel authorization checks read.
+type memorySessionRepo struct {
+	repository.SessionRepository
+
+	mu       sync.Mutex
+	sessions []models.Session
+}
+
+func (r *memorySessionRepo) add(userID uuid.UUID, kernelID string) models.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session := models.Session{
+		ID:              uuid.New(),
+		UserID:          userID,
+		NotebookID:      uuid.New(),
+		CurrentKernelID: uuid.MustParse(kernelID),
+		Status:          models.SessionStatusActive,
+	}
+	r.sessions = append(r.sessions, session)
+	return session
+}
+
+func (r *memorySessionRepo) MarkKernelRestarted(ctx context.Context, kernelID uuid.UUID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.sessions {
+		if r.sessions[i].CurrentKernelID == kernelID {
+			r.sessions[i].KernelStartedAt = at
+		}
+	}
+	return nil
+}
+
+func (r *memorySessionRepo) GetSessionByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.ID == id && session.UserID == userID {
+			return &session, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (r *memorySessionRepo) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []models.Session
+	for _, session := range r.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *memorySessionRepo) GetKernelOwnerID(ctx context.Context, kernelID uuid.UUID) (uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.CurrentKernelID == kernelID {
+			return session.UserID, nil
+		}
+	}
+	return uuid.Nil, pgx.ErrNoRows
+}
+
+type kernelControllerFixture struct {
+	gateway *fakegateway.Server
+	server  *httptest.Server
+	repo    *memorySessionRepo
+}
+
+// newKernelControllerFixture serves the kernel routes with the caller taken from the X-User-ID and
+// X-User-Role headers instead of the auth service.
+func newKernelControllerFixture(t *testing.T) *kernelControllerFixture {
+	t.Helper()
+	logger := zerolog.Nop()
+	pkg.Logger = &logger
+
+	gateway := fakegateway.New("")
+	t.Cleanup(gateway.Close)
+	client := gateway.Client()
+
+	repo := &memorySessionRepo{}
+	sessionModule := modules.NewSessionModule(repo, client, logger, nil)
+	controller := controllers.NewKernelController(client, logger, kernelhub.NewManager(client, nil, logger), sessionModule, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/kernels", controller.ListKernelsHandler)
+	mux.HandleFunc("POST /api/v1/kernels", controller.StartKernelHandler)
+	mux.HandleFunc("GET /api/v1/kernels/{id}", controller.GetKernelInfoHandler)
+	mux.HandleFunc("DELETE /api/v1/kernels/{id}", controller.DeleteKernelHandler)
+	mux.HandleFunc("POST /api/v1/kernels/{id}/interrupt", controller.InterruptKernelHandler)
+	mux.HandleFunc("POST /api/v1/kernels/{id}/restart", controller.RestartKernelHandler)
+	mux.HandleFunc("GET /api/v1/kernels/{id}/channels", controller.KernelChannelsHandler)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := &middleware.User{ID: r.Header.Get("X-User-ID"), Role: r.Header.Get("X-User-Role")}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, user)))
+	}))
+	t.Cleanup(server.Close)
+
+	return &kernelControllerFixture{gateway: gateway, server: server, repo: repo}
+}
+
+// sessionKernel starts a kernel on the gateway and attaches it to a session of the user.
+func (f *kernelControllerFixture) sessionKernel(userID uuid.UUID) string {
+	kernel := f.gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	f.repo.add(userID, kernel.ID)
+	return kernel.ID
+}
+
+func (f *kernelControllerFixture) do(t *testing.T, method, path string, userID uuid.UUID, role string, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-User-ID", userID.String())
+	req.Header.Set("X-User-Role", role)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (f *kernelControllerFixture) dial(t *testing.T, kernelID string, userID uuid.UUID, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	header := http.Header{"X-User-ID": {userID.String()}}
+	url := "ws" + strings.TrimPrefix(f.server.URL, "http") + "/api/v1/kernels/" + kernelID + "/channels"
+	conn, _, err := dialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial kernel channels: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestKernelControllerREST(t *testing.T) {
+	f := newKernelControllerFixture(t)
+	owner, other := uuid.New(), uuid.New()
+	kernelID := f.sessionKernel(owner)
+	f.sessionKernel(other)
+
+	t.Run("users only list the kernels of their sessions", func(t *testing.T) {
+		var kernels []jupyterclient.Kernel
+		resp := f.do(t, http.MethodGet, "/api/v1/kernels", owner, "", "")
+		if err := json.NewDecoder(resp.Body).Decode(&kernels); err != nil {
+			t.Fatal(err)
+		}
+		if len(kernels) != 1 || kernels[0].ID != kernelID {
+			t.Errorf("owner sees %v, want only %s", kernels, kernelID)
+		}
+
+		resp = f.do(t, http.MethodGet, "/api/v1/kernels", owner, middleware.RoleAdmin, "")
+		if err := json.NewDecoder(resp.Body).Decode(&kernels); err != nil {
+			t.Fatal(err)
+		}
+		if len(kernels) != 2 {
+			t.Errorf("admin sees %d kernels, want 2", len(kernels))
+		}
+	})
+
+	t.Run("kernel actions require owning the kernel", func(t *testing.T) {
+		if resp := f.do(t, http.MethodGet, "/api/v1/kernels/"+kernelID, other, "", ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("other user: status %d, want 403", resp.StatusCode)
+		}
+		if resp := f.do(t, http.MethodGet, "/api/v1/kernels/"+kernelID, owner, "", ""); resp.StatusCode != http.StatusOK {
+			t.Errorf("owner: status %d, want 200", resp.StatusCode)
+		}
+		if resp := f.do(t, http.MethodPost, "/api/v1/kernels/"+kernelID+"/interrupt", other, "", ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("other user interrupt: status %d, want 403", resp.StatusCode)
+		}
+		if f.gateway.Interrupts(kernelID) != 0 {
+			t.Error("kernel was interrupted for another user")
+		}
+	})
+
+	t.Run("owners interrupt, restart and delete their kernels", func(t *testing.T) {
+		if resp := f.do(t, http.MethodPost, "/api/v1/kernels/"+kernelID+"/interrupt", owner, "", ""); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("interrupt: status %d, want 204", resp.StatusCode)
+		}
+		if f.gateway.Interrupts(kernelID) != 1 {
+			t.Errorf("gateway saw %d interrupts, want 1", f.gateway.Interrupts(kernelID))
+		}
+		if resp := f.do(t, http.MethodPost, "/api/v1/kernels/"+kernelID+"/restart", owner, "", ""); resp.StatusCode != http.StatusOK {
+			t.Errorf("restart: status %d, want 200", resp.StatusCode)
+		}
+		if resp := f.do(t, http.MethodDelete, "/api/v1/kernels/"+kernelID, owner, "", ""); resp.StatusCode != http.StatusNoContent {
+			t.Errorf("delete: status %d, want 204", resp.StatusCode)
+		}
+		if _, ok := f.gateway.Kernel(kernelID); ok {
+			t.Error("kernel still running after delete")
+		}
+	})
+
+	t.Run("only admins start standalone kernels", func(t *testing.T) {
+		body := `{"language":"` + fakegateway.DefaultKernelSpec + `"}`
+		if resp := f.do(t, http.MethodPost, "/api/v1/kernels", owner, "", body); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("user: status %d, want 403", resp.StatusCode)
+		}
+		if resp := f.do(t, http.MethodPost, "/api/v1/kernels", owner, middleware.RoleAdmin, body); resp.StatusCode != http.StatusCreated {
+			t.Errorf("admin: status %d, want 201", resp.StatusCode)
+		}
+	})
+}
+
+func TestKernelControllerChannels(t *testing.T) {
+	for _, protocol := range []string{jupyterclient.ProtocolLegacy, jupyterclient.ProtocolV1} {
+		name := protocol
+		if name == "" {
+			name = "legacy"
+		}
+		t.Run(name, func(t *testing.T) {
+			f := newKernelControllerFixture(t)
+			owner := uuid.New()
+			kernelID := f.sessionKernel(owner)
+			f.gateway.OnExecute("print('hello')", fakegateway.Reply{
+				Outputs: []fakegateway.Output{fakegateway.Stream("stdout", "hello\n")},
+			})
+
+			var subprotocols []string
+			if protocol != "" {
+				subprotocols = []string{protocol}
+			}
+			conn := f.dial(t, kernelID, owner, subprotocols...)
+			if conn.Subprotocol() != protocol {
+				t.Fatalf("negotiated protocol %q, want %q", conn.Subprotocol(), protocol)
+			}
+
+			request, err := jupyterclient.NewMessage(jupyterclient.ChannelShell, "execute_request", uuid.NewString(), jupyterclient.ExecuteRequestContent{
+				Code:         "print('hello')",
+				StoreHistory: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			messageType, data, err := jupyterclient.EncodeMessage(protocol, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout string
+			var reply *jupyterclient.ExecuteReplyContent
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for reply == nil {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("reading kernel messages: %v", err)
+				}
+				msg, err := jupyterclient.DecodeMessage(protocol, messageType, data)
+				if err != nil {
+					t.Fatalf("decoding kernel message: %v", err)
+				}
+				if msg.ParentHeader.MsgID != request.Header.MsgID {
+					continue
+				}
+				switch msg.Header.MsgType {
+				case "stream":
+					var content jupyterclient.StreamContent
+					_ = json.Unmarshal(msg.Content, &content)
+					stdout += content.Text
+				case "execute_reply":
+					reply = &jupyterclient.ExecuteReplyContent{}
+					_ = json.Unmarshal(msg.Content, reply)
+				}
+			}
+
+			if reply.Status != "ok" || reply.ExecutionCount != 1 {
+				t.Errorf("execute_reply = %+v, want status ok and execution count 1", reply)
+			}
+			if stdout != "hello\n" {
+				t.Errorf("stdout = %q, want %q", stdout, "hello\n")
+			}
+		})
+	}
+}
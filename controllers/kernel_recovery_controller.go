@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// KernelRecoveryController holds the dependencies for recovering sessions whose kernel died.
+type KernelRecoveryController struct {
+	Module *modules.KernelRecoveryModule
+	Logger zerolog.Logger
+}
+
+// NewKernelRecoveryController creates and returns a new KernelRecoveryController.
+func NewKernelRecoveryController(module *modules.KernelRecoveryModule, logger zerolog.Logger) *KernelRecoveryController {
+	return &KernelRecoveryController{
+		Module: module,
+		Logger: logger,
+	}
+}
+
+// RecoverSessionHandler handles POST /api/v1/sessions/{id}/recover
+func (c *KernelRecoveryController) RecoverSessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RecoverSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Replaying a long history can take as long as running the notebook did.
+	ctx, cancel := context.WithTimeout(r.Context(), defaultExecutionTimeout)
+	defer cancel()
+
+	result, err := c.Module.RecoverSession(ctx, sessionID, user.ID, &req)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", sessionID.String()).Msg("failed to recover session")
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "session not found", http.StatusNotFound)
+		case errors.Is(err, modules.ErrInvalidRecoveryMode):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, modules.ErrRecoveryInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, "recovery timed out", http.StatusGatewayTimeout)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, result, &c.Logger)
+}
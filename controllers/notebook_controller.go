@@ -3,14 +3,20 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg" // Added pkg import
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
@@ -46,6 +52,10 @@ func (c *NotebookController) CreateNotebookHandler(w http.ResponseWriter, r *htt
 	}
 	nb, err := c.NotebookModule.CreateNotebook(ctx, &req, user.ID)
 	if err != nil {
+		if errors.Is(err, modules.ErrInvalidEnvVar) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		c.Logger.Error().Err(err).Msg("failed to create notebook")
 		http.Error(w, fmt.Sprintf("error creating notebook: %v", err), http.StatusInternalServerError)
 		return
@@ -53,6 +63,83 @@ func (c *NotebookController) CreateNotebookHandler(w http.ResponseWriter, r *htt
 	pkg.WriteJSONResponseWithLogger(w, http.StatusCreated, nb, c.Logger)
 }
 
+// maxNotebookImportSize bounds the size of an uploaded notebook file.
+const maxNotebookImportSize = 50 << 20
+
+// ImportNotebookHandler handles POST /api/v1/notebooks/import. It accepts an .ipynb file or a
+// percent-format .py script as the "file" field of a multipart form, with optional "title",
+// "format" ("ipynb" or "py", by default taken from the file name) and "problem_statement_id"
+// fields, or a JSON ImportNotebookRequest.
+func (c *NotebookController) ImportNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	user, ok := ctx.Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		c.Logger.Error().Msg("userID not found in context for notebook import")
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxNotebookImportSize)
+	var req models.ImportNotebookRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxNotebookImportSize); err != nil {
+			http.Error(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "missing notebook file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "failed to read notebook file", http.StatusBadRequest)
+			return
+		}
+		format := r.FormValue("format")
+		if format == "" && strings.HasSuffix(header.Filename, ".py") {
+			format = "py"
+		}
+		switch format {
+		case "", "ipynb":
+			req.Notebook = content
+		case "py":
+			req.Script, req.Format = string(content), format
+		default:
+			http.Error(w, fmt.Sprintf("unsupported import format '%s'", format), http.StatusBadRequest)
+			return
+		}
+		req.Title = r.FormValue("title")
+		if req.Title == "" {
+			req.Title = strings.TrimSuffix(strings.TrimSuffix(header.Filename, ".ipynb"), ".py")
+		}
+		if problemID := r.FormValue("problem_statement_id"); problemID != "" {
+			req.ProblemStatementID = &problemID
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := c.NotebookModule.ImportNotebook(ctx, &req, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, nbformat.ErrInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, modules.ErrProblemNotOwned):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			c.Logger.Error().Err(err).Msg("failed to import notebook")
+			http.Error(w, fmt.Sprintf("error importing notebook: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	pkg.WriteJSONResponseWithLogger(w, http.StatusCreated, result, c.Logger)
+}
+
 // ListNotebooksHandler handles GET /api/v1/notebooks
 func (c *NotebookController) ListNotebooksHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
@@ -112,6 +199,78 @@ func (c *NotebookController) GetNotebookByIDHandler(w http.ResponseWriter, r *ht
 	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, nb, c.Logger)
 }
 
+// ExportNotebookHandler handles GET /api/v1/notebooks/{id}/export?format=ipynb|py, downloading
+// the notebook as a Jupyter notebook file or as a percent-format Python script.
+func (c *NotebookController) ExportNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	notebookID := r.PathValue("id")
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	user, ok := ctx.Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		c.Logger.Error().Msg("userID not found in context for notebook export")
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+	if _, err := uuid.Parse(notebookID); err != nil {
+		http.Error(w, "invalid notebook ID", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ipynb"
+	}
+
+	var title, contentType string
+	var data []byte
+	var err error
+	switch format {
+	case "ipynb":
+		var nb *nbformat.Notebook
+		if nb, err = c.NotebookModule.ExportNotebook(ctx, notebookID, user.ID); err == nil {
+			title, contentType = nbformat.StringMetadata(nb.Metadata, "title"), "application/x-ipynb+json"
+			data, err = nb.Marshal()
+		}
+	case "py":
+		title, data, err = c.NotebookModule.ExportNotebookScript(ctx, notebookID, user.ID)
+		contentType = "text/x-python; charset=utf-8"
+	default:
+		http.Error(w, fmt.Sprintf("unsupported export format '%s'", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, modules.ErrNotebookNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("failed to export notebook")
+		http.Error(w, "error exporting notebook", http.StatusInternalServerError)
+		return
+	}
+
+	filename := exportFilename(title) + "." + format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("failed to write exported notebook")
+	}
+}
+
+// exportFilename turns a notebook title into a file name, without extension.
+func exportFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		return "notebook"
+	}
+	return name
+}
+
 // UpdateNotebookByIDHandler handles PUT /api/v1/notebooks/{id}
 func (c *NotebookController) UpdateNotebookByIDHandler(w http.ResponseWriter, r *http.Request) {
 	notebookID := r.PathValue("id")
@@ -132,6 +291,10 @@ func (c *NotebookController) UpdateNotebookByIDHandler(w http.ResponseWriter, r
 	}
 	updated, err := c.NotebookModule.UpdateNotebook(ctx, notebookID, &req, user.ID)
 	if err != nil {
+		if errors.Is(err, modules.ErrInvalidEnvVar) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("update notebook failed")
 		http.Error(w, "error updating notebook", http.StatusInternalServerError)
 		return
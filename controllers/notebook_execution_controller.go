@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// NotebookExecutionController holds the dependencies for the parameterized notebook execution handlers.
+type NotebookExecutionController struct {
+	Module *modules.NotebookExecutionModule
+	Logger zerolog.Logger
+}
+
+// NewNotebookExecutionController creates and returns a new NotebookExecutionController.
+func NewNotebookExecutionController(module *modules.NotebookExecutionModule, logger zerolog.Logger) *NotebookExecutionController {
+	return &NotebookExecutionController{
+		Module: module,
+		Logger: logger,
+	}
+}
+
+// CreateNotebookExecutionHandler handles POST /api/v1/notebooks/{id}/runs
+func (c *NotebookExecutionController) CreateNotebookExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	notebookID := r.PathValue("id")
+	c.Logger.Info().Str("notebook_id", notebookID).Msg("received request to execute notebook")
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateNotebookExecutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	execution, err := c.Module.StartExecution(ctx, notebookID, user.ID, &req)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("failed to start notebook execution")
+		switch {
+		case errors.Is(err, modules.ErrInvalidParameters), errors.Is(err, modules.ErrUnknownKernelSpec):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err.Error() == "invalid notebook ID format":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.Contains(err.Error(), "not found"):
+			http.Error(w, "notebook not found", http.StatusNotFound)
+		default:
+			http.Error(w, "failed to start notebook execution", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.Logger.Info().
+		Str("notebook_id", notebookID).
+		Str("execution_id", execution.ID.String()).
+		Msg("notebook execution started")
+	pkg.WriteJSONResponseWithLogger(w, http.StatusAccepted, execution, &c.Logger)
+}
+
+// ListNotebookExecutionsHandler handles GET /api/v1/notebooks/{id}/runs
+func (c *NotebookExecutionController) ListNotebookExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	notebookID := r.PathValue("id")
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	executions, err := c.Module.ListExecutions(ctx, notebookID, user.ID)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("notebook_id", notebookID).Msg("failed to list notebook runs")
+		switch {
+		case errors.Is(err, modules.ErrInvalidNotebookID):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, modules.ErrNotebookNotFound):
+			http.Error(w, "notebook not found", http.StatusNotFound)
+		default:
+			http.Error(w, "failed to retrieve notebook runs", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, executions, &c.Logger)
+}
+
+// GetNotebookExecutionHandler handles GET /api/v1/notebooks/{id}/runs/{run_id}
+func (c *NotebookExecutionController) GetNotebookExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	notebookID := r.PathValue("id")
+	executionID, err := uuid.Parse(r.PathValue("run_id"))
+	if err != nil {
+		http.Error(w, "invalid run ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	execution, err := c.Module.GetExecution(ctx, notebookID, executionID, user.ID)
+	if err != nil {
+		c.Logger.Error().Err(err).Str("execution_id", executionID.String()).Msg("failed to get notebook execution")
+		if err.Error() == "notebook execution not found or not owned by user" {
+			http.Error(w, "notebook execution not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to retrieve notebook execution", http.StatusInternalServerError)
+		return
+	}
+
+	pkg.WriteJSONResponseWithLogger(w, http.StatusOK, execution, &c.Logger)
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"time"
 
@@ -11,9 +13,14 @@ import (
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg" // Added pkg import
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/rs/zerolog"
 )
 
+// sessionEventsKeepAlive is how often a comment is sent on an idle session event stream so that
+// proxies do not close it.
+const sessionEventsKeepAlive = 15 * time.Second
+
 // SessionController holds the dependencies for the session handlers.
 type SessionController struct {
 	Module *modules.SessionModule
@@ -49,6 +56,10 @@ func (c *SessionController) CreateSessionHandler(w http.ResponseWriter, r *http.
 	session, err := c.Module.CreateSession(ctx, user.ID, req.NotebookID, req.Language)
 	if err != nil {
 		c.Logger.Error().Err(err).Msg("failed to create session")
+		if errors.Is(err, modules.ErrUnknownKernelSpec) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -196,3 +207,81 @@ func (c *SessionController) DeleteSessionByIDHandler(w http.ResponseWriter, r *h
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// SessionEventsHandler handles GET /api/v1/sessions/{id}/events and streams the lifecycle events of
+// the session and its kernel as Server-Sent Events, starting with the kernel's current status.
+func (c *SessionController) SessionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := r.Context().Value(middleware.UserContextKey).(*middleware.User)
+	if !ok || user.ID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID, err := uuid.Parse(user.ID)
+	if err != nil {
+		http.Error(w, "invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	sub, current, err := c.Module.SubscribeEvents(ctx, id, userID)
+	cancel()
+	if err != nil {
+		c.Logger.Error().Err(err).Str("session_id", id.String()).Msg("failed to subscribe to session events")
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "session not found", http.StatusNotFound)
+		case errors.Is(err, modules.ErrEventsUnavailable):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, "failed to subscribe to session events", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if current != nil {
+		if err := writeSSEEvent(w, current.Type, current); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sessionEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := writeSSEEvent(w, event.Type, event); err != nil {
+				c.Logger.Debug().Err(err).Str("session_id", id.String()).Msg("failed to write session event")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -1,7 +1,120 @@
 package controllers_test
 
-import "testing"
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
 
-func TestSessionController(t *testing.T) {
-	// TODO: Write unit tests for the SessionController
+	"github.com/Thanus-Kumaar/controller_microservice_v2/controllers"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// readSSEEvent reads the next event of a Server-Sent Events stream, skipping comments.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (string, events.Event) {
+	t.Helper()
+	var name string
+	var event events.Event
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("decoding event: %v", err)
+			}
+		case line == "" && name != "":
+			return name, event
+		}
+	}
+}
+
+func TestSessionEventsHandler(t *testing.T) {
+	logger := zerolog.Nop()
+	pkg.Logger = &logger
+
+	gateway := fakegateway.New("")
+	t.Cleanup(gateway.Close)
+	bus := events.NewBus()
+	repo := &memorySessionRepo{}
+	sessionModule := modules.NewSessionModule(repo, gateway.Client(), logger, nil).WithEvents(bus)
+	controller := controllers.NewSessionController(sessionModule, logger)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/sessions/{id}/events", controller.SessionEventsHandler)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := &middleware.User{ID: r.Header.Get("X-User-ID")}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, user)))
+	}))
+	t.Cleanup(server.Close)
+
+	owner := uuid.New()
+	kernel := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	session := repo.add(owner, kernel.ID)
+	other := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	repo.add(uuid.New(), other.ID)
+
+	open := func(userID uuid.UUID) *http.Response {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/sessions/"+session.ID.String()+"/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-User-ID", userID.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := open(uuid.New()); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("other user: status %d, want 404", resp.StatusCode)
+	}
+
+	resp := open(owner)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	name, event := readSSEEvent(t, reader)
+	if name != events.KernelStatus || event.KernelID != kernel.ID || event.ExecutionState != "idle" {
+		t.Fatalf("first event %s %+v, want the kernel's idle status", name, event)
+	}
+
+	bus.Publish(events.Event{Type: events.KernelStatus, KernelID: other.ID, ExecutionState: "busy"})
+	bus.Publish(events.Event{Type: events.KernelStatus, KernelID: kernel.ID, ExecutionState: "busy"})
+	replacement := uuid.NewString()
+	bus.Publish(events.Event{Type: events.KernelReplaced, SessionID: session.ID.String(), KernelID: replacement})
+	bus.Publish(events.Event{Type: events.KernelStatus, KernelID: replacement, ExecutionState: "starting"})
+
+	want := []events.Event{
+		{Type: events.KernelStatus, KernelID: kernel.ID, ExecutionState: "busy"},
+		{Type: events.KernelReplaced, SessionID: session.ID.String(), KernelID: replacement},
+		{Type: events.KernelStatus, KernelID: replacement, ExecutionState: "starting"},
+	}
+	for _, w := range want {
+		name, event := readSSEEvent(t, reader)
+		if name != w.Type || event.KernelID != w.KernelID || event.ExecutionState != w.ExecutionState {
+			t.Errorf("got %s %+v, want %+v", name, event, w)
+		}
+	}
 }
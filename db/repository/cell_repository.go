@@ -3,7 +3,10 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
 	"github.com/google/uuid"
@@ -12,6 +15,9 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// ErrCellNotFound is returned when a cell does not exist or the user does not own it.
+var ErrCellNotFound = errors.New("cell not found or not owned by user")
+
 // CellRepository defines the data access methods for a cell, ensuring ownership.
 type CellRepository interface {
 	CreateCell(ctx context.Context, cell *models.Cell) (*models.Cell, error)
@@ -19,9 +25,13 @@ type CellRepository interface {
 	GetCellsByNotebookID(ctx context.Context, notebookID uuid.UUID) ([]*models.Cell, error)
 	UpdateCell(ctx context.Context, cell *models.Cell, userID string) (*models.Cell, error)
 	DeleteCell(ctx context.Context, id uuid.UUID, userID string) error
-	UpdateCells(ctx context.Context, notebookID uuid.UUID, req *models.UpdateCellsRequest) error
+	UpdateCells(ctx context.Context, notebookID uuid.UUID, req *models.UpdateCellsRequest) ([]*models.CellOutput, error)
 
 	CreateCellOutput(ctx context.Context, output *models.CellOutput) (*models.CellOutput, error)
+	SaveCellOutputs(ctx context.Context, outputs []*models.CellOutput) error
+	DeleteCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) error
+	GetDisplayOutputs(ctx context.Context, cellID uuid.UUID, displayID string) ([]*models.CellOutput, error)
+	UpdateCellOutputData(ctx context.Context, outputID uuid.UUID, data json.RawMessage, minioURL string) error
 	GetCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) ([]*models.CellOutput, error)
 	GetCellOutputByID(ctx context.Context, outputID uuid.UUID, userID string) (*models.CellOutput, error)
 	DeleteCellOutput(ctx context.Context, id uuid.UUID, userID string) error
@@ -38,9 +48,9 @@ func NewCellRepository(db *pgxpool.Pool, logger zerolog.Logger) CellRepository {
 
 func (r *cellRepository) CreateCell(ctx context.Context, cell *models.Cell) (*models.Cell, error) {
 	query := `
-		INSERT INTO cells (id, notebook_id, cell_index, cell_name, cell_type, source, execution_count)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, notebook_id, cell_index, cell_name, cell_type, source, execution_count;
+		INSERT INTO cells (id, notebook_id, cell_index, cell_name, cell_type, source, execution_count, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, notebook_id, cell_index, cell_name, cell_type, source, execution_count, metadata;
 	`
 	row := r.db.QueryRow(ctx, query,
 		cell.ID.ToUUID(),
@@ -50,6 +60,7 @@ func (r *cellRepository) CreateCell(ctx context.Context, cell *models.Cell) (*mo
 		cell.CellType,
 		cell.Source,
 		cell.ExecutionCount,
+		nullableJSON(cell.Metadata),
 	)
 
 	var createdCell models.Cell
@@ -62,6 +73,7 @@ func (r *cellRepository) CreateCell(ctx context.Context, cell *models.Cell) (*mo
 		&createdCell.CellType,
 		&createdCell.Source,
 		&createdCell.ExecutionCount,
+		&createdCell.Metadata,
 	)
 	if err != nil {
 		return nil, err
@@ -77,7 +89,7 @@ func (r *cellRepository) GetCellByID(
 	userID string,
 ) (*models.Cell, error) {
 	query := `
-		SELECT c.id, c.notebook_id, c.cell_index, c.cell_name, c.cell_type, c.source, c.execution_count
+		SELECT c.id, c.notebook_id, c.cell_index, c.cell_name, c.cell_type, c.source, c.execution_count, c.metadata
 		FROM cells c
 		JOIN notebooks n ON c.notebook_id = n.id
 		JOIN problem_statements ps ON n.problem_statement_id = ps.id
@@ -95,10 +107,11 @@ func (r *cellRepository) GetCellByID(
 		&cell.CellType,
 		&cell.Source,
 		&cell.ExecutionCount,
+		&cell.Metadata,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("cell not found or not owned by user")
+			return nil, ErrCellNotFound
 		}
 		return nil, err
 	}
@@ -113,7 +126,7 @@ func (r *cellRepository) GetCellsByNotebookID(
 ) ([]*models.Cell, error) {
 	// Ownership check is expected to happen in the controller/module before this call
 	query := `
-		SELECT id, notebook_id, cell_index, cell_name, cell_type, source, execution_count
+		SELECT id, notebook_id, cell_index, cell_name, cell_type, source, execution_count, metadata
 		FROM cells
 		WHERE notebook_id = $1
 		ORDER BY cell_index;
@@ -136,6 +149,7 @@ func (r *cellRepository) GetCellsByNotebookID(
 			&cell.CellType,
 			&cell.Source,
 			&cell.ExecutionCount,
+			&cell.Metadata,
 		)
 		if err != nil {
 			return nil, err
@@ -150,13 +164,13 @@ func (r *cellRepository) GetCellsByNotebookID(
 func (r *cellRepository) UpdateCell(ctx context.Context, cell *models.Cell, userID string) (*models.Cell, error) {
 	query := `
 		UPDATE cells
-		SET cell_index = $2, cell_name = $3, cell_type = $4, source = $5, execution_count = $6
+		SET cell_index = $2, cell_name = $3, cell_type = $4, source = $5, execution_count = $6, metadata = $8
 		WHERE id = $1 AND notebook_id IN (
 			SELECT n.id FROM notebooks n
 			JOIN problem_statements ps ON n.problem_statement_id = ps.id
 			WHERE ps.created_by = $7
 		)
-		RETURNING id, notebook_id, cell_index, cell_name, cell_type, source, execution_count;
+		RETURNING id, notebook_id, cell_index, cell_name, cell_type, source, execution_count, metadata;
 	`
 	row := r.db.QueryRow(ctx, query,
 		cell.ID.ToUUID(),
@@ -166,6 +180,7 @@ func (r *cellRepository) UpdateCell(ctx context.Context, cell *models.Cell, user
 		cell.Source,
 		cell.ExecutionCount,
 		userID,
+		nullableJSON(cell.Metadata),
 	)
 
 	var updatedCell models.Cell
@@ -178,10 +193,11 @@ func (r *cellRepository) UpdateCell(ctx context.Context, cell *models.Cell, user
 		&updatedCell.CellType,
 		&updatedCell.Source,
 		&updatedCell.ExecutionCount,
+		&updatedCell.Metadata,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("cell not found or not owned by user")
+			return nil, ErrCellNotFound
 		}
 		return nil, err
 	}
@@ -204,12 +220,14 @@ func (r *cellRepository) DeleteCell(ctx context.Context, id uuid.UUID, userID st
 		return err
 	}
 	if cmdTag.RowsAffected() == 0 {
-		return errors.New("cell not found or not owned by user")
+		return ErrCellNotFound
 	}
 	return nil
 }
 
-func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID, req *models.UpdateCellsRequest) error {
+// UpdateCells applies a batch of cell changes to a notebook. Cells missing from the new order are
+// deleted; the outputs they had in the blob store are returned for the caller to remove.
+func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID, req *models.UpdateCellsRequest) ([]*models.CellOutput, error) {
 	r.Logger.Info().
 		Str("notebook_id", notebookID.String()).
 		Int("delete_count", len(req.CellsToDelete)).
@@ -220,7 +238,7 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		r.Logger.Error().Err(err).Msg("Failed to begin transaction")
-		return err
+		return nil, err
 	}
 	defer func() {
 		_ = tx.Rollback(ctx)
@@ -235,7 +253,7 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 		_, err := tx.Exec(ctx, "UPDATE notebooks SET requirements = $1 WHERE id = $2", *req.Requirements, notebookID)
 		if err != nil {
 			r.Logger.Error().Err(err).Msg("Failed to update notebook requirements")
-			return err
+			return nil, err
 		}
 	}
 
@@ -243,7 +261,7 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 	rows, err := tx.Query(ctx, "SELECT id FROM cells WHERE notebook_id = $1", notebookID)
 	if err != nil {
 		r.Logger.Error().Err(err).Msg("Failed to fetch existing cell IDs")
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -251,7 +269,7 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 	for rows.Next() {
 		var id uuid.UUID
 		if err := rows.Scan(&id); err != nil {
-			return err
+			return nil, err
 		}
 		existingIDs[id.String()] = true
 	}
@@ -278,14 +296,20 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 	}
 
 	// delete all orphaned and explicitly removed cells
+	var deletedOutputs []*models.CellOutput
 	if len(idsToDeleteMap) > 0 {
 		deleteSlice := make([]uuid.UUID, 0, len(idsToDeleteMap))
 		for id := range idsToDeleteMap {
 			deleteSlice = append(deleteSlice, id)
 		}
+		deletedOutputs, err = outputsWithBlobs(ctx, tx, "cell_id = ANY($1)", deleteSlice)
+		if err != nil {
+			r.Logger.Error().Err(err).Msg("Failed to list outputs of deleted cells")
+			return nil, err
+		}
 		if _, err := tx.Exec(ctx, "DELETE FROM cells WHERE id = ANY($1)", deleteSlice); err != nil {
 			r.Logger.Error().Err(err).Msg("Failed to delete cells")
-			return err
+			return nil, err
 		}
 	}
 
@@ -297,7 +321,7 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 			upsertedIDs[idStr] = true
 			cellUUID, err := uuid.Parse(idStr)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			cellIndex, ok := orderMap[idStr]
@@ -314,14 +338,14 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 			}
 
 			query := `
-                INSERT INTO cells (id, notebook_id, cell_type, source, cell_name, execution_count, cell_index)
-                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                INSERT INTO cells (id, notebook_id, cell_type, source, cell_name, execution_count, cell_index, metadata)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
                 ON CONFLICT (id) DO UPDATE
-                SET source = $4, cell_name = $5, execution_count = $6, cell_index = $7;
+                SET source = $4, cell_name = $5, execution_count = $6, cell_index = $7, metadata = COALESCE($8, cells.metadata);
             `
-			if _, err := tx.Exec(ctx, query, cellUUID, notebookID, cellData.CellType, cellData.Source, nullCellName, cellData.ExecutionCount, cellIndex); err != nil {
+			if _, err := tx.Exec(ctx, query, cellUUID, notebookID, cellData.CellType, cellData.Source, nullCellName, cellData.ExecutionCount, cellIndex, nullableJSON(cellData.Metadata)); err != nil {
 				r.Logger.Error().Err(err).Str("cell_id", idStr).Msg("Failed to upsert cell")
-				return err
+				return nil, err
 			}
 		}
 	}
@@ -332,21 +356,23 @@ func (r *cellRepository) UpdateCells(ctx context.Context, notebookID uuid.UUID,
 			cellUUID, _ := uuid.Parse(idStr)
 			if _, err := tx.Exec(ctx, "UPDATE cells SET cell_index = $1 WHERE id = $2", index, cellUUID); err != nil {
 				r.Logger.Error().Err(err).Str("cell_id", idStr).Msg("Failed to reorder cell")
-				return err
+				return nil, err
 			}
 		}
 	}
 
-	return tx.Commit(ctx)
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return deletedOutputs, nil
 }
 
-
 func (r *cellRepository) CreateCellOutput(ctx context.Context, output *models.CellOutput) (*models.CellOutput, error) {
 	r.Logger.Debug().Str("output_id", output.ID.String()).Str("cell_id", output.CellID.ToUUID().String()).Msg("CellRepository: Creating cell output")
 	query := `
-		INSERT INTO cell_outputs (id, cell_id, output_index, type, data_json, minio_url, execution_count)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, cell_id, output_index, type, data_json, minio_url, execution_count;
+		INSERT INTO cell_outputs (id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, metadata;
 	`
 	row := r.db.QueryRow(ctx, query,
 		output.ID,
@@ -356,6 +382,8 @@ func (r *cellRepository) CreateCellOutput(ctx context.Context, output *models.Ce
 		output.DataJSON,
 		output.MinioURL,
 		output.ExecutionCount,
+		output.BufferKeys,
+		nullableJSON(output.Metadata),
 	)
 
 	var createdOutput models.CellOutput
@@ -368,6 +396,8 @@ func (r *cellRepository) CreateCellOutput(ctx context.Context, output *models.Ce
 		&createdOutput.DataJSON,
 		&createdOutput.MinioURL,
 		&createdOutput.ExecutionCount,
+		&createdOutput.BufferKeys,
+		&createdOutput.Metadata,
 	)
 	if err != nil {
 		r.Logger.Error().Err(err).Msg("CellRepository: Failed to scan created cell output")
@@ -378,10 +408,81 @@ func (r *cellRepository) CreateCellOutput(ctx context.Context, output *models.Ce
 	return &createdOutput, nil
 }
 
+// SaveCellOutputs writes a batch of outputs in one statement. Outputs that already exist, such as
+// a stream output that grew since it was last saved, have their data replaced.
+func (r *cellRepository) SaveCellOutputs(ctx context.Context, outputs []*models.CellOutput) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	const columns = 10
+	var query strings.Builder
+	query.WriteString("INSERT INTO cell_outputs (id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, display_id, metadata) VALUES ")
+	args := make([]any, 0, len(outputs)*columns)
+	for i, output := range outputs {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * columns
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NULLIF($%d, ''), $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10)
+		args = append(args,
+			output.ID,
+			output.CellID.ToUUID(),
+			output.OutputIndex,
+			output.Type,
+			output.DataJSON,
+			output.MinioURL,
+			output.ExecutionCount,
+			output.BufferKeys,
+			output.DisplayID,
+			nullableJSON(output.Metadata),
+		)
+	}
+	query.WriteString(" ON CONFLICT (id) DO UPDATE SET data_json = excluded.data_json, minio_url = excluded.minio_url, buffer_keys = excluded.buffer_keys")
+
+	if _, err := r.db.Exec(ctx, query.String(), args...); err != nil {
+		r.Logger.Error().Err(err).Int("count", len(outputs)).Msg("CellRepository: Failed to save cell outputs")
+		return err
+	}
+	r.Logger.Debug().Int("count", len(outputs)).Msg("CellRepository: Saved cell outputs")
+	return nil
+}
+
+// DeleteCellOutputsByCellID removes all outputs of a cell, before it shows the outputs of a new execution.
+func (r *cellRepository) DeleteCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM cell_outputs WHERE cell_id = $1", cellID)
+	return err
+}
+
+// GetDisplayOutputs returns the outputs showing a display_id in the notebook of the given cell.
+func (r *cellRepository) GetDisplayOutputs(ctx context.Context, cellID uuid.UUID, displayID string) ([]*models.CellOutput, error) {
+	query := `
+		SELECT id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, COALESCE(display_id, ''), metadata
+		FROM cell_outputs
+		WHERE display_id = $2 AND cell_id IN (
+			SELECT c.id FROM cells c
+			WHERE c.notebook_id = (SELECT notebook_id FROM cells WHERE id = $1)
+		);
+	`
+	rows, err := r.db.Query(ctx, query, cellID, displayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCellOutputs(rows)
+}
+
+// UpdateCellOutputData replaces the data of an output, along with the blob store prefix of its
+// offloaded entries.
+func (r *cellRepository) UpdateCellOutputData(ctx context.Context, outputID uuid.UUID, data json.RawMessage, minioURL string) error {
+	_, err := r.db.Exec(ctx, "UPDATE cell_outputs SET data_json = $2, minio_url = $3 WHERE id = $1", outputID, data, minioURL)
+	return err
+}
+
 func (r *cellRepository) GetCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) ([]*models.CellOutput, error) {
 	// Ownership check is expected to happen in the controller/module before this call
 	query := `
-		SELECT id, cell_id, output_index, type, data_json, minio_url, execution_count
+		SELECT id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, COALESCE(display_id, ''), metadata
 		FROM cell_outputs
 		WHERE cell_id = $1
 		ORDER BY output_index;
@@ -391,7 +492,32 @@ func (r *cellRepository) GetCellOutputsByCellID(ctx context.Context, cellID uuid
 		return nil, err
 	}
 	defer rows.Close()
+	return scanCellOutputs(rows)
+}
 
+// rowsQuerier is implemented by both pools and transactions.
+type rowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// outputsWithBlobs selects the outputs matching where that keep buffers or data in the blob store,
+// so their blobs can be removed once the outputs are deleted.
+func outputsWithBlobs(ctx context.Context, q rowsQuerier, where string, args ...any) ([]*models.CellOutput, error) {
+	query := `
+		SELECT id, cell_id, output_index, type, data_json, COALESCE(minio_url, ''), execution_count, buffer_keys, COALESCE(display_id, ''), metadata
+		FROM cell_outputs
+		WHERE (` + where + `) AND (minio_url <> '' OR cardinality(buffer_keys) > 0);
+	`
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCellOutputs(rows)
+}
+
+// scanCellOutputs reads the outputs of a query selecting the columns of cell_outputs.
+func scanCellOutputs(rows pgx.Rows) ([]*models.CellOutput, error) {
 	var outputs []*models.CellOutput
 	for rows.Next() {
 		var output models.CellOutput
@@ -404,6 +530,9 @@ func (r *cellRepository) GetCellOutputsByCellID(ctx context.Context, cellID uuid
 			&output.DataJSON,
 			&output.MinioURL,
 			&output.ExecutionCount,
+			&output.BufferKeys,
+			&output.DisplayID,
+			&output.Metadata,
 		)
 		if err != nil {
 			return nil, err
@@ -417,7 +546,7 @@ func (r *cellRepository) GetCellOutputsByCellID(ctx context.Context, cellID uuid
 
 func (r *cellRepository) GetCellOutputByID(ctx context.Context, outputID uuid.UUID, userID string) (*models.CellOutput, error) {
 	query := `
-		SELECT co.id, co.cell_id, co.output_index, co.type, co.data_json, co.minio_url, co.execution_count
+		SELECT co.id, co.cell_id, co.output_index, co.type, co.data_json, co.minio_url, co.execution_count, co.buffer_keys, COALESCE(co.display_id, ''), co.metadata
 		FROM cell_outputs co
 		JOIN cells c ON co.cell_id = c.id
 		JOIN notebooks n ON c.notebook_id = n.id
@@ -436,6 +565,9 @@ func (r *cellRepository) GetCellOutputByID(ctx context.Context, outputID uuid.UU
 		&output.DataJSON,
 		&output.MinioURL,
 		&output.ExecutionCount,
+		&output.BufferKeys,
+		&output.DisplayID,
+		&output.Metadata,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -466,3 +598,11 @@ func (r *cellRepository) DeleteCellOutput(ctx context.Context, id uuid.UUID, use
 	}
 	return nil
 }
+
+// nullableJSON maps an absent or JSON null document to SQL NULL.
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ExecutionRepository defines the data access methods for the execution history.
+type ExecutionRepository interface {
+	CreateExecution(ctx context.Context, execution *models.Execution) error
+	MarkExecutionRunning(ctx context.Context, msgID string, startedAt time.Time) error
+	FinishExecution(ctx context.Context, msgID string, status string, executionCount *int, finishedAt time.Time) error
+	ListExecutionsByCellID(ctx context.Context, cellID uuid.UUID) ([]models.Execution, error)
+	ListExecutionsByNotebookID(ctx context.Context, notebookID uuid.UUID) ([]models.Execution, error)
+	ListSuccessfulExecutionsByKernelID(ctx context.Context, kernelID uuid.UUID, after time.Time, before time.Time) ([]models.Execution, error)
+	GetExecutionByID(ctx context.Context, id uuid.UUID) (*models.Execution, error)
+	SaveExecutionMessages(ctx context.Context, messages []models.ExecutionMessage) error
+	ListExecutionMessages(ctx context.Context, executionID uuid.UUID) ([]models.ExecutionMessage, error)
+}
+
+type executionRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewExecutionRepository creates a new ExecutionRepository.
+func NewExecutionRepository(db *pgxpool.Pool) ExecutionRepository {
+	return &executionRepository{db: db}
+}
+
+const executionColumns = `id, notebook_id, cell_id, session_id, kernel_id, msg_id, source, started_at, finished_at, status, execution_count`
+
+// CreateExecution inserts a new execution record.
+func (r *executionRepository) CreateExecution(ctx context.Context, execution *models.Execution) error {
+	query := `
+		INSERT INTO executions (` + executionColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+	`
+	_, err := r.db.Exec(ctx, query,
+		execution.ID,
+		execution.NotebookID,
+		execution.CellID,
+		execution.SessionID,
+		execution.KernelID,
+		execution.MsgID,
+		execution.Source,
+		execution.StartedAt,
+		execution.FinishedAt,
+		execution.Status,
+		execution.ExecutionCount,
+	)
+	return err
+}
+
+// MarkExecutionRunning moves a queued execution to running, using the time the kernel went busy as its start.
+func (r *executionRepository) MarkExecutionRunning(ctx context.Context, msgID string, startedAt time.Time) error {
+	query := `
+		UPDATE executions
+		SET status = 'running', started_at = $2
+		WHERE msg_id = $1 AND status = 'queued';
+	`
+	_, err := r.db.Exec(ctx, query, msgID, startedAt)
+	return err
+}
+
+// FinishExecution records the outcome of an execution from its execute_reply.
+func (r *executionRepository) FinishExecution(ctx context.Context, msgID string, status string, executionCount *int, finishedAt time.Time) error {
+	query := `
+		UPDATE executions
+		SET status = $2, execution_count = $3, finished_at = $4
+		WHERE msg_id = $1;
+	`
+	cmdTag, err := r.db.Exec(ctx, query, msgID, status, executionCount, finishedAt)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListExecutionsByCellID retrieves the executions of a cell, most recent first.
+func (r *executionRepository) ListExecutionsByCellID(ctx context.Context, cellID uuid.UUID) ([]models.Execution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM executions
+		WHERE cell_id = $1
+		ORDER BY started_at DESC;
+	`
+	return r.queryExecutions(ctx, query, cellID)
+}
+
+// ListExecutionsByNotebookID retrieves the executions of all cells of a notebook, most recent first.
+func (r *executionRepository) ListExecutionsByNotebookID(ctx context.Context, notebookID uuid.UUID) ([]models.Execution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM executions
+		WHERE notebook_id = $1
+		ORDER BY started_at DESC;
+	`
+	return r.queryExecutions(ctx, query, notebookID)
+}
+
+// ListSuccessfulExecutionsByKernelID retrieves the executions that completed successfully on a kernel
+// between two times, such as since its last (re)start and until it died, in the order they ran.
+func (r *executionRepository) ListSuccessfulExecutionsByKernelID(ctx context.Context, kernelID uuid.UUID, after time.Time, before time.Time) ([]models.Execution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM executions
+		WHERE kernel_id = $1 AND status = 'ok' AND started_at >= $2 AND started_at < $3
+		ORDER BY started_at ASC;
+	`
+	return r.queryExecutions(ctx, query, kernelID, after, before)
+}
+
+// GetExecutionByID retrieves one execution record.
+func (r *executionRepository) GetExecutionByID(ctx context.Context, id uuid.UUID) (*models.Execution, error) {
+	query := `
+		SELECT ` + executionColumns + `
+		FROM executions
+		WHERE id = $1;
+	`
+	executions, err := r.queryExecutions(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(executions) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &executions[0], nil
+}
+
+// SaveExecutionMessages appends a batch of entries to the message logs of executions in one statement.
+func (r *executionRepository) SaveExecutionMessages(ctx context.Context, messages []models.ExecutionMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	const columns = 6
+	var query strings.Builder
+	query.WriteString("INSERT INTO execution_messages (execution_id, seq, channel, msg_type, content, received_at) VALUES ")
+	args := make([]any, 0, len(messages)*columns)
+	for i, message := range messages {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * columns
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+		args = append(args,
+			message.ExecutionID,
+			message.Seq,
+			message.Channel,
+			message.MsgType,
+			nullableJSON(message.Content),
+			message.ReceivedAt,
+		)
+	}
+	_, err := r.db.Exec(ctx, query.String(), args...)
+	return err
+}
+
+// ListExecutionMessages retrieves the message log of an execution in order.
+func (r *executionRepository) ListExecutionMessages(ctx context.Context, executionID uuid.UUID) ([]models.ExecutionMessage, error) {
+	query := `
+		SELECT execution_id, seq, channel, msg_type, content, received_at
+		FROM execution_messages
+		WHERE execution_id = $1
+		ORDER BY seq ASC;
+	`
+	rows, err := r.db.Query(ctx, query, executionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.ExecutionMessage{}
+	for rows.Next() {
+		var message models.ExecutionMessage
+		if err := rows.Scan(
+			&message.ExecutionID,
+			&message.Seq,
+			&message.Channel,
+			&message.MsgType,
+			&message.Content,
+			&message.ReceivedAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *executionRepository) queryExecutions(ctx context.Context, query string, args ...any) ([]models.Execution, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []models.Execution{}
+	for rows.Next() {
+		var execution models.Execution
+		if err := rows.Scan(
+			&execution.ID,
+			&execution.NotebookID,
+			&execution.CellID,
+			&execution.SessionID,
+			&execution.KernelID,
+			&execution.MsgID,
+			&execution.Source,
+			&execution.StartedAt,
+			&execution.FinishedAt,
+			&execution.Status,
+			&execution.ExecutionCount,
+		); err != nil {
+			return nil, err
+		}
+		executions = append(executions, execution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return executions, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// KernelGatewayRepository persists which Jupyter gateway runs each kernel.
+// It satisfies jupyterclient.PlacementStore.
+type KernelGatewayRepository interface {
+	SaveKernelGateway(ctx context.Context, kernelID string, gatewayID string) error
+	GetKernelGateway(ctx context.Context, kernelID string) (string, error)
+	DeleteKernelGateway(ctx context.Context, kernelID string) error
+}
+
+type kernelGatewayRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewKernelGatewayRepository creates a new KernelGatewayRepository.
+func NewKernelGatewayRepository(db *pgxpool.Pool) KernelGatewayRepository {
+	return &kernelGatewayRepository{db: db}
+}
+
+// SaveKernelGateway records the gateway a kernel was started on.
+func (r *kernelGatewayRepository) SaveKernelGateway(ctx context.Context, kernelID string, gatewayID string) error {
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO kernel_gateways (kernel_id, gateway_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (kernel_id) DO UPDATE SET gateway_id = excluded.gateway_id;
+	`
+	_, err = r.db.Exec(ctx, query, kernelUUID, gatewayID, time.Now().UTC())
+	return err
+}
+
+// GetKernelGateway returns the gateway running a kernel, or an empty ID when it is not known.
+func (r *kernelGatewayRepository) GetKernelGateway(ctx context.Context, kernelID string) (string, error) {
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return "", nil
+	}
+
+	query := `SELECT gateway_id FROM kernel_gateways WHERE kernel_id = $1;`
+	var gatewayID string
+	if err := r.db.QueryRow(ctx, query, kernelUUID).Scan(&gatewayID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return gatewayID, nil
+}
+
+// DeleteKernelGateway forgets the placement of a deleted kernel.
+func (r *kernelGatewayRepository) DeleteKernelGateway(ctx context.Context, kernelID string) error {
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return nil
+	}
+
+	query := `DELETE FROM kernel_gateways WHERE kernel_id = $1;`
+	_, err = r.db.Exec(ctx, query, kernelUUID)
+	return err
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// NotebookExecutionRepository defines the data access methods for parameterized notebook runs.
+type NotebookExecutionRepository interface {
+	CreateNotebookExecution(ctx context.Context, execution *models.NotebookExecution) (*models.NotebookExecution, error)
+	FinishNotebookExecution(ctx context.Context, execution *models.NotebookExecution) error
+	GetNotebookExecutionByID(ctx context.Context, id uuid.UUID, userID string) (*models.NotebookExecution, error)
+	ListNotebookExecutionsByNotebookID(ctx context.Context, notebookID uuid.UUID) ([]models.NotebookExecution, error)
+}
+
+type notebookExecutionRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewNotebookExecutionRepository creates a new NotebookExecutionRepository.
+func NewNotebookExecutionRepository(db *pgxpool.Pool) NotebookExecutionRepository {
+	return &notebookExecutionRepository{db: db}
+}
+
+// CreateNotebookExecution inserts the record of a run that has just started.
+func (r *notebookExecutionRepository) CreateNotebookExecution(ctx context.Context, execution *models.NotebookExecution) (*models.NotebookExecution, error) {
+	query := `
+		INSERT INTO notebook_executions (id, notebook_id, created_by, kernel_name, parameters, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, notebook_id, created_by, kernel_name, parameters, status, error, notebook_json, started_at, finished_at;
+	`
+	row := r.db.QueryRow(ctx, query,
+		execution.ID,
+		execution.NotebookID,
+		execution.CreatedBy,
+		execution.KernelName,
+		execution.Parameters,
+		execution.Status,
+		execution.StartedAt,
+	)
+	return scanNotebookExecution(row)
+}
+
+// FinishNotebookExecution stores the final status and executed notebook of a running record.
+// Records that already finished are left untouched so they stay immutable.
+func (r *notebookExecutionRepository) FinishNotebookExecution(ctx context.Context, execution *models.NotebookExecution) error {
+	query := `
+		UPDATE notebook_executions
+		SET status = $2, error = $3, notebook_json = $4, finished_at = $5
+		WHERE id = $1 AND status = 'running';
+	`
+	cmdTag, err := r.db.Exec(ctx, query,
+		execution.ID,
+		execution.Status,
+		execution.Error,
+		nullableJSON(execution.Notebook),
+		execution.FinishedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("notebook execution not found or already finished")
+	}
+	return nil
+}
+
+// GetNotebookExecutionByID retrieves a run record, ensuring the user owns the notebook.
+func (r *notebookExecutionRepository) GetNotebookExecutionByID(ctx context.Context, id uuid.UUID, userID string) (*models.NotebookExecution, error) {
+	query := `
+		SELECT ne.id, ne.notebook_id, ne.created_by, ne.kernel_name, ne.parameters, ne.status, ne.error, ne.notebook_json, ne.started_at, ne.finished_at
+		FROM notebook_executions ne
+		JOIN notebooks n ON ne.notebook_id = n.id
+		JOIN problem_statements ps ON n.problem_statement_id = ps.id
+		WHERE ne.id = $1 AND ps.created_by = $2;
+	`
+	execution, err := scanNotebookExecution(r.db.QueryRow(ctx, query, id, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("notebook execution not found or not owned by user")
+		}
+		return nil, err
+	}
+	return execution, nil
+}
+
+// ListNotebookExecutionsByNotebookID retrieves the run records of a notebook, most recent first.
+// The executed notebooks are left out; GetNotebookExecutionByID returns them.
+func (r *notebookExecutionRepository) ListNotebookExecutionsByNotebookID(ctx context.Context, notebookID uuid.UUID) ([]models.NotebookExecution, error) {
+	query := `
+		SELECT id, notebook_id, created_by, kernel_name, parameters, status, error, NULL::jsonb, started_at, finished_at
+		FROM notebook_executions
+		WHERE notebook_id = $1
+		ORDER BY started_at DESC;
+	`
+	rows, err := r.db.Query(ctx, query, notebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []models.NotebookExecution{}
+	for rows.Next() {
+		execution, err := scanNotebookExecution(rows)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, *execution)
+	}
+	return executions, rows.Err()
+}
+
+func scanNotebookExecution(row pgx.Row) (*models.NotebookExecution, error) {
+	var execution models.NotebookExecution
+	if err := row.Scan(
+		&execution.ID,
+		&execution.NotebookID,
+		&execution.CreatedBy,
+		&execution.KernelName,
+		&execution.Parameters,
+		&execution.Status,
+		&execution.Error,
+		&execution.Notebook,
+		&execution.StartedAt,
+		&execution.FinishedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
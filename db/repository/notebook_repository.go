@@ -3,12 +3,14 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"time"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -16,8 +18,11 @@ type NotebookRepository interface {
 	CreateNotebook(ctx context.Context, req *models.CreateNotebookRequest) (*models.Notebook, error)
 	ListNotebooks(ctx context.Context, filters map[string]string, userID string) ([]models.Notebook, error)
 	GetNotebookByID(ctx context.Context, id string, userID string) (*models.Notebook, error)
+	IsNotebookOwner(ctx context.Context, id uuid.UUID, userID string) (bool, error)
 	UpdateNotebook(ctx context.Context, id string, req *models.UpdateNotebookRequest, userID string) (*models.Notebook, error)
-	DeleteNotebook(ctx context.Context, id string, userID string) error
+	DeleteNotebook(ctx context.Context, id string, userID string) ([]*models.CellOutput, error)
+	GetNotebookEnvVars(ctx context.Context, id uuid.UUID) (map[string]string, error)
+	ImportNotebook(ctx context.Context, req *models.CreateNotebookRequest, cells []models.Cell, widgetState json.RawMessage) (*models.Notebook, error)
 }
 
 type notebookRepository struct {
@@ -34,20 +39,30 @@ func (r *notebookRepository) CreateNotebook(
 	ctx context.Context,
 	req *models.CreateNotebookRequest,
 ) (*models.Notebook, error) {
+	return insertNotebook(ctx, r.pool, req)
+}
+
+// rowQuerier is implemented by both pools and transactions.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func insertNotebook(ctx context.Context, q rowQuerier, req *models.CreateNotebookRequest) (*models.Notebook, error) {
 	id := uuid.New().String()
 	now := time.Now().UTC()
 
 	query := `
-		INSERT INTO notebooks (id, title, context_minio_url, requirements, problem_statement_id, created_at, last_modified_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, title, context_minio_url, requirements, problem_statement_id, created_at, last_modified_at;
+		INSERT INTO notebooks (id, title, context_minio_url, requirements, env_vars, problem_statement_id, created_at, last_modified_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, title, context_minio_url, requirements, env_vars, problem_statement_id, created_at, last_modified_at;
 		`
 
-	row := r.pool.QueryRow(ctx, query,
+	row := q.QueryRow(ctx, query,
 		id,
 		req.Title,
 		nil, // TODO: Should include logic for context minIO url
 		req.Requirements,
+		req.EnvVars,
 		req.ProblemStatementID,
 		now,
 		now,
@@ -59,6 +74,7 @@ func (r *notebookRepository) CreateNotebook(
 		&nb.Title,
 		&nb.ContextMinioURL,
 		&nb.Requirements,
+		&nb.EnvVars,
 		&nb.ProblemStatementID,
 		&nb.CreatedAt,
 		&nb.LastModifiedAt,
@@ -69,13 +85,79 @@ func (r *notebookRepository) CreateNotebook(
 	return &nb, nil
 }
 
+// ImportNotebook creates a notebook with its cells, their outputs and its widget state in one
+// transaction, so a failed import leaves nothing behind. The cells' NotebookID is set.
+func (r *notebookRepository) ImportNotebook(
+	ctx context.Context,
+	req *models.CreateNotebookRequest,
+	cells []models.Cell,
+	widgetState json.RawMessage,
+) (*models.Notebook, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	nb, err := insertNotebook(ctx, tx, req)
+	if err != nil {
+		return nil, err
+	}
+	notebookID := uuid.MustParse(nb.ID)
+
+	batch := &pgx.Batch{}
+	for i := range cells {
+		cell := &cells[i]
+		cell.NotebookID = notebookID
+		batch.Queue(`
+			INSERT INTO cells (id, notebook_id, cell_index, cell_name, cell_type, source, execution_count, metadata)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+			cell.ID.ToUUID(), notebookID, cell.CellIndex, cell.CellName, cell.CellType, cell.Source, cell.ExecutionCount, nullableJSON(cell.Metadata),
+		)
+		for j := range cell.Outputs {
+			output := &cell.Outputs[j]
+			batch.Queue(`
+				INSERT INTO cell_outputs (id, cell_id, output_index, type, data_json, minio_url, execution_count, buffer_keys, metadata)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+				output.ID, cell.ID.ToUUID(), output.OutputIndex, output.Type, output.DataJSON, output.MinioURL, output.ExecutionCount, output.BufferKeys, nullableJSON(output.Metadata),
+			)
+		}
+	}
+	if len(widgetState) > 0 {
+		// The state was not captured from a kernel of this controller.
+		batch.Queue(`
+			INSERT INTO notebook_widget_states (notebook_id, kernel_id, state, updated_at)
+			VALUES ($1, $2, $3, $4);`,
+			notebookID, uuid.Nil, []byte(widgetState), time.Now().UTC(),
+		)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return nil, err
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	nb.Cells = cells
+	nb.WidgetState = widgetState
+	return nb, nil
+}
+
 func (r *notebookRepository) ListNotebooks(
 	ctx context.Context,
 	filters map[string]string,
 	userID string,
 ) ([]models.Notebook, error) {
 	query := `
-		SELECT n.id, n.title, n.context_minio_url, n.requirements, n.problem_statement_id, n.created_at, n.last_modified_at
+		SELECT n.id, n.title, n.context_minio_url, n.requirements, n.env_vars, n.problem_statement_id, n.created_at, n.last_modified_at
 		FROM notebooks n
 		JOIN problem_statements ps ON n.problem_statement_id = ps.id
 		WHERE ps.created_by = $1`
@@ -105,6 +187,7 @@ func (r *notebookRepository) ListNotebooks(
 			&nb.Title,
 			&nb.ContextMinioURL,
 			&nb.Requirements,
+			&nb.EnvVars,
 			&nb.ProblemStatementID,
 			&nb.CreatedAt,
 			&nb.LastModifiedAt,
@@ -117,6 +200,20 @@ func (r *notebookRepository) ListNotebooks(
 	return notebooks, nil
 }
 
+// IsNotebookOwner reports whether the notebook exists under a problem statement created by the user.
+func (r *notebookRepository) IsNotebookOwner(ctx context.Context, id uuid.UUID, userID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM notebooks n
+			JOIN problem_statements ps ON n.problem_statement_id = ps.id
+			WHERE n.id = $1 AND ps.created_by = $2
+		);
+	`
+	var owned bool
+	err := r.pool.QueryRow(ctx, query, id, userID).Scan(&owned)
+	return owned, err
+}
+
 func (r *notebookRepository) GetNotebookByID(
 	ctx context.Context,
 	id string,
@@ -129,9 +226,9 @@ func (r *notebookRepository) GetNotebookByID(
 
 	query := `
 		SELECT
-			n.id, n.title, n.context_minio_url, n.requirements, n.problem_statement_id, n.created_at, n.last_modified_at,
-			c.id, c.notebook_id, c.cell_index, c.cell_name, c.cell_type, c.source, c.execution_count,
-			co.id, co.cell_id, co.output_index, co.type, co.data_json, co.minio_url, co.execution_count,
+			n.id, n.title, n.context_minio_url, n.requirements, n.env_vars, n.problem_statement_id, n.created_at, n.last_modified_at,
+			c.id, c.notebook_id, c.cell_index, c.cell_name, c.cell_type, c.source, c.execution_count, c.metadata,
+			co.id, co.cell_id, co.output_index, co.type, co.data_json, co.minio_url, co.execution_count, co.buffer_keys, co.display_id, co.metadata,
 			er.id, er.source_cell_id, er.start_time, er.end_time, er.status,
 			cv.id, cv.evolution_run_id, cv.code, cv.metric, cv.is_best, cv.generation, cv.parent_variant_id
 		FROM
@@ -178,6 +275,7 @@ func (r *notebookRepository) GetNotebookByID(
 			cellType          sql.NullString
 			cellSource        sql.NullString
 			cellExecCount     sql.NullInt32
+			cellMetadata      []byte
 			outputID          uuid.NullUUID
 			outputCellID      uuid.NullUUID
 			outputIndex       sql.NullInt32
@@ -185,6 +283,9 @@ func (r *notebookRepository) GetNotebookByID(
 			outputDataJSON    []byte
 			outputMinioURL    sql.NullString
 			outputExecCount   sql.NullInt32
+			outputBufferKeys  []string
+			outputDisplayID   sql.NullString
+			outputMetadata    []byte
 			erID              uuid.NullUUID
 			erSourceCellID    uuid.NullUUID
 			erStartTime       sql.NullTime
@@ -200,18 +301,16 @@ func (r *notebookRepository) GetNotebookByID(
 		)
 
 		if err := rows.Scan(
-			&notebook.ID, &notebook.Title, &notebook.ContextMinioURL, &notebook.Requirements, &notebook.ProblemStatementID, &notebook.CreatedAt, &notebook.LastModifiedAt,
-			&cellID, &cellNotebookID, &cellIndex, &cellName, &cellType, &cellSource, &cellExecCount,
-			&outputID, &outputCellID, &outputIndex, &outputType, &outputDataJSON, &outputMinioURL, &outputExecCount,
+			&notebook.ID, &notebook.Title, &notebook.ContextMinioURL, &notebook.Requirements, &notebook.EnvVars, &notebook.ProblemStatementID, &notebook.CreatedAt, &notebook.LastModifiedAt,
+			&cellID, &cellNotebookID, &cellIndex, &cellName, &cellType, &cellSource, &cellExecCount, &cellMetadata,
+			&outputID, &outputCellID, &outputIndex, &outputType, &outputDataJSON, &outputMinioURL, &outputExecCount, &outputBufferKeys, &outputDisplayID, &outputMetadata,
 			&erID, &erSourceCellID, &erStartTime, &erEndTime, &erStatus,
 			&cvID, &cvEvolutionRunID, &cvCode, &cvMetric, &cvIsBest, &cvGeneration, &cvParentVariantID,
 		); err != nil {
 			return nil, err
 		}
 
-		if !notebookFetched {
-			notebookFetched = true
-		}
+		notebookFetched = true
 
 		if cellID.Valid {
 			if _, exists := cellMap[cellID.UUID]; !exists {
@@ -223,6 +322,7 @@ func (r *notebookRepository) GetNotebookByID(
 					CellType:       cellType.String,
 					Source:         cellSource.String,
 					ExecutionCount: int(cellExecCount.Int32),
+					Metadata:       cellMetadata,
 					Outputs:        []models.CellOutput{},
 					EvolutionRuns:  []models.EvolutionRun{},
 				}
@@ -240,6 +340,9 @@ func (r *notebookRepository) GetNotebookByID(
 					DataJSON:       outputDataJSON,
 					MinioURL:       outputMinioURL.String,
 					ExecutionCount: int(outputExecCount.Int32),
+					BufferKeys:     outputBufferKeys,
+					DisplayID:      outputDisplayID.String,
+					Metadata:       outputMetadata,
 				})
 				outputMap[outputID.UUID] = true
 			}
@@ -295,6 +398,15 @@ func (r *notebookRepository) GetNotebookByID(
 		notebook.Cells[i] = *cellMap[cellID]
 	}
 
+	// The widget state is read on its own rather than joined, as it would be copied onto every row.
+	var widgetState []byte
+	err = r.pool.QueryRow(ctx, `SELECT state FROM notebook_widget_states WHERE notebook_id = $1;`, notebookUUID).
+		Scan(&widgetState)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	notebook.WidgetState = widgetState
+
 	return &notebook, nil
 }
 func (r *notebookRepository) UpdateNotebook(
@@ -320,6 +432,14 @@ func (r *notebookRepository) UpdateNotebook(
 		args = append(args, *req.Requirements)
 		argIndex++
 	}
+	if req.EnvVars != nil {
+		if setClause != "" {
+			setClause += ", "
+		}
+		setClause += "env_vars = $" + strconv.Itoa(argIndex)
+		args = append(args, req.EnvVars)
+		argIndex++
+	}
 
 	if setClause == "" {
 		return r.GetNotebookByID(ctx, id, userID)
@@ -337,7 +457,7 @@ func (r *notebookRepository) UpdateNotebook(
 		SET ` + setClause + `
 		FROM problem_statements ps
 		WHERE n.id = $` + strconv.Itoa(argIndex) + ` AND n.problem_statement_id = ps.id AND ps.created_by = $` + strconv.Itoa(argIndex+1) + `
-		RETURNING n.id, n.title, n.context_minio_url, n.requirements, n.problem_statement_id, n.created_at, n.last_modified_at;
+		RETURNING n.id, n.title, n.context_minio_url, n.requirements, n.env_vars, n.problem_statement_id, n.created_at, n.last_modified_at;
 	`
 
 	row := r.pool.QueryRow(ctx, query, args...)
@@ -348,6 +468,7 @@ func (r *notebookRepository) UpdateNotebook(
 		&nb.Title,
 		&nb.ContextMinioURL,
 		&nb.Requirements,
+		&nb.EnvVars,
 		&nb.ProblemStatementID,
 		&nb.CreatedAt,
 		&nb.LastModifiedAt,
@@ -358,22 +479,49 @@ func (r *notebookRepository) UpdateNotebook(
 	return &nb, nil
 }
 
+// GetNotebookEnvVars returns the environment variables of a notebook. Ownership is not checked;
+// it serves internal callers such as kernel recovery that already hold the notebook's session.
+func (r *notebookRepository) GetNotebookEnvVars(ctx context.Context, id uuid.UUID) (map[string]string, error) {
+	var env map[string]string
+	if err := r.pool.QueryRow(ctx, `SELECT env_vars FROM notebooks WHERE id = $1`, id).Scan(&env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// DeleteNotebook deletes a notebook with its cells and their outputs. The outputs that had buffers
+// or data in the blob store are returned for the caller to remove.
 func (r *notebookRepository) DeleteNotebook(
 	ctx context.Context,
 	id string,
 	userID string,
-) error {
+) ([]*models.CellOutput, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	outputs, err := outputsWithBlobs(ctx, tx, "cell_id IN (SELECT id FROM cells WHERE notebook_id = $1)", id)
+	if err != nil {
+		return nil, err
+	}
 	query := `
 		DELETE FROM notebooks n
 		USING problem_statements ps
 		WHERE n.id = $1 AND n.problem_statement_id = ps.id AND ps.created_by = $2;
 	`
-	cmd, err := r.pool.Exec(ctx, query, id, userID)
+	cmd, err := tx.Exec(ctx, query, id, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if cmd.RowsAffected() == 0 {
-		return errors.New("notebook not found or not owned by user")
+		return nil, errors.New("notebook not found or not owned by user")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
 	}
-	return nil
+	return outputs, nil
 }
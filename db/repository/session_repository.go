@@ -17,6 +17,12 @@ type SessionRepository interface {
 	GetSessionByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Session, error)
 	UpdateSessionStatus(ctx context.Context, id uuid.UUID, userID uuid.UUID, status string) (*models.Session, error)
 	DeleteSession(ctx context.Context, id uuid.UUID, userID uuid.UUID) error
+	GetKernelOwnerID(ctx context.Context, kernelID uuid.UUID) (uuid.UUID, error)
+	GetSessionByKernelID(ctx context.Context, kernelID uuid.UUID) (*models.Session, error)
+	GetLatestSessionByNotebookID(ctx context.Context, notebookID uuid.UUID) (*models.Session, error)
+	SetSessionStatus(ctx context.Context, id uuid.UUID, status string) error
+	UpdateSessionKernel(ctx context.Context, id uuid.UUID, kernelID uuid.UUID) error
+	MarkKernelRestarted(ctx context.Context, kernelID uuid.UUID, at time.Time) error
 }
 
 // sessionRepository is the concrete implementation of SessionRepository.
@@ -32,14 +38,15 @@ func NewSessionRepository(db *pgxpool.Pool) SessionRepository {
 // CreateSession inserts a new session into the database.
 func (r *sessionRepository) CreateSession(ctx context.Context, session *models.Session) (*models.Session, error) {
 	query := `
-		INSERT INTO sessions (id, notebook_id, current_kernel_id, status, last_active_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, notebook_id, current_kernel_id, status, last_active_at;
+		INSERT INTO sessions (id, notebook_id, current_kernel_id, kernel_name, status, last_active_at, kernel_started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		RETURNING id, notebook_id, current_kernel_id, kernel_name, status, last_active_at, kernel_started_at;
 	`
 	row := r.db.QueryRow(ctx, query,
 		session.ID,
 		session.NotebookID,
 		session.CurrentKernelID,
+		session.KernelName,
 		session.Status,
 		session.LastActiveAt,
 	)
@@ -49,8 +56,10 @@ func (r *sessionRepository) CreateSession(ctx context.Context, session *models.S
 		&createdSession.ID,
 		&createdSession.NotebookID,
 		&createdSession.CurrentKernelID,
+		&createdSession.KernelName,
 		&createdSession.Status,
 		&createdSession.LastActiveAt,
+		&createdSession.KernelStartedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -61,7 +70,7 @@ func (r *sessionRepository) CreateSession(ctx context.Context, session *models.S
 // ListSessions retrieves all sessions for a given user ID by joining through notebooks and problem_statements.
 func (r *sessionRepository) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
 	query := `
-		SELECT s.id, s.notebook_id, s.current_kernel_id, s.status, s.last_active_at
+		SELECT s.id, s.notebook_id, s.current_kernel_id, s.kernel_name, s.status, s.last_active_at, s.kernel_started_at
 		FROM sessions s
 		JOIN notebooks n ON s.notebook_id = n.id
 		JOIN problem_statements ps ON n.problem_statement_id = ps.id
@@ -80,8 +89,10 @@ func (r *sessionRepository) ListSessions(ctx context.Context, userID uuid.UUID)
 			&session.ID,
 			&session.NotebookID,
 			&session.CurrentKernelID,
+			&session.KernelName,
 			&session.Status,
 			&session.LastActiveAt,
+			&session.KernelStartedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -98,7 +109,7 @@ func (r *sessionRepository) ListSessions(ctx context.Context, userID uuid.UUID)
 // GetSessionByID retrieves a single session by its ID and user ID, joining through notebooks and problem_statements.
 func (r *sessionRepository) GetSessionByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*models.Session, error) {
 	query := `
-		SELECT s.id, s.notebook_id, s.current_kernel_id, s.status, s.last_active_at
+		SELECT s.id, s.notebook_id, s.current_kernel_id, s.kernel_name, s.status, s.last_active_at, s.kernel_started_at
 		FROM sessions s
 		JOIN notebooks n ON s.notebook_id = n.id
 		JOIN problem_statements ps ON n.problem_statement_id = ps.id
@@ -111,8 +122,10 @@ func (r *sessionRepository) GetSessionByID(ctx context.Context, id uuid.UUID, us
 		&session.ID,
 		&session.NotebookID,
 		&session.CurrentKernelID,
+		&session.KernelName,
 		&session.Status,
 		&session.LastActiveAt,
+		&session.KernelStartedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -130,7 +143,7 @@ func (r *sessionRepository) UpdateSessionStatus(ctx context.Context, id uuid.UUI
 			JOIN problem_statements ps ON n.problem_statement_id = ps.id
 			WHERE ps.created_by = $2
 		)
-		RETURNING id, notebook_id, current_kernel_id, status, last_active_at;
+		RETURNING id, notebook_id, current_kernel_id, kernel_name, status, last_active_at, kernel_started_at;
 	`
 	row := r.db.QueryRow(ctx, query, id, userID, status, time.Now().UTC())
 
@@ -139,8 +152,10 @@ func (r *sessionRepository) UpdateSessionStatus(ctx context.Context, id uuid.UUI
 		&updatedSession.ID,
 		&updatedSession.NotebookID,
 		&updatedSession.CurrentKernelID,
+		&updatedSession.KernelName,
 		&updatedSession.Status,
 		&updatedSession.LastActiveAt,
+		&updatedSession.KernelStartedAt,
 	); err != nil {
 		return nil, err
 	}
@@ -168,3 +183,120 @@ func (r *sessionRepository) DeleteSession(ctx context.Context, id uuid.UUID, use
 	return nil
 }
 
+// GetKernelOwnerID returns the user who owns the session currently running the given kernel,
+// resolved through the session's notebook and its problem statement.
+func (r *sessionRepository) GetKernelOwnerID(ctx context.Context, kernelID uuid.UUID) (uuid.UUID, error) {
+	query := `
+		SELECT ps.created_by
+		FROM sessions s
+		JOIN notebooks n ON s.notebook_id = n.id
+		JOIN problem_statements ps ON n.problem_statement_id = ps.id
+		WHERE s.current_kernel_id = $1
+		LIMIT 1;
+	`
+	var ownerID uuid.UUID
+	if err := r.db.QueryRow(ctx, query, kernelID).Scan(&ownerID); err != nil {
+		return uuid.Nil, err
+	}
+	return ownerID, nil
+}
+
+// GetSessionByKernelID retrieves the session currently running the given kernel, regardless of owner.
+func (r *sessionRepository) GetSessionByKernelID(ctx context.Context, kernelID uuid.UUID) (*models.Session, error) {
+	query := `
+		SELECT id, notebook_id, current_kernel_id, kernel_name, status, last_active_at, kernel_started_at
+		FROM sessions
+		WHERE current_kernel_id = $1
+		LIMIT 1;
+	`
+	row := r.db.QueryRow(ctx, query, kernelID)
+
+	var session models.Session
+	if err := row.Scan(
+		&session.ID,
+		&session.NotebookID,
+		&session.CurrentKernelID,
+		&session.KernelName,
+		&session.Status,
+		&session.LastActiveAt,
+		&session.KernelStartedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetLatestSessionByNotebookID returns the notebook's most recently active session. It returns
+// pgx.ErrNoRows when the notebook has never had one.
+func (r *sessionRepository) GetLatestSessionByNotebookID(ctx context.Context, notebookID uuid.UUID) (*models.Session, error) {
+	query := `
+		SELECT id, notebook_id, current_kernel_id, kernel_name, status, last_active_at, kernel_started_at
+		FROM sessions
+		WHERE notebook_id = $1
+		ORDER BY last_active_at DESC
+		LIMIT 1;
+	`
+	row := r.db.QueryRow(ctx, query, notebookID)
+
+	var session models.Session
+	if err := row.Scan(
+		&session.ID,
+		&session.NotebookID,
+		&session.CurrentKernelID,
+		&session.KernelName,
+		&session.Status,
+		&session.LastActiveAt,
+		&session.KernelStartedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// SetSessionStatus updates the status of a session without an ownership check, for background jobs.
+func (r *sessionRepository) SetSessionStatus(ctx context.Context, id uuid.UUID, status string) error {
+	query := `
+		UPDATE sessions
+		SET status = $2, last_active_at = $3
+		WHERE id = $1;
+	`
+	cmdTag, err := r.db.Exec(ctx, query, id, status, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateSessionKernel points a session at a replacement kernel and marks it active again.
+func (r *sessionRepository) UpdateSessionKernel(ctx context.Context, id uuid.UUID, kernelID uuid.UUID) error {
+	query := `
+		UPDATE sessions
+		SET current_kernel_id = $2, status = 'active', last_active_at = $3, kernel_started_at = $3
+		WHERE id = $1;
+	`
+	cmdTag, err := r.db.Exec(ctx, query, id, kernelID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// MarkKernelRestarted records that the session's kernel lost its state at the given time, so only
+// later executions are replayed onto it.
+func (r *sessionRepository) MarkKernelRestarted(ctx context.Context, kernelID uuid.UUID, at time.Time) error {
+	query := `
+		UPDATE sessions
+		SET kernel_started_at = $2
+		WHERE current_kernel_id = $1;
+	`
+	_, err := r.db.Exec(ctx, query, kernelID, at)
+	return err
+}
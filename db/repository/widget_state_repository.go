@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// WidgetStateRepository defines the data access methods for the saved ipywidgets state of notebooks.
+type WidgetStateRepository interface {
+	SaveWidgetState(ctx context.Context, notebookID uuid.UUID, kernelID uuid.UUID, state json.RawMessage) error
+	// GetWidgetState returns the saved state and the kernel it was captured from, or pgx.ErrNoRows.
+	GetWidgetState(ctx context.Context, notebookID uuid.UUID) (json.RawMessage, uuid.UUID, error)
+}
+
+type widgetStateRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewWidgetStateRepository creates a new WidgetStateRepository.
+func NewWidgetStateRepository(db *pgxpool.Pool) WidgetStateRepository {
+	return &widgetStateRepository{db: db}
+}
+
+// SaveWidgetState replaces the saved widget state of a notebook.
+func (r *widgetStateRepository) SaveWidgetState(ctx context.Context, notebookID uuid.UUID, kernelID uuid.UUID, state json.RawMessage) error {
+	query := `
+		INSERT INTO notebook_widget_states (notebook_id, kernel_id, state, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (notebook_id) DO UPDATE
+		SET kernel_id = excluded.kernel_id, state = excluded.state, updated_at = excluded.updated_at;
+	`
+	_, err := r.db.Exec(ctx, query, notebookID, kernelID, []byte(state), time.Now().UTC())
+	return err
+}
+
+// GetWidgetState retrieves the saved widget state of a notebook.
+func (r *widgetStateRepository) GetWidgetState(ctx context.Context, notebookID uuid.UUID) (json.RawMessage, uuid.UUID, error) {
+	query := `SELECT state, kernel_id FROM notebook_widget_states WHERE notebook_id = $1;`
+
+	var state []byte
+	var kernelID uuid.UUID
+	if err := r.db.QueryRow(ctx, query, notebookID).Scan(&state, &kernelID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, uuid.Nil, pgx.ErrNoRows
+		}
+		return nil, uuid.Nil, err
+	}
+	return state, kernelID, nil
+}
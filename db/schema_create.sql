@@ -109,6 +109,7 @@ CREATE TABLE IF NOT EXISTS notebooks (
   context_minio_url TEXT,
   problem_statement_id UUID REFERENCES problem_statements(id) ON DELETE CASCADE,
   requirements TEXT,
+  env_vars JSONB,
   created_at TIMESTAMPTZ NOT NULL,
   last_modified_at TIMESTAMPTZ NOT NULL
 );
@@ -117,8 +118,10 @@ CREATE TABLE IF NOT EXISTS sessions (
   id UUID PRIMARY KEY,
   notebook_id UUID REFERENCES notebooks(id) ON DELETE CASCADE,
   current_kernel_id UUID,
+  kernel_name TEXT NOT NULL DEFAULT '',
   status TEXT NOT NULL,
-  last_active_at TIMESTAMPTZ NOT NULL
+  last_active_at TIMESTAMPTZ NOT NULL,
+  kernel_started_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
 CREATE TABLE IF NOT EXISTS cells (
@@ -128,7 +131,8 @@ CREATE TABLE IF NOT EXISTS cells (
   cell_name TEXT,
   cell_type TEXT NOT NULL CHECK (cell_type IN ('code', 'markdown', 'raw')),
   source TEXT NOT NULL,
-  execution_count INT
+  execution_count INT,
+  metadata JSONB
 );
 
 CREATE TABLE IF NOT EXISTS cell_outputs (
@@ -138,9 +142,75 @@ CREATE TABLE IF NOT EXISTS cell_outputs (
   type TEXT NOT NULL CHECK (type IN ('stream', 'display_data', 'execute_result', 'error')),
   data_json JSONB,
   minio_url TEXT,
+  execution_count INT,
+  -- Blob store keys of the binary buffers the output message carried, in order.
+  buffer_keys TEXT[],
+  -- display_id of a display_data output, whose data update_display_data messages replace.
+  display_id TEXT,
+  -- Metadata of a display_data or execute_result output, such as image sizes.
+  metadata JSONB
+);
+
+-- Latest ipywidgets state of each notebook, in the application/vnd.jupyter.widget-state+json
+-- format, so widgets re-render when the notebook is reopened.
+CREATE TABLE IF NOT EXISTS notebook_widget_states (
+  notebook_id UUID PRIMARY KEY REFERENCES notebooks(id) ON DELETE CASCADE,
+  kernel_id UUID NOT NULL,
+  state JSONB NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Which gateway of the Jupyter gateway pool runs each kernel, so kernel
+-- requests keep reaching the right backend across controller restarts.
+CREATE TABLE IF NOT EXISTS kernel_gateways (
+  kernel_id UUID PRIMARY KEY,
+  gateway_id TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+-- One row per execute_request sent for a cell, kept when the cell or session is
+-- deleted so instructors can review what was actually run.
+CREATE TABLE IF NOT EXISTS executions (
+  id UUID PRIMARY KEY,
+  notebook_id UUID REFERENCES notebooks(id) ON DELETE CASCADE,
+  cell_id UUID REFERENCES cells(id) ON DELETE SET NULL,
+  session_id UUID REFERENCES sessions(id) ON DELETE SET NULL,
+  kernel_id UUID NOT NULL,
+  msg_id TEXT UNIQUE NOT NULL,
+  source TEXT NOT NULL,
+  started_at TIMESTAMPTZ NOT NULL,
+  finished_at TIMESTAMPTZ,
+  status TEXT NOT NULL CHECK (status IN ('queued', 'running', 'ok', 'error', 'aborted')),
   execution_count INT
 );
 
+-- The message log of each execution: its execute_request and the iopub, shell
+-- and stdin messages the kernel sent in reply, in the order they were seen.
+CREATE TABLE IF NOT EXISTS execution_messages (
+  execution_id UUID REFERENCES executions(id) ON DELETE CASCADE,
+  seq INT NOT NULL,
+  channel TEXT NOT NULL,
+  msg_type TEXT NOT NULL,
+  content JSONB,
+  received_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (execution_id, seq)
+);
+
+-- Immutable records of headless, parameterized notebook runs. The executed copy
+-- of the notebook (cells and outputs) is stored as a snapshot in notebook_json.
+CREATE TABLE IF NOT EXISTS notebook_executions (
+  id UUID PRIMARY KEY,
+  notebook_id UUID REFERENCES notebooks(id) ON DELETE CASCADE,
+  created_by UUID REFERENCES users(id) ON DELETE CASCADE,
+  kernel_name TEXT NOT NULL,
+  parameters JSONB NOT NULL,
+  status TEXT NOT NULL CHECK (status IN ('running', 'completed', 'failed')),
+  error TEXT,
+  notebook_json JSONB,
+  started_at TIMESTAMPTZ NOT NULL,
+  finished_at TIMESTAMPTZ
+);
+
 CREATE TABLE IF NOT EXISTS evolution_runs (
   id UUID PRIMARY KEY,
   source_cell_id UUID REFERENCES cells(id) ON DELETE CASCADE,
@@ -165,3 +235,9 @@ CREATE TABLE cell_variations (
 -- =============================================================================
 
 CREATE INDEX IF NOT EXISTS idx_password_reset_user_id ON password_reset_otps(user_id);
+CREATE INDEX IF NOT EXISTS idx_executions_cell_id ON executions(cell_id);
+CREATE INDEX IF NOT EXISTS idx_executions_notebook_id ON executions(notebook_id);
+CREATE INDEX IF NOT EXISTS idx_executions_kernel_id ON executions(kernel_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_notebook_executions_notebook_id ON notebook_executions(notebook_id);
+CREATE INDEX IF NOT EXISTS idx_cell_outputs_display_id ON cell_outputs(display_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_notebook_id ON sessions(notebook_id, last_active_at DESC);
@@ -3,6 +3,10 @@
 -- The order respects foreign key constraints.
 
 DROP TABLE IF EXISTS cell_variations;
+DROP TABLE IF EXISTS notebook_executions;
+DROP TABLE IF EXISTS executions;
+DROP TABLE IF EXISTS kernel_gateways;
+DROP TABLE IF EXISTS notebook_widget_states;
 DROP TABLE IF EXISTS cell_outputs;
 DROP TABLE IF EXISTS evolution_runs;
 DROP TABLE IF EXISTS cells;
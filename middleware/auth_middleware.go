@@ -16,6 +16,9 @@ const (
 	UserContextKey = contextKey("user")
 )
 
+// RoleAdmin is the role that may act on every user's resources.
+const RoleAdmin = "admin"
+
 type User struct {
 	ID       string
 	Role     string
@@ -24,6 +27,11 @@ type User struct {
 	FullName string
 }
 
+// IsAdmin reports whether the user has the admin role.
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("t")
@@ -1,11 +1,17 @@
 package modules
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
@@ -13,14 +19,17 @@ import (
 
 // CellModule encapsulates the business logic for cells.
 type CellModule struct {
-	Repo   repository.CellRepository
+	Repo repository.CellRepository
+	// Blobs holds the buffers and the offloaded data of the outputs.
+	Blobs  blobstore.Store
 	Logger zerolog.Logger
 }
 
 // NewCellModule creates and returns a new CellModule.
-func NewCellModule(repo repository.CellRepository, logger zerolog.Logger) *CellModule {
+func NewCellModule(repo repository.CellRepository, blobs blobstore.Store, logger zerolog.Logger) *CellModule {
 	return &CellModule{
 		Repo:   repo,
+		Blobs:  blobs,
 		Logger: logger,
 	}
 }
@@ -44,6 +53,7 @@ func (m *CellModule) CreateCell(
 		},
 		CellType: req.CellType,
 		Source:   req.Source,
+		Metadata: req.Metadata,
 	}
 
 	return m.Repo.CreateCell(ctx, cell)
@@ -80,6 +90,9 @@ func (m *CellModule) UpdateCell(ctx context.Context, id uuid.UUID, req *models.U
 	if req.ExecutionCount != nil {
 		cell.ExecutionCount = *req.ExecutionCount
 	}
+	if req.Metadata != nil {
+		cell.Metadata = req.Metadata
+	}
 
 	return m.Repo.UpdateCell(ctx, cell, userID)
 }
@@ -91,12 +104,33 @@ func (m *CellModule) UpdateCells(ctx context.Context, notebookID uuid.UUID, req
 		Int("upsert_count", len(req.CellsToUpsert)).
 		Msg("Updating cells in module")
 	// Ownership is verified in the controller.
-	return m.Repo.UpdateCells(ctx, notebookID, req)
+	deleted, err := m.Repo.UpdateCells(ctx, notebookID, req)
+	if err != nil {
+		return err
+	}
+	m.deleteOutputsBlobs(ctx, deleted)
+	return nil
 }
 
 func (m *CellModule) DeleteCell(ctx context.Context, id uuid.UUID, userID string) error {
 	// Ownership is verified in the controller by calling GetCellByID first.
-	return m.Repo.DeleteCell(ctx, id, userID)
+	outputs, err := m.Repo.GetCellOutputsByCellID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := m.Repo.DeleteCell(ctx, id, userID); err != nil {
+		return err
+	}
+	m.deleteOutputsBlobs(ctx, outputs)
+	return nil
+}
+
+// deleteOutputsBlobs removes the blobs of deleted outputs. The outputs are gone either way; blobs
+// that fail to delete are merely orphaned.
+func (m *CellModule) deleteOutputsBlobs(ctx context.Context, outputs []*models.CellOutput) {
+	if err := deleteOutputsBlobs(ctx, m.Blobs, outputs); err != nil {
+		m.Logger.Warn().Err(err).Int("count", len(outputs)).Msg("CellModule: Failed to delete output blobs")
+	}
 }
 
 func (m *CellModule) CreateCellOutput(ctx context.Context, req *models.CreateCellOutputRequest) (*models.CellOutput, error) {
@@ -112,10 +146,22 @@ func (m *CellModule) CreateCellOutput(ctx context.Context, req *models.CreateCel
 		DataJSON:    req.DataJSON,
 		MinioURL:    req.MinioURL,
 	}
+	// Data that already points to the blob store is saved as it is.
+	if output.MinioURL == "" {
+		if err := offloadOutputData(ctx, m.Blobs, output); err != nil {
+			// What could not be offloaded is kept inline.
+			m.Logger.Warn().Err(err).Str("generated_output_id", output.ID.String()).Msg("CellModule: Failed to offload output data")
+		}
+	}
 	m.Logger.Debug().Str("generated_output_id", output.ID.String()).Str("cell_id", output.CellID.ToUUID().String()).Msg("CellModule: Creating cell output")
 	createdOutput, err := m.Repo.CreateCellOutput(ctx, output)
 	if err != nil {
 		m.Logger.Error().Err(err).Msg("CellModule: Failed to create cell output in repository")
+		if output.MinioURL == outputDataPrefix(output.ID) {
+			if err := deleteOutputBlobs(ctx, m.Blobs, output); err != nil {
+				m.Logger.Warn().Err(err).Str("generated_output_id", output.ID.String()).Msg("CellModule: Failed to delete output blobs")
+			}
+		}
 		return nil, err
 	}
 	m.Logger.Info().Str("created_output_id", createdOutput.ID.String()).Msg("CellModule: Successfully created cell output in repository")
@@ -131,7 +177,95 @@ func (m *CellModule) GetCellOutputByID(ctx context.Context, outputID uuid.UUID,
 	return m.Repo.GetCellOutputByID(ctx, outputID, userID)
 }
 
+// OpenCellOutputData returns the content of one mime type of an output's data, read from the blob
+// store when it was offloaded there. It returns ErrOutputDataNotFound when the output has no such data.
+func (m *CellModule) OpenCellOutputData(ctx context.Context, output *models.CellOutput, mime string) (io.ReadCloser, error) {
+	// Ownership is verified in the controller.
+	return openOutputData(ctx, m.Blobs, output, mime)
+}
+
 func (m *CellModule) DeleteCellOutput(ctx context.Context, id uuid.UUID, userID string) error {
 	// Ownership is verified in the controller.
-	return m.Repo.DeleteCellOutput(ctx, id, userID)
+	output, err := m.Repo.GetCellOutputByID(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := m.Repo.DeleteCellOutput(ctx, id, userID); err != nil {
+		return err
+	}
+	if err := deleteOutputBlobs(ctx, m.Blobs, output); err != nil {
+		// The output is gone; its blobs are merely orphaned.
+		m.Logger.Warn().Err(err).Str("output_id", id.String()).Msg("CellModule: Failed to delete output blobs")
+	}
+	return nil
+}
+
+// CellOutputFromMessage converts a kernel output message (stream, display_data, execute_result
+// or error) into an unsaved CellOutput. The caller assigns the cell and output index.
+func CellOutputFromMessage(msg *jupyterclient.Message) (*models.CellOutput, error) {
+	output := &models.CellOutput{
+		ID:   uuid.New(),
+		Type: msg.Header.MsgType,
+	}
+
+	var err error
+	switch msg.Header.MsgType {
+	case "stream":
+		var content jupyterclient.StreamContent
+		if err = json.Unmarshal(msg.Content, &content); err == nil {
+			output.DataJSON, err = json.Marshal(content)
+		}
+	case "display_data":
+		var content jupyterclient.DisplayDataContent
+		if err = json.Unmarshal(msg.Content, &content); err == nil {
+			output.DisplayID = content.DisplayID()
+			output.DataJSON, err = json.Marshal(content.Data)
+			if err == nil && len(content.Metadata) > 0 {
+				output.Metadata, err = json.Marshal(content.Metadata)
+			}
+		}
+	case "execute_result":
+		var content jupyterclient.ExecuteResultContent
+		if err = json.Unmarshal(msg.Content, &content); err == nil {
+			output.ExecutionCount = content.ExecutionCount
+			output.DataJSON, err = json.Marshal(content.Data)
+			if err == nil && len(content.Metadata) > 0 {
+				output.Metadata, err = json.Marshal(content.Metadata)
+			}
+		}
+	case "error":
+		var content jupyterclient.ErrorContent
+		if err = json.Unmarshal(msg.Content, &content); err == nil {
+			output.DataJSON, err = json.Marshal(content)
+		}
+	default:
+		return nil, fmt.Errorf("message type '%s' is not a cell output", msg.Header.MsgType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s content: %w", msg.Header.MsgType, err)
+	}
+
+	return output, nil
+}
+
+// storeOutputBuffers saves the binary buffers of an output message to the blob store and records their
+// keys on the output, which must already have its ID.
+func storeOutputBuffers(ctx context.Context, blobs blobstore.Store, output *models.CellOutput, buffers [][]byte) error {
+	if len(buffers) == 0 {
+		return nil
+	}
+	if blobs == nil {
+		return errors.New("no blob store configured for output buffers")
+	}
+
+	keys := make([]string, 0, len(buffers))
+	for i, buffer := range buffers {
+		key := fmt.Sprintf("outputs/%s/buffers/%d", output.ID, i)
+		if err := blobs.Put(ctx, key, bytes.NewReader(buffer), int64(len(buffer)), "application/octet-stream"); err != nil {
+			return fmt.Errorf("failed to store output buffer %d: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+	output.BufferKeys = keys
+	return nil
 }
@@ -0,0 +1,145 @@
+package modules
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// CellOutputRecorder persists the outputs of executions sent through the kernel websocket proxy.
+// It is registered as the kernel hub observer, so it sees each kernel message exactly once.
+type CellOutputRecorder struct {
+	CellRepo repository.CellRepository
+	// Blobs receives the binary buffers of the outputs.
+	Blobs  blobstore.Store
+	Logger zerolog.Logger
+
+	mu sync.Mutex
+	// executions holds the writer of each execute_request with a cell_id, by msg_id.
+	executions map[string]*recordedExecution
+}
+
+// recordedExecution is an execution whose outputs are being recorded.
+type recordedExecution struct {
+	kernelID string
+	cellID   uuid.UUID
+	writer   *outputWriter
+}
+
+// NewCellOutputRecorder creates and returns a new CellOutputRecorder.
+func NewCellOutputRecorder(cellRepo repository.CellRepository, blobs blobstore.Store, logger zerolog.Logger) *CellOutputRecorder {
+	return &CellOutputRecorder{
+		CellRepo:   cellRepo,
+		Blobs:      blobs,
+		Logger:     logger,
+		executions: make(map[string]*recordedExecution),
+	}
+}
+
+// FromClient starts recording an execute_request's outputs against the cell_id in its metadata.
+// Its outputs replace those of the cell's previous execution, which are cleared right away.
+func (r *CellOutputRecorder) FromClient(kernelID string, msg *jupyterclient.Message) {
+	if msg.Header.MsgType != "execute_request" {
+		return
+	}
+
+	var metadata map[string]any
+	if err := json.Unmarshal(msg.Metadata, &metadata); err != nil {
+		r.Logger.Warn().Err(err).Msg("Failed to unmarshal execute_request metadata")
+		return
+	}
+	cellIDStr, ok := metadata["cell_id"].(string)
+	if !ok {
+		r.Logger.Warn().Interface("metadata", metadata).Msg("cell_id not found in execute_request metadata or is not a string")
+		return
+	}
+	cellID, err := uuid.Parse(cellIDStr)
+	if err != nil {
+		r.Logger.Warn().Err(err).Str("cell_id_str", cellIDStr).Msg("Failed to parse cell_id from metadata")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.executions[msg.Header.MsgID]; ok {
+		return
+	}
+	r.executions[msg.Header.MsgID] = &recordedExecution{
+		kernelID: kernelID,
+		cellID:   cellID,
+		writer:   newOutputWriter(cellID, r.CellRepo, r.Blobs, r.Logger, false),
+	}
+	r.Logger.Debug().Str("msg_id", msg.Header.MsgID).Str("cell_id", cellID.String()).Msg("Recording execution outputs")
+}
+
+// FromKernel hands output messages to the writer of the execute_request that produced them. The
+// execute_reply has the outputs received before it saved right away, without holding up the hub's
+// read loop. The writer is closed once the kernel reports idle for the request, which follows its
+// last output on iopub, or when the kernel restarts or dies.
+func (r *CellOutputRecorder) FromKernel(kernelID string, msg *jupyterclient.Message) {
+	switch msg.Header.MsgType {
+	case "status":
+		var status jupyterclient.StatusContent
+		if err := json.Unmarshal(msg.Content, &status); err != nil {
+			return
+		}
+		switch status.ExecutionState {
+		case "idle":
+			r.finish(func(msgID string, _ *recordedExecution) bool { return msgID == msg.ParentHeader.MsgID })
+		case "restarting", "dead":
+			// Requests still running never report idle.
+			r.finish(func(_ string, execution *recordedExecution) bool { return execution.kernelID == kernelID })
+		}
+
+	case "execute_reply":
+		if execution := r.execution(msg.ParentHeader.MsgID); execution != nil {
+			execution.writer.Flush()
+		}
+
+	case "stream", "display_data", "update_display_data", "execute_result", "error", "clear_output":
+		if execution := r.execution(msg.ParentHeader.MsgID); execution != nil {
+			execution.writer.Write(msg)
+		}
+	}
+}
+
+// KernelGone stops recording the executions of a kernel that died or left the kernel hub.
+func (r *CellOutputRecorder) KernelGone(kernelID string) {
+	r.finish(func(_ string, execution *recordedExecution) bool { return execution.kernelID == kernelID })
+}
+
+func (r *CellOutputRecorder) execution(msgID string) *recordedExecution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.executions[msgID]
+}
+
+// finish stops recording the executions match selects. Their remaining outputs are saved in the
+// background, as closing a writer waits for the database.
+func (r *CellOutputRecorder) finish(match func(msgID string, execution *recordedExecution) bool) {
+	r.mu.Lock()
+	var finished []*recordedExecution
+	for msgID, execution := range r.executions {
+		if match(msgID, execution) {
+			finished = append(finished, execution)
+			delete(r.executions, msgID)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, execution := range finished {
+		go r.close(execution)
+	}
+}
+
+func (r *CellOutputRecorder) close(execution *recordedExecution) {
+	if err := execution.writer.Close(); err != nil {
+		r.Logger.Error().Err(err).Str("cell_id", execution.cellID.String()).Msg("Some outputs of the execution were not saved")
+	}
+	r.Logger.Debug().Str("cell_id", execution.cellID.String()).Msg("Execution finished, stopped recording its outputs")
+}
@@ -0,0 +1,399 @@
+package modules_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// memoryCellOutputRepo keeps cell outputs in memory; methods the tests do not need are left to the embedded interface.
+type memoryCellOutputRepo struct {
+	repository.CellRepository
+
+	mu      sync.Mutex
+	outputs map[uuid.UUID]models.CellOutput
+	batches int
+	// failDeletes is how many more calls to DeleteCellOutputsByCellID fail; deletes counts the calls.
+	failDeletes int
+	deletes     int
+}
+
+func (r *memoryCellOutputRepo) SaveCellOutputs(ctx context.Context, outputs []*models.CellOutput) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, output := range outputs {
+		r.outputs[output.ID] = *output
+	}
+	r.batches++
+	return nil
+}
+
+func (r *memoryCellOutputRepo) GetCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) ([]*models.CellOutput, error) {
+	var outputs []*models.CellOutput
+	for _, output := range r.sorted() {
+		if output.CellID.ToUUID() == cellID {
+			outputs = append(outputs, &output)
+		}
+	}
+	return outputs, nil
+}
+
+func (r *memoryCellOutputRepo) DeleteCellOutputsByCellID(ctx context.Context, cellID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deletes++
+	if r.failDeletes > 0 {
+		r.failDeletes--
+		return errors.New("database unavailable")
+	}
+	for id, output := range r.outputs {
+		if output.CellID.ToUUID() == cellID {
+			delete(r.outputs, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryCellOutputRepo) CreateCellOutput(ctx context.Context, output *models.CellOutput) (*models.CellOutput, error) {
+	return output, r.SaveCellOutputs(ctx, []*models.CellOutput{output})
+}
+
+func (r *memoryCellOutputRepo) GetDisplayOutputs(ctx context.Context, cellID uuid.UUID, displayID string) ([]*models.CellOutput, error) {
+	var outputs []*models.CellOutput
+	for _, output := range r.sorted() {
+		if output.DisplayID == displayID {
+			outputs = append(outputs, &output)
+		}
+	}
+	return outputs, nil
+}
+
+func (r *memoryCellOutputRepo) UpdateCellOutputData(ctx context.Context, outputID uuid.UUID, data json.RawMessage, minioURL string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if output, ok := r.outputs[outputID]; ok {
+		output.DataJSON, output.MinioURL = data, minioURL
+		r.outputs[outputID] = output
+	}
+	return nil
+}
+
+// sorted returns the stored outputs by index.
+func (r *memoryCellOutputRepo) sorted() []models.CellOutput {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	outputs := make([]models.CellOutput, 0, len(r.outputs))
+	for _, output := range r.outputs {
+		outputs = append(outputs, output)
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].OutputIndex < outputs[j].OutputIndex })
+	return outputs
+}
+
+// waitForOutputs returns the stored outputs once done accepts them, or after five seconds, as the
+// recorder saves them in the background.
+func (r *memoryCellOutputRepo) waitForOutputs(done func(outputs []models.CellOutput) bool) []models.CellOutput {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		outputs := r.sorted()
+		if done(outputs) || time.Now().After(deadline) {
+			return outputs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// outputCount accepts stored outputs when there are n of them.
+func outputCount(n int) func(outputs []models.CellOutput) bool {
+	return func(outputs []models.CellOutput) bool { return len(outputs) == n }
+}
+
+func kernelMessage(t *testing.T, channel, msgType string, parent *jupyterclient.Message, content any) *jupyterclient.Message {
+	t.Helper()
+	msg, err := jupyterclient.NewMessage(channel, msgType, "test", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent != nil {
+		msg.ParentHeader = parent.Header
+	}
+	return msg
+}
+
+// startExecution passes an execute_request for the cell to the recorder.
+func startExecution(t *testing.T, recorder *modules.CellOutputRecorder, kernelID string, cellID uuid.UUID) *jupyterclient.Message {
+	t.Helper()
+	request := kernelMessage(t, jupyterclient.ChannelShell, "execute_request", nil, jupyterclient.ExecuteRequestContent{Code: "run()"})
+	request.Metadata = json.RawMessage(fmt.Sprintf(`{"cell_id": %q}`, cellID))
+	recorder.FromClient(kernelID, request)
+	return request
+}
+
+func TestCellOutputRecorder(t *testing.T) {
+	repo := &memoryCellOutputRepo{outputs: make(map[uuid.UUID]models.CellOutput)}
+	recorder := modules.NewCellOutputRecorder(repo, nil, zerolog.Nop())
+	kernelID := uuid.NewString()
+	cellID := uuid.New()
+	stale := models.CellOutput{ID: uuid.New(), CellID: models.StringUUID(cellID), Type: "stream"}
+	repo.outputs[stale.ID] = stale
+
+	request := startExecution(t, recorder, kernelID, cellID)
+
+	stream := func(name, text string) *jupyterclient.Message {
+		return kernelMessage(t, jupyterclient.ChannelIOPub, "stream", request, jupyterclient.StreamContent{Name: name, Text: text})
+	}
+	var want string
+	for i := 0; i < 500; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		want += line
+		recorder.FromKernel(kernelID, stream("stdout", line))
+	}
+	recorder.FromKernel(kernelID, stream("stderr", "warning\n"))
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelIOPub, "display_data", request,
+		jupyterclient.DisplayDataContent{Data: map[string]any{"text/plain": "figure"}}))
+	recorder.FromKernel(kernelID, stream("stdout", "done\n"))
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelShell, "execute_reply", request,
+		jupyterclient.ExecuteReplyContent{Status: "ok", ExecutionCount: 1}))
+
+	// Everything before the execute_reply is saved without waiting for the next batch, in place of
+	// the outputs of the previous execution.
+	wantTypes := []string{"stream", "stream", "display_data", "stream"}
+	outputs := repo.waitForOutputs(outputCount(len(wantTypes)))
+	if len(outputs) != len(wantTypes) {
+		t.Fatalf("got %d outputs, want %d: %+v", len(outputs), len(wantTypes), outputs)
+	}
+	for i, output := range outputs {
+		if output.OutputIndex != i || output.Type != wantTypes[i] || output.CellID.ToUUID() != cellID {
+			t.Errorf("output %d = index %d, type %s, cell %s; want index %d, type %s, cell %s",
+				i, output.OutputIndex, output.Type, output.CellID.ToUUID(), i, wantTypes[i], cellID)
+		}
+	}
+	var merged jupyterclient.StreamContent
+	if err := json.Unmarshal(outputs[0].DataJSON, &merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Name != "stdout" || merged.Text != want {
+		t.Errorf("merged stream = %s %q..., want the 500 stdout chunks in order", merged.Name, merged.Text[:min(len(merged.Text), 20)])
+	}
+	if repo.batches > 10 {
+		t.Errorf("outputs were saved in %d batches, want them batched", repo.batches)
+	}
+
+	// Outputs arriving after the reply are still saved, after the others, when the kernel goes idle.
+	recorder.FromKernel(kernelID, stream("stderr", "late\n"))
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelIOPub, "status", request,
+		jupyterclient.StatusContent{ExecutionState: "idle"}))
+	outputs = repo.waitForOutputs(outputCount(5))
+	if len(outputs) != 5 || outputs[4].OutputIndex != 4 || outputs[4].Type != "stream" {
+		t.Errorf("outputs after idle = %+v, want the late stream output at index 4", outputs)
+	}
+
+	// The execution is forgotten once the kernel is idle.
+	recorder.FromKernel(kernelID, stream("stdout", "ignored\n"))
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelShell, "execute_reply", request,
+		jupyterclient.ExecuteReplyContent{Status: "ok"}))
+	if got := len(repo.sorted()); got != 5 {
+		t.Errorf("got %d outputs after the execution finished, want 5", got)
+	}
+}
+
+func TestCellOutputRecorderRetriesClear(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		failDeletes int
+		wantNew     bool
+	}{
+		{name: "recovers", failDeletes: 2, wantNew: true},
+		{name: "gives up", failDeletes: 3, wantNew: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &memoryCellOutputRepo{outputs: make(map[uuid.UUID]models.CellOutput), failDeletes: tc.failDeletes}
+			recorder := modules.NewCellOutputRecorder(repo, nil, zerolog.Nop())
+			kernelID := uuid.NewString()
+			cellID := uuid.New()
+			stale := models.CellOutput{ID: uuid.New(), CellID: models.StringUUID(cellID), Type: "error"}
+			repo.outputs[stale.ID] = stale
+
+			request := startExecution(t, recorder, kernelID, cellID)
+			recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelIOPub, "stream", request,
+				jupyterclient.StreamContent{Name: "stdout", Text: "hi\n"}))
+			recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelShell, "execute_reply", request,
+				jupyterclient.ExecuteReplyContent{Status: "ok"}))
+
+			// The outputs are never saved next to the stale ones; they wait for a clear to succeed.
+			outputs := repo.waitForOutputs(func(outputs []models.CellOutput) bool {
+				repo.mu.Lock()
+				defer repo.mu.Unlock()
+				return repo.deletes >= 3 && (!tc.wantNew || len(outputs) == 1 && outputs[0].Type == "stream")
+			})
+			if tc.wantNew {
+				if len(outputs) != 1 || outputs[0].Type != "stream" {
+					t.Errorf("outputs = %+v, want the stream output in place of the stale one", outputs)
+				}
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+			outputs = repo.sorted()
+			if len(outputs) != 1 || outputs[0].ID != stale.ID {
+				t.Errorf("outputs = %+v, want the stale output kept and the new ones dropped", outputs)
+			}
+			repo.mu.Lock()
+			defer repo.mu.Unlock()
+			if repo.deletes != 3 {
+				t.Errorf("the clear was tried %d times, want 3", repo.deletes)
+			}
+		})
+	}
+}
+
+func TestCellOutputRecorderClearAndUpdate(t *testing.T) {
+	repo := &memoryCellOutputRepo{outputs: make(map[uuid.UUID]models.CellOutput)}
+	recorder := modules.NewCellOutputRecorder(repo, nil, zerolog.Nop())
+	kernelID := uuid.NewString()
+	cellID := uuid.New()
+
+	request := startExecution(t, recorder, kernelID, cellID)
+	send := func(msgType string, content any) {
+		channel := jupyterclient.ChannelIOPub
+		if msgType == "execute_reply" {
+			channel = jupyterclient.ChannelShell
+		}
+		recorder.FromKernel(kernelID, kernelMessage(t, channel, msgType, request, content))
+	}
+	display := func(text string) jupyterclient.DisplayDataContent {
+		return jupyterclient.DisplayDataContent{
+			Data:      map[string]any{"text/plain": text},
+			Transient: map[string]any{"display_id": "progress"},
+		}
+	}
+
+	send("stream", jupyterclient.StreamContent{Name: "stdout", Text: "epoch 1\n"})
+	// A waiting clear keeps the outputs until the next one arrives.
+	send("clear_output", jupyterclient.ClearOutputContent{Wait: true})
+	send("execute_reply", jupyterclient.ExecuteReplyContent{Status: "ok"})
+	if outputs := repo.waitForOutputs(outputCount(1)); len(outputs) != 1 {
+		t.Fatalf("got %d outputs after clear_output with wait, want the 1 output kept until the next", len(outputs))
+	}
+
+	send("stream", jupyterclient.StreamContent{Name: "stdout", Text: "epoch 2\n"})
+	send("display_data", display("0%"))
+	send("update_display_data", display("100%"))
+	send("execute_reply", jupyterclient.ExecuteReplyContent{Status: "ok"})
+
+	outputs := repo.waitForOutputs(func(outputs []models.CellOutput) bool {
+		return len(outputs) == 2 && string(outputs[0].DataJSON) == `{"name":"stdout","text":"epoch 2\n"}` &&
+			string(outputs[1].DataJSON) == `{"text/plain":"100%"}`
+	})
+	if len(outputs) != 2 {
+		t.Fatalf("got %d outputs, want the stream and the display: %+v", len(outputs), outputs)
+	}
+	if outputs[0].OutputIndex != 0 || string(outputs[0].DataJSON) != `{"name":"stdout","text":"epoch 2\n"}` {
+		t.Errorf("first output = %d %s, want the stream after the clear at index 0", outputs[0].OutputIndex, outputs[0].DataJSON)
+	}
+	if outputs[1].DisplayID != "progress" || string(outputs[1].DataJSON) != `{"text/plain":"100%"}` {
+		t.Errorf("display output = %q %s, want display progress updated in place", outputs[1].DisplayID, outputs[1].DataJSON)
+	}
+
+	send("clear_output", jupyterclient.ClearOutputContent{})
+	send("execute_reply", jupyterclient.ExecuteReplyContent{Status: "ok"})
+	if outputs := repo.waitForOutputs(outputCount(0)); len(outputs) != 0 {
+		t.Errorf("got %d outputs after clear_output, want none", len(outputs))
+	}
+}
+
+func TestCellOutputRecorderOffloadsData(t *testing.T) {
+	blobs, err := blobstore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &memoryCellOutputRepo{outputs: make(map[uuid.UUID]models.CellOutput)}
+	recorder := modules.NewCellOutputRecorder(repo, blobs, zerolog.Nop())
+	cells := modules.NewCellModule(repo, blobs, zerolog.Nop())
+	kernelID := uuid.NewString()
+	cellID := uuid.New()
+
+	png := []byte("\x89PNG\r\n\x1a\nimage")
+	request := startExecution(t, recorder, kernelID, cellID)
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelIOPub, "display_data", request, jupyterclient.DisplayDataContent{
+		Data:      map[string]any{"image/png": base64.StdEncoding.EncodeToString(png), "text/plain": "Figure 1"},
+		Transient: map[string]any{"display_id": "figure"},
+	}))
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelShell, "execute_reply", request,
+		jupyterclient.ExecuteReplyContent{Status: "ok"}))
+
+	outputs := repo.waitForOutputs(outputCount(1))
+	if len(outputs) != 1 {
+		t.Fatalf("got %d outputs, want 1", len(outputs))
+	}
+	output := outputs[0]
+	if output.MinioURL == "" || string(output.DataJSON) != `{"image/png":null,"text/plain":"Figure 1"}` {
+		t.Fatalf("output = %s at %q, want the PNG offloaded and the text kept inline", output.DataJSON, output.MinioURL)
+	}
+
+	for mime, want := range map[string]string{"image/png": string(png), "text/plain": "Figure 1"} {
+		data, err := cells.OpenCellOutputData(context.Background(), &output, mime)
+		if err != nil {
+			t.Fatalf("OpenCellOutputData(%s): %v", mime, err)
+		}
+		got, _ := io.ReadAll(data)
+		data.Close()
+		if string(got) != want {
+			t.Errorf("%s data = %q, want %q", mime, got, want)
+		}
+	}
+	if _, err := cells.OpenCellOutputData(context.Background(), &output, "text/html"); !errors.Is(err, modules.ErrOutputDataNotFound) {
+		t.Errorf("OpenCellOutputData(text/html) error = %v, want ErrOutputDataNotFound", err)
+	}
+
+	// Another cell updating the display offloads its new data and deletes the blobs it replaced.
+	other := startExecution(t, recorder, kernelID, uuid.New())
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelIOPub, "update_display_data", other, jupyterclient.DisplayDataContent{
+		Data:      map[string]any{"image/jpeg": base64.StdEncoding.EncodeToString([]byte("jpeg")), "text/plain": "Figure 2"},
+		Transient: map[string]any{"display_id": "figure"},
+	}))
+	updated := repo.waitForOutputs(func(outputs []models.CellOutput) bool {
+		return len(outputs) == 1 && string(outputs[0].DataJSON) == `{"image/jpeg":null,"text/plain":"Figure 2"}`
+	})[0]
+	if updated.MinioURL == "" {
+		t.Errorf("updated display = %s at %q, want the JPEG offloaded", updated.DataJSON, updated.MinioURL)
+	}
+	if _, err := blobs.Get(context.Background(), output.MinioURL+"/image/png"); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("blob of the replaced PNG: error = %v, want ErrNotFound", err)
+	}
+	output = updated
+
+	// Running the cell again deletes the blobs of its previous outputs.
+	rerun := startExecution(t, recorder, kernelID, cellID)
+	recorder.FromKernel(kernelID, kernelMessage(t, jupyterclient.ChannelShell, "execute_reply", rerun,
+		jupyterclient.ExecuteReplyContent{Status: "ok"}))
+	repo.waitForOutputs(outputCount(0))
+	if _, err := blobs.Get(context.Background(), output.MinioURL+"/image/jpeg"); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("blob of the previous run's JPEG: error = %v, want ErrNotFound", err)
+	}
+
+	// Outputs created through the API are offloaded too.
+	created, err := cells.CreateCellOutput(context.Background(), &models.CreateCellOutputRequest{
+		CellID: models.StringUUID(cellID), Type: "display_data", DataJSON: json.RawMessage(`{"image/png": "` + base64.StdEncoding.EncodeToString(png) + `"}`),
+	})
+	if err != nil {
+		t.Fatalf("CreateCellOutput: %v", err)
+	}
+	if created.MinioURL == "" || string(created.DataJSON) != `{"image/png":null}` {
+		t.Errorf("created output = %s at %q, want the PNG offloaded", created.DataJSON, created.MinioURL)
+	}
+}
@@ -0,0 +1,458 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrCellNotInSession is returned when a cell does not belong to the session's notebook.
+	ErrCellNotInSession = errors.New("cell does not belong to the session's notebook")
+	// ErrNotCodeCell is returned when execution is requested for a markdown or raw cell.
+	ErrNotCodeCell = errors.New("only code cells can be executed")
+	// ErrInputRequired is returned when executed code asks for more input than the caller queued.
+	ErrInputRequired = errors.New("execution requested input but no queued input is left")
+	// ErrCellNotFound is returned when a cell does not exist or the user does not own it.
+	ErrCellNotFound = repository.ErrCellNotFound
+	// ErrInvalidNotebookID is returned when a notebook ID is not a UUID.
+	ErrInvalidNotebookID = errors.New("invalid notebook ID format")
+	// ErrExecutionNotFound is returned when an execution does not exist or the user does not own its notebook.
+	ErrExecutionNotFound = errors.New("execution not found")
+)
+
+// inputQueue answers a kernel's input_requests, in order, with the inputs queued by the caller.
+// It is shared by the cells of a run so that each input() consumes the next value.
+type inputQueue struct {
+	values []string
+}
+
+func newInputQueue(values []string) *inputQueue {
+	return &inputQueue{values: append([]string(nil), values...)}
+}
+
+// next returns the next queued input, or ErrInputRequired so the execution fails instead of blocking.
+func (q *inputQueue) next(req jupyterclient.InputRequestContent) (string, error) {
+	if len(q.values) == 0 {
+		return "", fmt.Errorf("%w (prompt: %q)", ErrInputRequired, req.Prompt)
+	}
+	value := q.values[0]
+	q.values = q.values[1:]
+	return value, nil
+}
+
+// ExecutionModule runs notebook code on session kernels from the controller itself,
+// without a browser holding the kernel websocket.
+type ExecutionModule struct {
+	SessionRepo   repository.SessionRepository
+	CellRepo      repository.CellRepository
+	ExecutionRepo repository.ExecutionRepository
+	NotebookRepo  repository.NotebookRepository
+	Jupyter       jupyterclient.Gateway
+	// Blobs receives the binary buffers of the outputs.
+	Blobs  blobstore.Store
+	Logger zerolog.Logger
+	// Events receives the start and end of the executions and the restarts of notebook runs.
+	Events *events.Bus
+}
+
+// NewExecutionModule creates and returns a new ExecutionModule.
+func NewExecutionModule(
+	sessionRepo repository.SessionRepository,
+	cellRepo repository.CellRepository,
+	executionRepo repository.ExecutionRepository,
+	notebookRepo repository.NotebookRepository,
+	jupyter jupyterclient.Gateway,
+	blobs blobstore.Store,
+	logger zerolog.Logger,
+) *ExecutionModule {
+	return &ExecutionModule{
+		SessionRepo:   sessionRepo,
+		CellRepo:      cellRepo,
+		ExecutionRepo: executionRepo,
+		NotebookRepo:  notebookRepo,
+		Jupyter:       jupyter,
+		Blobs:         blobs,
+		Logger:        logger,
+	}
+}
+
+// ExecuteCell runs the stored source of a cell on the session's current kernel, persists
+// the outputs and records the new execution count on the cell. Calls to input() are answered
+// from inputs in order.
+func (m *ExecutionModule) ExecuteCell(ctx context.Context, sessionID uuid.UUID, cellID uuid.UUID, userID string, inputs []string) (*models.CellExecutionResult, error) {
+	session, cell, err := m.loadSessionCell(ctx, sessionID, cellID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, session.CurrentKernelID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to session kernel: %w", err)
+	}
+	defer kc.Close()
+
+	return m.executeCell(ctx, kc, session, cell, userID, newInputQueue(inputs))
+}
+
+// loadSessionCell fetches the session and cell, enforcing ownership and that the cell is runnable code in the session's notebook.
+func (m *ExecutionModule) loadSessionCell(ctx context.Context, sessionID uuid.UUID, cellID uuid.UUID, userID string) (*models.Session, *models.Cell, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, nil, errors.New("invalid user ID format")
+	}
+
+	session, err := m.SessionRepo.GetSessionByID(ctx, sessionID, userUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cell, err := m.CellRepo.GetCellByID(ctx, cellID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cell.NotebookID != session.NotebookID {
+		return nil, nil, ErrCellNotInSession
+	}
+	if cell.CellType != "code" {
+		return nil, nil, ErrNotCodeCell
+	}
+
+	return session, cell, nil
+}
+
+// executeCell runs one cell on an open kernel connection and persists what it produced.
+func (m *ExecutionModule) executeCell(
+	ctx context.Context,
+	kc *jupyterclient.KernelConnection,
+	session *models.Session,
+	cell *models.Cell,
+	userID string,
+	inputs *inputQueue,
+) (*models.CellExecutionResult, error) {
+	cellID := cell.ID.ToUUID()
+	m.Logger.Info().
+		Str("session_id", session.ID.String()).
+		Str("cell_id", cellID.String()).
+		Msg("executing cell on session kernel")
+
+	startedAt := time.Now().UTC()
+	m.Events.Publish(events.Event{Type: events.ExecutionStarted, SessionID: session.ID.String(), KernelID: session.CurrentKernelID.String()})
+	execResult, err := kc.Execute(ctx, cell.Source, jupyterclient.ExecuteOptions{
+		StoreHistory: true,
+		Metadata:     map[string]any{"cell_id": cellID.String()},
+		OnInput:      inputs.next,
+	})
+	m.recordExecution(session, cell, execResult, startedAt)
+
+	finished := events.Event{Type: events.ExecutionFinished, SessionID: session.ID.String(), KernelID: session.CurrentKernelID.String(), Status: "error"}
+	if execResult != nil {
+		finished.MsgID = execResult.MsgID
+		finished.Status = execResult.Status
+	}
+	m.Events.Publish(finished)
+	if err != nil {
+		// The kernel is still running the cell, or blocked on input(); stop it.
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, ErrInputRequired) {
+			m.interruptKernel(session.CurrentKernelID.String())
+		}
+		return nil, fmt.Errorf("failed to execute cell: %w", err)
+	}
+
+	outputs, err := m.persistOutputs(ctx, cellID, execResult.Outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	cell.ExecutionCount = execResult.ExecutionCount
+	if _, err := m.CellRepo.UpdateCell(ctx, cell, userID); err != nil {
+		return nil, fmt.Errorf("failed to update cell execution count: %w", err)
+	}
+
+	return &models.CellExecutionResult{
+		CellID:         cell.ID,
+		SessionID:      session.ID,
+		KernelID:       session.CurrentKernelID,
+		MsgID:          execResult.MsgID,
+		Status:         execResult.Status,
+		ExecutionCount: execResult.ExecutionCount,
+		Outputs:        outputs,
+	}, nil
+}
+
+// persistOutputs replaces the cell's stored outputs with those of an execution's output messages.
+func (m *ExecutionModule) persistOutputs(ctx context.Context, cellID uuid.UUID, messages []*jupyterclient.Message) ([]models.CellOutput, error) {
+	writer := newOutputWriter(cellID, m.CellRepo, m.Blobs, m.Logger, true)
+	for _, msg := range messages {
+		writer.Write(msg)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to save cell outputs: %w", err)
+	}
+	return writer.Outputs(), nil
+}
+
+// recordExecution adds a server-side execution to the execution history. A run that never got a
+// reply, e.g. because it timed out, is recorded as aborted.
+func (m *ExecutionModule) recordExecution(session *models.Session, cell *models.Cell, result *jupyterclient.ExecutionResult, startedAt time.Time) {
+	if result == nil || result.MsgID == "" {
+		return
+	}
+
+	cellID := cell.ID.ToUUID()
+	finishedAt := time.Now().UTC()
+	execution := &models.Execution{
+		ID:         uuid.New(),
+		NotebookID: session.NotebookID,
+		CellID:     &cellID,
+		SessionID:  &session.ID,
+		KernelID:   session.CurrentKernelID,
+		MsgID:      result.MsgID,
+		Source:     cell.Source,
+		StartedAt:  startedAt,
+		FinishedAt: &finishedAt,
+		Status:     models.ExecutionStatusAborted,
+	}
+	switch result.Status {
+	case "":
+	case models.ExecutionStatusOK, models.ExecutionStatusAborted:
+		execution.Status = result.Status
+	default:
+		execution.Status = models.ExecutionStatusError
+	}
+	if result.ExecutionCount > 0 {
+		execution.ExecutionCount = &result.ExecutionCount
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.ExecutionRepo.CreateExecution(ctx, execution); err != nil {
+		m.Logger.Error().Err(err).Str("msg_id", result.MsgID).Msg("failed to record execution")
+	}
+}
+
+// ListCellExecutions returns the execution history of a cell. Admins may read any cell's history.
+func (m *ExecutionModule) ListCellExecutions(ctx context.Context, cellID uuid.UUID, userID string, isAdmin bool) ([]models.Execution, error) {
+	if !isAdmin {
+		if _, err := m.CellRepo.GetCellByID(ctx, cellID, userID); err != nil {
+			return nil, err
+		}
+	}
+	return m.ExecutionRepo.ListExecutionsByCellID(ctx, cellID)
+}
+
+// ListNotebookExecutions returns the execution history of all cells of a notebook. Admins may read any notebook's history.
+func (m *ExecutionModule) ListNotebookExecutions(ctx context.Context, notebookID string, userID string, isAdmin bool) ([]models.Execution, error) {
+	notebookUUID, err := uuid.Parse(notebookID)
+	if err != nil {
+		return nil, ErrInvalidNotebookID
+	}
+	if !isAdmin {
+		owned, err := m.NotebookRepo.IsNotebookOwner(ctx, notebookUUID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !owned {
+			return nil, ErrNotebookNotFound
+		}
+	}
+	return m.ExecutionRepo.ListExecutionsByNotebookID(ctx, notebookUUID)
+}
+
+// ListExecutionMessages returns the message log of an execution. Admins may read any execution's log.
+func (m *ExecutionModule) ListExecutionMessages(ctx context.Context, executionID uuid.UUID, userID string, isAdmin bool) ([]models.ExecutionMessage, error) {
+	execution, err := m.ExecutionRepo.GetExecutionByID(ctx, executionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrExecutionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin {
+		owned, err := m.NotebookRepo.IsNotebookOwner(ctx, execution.NotebookID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !owned {
+			return nil, ErrExecutionNotFound
+		}
+	}
+	return m.ExecutionRepo.ListExecutionMessages(ctx, executionID)
+}
+
+// interruptKernel stops a run that outlived its request so the kernel does not stay busy.
+func (m *ExecutionModule) interruptKernel(kernelID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := m.Jupyter.InterruptKernel(ctx, kernelID); err != nil {
+		m.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("failed to interrupt kernel after cancelled execution")
+	}
+}
+
+// ErrInvalidRunRequest is returned when a notebook run request has an unknown mode or a missing/foreign cell_id.
+var ErrInvalidRunRequest = errors.New("invalid run request")
+
+// RunPlan is a validated notebook run: the session to use and the code cells to execute in order.
+type RunPlan struct {
+	Session     *models.Session
+	Cells       []*models.Cell
+	StopOnError bool
+	Restart     bool
+	UserID      string
+	// Inputs answer the input() calls of the run's cells, in order.
+	Inputs []string
+}
+
+// PlanRun validates a run request and selects the code cells it covers in cell_index order.
+func (m *ExecutionModule) PlanRun(ctx context.Context, sessionID uuid.UUID, userID string, req *models.RunNotebookRequest) (*RunPlan, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = models.RunModeAll
+	}
+	if mode != models.RunModeAll && mode != models.RunModeFromCell && mode != models.RunModeUntilCell {
+		return nil, fmt.Errorf("%w: unknown mode '%s'", ErrInvalidRunRequest, req.Mode)
+	}
+	if mode != models.RunModeAll && req.CellID == nil {
+		return nil, fmt.Errorf("%w: cell_id is required for mode '%s'", ErrInvalidRunRequest, mode)
+	}
+
+	session, err := m.SessionRepo.GetSessionByID(ctx, sessionID, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	cells, err := m.CellRepo.GetCellsByNotebookID(ctx, session.NotebookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notebook cells: %w", err)
+	}
+
+	start, end := 0, len(cells)
+	if mode != models.RunModeAll {
+		pivot := -1
+		for i, cell := range cells {
+			if cell.ID == *req.CellID {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("%w: cell_id is not part of the session's notebook", ErrInvalidRunRequest)
+		}
+		if mode == models.RunModeFromCell {
+			start = pivot
+		} else {
+			end = pivot + 1
+		}
+	}
+
+	plan := &RunPlan{
+		Session:     session,
+		StopOnError: req.StopOnError == nil || *req.StopOnError,
+		Restart:     req.Restart,
+		UserID:      userID,
+		Inputs:      req.Inputs,
+	}
+	for _, cell := range cells[start:end] {
+		if cell.CellType == "code" {
+			plan.Cells = append(plan.Cells, cell)
+		}
+	}
+
+	return plan, nil
+}
+
+// Run executes a plan on the session kernel, reporting progress through emit. It stops at the
+// first cell that errors when the plan asks for it.
+func (m *ExecutionModule) Run(ctx context.Context, plan *RunPlan, emit func(models.RunEvent)) error {
+	kernelID := plan.Session.CurrentKernelID.String()
+	total := len(plan.Cells)
+	finish := func(status string, runErr error) error {
+		event := models.RunEvent{Type: models.RunEventFinished, SessionID: plan.Session.ID, Total: total, Status: status}
+		if runErr != nil {
+			event.Error = runErr.Error()
+		}
+		emit(event)
+		return runErr
+	}
+
+	emit(models.RunEvent{Type: models.RunEventStarted, SessionID: plan.Session.ID, Total: total})
+
+	if plan.Restart {
+		if _, err := m.Jupyter.RestartKernel(ctx, kernelID); err != nil {
+			return finish("error", fmt.Errorf("failed to restart kernel: %w", err))
+		}
+		if err := m.SessionRepo.MarkKernelRestarted(ctx, plan.Session.CurrentKernelID, time.Now().UTC()); err != nil {
+			m.Logger.Warn().Err(err).Str("kernel_id", kernelID).Msg("failed to record kernel restart")
+		}
+		m.Events.Publish(events.Event{Type: events.KernelRestarted, SessionID: plan.Session.ID.String(), KernelID: kernelID, Reason: "notebook_run"})
+	}
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, kernelID)
+	if err != nil {
+		return finish("error", fmt.Errorf("failed to connect to session kernel: %w", err))
+	}
+	defer kc.Close()
+
+	inputs := newInputQueue(plan.Inputs)
+	for i, cell := range plan.Cells {
+		cellID := cell.ID
+		cellIndex := cell.CellIndex
+		emit(models.RunEvent{
+			Type:      models.RunEventCellStarted,
+			SessionID: plan.Session.ID,
+			CellID:    &cellID,
+			CellIndex: &cellIndex,
+			Position:  i + 1,
+			Total:     total,
+		})
+
+		result, err := m.executeCell(ctx, kc, plan.Session, cell, plan.UserID, inputs)
+		if err != nil {
+			emit(models.RunEvent{
+				Type:      models.RunEventCellFinished,
+				SessionID: plan.Session.ID,
+				CellID:    &cellID,
+				CellIndex: &cellIndex,
+				Position:  i + 1,
+				Total:     total,
+				Status:    "error",
+				Error:     err.Error(),
+			})
+			return finish("error", err)
+		}
+
+		emit(models.RunEvent{
+			Type:           models.RunEventCellFinished,
+			SessionID:      plan.Session.ID,
+			CellID:         &cellID,
+			CellIndex:      &cellIndex,
+			Position:       i + 1,
+			Total:          total,
+			Status:         result.Status,
+			ExecutionCount: result.ExecutionCount,
+			Outputs:        result.Outputs,
+		})
+
+		if result.Status != "ok" && plan.StopOnError {
+			return finish("error", nil)
+		}
+	}
+
+	return finish("ok", nil)
+}
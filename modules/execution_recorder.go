@@ -0,0 +1,256 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// executionRecorderQueueSize bounds the history writes waiting for the database.
+	executionRecorderQueueSize = 1024
+	// executionLogBatchSize is how many messages of an execution's log are buffered before they are written.
+	executionLogBatchSize = 100
+)
+
+// ExecutionRecorder writes the execution history of cells run through the kernel websocket proxy,
+// with the message log of each execution. Writes are applied by a single worker so the updates
+// and log entries of an execution never overtake its insert.
+type ExecutionRecorder struct {
+	Repo        repository.ExecutionRepository
+	SessionRepo repository.SessionRepository
+	Logger      zerolog.Logger
+
+	queue chan func(ctx context.Context)
+
+	mu sync.Mutex
+	// pending holds the recorded executions whose messages are still being logged, by msg_id.
+	pending map[string]*recordedRun
+}
+
+// recordedRun is an execution being recorded. Its log is kept until both its execute_reply and
+// the kernel going idle for it arrived, as the two travel on different channels.
+type recordedRun struct {
+	kernelID    string
+	executionID uuid.UUID
+	// log holds the messages not written yet, and seq numbers the next one.
+	log     []models.ExecutionMessage
+	seq     int
+	replied bool
+	idle    bool
+}
+
+// NewExecutionRecorder creates an ExecutionRecorder and starts its database worker.
+func NewExecutionRecorder(repo repository.ExecutionRepository, sessionRepo repository.SessionRepository, logger zerolog.Logger) *ExecutionRecorder {
+	r := &ExecutionRecorder{
+		Repo:        repo,
+		SessionRepo: sessionRepo,
+		Logger:      logger,
+		queue:       make(chan func(ctx context.Context), executionRecorderQueueSize),
+		pending:     make(map[string]*recordedRun),
+	}
+	go r.run()
+	return r
+}
+
+// FromClient records a queued execution for every non-silent execute_request that names a cell.
+func (r *ExecutionRecorder) FromClient(kernelID string, msg *jupyterclient.Message) {
+	if msg.Header.MsgType != "execute_request" {
+		return
+	}
+
+	var metadata struct {
+		CellID string `json:"cell_id"`
+	}
+	if err := json.Unmarshal(msg.Metadata, &metadata); err != nil {
+		return
+	}
+	cellID, err := uuid.Parse(metadata.CellID)
+	if err != nil {
+		return
+	}
+	var content jupyterclient.ExecuteRequestContent
+	if err := json.Unmarshal(msg.Content, &content); err != nil || content.Silent {
+		return
+	}
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return
+	}
+
+	msgID := msg.Header.MsgID
+	requestedAt := time.Now().UTC()
+	run := &recordedRun{kernelID: kernelID, executionID: uuid.New()}
+	r.mu.Lock()
+	r.pending[msgID] = run
+	r.mu.Unlock()
+
+	r.enqueue(func(ctx context.Context) {
+		session, err := r.SessionRepo.GetSessionByKernelID(ctx, kernelUUID)
+		if err != nil {
+			r.Logger.Warn().Err(err).Str("kernel_id", kernelID).Msg("no session for kernel, not recording execution")
+			r.forget(msgID)
+			return
+		}
+
+		execution := &models.Execution{
+			ID:         run.executionID,
+			NotebookID: session.NotebookID,
+			CellID:     &cellID,
+			SessionID:  &session.ID,
+			KernelID:   kernelUUID,
+			MsgID:      msgID,
+			Source:     content.Code,
+			StartedAt:  requestedAt,
+			Status:     models.ExecutionStatusQueued,
+		}
+		if err := r.Repo.CreateExecution(ctx, execution); err != nil {
+			r.Logger.Error().Err(err).Str("msg_id", msgID).Msg("failed to record execution")
+			r.forget(msgID)
+		}
+	})
+
+	r.mu.Lock()
+	r.logMessage(run, msg, requestedAt)
+	r.mu.Unlock()
+}
+
+// FromKernel logs the kernel's messages in reply to a recorded execution, and tracks the execution
+// through the kernel going busy and its execute_reply. Executions still pending when the kernel
+// restarts or dies are forgotten, as no reply will come.
+func (r *ExecutionRecorder) FromKernel(kernelID string, msg *jupyterclient.Message) {
+	if msg.Header.MsgType == "status" {
+		var status jupyterclient.StatusContent
+		if err := json.Unmarshal(msg.Content, &status); err == nil &&
+			(status.ExecutionState == "restarting" || status.ExecutionState == "dead") {
+			r.KernelGone(kernelID)
+			return
+		}
+	}
+
+	msgID := msg.ParentHeader.MsgID
+	observedAt := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.pending[msgID]
+	if !ok {
+		return
+	}
+	r.logMessage(run, msg, observedAt)
+
+	switch msg.Header.MsgType {
+	case "status":
+		var status jupyterclient.StatusContent
+		if err := json.Unmarshal(msg.Content, &status); err != nil {
+			return
+		}
+		switch status.ExecutionState {
+		case "busy":
+			r.enqueue(func(ctx context.Context) {
+				if err := r.Repo.MarkExecutionRunning(ctx, msgID, observedAt); err != nil {
+					r.Logger.Error().Err(err).Str("msg_id", msgID).Msg("failed to mark execution as running")
+				}
+			})
+		case "idle":
+			run.idle = true
+		}
+	case "execute_reply":
+		var reply jupyterclient.ExecuteReplyContent
+		if err := json.Unmarshal(msg.Content, &reply); err != nil {
+			r.Logger.Warn().Err(err).Str("msg_id", msgID).Msg("failed to decode execute_reply")
+			return
+		}
+		run.replied = true
+
+		status := reply.Status
+		if status != models.ExecutionStatusOK && status != models.ExecutionStatusAborted {
+			status = models.ExecutionStatusError
+		}
+		var executionCount *int
+		if reply.ExecutionCount > 0 {
+			executionCount = &reply.ExecutionCount
+		}
+		r.enqueue(func(ctx context.Context) {
+			if err := r.Repo.FinishExecution(ctx, msgID, status, executionCount, observedAt); err != nil {
+				r.Logger.Error().Err(err).Str("msg_id", msgID).Msg("failed to record execution result")
+			}
+		})
+	}
+
+	if run.replied && run.idle {
+		r.writeLog(run)
+		delete(r.pending, msgID)
+	}
+}
+
+// KernelGone writes what was logged of the kernel's pending executions and forgets them once the
+// kernel hub stops reading the kernel.
+func (r *ExecutionRecorder) KernelGone(kernelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for msgID, run := range r.pending {
+		if run.kernelID == kernelID {
+			r.writeLog(run)
+			delete(r.pending, msgID)
+		}
+	}
+}
+
+// logMessage adds a message to the log of a run, writing the log out once a batch is full. r.mu must be held.
+func (r *ExecutionRecorder) logMessage(run *recordedRun, msg *jupyterclient.Message, receivedAt time.Time) {
+	run.log = append(run.log, models.ExecutionMessage{
+		ExecutionID: run.executionID,
+		Seq:         run.seq,
+		Channel:     msg.Channel,
+		MsgType:     msg.Header.MsgType,
+		Content:     msg.Content,
+		ReceivedAt:  receivedAt,
+	})
+	run.seq++
+	if len(run.log) >= executionLogBatchSize {
+		r.writeLog(run)
+	}
+}
+
+// writeLog queues the write of the messages logged for a run so far. r.mu must be held.
+func (r *ExecutionRecorder) writeLog(run *recordedRun) {
+	if len(run.log) == 0 {
+		return
+	}
+	messages := run.log
+	run.log = nil
+	r.enqueue(func(ctx context.Context) {
+		if err := r.Repo.SaveExecutionMessages(ctx, messages); err != nil {
+			r.Logger.Error().Err(err).Str("execution_id", run.executionID.String()).Int("count", len(messages)).Msg("failed to save execution messages")
+		}
+	})
+}
+
+func (r *ExecutionRecorder) forget(msgID string) {
+	r.mu.Lock()
+	delete(r.pending, msgID)
+	r.mu.Unlock()
+}
+
+func (r *ExecutionRecorder) enqueue(write func(ctx context.Context)) {
+	select {
+	case r.queue <- write:
+	default:
+		r.Logger.Error().Msg("execution history queue is full, dropping write")
+	}
+}
+
+func (r *ExecutionRecorder) run() {
+	for write := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		write(ctx)
+		cancel()
+	}
+}
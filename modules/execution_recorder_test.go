@@ -0,0 +1,128 @@
+package modules_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// memoryExecutionRepo keeps the execution history in memory; methods the tests do not need are
+// left to the embedded interface.
+type memoryExecutionRepo struct {
+	repository.ExecutionRepository
+
+	mu         sync.Mutex
+	executions map[string]*models.Execution
+	messages   []models.ExecutionMessage
+}
+
+func (r *memoryExecutionRepo) CreateExecution(ctx context.Context, execution *models.Execution) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *execution
+	r.executions[execution.MsgID] = &stored
+	return nil
+}
+
+func (r *memoryExecutionRepo) MarkExecutionRunning(ctx context.Context, msgID string, startedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.executions[msgID].Status = models.ExecutionStatusRunning
+	return nil
+}
+
+func (r *memoryExecutionRepo) FinishExecution(ctx context.Context, msgID string, status string, executionCount *int, finishedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.executions[msgID].Status = status
+	r.executions[msgID].ExecutionCount = executionCount
+	return nil
+}
+
+func (r *memoryExecutionRepo) SaveExecutionMessages(ctx context.Context, messages []models.ExecutionMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, messages...)
+	return nil
+}
+
+func (r *memorySessionRepo) GetSessionByKernelID(ctx context.Context, kernelID uuid.UUID) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.CurrentKernelID == kernelID {
+			return session, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func TestExecutionRecorderLogsMessages(t *testing.T) {
+	repo := &memoryExecutionRepo{executions: make(map[string]*models.Execution)}
+	sessions := newMemorySessionRepo()
+	kernelID := uuid.New()
+	sessions.sessions[uuid.New()] = &models.Session{NotebookID: uuid.New(), CurrentKernelID: kernelID}
+	recorder := modules.NewExecutionRecorder(repo, sessions, zerolog.Nop())
+
+	request := kernelMessage(t, jupyterclient.ChannelShell, "execute_request", nil, jupyterclient.ExecuteRequestContent{Code: "print('hi')"})
+	request.Metadata = json.RawMessage(fmt.Sprintf(`{"cell_id": %q}`, uuid.New()))
+	recorder.FromClient(kernelID.String(), request)
+
+	send := func(channel, msgType string, content any) {
+		recorder.FromKernel(kernelID.String(), kernelMessage(t, channel, msgType, request, content))
+	}
+	send(jupyterclient.ChannelIOPub, "status", jupyterclient.StatusContent{ExecutionState: "busy"})
+	for i := 0; i < 150; i++ {
+		send(jupyterclient.ChannelIOPub, "stream", jupyterclient.StreamContent{Name: "stdout", Text: "hi\n"})
+	}
+	// The reply may overtake the kernel going idle; the log ends with whichever comes last.
+	send(jupyterclient.ChannelIOPub, "status", jupyterclient.StatusContent{ExecutionState: "idle"})
+	send(jupyterclient.ChannelShell, "execute_reply", jupyterclient.ExecuteReplyContent{Status: "ok", ExecutionCount: 1})
+	// Messages of finished executions are not logged.
+	send(jupyterclient.ChannelIOPub, "stream", jupyterclient.StreamContent{Name: "stdout", Text: "late\n"})
+
+	const want = 1 + 1 + 150 + 2
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		repo.mu.Lock()
+		got := len(repo.messages)
+		repo.mu.Unlock()
+		if got >= want || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if len(repo.messages) != want {
+		t.Fatalf("logged %d messages, want %d", len(repo.messages), want)
+	}
+	execution := repo.executions[request.Header.MsgID]
+	if execution == nil || execution.Status != models.ExecutionStatusOK {
+		t.Fatalf("execution = %+v, want it recorded as ok", execution)
+	}
+	for i, message := range repo.messages {
+		if message.Seq != i || message.ExecutionID != execution.ID {
+			t.Fatalf("message %d = seq %d of %s, want seq %d of %s", i, message.Seq, message.ExecutionID, i, execution.ID)
+		}
+	}
+	first, last := repo.messages[0], repo.messages[want-1]
+	if first.MsgType != "execute_request" || first.Channel != jupyterclient.ChannelShell {
+		t.Errorf("first message = %s on %s, want the execute_request on shell", first.MsgType, first.Channel)
+	}
+	if last.MsgType != "execute_reply" || string(last.Content) == "" {
+		t.Errorf("last message = %s %s, want the execute_reply with its content", last.MsgType, last.Content)
+	}
+}
@@ -0,0 +1,116 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// ErrInvalidCursorPos is returned when a cursor position falls outside of the code.
+var ErrInvalidCursorPos = errors.New("cursor_pos must be between 0 and the length of the code")
+
+// IntrospectionModule answers editor questions about the code and state of a session, such as
+// completions, object documentation and the variables currently defined, by asking the session's kernel.
+type IntrospectionModule struct {
+	SessionRepo repository.SessionRepository
+	Jupyter     jupyterclient.Gateway
+	Logger      zerolog.Logger
+}
+
+// NewIntrospectionModule creates and returns a new IntrospectionModule.
+func NewIntrospectionModule(sessionRepo repository.SessionRepository, jupyter jupyterclient.Gateway, logger zerolog.Logger) *IntrospectionModule {
+	return &IntrospectionModule{
+		SessionRepo: sessionRepo,
+		Jupyter:     jupyter,
+		Logger:      logger,
+	}
+}
+
+// Complete returns the kernel's completions for the code at the cursor position.
+func (m *IntrospectionModule) Complete(ctx context.Context, sessionID uuid.UUID, userID string, req *models.CompleteRequest) (*jupyterclient.CompleteReplyContent, error) {
+	cursorPos, err := resolveCursorPos(req.Code, req.CursorPos)
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := m.connect(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer kc.Close()
+
+	reply, err := kc.Complete(ctx, req.Code, cursorPos)
+	if err != nil {
+		return nil, fmt.Errorf("complete_request failed: %w", err)
+	}
+	if reply.Matches == nil {
+		reply.Matches = []string{}
+	}
+	return reply, nil
+}
+
+// Inspect returns the kernel's information about the object at the cursor position.
+func (m *IntrospectionModule) Inspect(ctx context.Context, sessionID uuid.UUID, userID string, req *models.InspectRequest) (*jupyterclient.InspectReplyContent, error) {
+	cursorPos, err := resolveCursorPos(req.Code, req.CursorPos)
+	if err != nil {
+		return nil, err
+	}
+	detailLevel := req.DetailLevel
+	if detailLevel != 1 {
+		detailLevel = 0
+	}
+
+	kc, err := m.connect(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer kc.Close()
+
+	reply, err := kc.Inspect(ctx, req.Code, cursorPos, detailLevel)
+	if err != nil {
+		return nil, fmt.Errorf("inspect_request failed: %w", err)
+	}
+	return reply, nil
+}
+
+// loadSession fetches a session owned by the user.
+func (m *IntrospectionModule) loadSession(ctx context.Context, sessionID uuid.UUID, userID string) (*models.Session, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+	return m.SessionRepo.GetSessionByID(ctx, sessionID, userUUID)
+}
+
+// connect opens a connection to the kernel of a session owned by the user.
+func (m *IntrospectionModule) connect(ctx context.Context, sessionID uuid.UUID, userID string) (*jupyterclient.KernelConnection, error) {
+	session, err := m.loadSession(ctx, sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, session.CurrentKernelID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to session kernel: %w", err)
+	}
+	return kc, nil
+}
+
+// resolveCursorPos defaults the cursor to the end of the code, counting unicode code points as Jupyter does.
+func resolveCursorPos(code string, cursorPos *int) (int, error) {
+	length := utf8.RuneCountInString(code)
+	if cursorPos == nil {
+		return length, nil
+	}
+	if *cursorPos < 0 || *cursorPos > length {
+		return 0, ErrInvalidCursorPos
+	}
+	return *cursorPos, nil
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+)
+
+// KernelEventPublisher publishes the status changes of kernels attached to the kernel hub, and the
+// start and end of the executions their frontends request, on the event bus.
+type KernelEventPublisher struct {
+	Events *events.Bus
+
+	mu sync.Mutex
+	// requested holds, per kernel, the execute_requests sent through the hub, so executions requested
+	// over other connections to the kernel, which already publish their own events, are left out.
+	requested map[string]map[string]struct{}
+}
+
+// NewKernelEventPublisher creates a KernelEventPublisher publishing on bus.
+func NewKernelEventPublisher(bus *events.Bus) *KernelEventPublisher {
+	return &KernelEventPublisher{
+		Events:    bus,
+		requested: make(map[string]map[string]struct{}),
+	}
+}
+
+// FromClient remembers the execute_requests of the hub's frontends.
+func (p *KernelEventPublisher) FromClient(kernelID string, msg *jupyterclient.Message) {
+	if msg.Header.MsgType != "execute_request" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.requested[kernelID] == nil {
+		p.requested[kernelID] = make(map[string]struct{})
+	}
+	p.requested[kernelID][msg.Header.MsgID] = struct{}{}
+}
+
+// FromKernel publishes iopub status messages, and execute_input and execute_reply messages of the
+// requests seen by FromClient.
+func (p *KernelEventPublisher) FromKernel(kernelID string, msg *jupyterclient.Message) {
+	switch msg.Header.MsgType {
+	case "status":
+		var content jupyterclient.StatusContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil || content.ExecutionState == "" {
+			return
+		}
+		p.Events.Publish(events.Event{
+			Type:           events.KernelStatus,
+			KernelID:       kernelID,
+			ExecutionState: content.ExecutionState,
+			MsgID:          msg.ParentHeader.MsgID,
+		})
+		if content.ExecutionState == "restarting" || content.ExecutionState == "dead" {
+			// Requests still waiting for a reply never get one.
+			p.KernelGone(kernelID)
+		}
+
+	case "execute_input":
+		if !p.isRequested(kernelID, msg.ParentHeader.MsgID, false) {
+			return
+		}
+		p.Events.Publish(events.Event{Type: events.ExecutionStarted, KernelID: kernelID, MsgID: msg.ParentHeader.MsgID})
+
+	case "execute_reply":
+		if !p.isRequested(kernelID, msg.ParentHeader.MsgID, true) {
+			return
+		}
+		var content jupyterclient.ExecuteReplyContent
+		_ = json.Unmarshal(msg.Content, &content)
+		p.Events.Publish(events.Event{
+			Type:     events.ExecutionFinished,
+			KernelID: kernelID,
+			MsgID:    msg.ParentHeader.MsgID,
+			Status:   content.Status,
+		})
+	}
+}
+
+// KernelGone forgets the requests sent to a kernel that died or left the kernel hub.
+func (p *KernelEventPublisher) KernelGone(kernelID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.requested, kernelID)
+}
+
+// isRequested reports whether the hub's frontends sent the request, forgetting it when done.
+func (p *KernelEventPublisher) isRequested(kernelID string, msgID string, done bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.requested[kernelID][msgID]
+	if ok && done {
+		delete(p.requested[kernelID], msgID)
+		if len(p.requested[kernelID]) == 0 {
+			delete(p.requested, kernelID)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,329 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// KernelDeathPolicyNotify only reports a kernel death to the attached frontends; it is the default policy.
+const KernelDeathPolicyNotify = "notify"
+
+// recoveryTimeout bounds a whole recovery, including the replay of the session's history.
+const recoveryTimeout = 10 * time.Minute
+
+var (
+	// ErrInvalidRecoveryMode is returned when a recovery is requested with an unknown mode.
+	ErrInvalidRecoveryMode = errors.New("mode must be 'restart' or 'new_kernel'")
+	// ErrRecoveryInProgress is returned when the session is already being recovered.
+	ErrRecoveryInProgress = errors.New("session is already being recovered")
+)
+
+// KernelRecoveryModule brings sessions back after their kernel died, either automatically when
+// the kernel hub reports a death or on request, and can replay what the user had run before.
+type KernelRecoveryModule struct {
+	SessionRepo   repository.SessionRepository
+	ExecutionRepo repository.ExecutionRepository
+	Jupyter       jupyterclient.Gateway
+	Logger        zerolog.Logger
+	// Policy is what happens automatically when a kernel dies: notify, restart or new_kernel.
+	Policy string
+	// Replay re-executes the session's successful cells after an automatic recovery.
+	Replay bool
+	// Events receives the deaths, restarts and replacements of session kernels.
+	Events *events.Bus
+	// KernelEnv, when set, returns the environment replacement kernels are started with.
+	KernelEnv func(ctx context.Context, session *models.Session) (map[string]string, error)
+
+	mu         sync.Mutex
+	recovering map[uuid.UUID]struct{}
+}
+
+// KernelRecoveryConfig is what the kernel recovery module does automatically when a kernel dies.
+type KernelRecoveryConfig struct {
+	// Policy is notify, restart or new_kernel; unknown values fall back to notify.
+	Policy string
+	// Replay re-executes the session's successful cells after an automatic recovery.
+	Replay bool
+}
+
+// KernelRecoveryConfigFromEnv loads the recovery configuration from KERNEL_DEATH_POLICY and
+// KERNEL_DEATH_REPLAY.
+func KernelRecoveryConfigFromEnv() KernelRecoveryConfig {
+	replay, _ := strconv.ParseBool(os.Getenv("KERNEL_DEATH_REPLAY"))
+	return KernelRecoveryConfig{
+		Policy: os.Getenv("KERNEL_DEATH_POLICY"),
+		Replay: replay,
+	}
+}
+
+// NewKernelRecoveryModule creates and returns a new KernelRecoveryModule applying config.
+func NewKernelRecoveryModule(
+	sessionRepo repository.SessionRepository,
+	executionRepo repository.ExecutionRepository,
+	jupyter jupyterclient.Gateway,
+	config KernelRecoveryConfig,
+	logger zerolog.Logger,
+) *KernelRecoveryModule {
+	policy := config.Policy
+	switch policy {
+	case models.RecoveryModeRestart, models.RecoveryModeNewKernel, KernelDeathPolicyNotify:
+	case "":
+		policy = KernelDeathPolicyNotify
+	default:
+		logger.Warn().Str("policy", policy).Msg("unknown KERNEL_DEATH_POLICY, falling back to notify")
+		policy = KernelDeathPolicyNotify
+	}
+
+	return &KernelRecoveryModule{
+		SessionRepo:   sessionRepo,
+		ExecutionRepo: executionRepo,
+		Jupyter:       jupyter,
+		Logger:        logger,
+		Policy:        policy,
+		Replay:        config.Replay,
+		recovering:    make(map[uuid.UUID]struct{}),
+	}
+}
+
+// HandleKernelDeath applies the configured policy to the session of a kernel that died.
+// It is registered as the kernel hub's death handler.
+func (m *KernelRecoveryModule) HandleKernelDeath(kernelID string, autoRestarted bool) {
+	logger := m.Logger.With().Str("kernel_id", kernelID).Logger()
+
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryTimeout)
+	defer cancel()
+
+	session, err := m.SessionRepo.GetSessionByKernelID(ctx, kernelUUID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Error().Err(err).Msg("failed to look up the session of a dead kernel")
+		}
+		return
+	}
+	if session.Status == models.SessionStatusCulled {
+		return
+	}
+
+	if autoRestarted {
+		m.Events.Publish(events.Event{Type: events.KernelRestarted, SessionID: session.ID.String(), KernelID: kernelID, Reason: "kernel_died"})
+	} else {
+		m.Events.Publish(events.Event{Type: events.KernelStatus, SessionID: session.ID.String(), KernelID: kernelID, ExecutionState: "dead"})
+	}
+
+	// The gateway already brought the kernel back, so only its state is left to restore.
+	if autoRestarted {
+		if !m.Replay {
+			m.markRestarted(ctx, session.CurrentKernelID, time.Now().UTC())
+			return
+		}
+		result, err := m.run(ctx, session, "", true, time.Now().UTC())
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to replay session after automatic kernel restart")
+			return
+		}
+		logger.Info().Int("replayed", result.Replayed).Msg("replayed session after automatic kernel restart")
+		return
+	}
+
+	if m.Policy == KernelDeathPolicyNotify {
+		return
+	}
+	result, err := m.run(ctx, session, m.Policy, m.Replay, time.Now().UTC())
+	if err != nil {
+		logger.Error().Err(err).Str("session_id", session.ID.String()).Msg("failed to recover session after kernel death")
+		return
+	}
+	logger.Info().
+		Str("session_id", session.ID.String()).
+		Str("mode", result.Mode).
+		Str("new_kernel_id", result.KernelID.String()).
+		Int("replayed", result.Replayed).
+		Msg("recovered session after kernel death")
+}
+
+// RecoverSession restarts or replaces the kernel of a user's session and optionally replays its history.
+func (m *KernelRecoveryModule) RecoverSession(ctx context.Context, sessionID uuid.UUID, userID string, req *models.RecoverSessionRequest) (*models.RecoverSessionResult, error) {
+	if req.Mode != models.RecoveryModeRestart && req.Mode != models.RecoveryModeNewKernel {
+		return nil, ErrInvalidRecoveryMode
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	session, err := m.SessionRepo.GetSessionByID(ctx, sessionID, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.run(ctx, session, req.Mode, req.Replay, time.Now().UTC())
+}
+
+// run recovers one session at a time. An empty mode keeps the current kernel as it is.
+func (m *KernelRecoveryModule) run(ctx context.Context, session *models.Session, mode string, replay bool, diedAt time.Time) (*models.RecoverSessionResult, error) {
+	m.mu.Lock()
+	if _, busy := m.recovering[session.ID]; busy {
+		m.mu.Unlock()
+		return nil, ErrRecoveryInProgress
+	}
+	m.recovering[session.ID] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.recovering, session.ID)
+		m.mu.Unlock()
+	}()
+
+	result := &models.RecoverSessionResult{
+		SessionID:        session.ID,
+		PreviousKernelID: session.CurrentKernelID,
+		KernelID:         session.CurrentKernelID,
+		Mode:             mode,
+	}
+
+	switch mode {
+	case models.RecoveryModeRestart:
+		if _, err := m.Jupyter.RestartKernel(ctx, session.CurrentKernelID.String()); err != nil {
+			// A kernel that died hard often cannot be restarted in place, so fall back to a fresh one.
+			m.Logger.Warn().Err(err).Str("kernel_id", session.CurrentKernelID.String()).Msg("failed to restart dead kernel, starting a new one")
+			result.Mode = models.RecoveryModeNewKernel
+			if err := m.replaceKernel(ctx, session, result); err != nil {
+				return nil, err
+			}
+		} else {
+			m.Events.Publish(events.Event{Type: events.KernelRestarted, SessionID: session.ID.String(), KernelID: session.CurrentKernelID.String(), Reason: "recovery"})
+			if err := m.SessionRepo.SetSessionStatus(ctx, session.ID, models.SessionStatusActive); err != nil {
+				return nil, fmt.Errorf("failed to reactivate session: %w", err)
+			}
+		}
+	case models.RecoveryModeNewKernel:
+		if err := m.replaceKernel(ctx, session, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if replay {
+		replayed, err := m.replay(ctx, session, result.KernelID, diedAt)
+		result.Replayed = replayed
+		if err != nil {
+			result.ReplayError = err.Error()
+		}
+	}
+	// A kernel kept in place lost its state unless the replay brought it all back, in which case its
+	// history still describes it. A replacement kernel's start is recorded with the session's new kernel.
+	if result.KernelID == session.CurrentKernelID && (!replay || result.ReplayError != "") {
+		m.markRestarted(ctx, result.KernelID, time.Now().UTC())
+	}
+
+	return result, nil
+}
+
+// replaceKernel starts a kernel of the session's kernelspec, points the session at it and deletes the old one.
+func (m *KernelRecoveryModule) replaceKernel(ctx context.Context, session *models.Session, result *models.RecoverSessionResult) error {
+	kernelName := session.KernelName
+	if kernelName == "" {
+		// Sessions created before kernel names were stored fall back to the gateway's default kernelspec.
+		specs, err := m.Jupyter.GetKernelSpecs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get kernelspecs: %w", err)
+		}
+		kernelName = specs.Default
+	}
+
+	var env map[string]string
+	if m.KernelEnv != nil {
+		var err error
+		if env, err = m.KernelEnv(ctx, session); err != nil {
+			// A kernel without its session environment beats no kernel at all.
+			m.Logger.Warn().Err(err).Str("session_id", session.ID.String()).Msg("failed to build kernel environment")
+		}
+	}
+
+	kernel, err := m.Jupyter.StartKernel(ctx, kernelName, env)
+	if err != nil {
+		return fmt.Errorf("failed to start replacement kernel: %w", err)
+	}
+	kernelID, err := uuid.Parse(kernel.ID)
+	if err != nil {
+		return errors.New("invalid kernel_id format from Jupyter gateway")
+	}
+
+	if err := m.SessionRepo.UpdateSessionKernel(ctx, session.ID, kernelID); err != nil {
+		if deleteErr := m.Jupyter.DeleteKernel(context.Background(), kernel.ID); deleteErr != nil {
+			m.Logger.Error().Err(deleteErr).Str("kernel_id", kernel.ID).Msg("failed to delete orphaned kernel")
+		}
+		return fmt.Errorf("failed to point session at replacement kernel: %w", err)
+	}
+	result.KernelID = kernelID
+	m.Events.Publish(events.Event{Type: events.KernelReplaced, SessionID: session.ID.String(), KernelID: kernel.ID, Reason: "recovery"})
+
+	// The dead kernel may linger on the gateway; it has no session anymore, so a failure here is only logged.
+	if err := m.Jupyter.DeleteKernel(ctx, session.CurrentKernelID.String()); err != nil {
+		m.Logger.Debug().Err(err).Str("kernel_id", session.CurrentKernelID.String()).Msg("failed to delete dead kernel")
+	}
+	return nil
+}
+
+// markRestarted records that a session kernel lost its state, so a later replay leaves out what ran before.
+func (m *KernelRecoveryModule) markRestarted(ctx context.Context, kernelID uuid.UUID, at time.Time) {
+	if err := m.SessionRepo.MarkKernelRestarted(ctx, kernelID, at); err != nil {
+		m.Logger.Warn().Err(err).Str("kernel_id", kernelID.String()).Msg("failed to record kernel restart")
+	}
+}
+
+// replay re-executes onto kernelID, in their original order, the sources that ran successfully on
+// the session's dead kernel between its last (re)start and its death, stopping at the first one that
+// fails. Code run before a restart or an earlier recovery without replay is left out, as the user
+// threw that state away. It returns how many were run.
+func (m *KernelRecoveryModule) replay(ctx context.Context, session *models.Session, kernelID uuid.UUID, before time.Time) (int, error) {
+	executions, err := m.ExecutionRepo.ListSuccessfulExecutionsByKernelID(ctx, session.CurrentKernelID, session.KernelStartedAt, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load execution history: %w", err)
+	}
+	if len(executions) == 0 {
+		return 0, nil
+	}
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, kernelID.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to kernel: %w", err)
+	}
+	defer kc.Close()
+
+	// Replayed code runs silently and is not recorded; a later death of a kernel restored in place
+	// replays the original history again.
+	replayed := 0
+	for _, execution := range executions {
+		result, err := kc.Execute(ctx, execution.Source, jupyterclient.ExecuteOptions{
+			Silent:      true,
+			StopOnError: true,
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to replay execution %s: %w", execution.ID, err)
+		}
+		replayed++
+		if result.Status != "ok" {
+			return replayed, fmt.Errorf("replay stopped: execution %s finished with status %q", execution.ID, result.Status)
+		}
+	}
+	return replayed, nil
+}
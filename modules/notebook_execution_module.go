@@ -0,0 +1,379 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// notebookExecutionTimeout bounds a whole headless run, including kernel start-up.
+const notebookExecutionTimeout = 2 * time.Hour
+
+// CellTagInjectedParameters marks the cell generated from the parameters of a notebook execution.
+const CellTagInjectedParameters = "injected-parameters"
+
+// ErrInvalidParameters is returned when execution parameters cannot be rendered as a parameters cell.
+var ErrInvalidParameters = errors.New("invalid notebook execution parameters")
+
+var pythonIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pythonKeywords are the names in Python's keyword.kwlist, which cannot be assigned to.
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true,
+	"await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// NotebookExecutionModule runs notebooks headlessly on throwaway kernels with injected parameters.
+type NotebookExecutionModule struct {
+	Repo         repository.NotebookExecutionRepository
+	NotebookRepo repository.NotebookRepository
+	Jupyter      jupyterclient.Gateway
+	Logger       zerolog.Logger
+}
+
+// NewNotebookExecutionModule creates and returns a new NotebookExecutionModule.
+func NewNotebookExecutionModule(
+	repo repository.NotebookExecutionRepository,
+	notebookRepo repository.NotebookRepository,
+	jupyter jupyterclient.Gateway,
+	logger zerolog.Logger,
+) *NotebookExecutionModule {
+	return &NotebookExecutionModule{
+		Repo:         repo,
+		NotebookRepo: notebookRepo,
+		Jupyter:      jupyter,
+		Logger:       logger,
+	}
+}
+
+// StartExecution records a new run of the notebook and executes it in the background.
+// The returned record is in the running state; poll GetExecution for the result.
+func (m *NotebookExecutionModule) StartExecution(
+	ctx context.Context,
+	notebookID string,
+	userID string,
+	req *models.CreateNotebookExecutionRequest,
+) (*models.NotebookExecution, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID format")
+	}
+
+	notebook, err := m.NotebookRepo.GetNotebookByID(ctx, notebookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if notebook == nil || notebook.ID == "" {
+		return nil, errors.New("notebook not found or not owned by user")
+	}
+
+	parametersCell, err := renderParametersCell(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	kernelName, err := m.resolveKernelName(ctx, req.KernelName)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := json.Marshal(req.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	if req.Parameters == nil {
+		parameters = []byte("{}")
+	}
+
+	execution, err := m.Repo.CreateNotebookExecution(ctx, &models.NotebookExecution{
+		ID:         uuid.New(),
+		NotebookID: uuid.MustParse(notebook.ID),
+		CreatedBy:  userUUID,
+		KernelName: kernelName,
+		Parameters: parameters,
+		Status:     models.NotebookExecutionRunning,
+		StartedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notebook execution: %w", err)
+	}
+
+	stopOnError := req.StopOnError == nil || *req.StopOnError
+	cells := injectParametersCell(notebook.Cells, parametersCell, notebook.ID)
+	// run finishes its own copy, so the record returned here is not written while it is encoded.
+	running := *execution
+	go m.run(&running, notebook, cells, stopOnError)
+
+	return execution, nil
+}
+
+// GetExecution returns a run record of the given notebook owned by the user.
+func (m *NotebookExecutionModule) GetExecution(ctx context.Context, notebookID string, executionID uuid.UUID, userID string) (*models.NotebookExecution, error) {
+	execution, err := m.Repo.GetNotebookExecutionByID(ctx, executionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if execution.NotebookID.String() != notebookID {
+		return nil, errors.New("notebook execution not found or not owned by user")
+	}
+	return execution, nil
+}
+
+// ListExecutions returns the run records of a notebook owned by the user, without their executed notebooks.
+func (m *NotebookExecutionModule) ListExecutions(ctx context.Context, notebookID string, userID string) ([]models.NotebookExecution, error) {
+	notebookUUID, err := uuid.Parse(notebookID)
+	if err != nil {
+		return nil, ErrInvalidNotebookID
+	}
+	owned, err := m.NotebookRepo.IsNotebookOwner(ctx, notebookUUID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !owned {
+		return nil, ErrNotebookNotFound
+	}
+	return m.Repo.ListNotebookExecutionsByNotebookID(ctx, notebookUUID)
+}
+
+// resolveKernelName falls back to the gateway's default kernelspec and checks the kernel is Python,
+// since parameters are injected as Python assignments.
+func (m *NotebookExecutionModule) resolveKernelName(ctx context.Context, kernelName string) (string, error) {
+	specs, err := m.Jupyter.GetKernelSpecs(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get kernelspecs from gateway: %w", err)
+	}
+	if kernelName == "" {
+		kernelName = specs.Default
+	}
+
+	spec, ok := specs.KernelSpecs[kernelName]
+	if !ok {
+		return "", fmt.Errorf("%w: '%s'", ErrUnknownKernelSpec, kernelName)
+	}
+	if !strings.EqualFold(spec.Spec.Language, "python") {
+		return "", fmt.Errorf("%w: parameters can only be injected into python kernels, '%s' is %s",
+			ErrUnknownKernelSpec, kernelName, spec.Spec.Language)
+	}
+	return kernelName, nil
+}
+
+// run executes the cells on a fresh kernel and stores the executed notebook on the record.
+func (m *NotebookExecutionModule) run(execution *models.NotebookExecution, notebook *models.Notebook, cells []models.Cell, stopOnError bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), notebookExecutionTimeout)
+	defer cancel()
+
+	logger := m.Logger.With().
+		Str("execution_id", execution.ID.String()).
+		Str("notebook_id", notebook.ID).
+		Logger()
+	logger.Info().Int("cells", len(cells)).Msg("starting headless notebook execution")
+
+	runErr := m.executeCells(ctx, execution.KernelName, cells, stopOnError, logger)
+
+	executed := *notebook
+	executed.Cells = cells
+	snapshot, err := json.Marshal(executed)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to marshal executed notebook")
+	}
+
+	finishedAt := time.Now().UTC()
+	execution.Status = models.NotebookExecutionCompleted
+	execution.Notebook = snapshot
+	execution.FinishedAt = &finishedAt
+	if runErr != nil {
+		execution.Status = models.NotebookExecutionFailed
+		message := runErr.Error()
+		execution.Error = &message
+	}
+
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+	if err := m.Repo.FinishNotebookExecution(saveCtx, execution); err != nil {
+		logger.Error().Err(err).Msg("failed to store notebook execution result")
+		return
+	}
+	logger.Info().Str("status", execution.Status).Msg("headless notebook execution finished")
+}
+
+// executeCells starts a throwaway kernel, runs every code cell in order recording outputs on
+// the cells, and deletes the kernel afterwards.
+func (m *NotebookExecutionModule) executeCells(ctx context.Context, kernelName string, cells []models.Cell, stopOnError bool, logger zerolog.Logger) error {
+	kernel, err := m.Jupyter.StartKernel(ctx, kernelName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start kernel: %w", err)
+	}
+	defer func() {
+		deleteCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := m.Jupyter.DeleteKernel(deleteCtx, kernel.ID); err != nil {
+			logger.Error().Err(err).Str("kernel_id", kernel.ID).Msg("failed to delete execution kernel")
+		}
+	}()
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, kernel.ID)
+	if err != nil {
+		return fmt.Errorf("failed to connect to kernel: %w", err)
+	}
+	defer kc.Close()
+
+	for i := range cells {
+		cell := &cells[i]
+		if cell.CellType != "code" {
+			continue
+		}
+
+		result, err := kc.Execute(ctx, cell.Source, jupyterclient.ExecuteOptions{
+			StoreHistory: true,
+			StopOnError:  stopOnError,
+			Metadata:     map[string]any{"cell_id": uuid.UUID(cell.ID).String()},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to execute cell %d: %w", cell.CellIndex, err)
+		}
+
+		cell.ExecutionCount = result.ExecutionCount
+		outputs := newOutputList(cell.ID.ToUUID(), true)
+		for _, msg := range result.Outputs {
+			outputs.apply(msg)
+		}
+		cell.Outputs = outputs.Outputs()
+
+		if result.Status != "ok" && stopOnError {
+			if result.Error != nil {
+				return fmt.Errorf("cell %d raised %s: %s", cell.CellIndex, result.Error.Ename, result.Error.Evalue)
+			}
+			return fmt.Errorf("cell %d finished with status '%s'", cell.CellIndex, result.Status)
+		}
+	}
+
+	return nil
+}
+
+// injectParametersCell returns a copy of the cells with the generated parameters cell placed after
+// the cell tagged "parameters", or first when no cell is tagged. Stored outputs are dropped.
+func injectParametersCell(cells []models.Cell, source string, notebookID string) []models.Cell {
+	insertAt := 0
+	for i := range cells {
+		if cells[i].HasTag(models.CellTagParameters) {
+			insertAt = i + 1
+			break
+		}
+	}
+
+	injected := models.Cell{
+		ID:         models.StringUUID(uuid.New()),
+		NotebookID: uuid.MustParse(notebookID),
+		CellName:   sql.NullString{String: "injected-parameters", Valid: true},
+		CellType:   "code",
+		Source:     source,
+		Metadata:   json.RawMessage(`{"tags":["` + CellTagInjectedParameters + `"]}`),
+	}
+
+	result := make([]models.Cell, 0, len(cells)+1)
+	for i, cell := range cells {
+		if i == insertAt {
+			result = append(result, injected)
+		}
+		cell.Outputs = nil
+		cell.EvolutionRuns = nil
+		cell.ExecutionCount = 0
+		result = append(result, cell)
+	}
+	if insertAt == len(cells) {
+		result = append(result, injected)
+	}
+	for i := range result {
+		result[i].CellIndex = i
+	}
+	return result
+}
+
+// renderParametersCell turns the parameter map into Python assignments, one per line in name order.
+func renderParametersCell(parameters map[string]json.RawMessage) (string, error) {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		if !pythonIdentifier.MatchString(name) || pythonKeywords[name] {
+			return "", fmt.Errorf("%w: '%s' is not a valid variable name", ErrInvalidParameters, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# Parameters\n")
+	for _, name := range names {
+		decoder := json.NewDecoder(bytes.NewReader(parameters[name]))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return "", fmt.Errorf("%w: '%s': %v", ErrInvalidParameters, name, err)
+		}
+		b.WriteString(name)
+		b.WriteString(" = ")
+		writePythonLiteral(&b, value)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// writePythonLiteral writes a decoded JSON value as the equivalent Python literal.
+func writePythonLiteral(b *strings.Builder, value any) {
+	switch v := value.(type) {
+	case nil:
+		b.WriteString("None")
+	case bool:
+		if v {
+			b.WriteString("True")
+		} else {
+			b.WriteString("False")
+		}
+	case json.Number:
+		b.WriteString(v.String())
+	case string:
+		// Go's quoted form only uses escapes that Python string literals understand.
+		b.WriteString(strconv.Quote(v))
+	case []any:
+		b.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writePythonLiteral(b, item)
+		}
+		b.WriteString("]")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(key))
+			b.WriteString(": ")
+			writePythonLiteral(b, v[key])
+		}
+		b.WriteString("}")
+	}
+}
@@ -2,17 +2,50 @@ package modules
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 )
 
+var (
+	// ErrInvalidEnvVar is returned when a notebook environment variable has an unusable name.
+	ErrInvalidEnvVar = errors.New("invalid environment variable")
+	// ErrProblemNotOwned is returned when a notebook is created under a problem statement the user
+	// does not own or that does not exist.
+	ErrProblemNotOwned = errors.New("problem statement not found or not owned by user")
+	// ErrNotebookNotFound is returned when a notebook does not exist or the user does not own it.
+	ErrNotebookNotFound = errors.New("notebook not found or not owned by user")
+)
+
+const (
+	// defaultImportTitle names imported notebooks that have no title.
+	defaultImportTitle = "Imported notebook"
+	// cellNameMetadata is the cell metadata entry exported notebooks keep the cell name in.
+	cellNameMetadata = "cell_name"
+)
+
+// envVarName matches the names accepted for notebook environment variables.
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // NotebookModule encapsulates business logic for notebooks.
 type NotebookModule struct {
 	repo        repository.NotebookRepository
 	ProblemRepo repository.ProblemRepository // Added ProblemRepository
+	// Blobs holds the binary and large output data of imported and exported notebooks.
+	Blobs blobstore.Store
+	// Sessions and Jupyter describe the kernel of exported notebooks.
+	Sessions repository.SessionRepository
+	Jupyter  jupyterclient.Gateway
 }
 
 // NewNotebookModule creates and returns a new NotebookModule.
@@ -26,6 +59,19 @@ func NewNotebookModule(
 	}
 }
 
+// WithBlobs sets the store that holds the binary and large output data of imported and exported notebooks.
+func (m *NotebookModule) WithBlobs(blobs blobstore.Store) *NotebookModule {
+	m.Blobs = blobs
+	return m
+}
+
+// WithKernels sets where exported notebooks find the kernel of their last session.
+func (m *NotebookModule) WithKernels(sessions repository.SessionRepository, jupyter jupyterclient.Gateway) *NotebookModule {
+	m.Sessions = sessions
+	m.Jupyter = jupyter
+	return m
+}
+
 // CreateNotebook handles the business logic for creating a new notebook.
 func (m *NotebookModule) CreateNotebook(
 	ctx context.Context,
@@ -35,21 +81,384 @@ func (m *NotebookModule) CreateNotebook(
 	if req == nil {
 		return nil, errors.New("invalid notebook request")
 	}
+	if err := validateEnvVars(req.EnvVars); err != nil {
+		return nil, err
+	}
 
-	// Verify ownership of the problem statement if provided
-	if req.ProblemStatementID == nil || *req.ProblemStatementID == "" {
-		return nil, errors.New("problem statement ID is required to create a notebook")
+	if err := m.checkProblemOwnership(ctx, req.ProblemStatementID, userID); err != nil {
+		return nil, err
 	}
 
-	problem, err := m.ProblemRepo.GetProblemByID(ctx, *req.ProblemStatementID)
+	return m.repo.CreateNotebook(ctx, req)
+}
+
+// checkProblemOwnership verifies that the problem statement a notebook is created under belongs to the user.
+func (m *NotebookModule) checkProblemOwnership(ctx context.Context, problemStatementID *string, userID string) error {
+	if problemStatementID == nil || *problemStatementID == "" {
+		return errors.New("problem statement ID is required to create a notebook")
+	}
+
+	problem, err := m.ProblemRepo.GetProblemByID(ctx, *problemStatementID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get problem statement for ownership verification: %w", err)
+		return fmt.Errorf("failed to get problem statement for ownership verification: %w", err)
 	}
 	if problem == nil || problem.CreatedBy.String() != userID {
-		return nil, errors.New("problem statement not found or not owned by user")
+		return ErrProblemNotOwned
 	}
+	return nil
+}
 
-	return m.repo.CreateNotebook(ctx, req)
+// ImportNotebook creates a notebook from an nbformat 4 file under a problem statement of the user,
+// with its cells, their outputs and its widget state. Content the notebook model cannot hold, such
+// as cell attachments, is dropped and listed in the result. A file that is not an nbformat 4
+// notebook is rejected with an error wrapping nbformat.ErrInvalid.
+func (m *NotebookModule) ImportNotebook(
+	ctx context.Context,
+	req *models.ImportNotebookRequest,
+	userID string,
+) (*models.NotebookImportResult, error) {
+	if req == nil {
+		return nil, errors.New("invalid import request")
+	}
+	switch {
+	case req.Format == "py" || (req.Format == "" && req.Script != ""):
+		return m.importScript(ctx, req, userID)
+	case req.Format != "" && req.Format != "ipynb":
+		return nil, fmt.Errorf("%w: unsupported import format '%s'", nbformat.ErrInvalid, req.Format)
+	}
+	nb, err := nbformat.Parse(req.Notebook)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkProblemOwnership(ctx, req.ProblemStatementID, userID); err != nil {
+		return nil, err
+	}
+
+	title := req.Title
+	if title == "" {
+		title = nbformat.StringMetadata(nb.Metadata, "title")
+	}
+	if title == "" {
+		title = defaultImportTitle
+	}
+
+	result := &models.NotebookImportResult{Unsupported: []string{}}
+	cells := make([]models.Cell, 0, len(nb.Cells))
+	for i, source := range nb.Cells {
+		cell, unsupported, err := m.importCell(ctx, i, source)
+		cells = append(cells, cell)
+		if err != nil {
+			m.deleteImportedBlobs(ctx, cells)
+			return nil, err
+		}
+		result.Unsupported = append(result.Unsupported, unsupported...)
+	}
+
+	createReq := &models.CreateNotebookRequest{Title: title, ProblemStatementID: req.ProblemStatementID}
+	if requirements := nbformat.StringMetadata(nb.Metadata, "requirements"); requirements != "" {
+		createReq.Requirements = &requirements
+	}
+	result.Notebook, err = m.repo.ImportNotebook(ctx, createReq, cells, nb.WidgetState())
+	if err != nil {
+		m.deleteImportedBlobs(ctx, cells)
+		return nil, fmt.Errorf("failed to store imported notebook: %w", err)
+	}
+	return result, nil
+}
+
+// deleteImportedBlobs removes the output data offloaded for cells whose import failed. It is best
+// effort: the blobs are merely orphaned when it fails.
+func (m *NotebookModule) deleteImportedBlobs(ctx context.Context, cells []models.Cell) {
+	ctx = context.WithoutCancel(ctx)
+	for _, cell := range cells {
+		for i := range cell.Outputs {
+			_ = deleteOutputBlobs(ctx, m.Blobs, &cell.Outputs[i])
+		}
+	}
+}
+
+// importScript creates a notebook from the cells of a percent-format script. Scripts hold no
+// outputs or metadata, so nothing is dropped.
+func (m *NotebookModule) importScript(
+	ctx context.Context,
+	req *models.ImportNotebookRequest,
+	userID string,
+) (*models.NotebookImportResult, error) {
+	scriptCells, err := nbformat.ParsePercent([]byte(req.Script))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkProblemOwnership(ctx, req.ProblemStatementID, userID); err != nil {
+		return nil, err
+	}
+
+	title := req.Title
+	if title == "" {
+		title = defaultImportTitle
+	}
+	cells := make([]models.Cell, 0, len(scriptCells))
+	for i, source := range scriptCells {
+		cell := models.Cell{
+			ID:        models.StringUUID(uuid.New()),
+			CellIndex: i,
+			CellType:  source.CellType,
+			Source:    source.Source,
+			Outputs:   []models.CellOutput{},
+		}
+		if source.Name != "" {
+			cell.CellName = sql.NullString{String: source.Name, Valid: true}
+		}
+		cells = append(cells, cell)
+	}
+
+	createReq := &models.CreateNotebookRequest{Title: title, ProblemStatementID: req.ProblemStatementID}
+	notebook, err := m.repo.ImportNotebook(ctx, createReq, cells, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store imported notebook: %w", err)
+	}
+	return &models.NotebookImportResult{Notebook: notebook, Unsupported: []string{}}, nil
+}
+
+// importCell converts the cell at index i of a notebook file, describing what it had to drop.
+func (m *NotebookModule) importCell(ctx context.Context, i int, source nbformat.Cell) (models.Cell, []string, error) {
+	var unsupported []string
+	cell := models.Cell{
+		ID:        models.StringUUID(uuid.New()),
+		CellIndex: i,
+		CellType:  source.CellType,
+		Source:    string(source.Source),
+		Outputs:   []models.CellOutput{},
+	}
+	// Notebooks exported by this service carry the name as cell_name; other tools use name.
+	name := nbformat.StringMetadata(source.Metadata, cellNameMetadata)
+	if name == "" {
+		name = nbformat.StringMetadata(source.Metadata, "name")
+	}
+	if name != "" {
+		cell.CellName = sql.NullString{String: name, Valid: true}
+	}
+	delete(source.Metadata, cellNameMetadata)
+	if len(source.Metadata) > 0 {
+		metadata, err := json.Marshal(source.Metadata)
+		if err != nil {
+			return cell, nil, err
+		}
+		cell.Metadata = metadata
+	}
+	if source.ExecutionCount != nil {
+		cell.ExecutionCount = *source.ExecutionCount
+	}
+	if len(source.Attachments) > 0 && string(source.Attachments) != "null" && string(source.Attachments) != "{}" {
+		unsupported = append(unsupported, fmt.Sprintf("cell %d: attachments are not supported and were dropped", i))
+	}
+	if source.CellType != "code" {
+		if len(source.Outputs) > 0 {
+			unsupported = append(unsupported, fmt.Sprintf("cell %d: outputs of a %s cell were dropped", i, source.CellType))
+		}
+		return cell, unsupported, nil
+	}
+
+	for j, out := range source.Outputs {
+		output := models.CellOutput{
+			ID:          uuid.New(),
+			CellID:      cell.ID,
+			OutputIndex: len(cell.Outputs),
+			Type:        out.OutputType,
+		}
+		var err error
+		switch out.OutputType {
+		case "stream":
+			output.DataJSON, err = json.Marshal(jupyterclient.StreamContent{Name: out.Name, Text: string(out.Text)})
+		case "display_data", "execute_result":
+			output.DataJSON, err = json.Marshal(out.MimeBundle())
+			if out.ExecutionCount != nil {
+				output.ExecutionCount = *out.ExecutionCount
+			}
+		case "error":
+			output.DataJSON, err = json.Marshal(jupyterclient.ErrorContent{Ename: out.Ename, Evalue: out.Evalue, Traceback: out.Traceback})
+		default:
+			unsupported = append(unsupported, fmt.Sprintf("cell %d, output %d: output_type '%s' is not supported and was dropped", i, j, out.OutputType))
+			continue
+		}
+		if err != nil {
+			return cell, nil, err
+		}
+		if len(out.Metadata) > 0 {
+			if output.Metadata, err = json.Marshal(out.Metadata); err != nil {
+				return cell, nil, err
+			}
+		}
+		// Entries that fail to upload stay inline, so the import loses nothing.
+		_ = offloadOutputData(ctx, m.Blobs, &output)
+		cell.Outputs = append(cell.Outputs, output)
+	}
+	return cell, unsupported, nil
+}
+
+// ExportNotebook assembles the notebook as an nbformat 4 document, with its outputs, widget state
+// and the kernel of its last session. Output data moved to the blob store is inlined again, so the
+// document is complete and imports back into the same notebook.
+func (m *NotebookModule) ExportNotebook(ctx context.Context, id string, userID string) (*nbformat.Notebook, error) {
+	notebook, err := m.GetNotebookByID(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	nb := nbformat.New()
+	if err := setMetadata(nb.Metadata, "title", notebook.Title); err != nil {
+		return nil, err
+	}
+	if notebook.Requirements.Valid && notebook.Requirements.String != "" {
+		if err := setMetadata(nb.Metadata, "requirements", notebook.Requirements.String); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.setKernelMetadata(ctx, uuid.MustParse(notebook.ID), nb.Metadata); err != nil {
+		return nil, err
+	}
+	if len(notebook.WidgetState) > 0 {
+		nb.SetWidgetState(notebook.WidgetState)
+	}
+
+	for i := range notebook.Cells {
+		cell, err := m.exportCell(ctx, &notebook.Cells[i])
+		if err != nil {
+			return nil, err
+		}
+		nb.Cells = append(nb.Cells, cell)
+	}
+	return nb, nil
+}
+
+// ExportNotebookScript writes the notebook's cells as a percent-format Python script, with each
+// cell's name in its "# %%" line. Outputs and metadata are left out.
+func (m *NotebookModule) ExportNotebookScript(ctx context.Context, id string, userID string) (string, []byte, error) {
+	notebook, err := m.GetNotebookByID(ctx, id, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	cells := make([]nbformat.PercentCell, 0, len(notebook.Cells))
+	for _, cell := range notebook.Cells {
+		cells = append(cells, nbformat.PercentCell{CellType: cell.CellType, Name: cell.CellName.String, Source: cell.Source})
+	}
+	return notebook.Title, nbformat.FormatPercent(cells), nil
+}
+
+// setKernelMetadata describes the kernel of the notebook's last session in the kernelspec and
+// language_info metadata. Notebooks that never had a session get neither.
+func (m *NotebookModule) setKernelMetadata(ctx context.Context, notebookID uuid.UUID, metadata map[string]json.RawMessage) error {
+	if m.Sessions == nil {
+		return nil
+	}
+	session, err := m.Sessions.GetLatestSessionByNotebookID(ctx, notebookID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get last session of notebook: %w", err)
+	}
+	if session.KernelName == "" {
+		return nil
+	}
+
+	kernelspec := map[string]string{"name": session.KernelName, "display_name": session.KernelName}
+	var language string
+	if m.Jupyter != nil {
+		// The kernelspec only adds display details, so the export goes on without the gateway.
+		if specs, err := m.Jupyter.GetKernelSpecs(ctx); err == nil {
+			if spec, ok := specs.KernelSpecs[session.KernelName]; ok {
+				kernelspec["display_name"] = spec.Spec.DisplayName
+				language = spec.Spec.Language
+			}
+		}
+	}
+	if language != "" {
+		kernelspec["language"] = language
+		if err := setMetadata(metadata, "language_info", map[string]string{"name": language}); err != nil {
+			return err
+		}
+	}
+	return setMetadata(metadata, "kernelspec", kernelspec)
+}
+
+// exportCell converts a cell to its nbformat form, keeping its name in the cell_name metadata.
+func (m *NotebookModule) exportCell(ctx context.Context, cell *models.Cell) (nbformat.Cell, error) {
+	exported := nbformat.Cell{
+		ID:       cell.ID.ToUUID().String(),
+		CellType: cell.CellType,
+		Source:   nbformat.MultilineString(cell.Source),
+		Metadata: map[string]json.RawMessage{},
+	}
+	if len(cell.Metadata) > 0 {
+		if err := json.Unmarshal(cell.Metadata, &exported.Metadata); err != nil || exported.Metadata == nil {
+			exported.Metadata = map[string]json.RawMessage{}
+		}
+	}
+	if cell.CellName.Valid && cell.CellName.String != "" {
+		if err := setMetadata(exported.Metadata, cellNameMetadata, cell.CellName.String); err != nil {
+			return exported, err
+		}
+	}
+	if cell.CellType != "code" {
+		return exported, nil
+	}
+
+	exported.ExecutionCount = executionCount(cell.ExecutionCount)
+	exported.Outputs = []nbformat.Output{}
+	for i := range cell.Outputs {
+		output := &cell.Outputs[i]
+		out := nbformat.Output{OutputType: output.Type}
+		switch output.Type {
+		case "stream":
+			var content jupyterclient.StreamContent
+			if err := json.Unmarshal(output.DataJSON, &content); err != nil {
+				return exported, fmt.Errorf("failed to decode output %s: %w", output.ID, err)
+			}
+			out.Name, out.Text = content.Name, nbformat.MultilineString(content.Text)
+		case "display_data", "execute_result":
+			data, err := inlineOutputData(ctx, m.Blobs, output)
+			if err != nil {
+				return exported, fmt.Errorf("failed to read output %s: %w", output.ID, err)
+			}
+			out.Data = data
+			if len(output.Metadata) > 0 {
+				if err := json.Unmarshal(output.Metadata, &out.Metadata); err != nil {
+					return exported, fmt.Errorf("failed to decode metadata of output %s: %w", output.ID, err)
+				}
+			}
+			if output.Type == "execute_result" {
+				out.ExecutionCount = executionCount(output.ExecutionCount)
+			}
+		case "error":
+			var content jupyterclient.ErrorContent
+			if err := json.Unmarshal(output.DataJSON, &content); err != nil {
+				return exported, fmt.Errorf("failed to decode output %s: %w", output.ID, err)
+			}
+			out.Ename, out.Evalue, out.Traceback = content.Ename, content.Evalue, content.Traceback
+		default:
+			continue
+		}
+		exported.Outputs = append(exported.Outputs, out)
+	}
+	return exported, nil
+}
+
+// executionCount returns the nbformat execution count of a stored count, where 0 means never run.
+func executionCount(count int) *int {
+	if count == 0 {
+		return nil
+	}
+	return &count
+}
+
+// setMetadata encodes value as the metadata entry key.
+func setMetadata(metadata map[string]json.RawMessage, key string, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	metadata[key] = encoded
+	return nil
 }
 
 // ListNotebooks handles the business logic for listing notebooks.
@@ -79,7 +488,7 @@ func (m *NotebookModule) GetNotebookByID(
 		return nil, err
 	}
 	if nb == nil || nb.ID == "" { // Check if notebook was actually found
-		return nil, errors.New("notebook not found or not owned by user")
+		return nil, ErrNotebookNotFound
 	}
 	return nb, nil
 }
@@ -94,6 +503,9 @@ func (m *NotebookModule) UpdateNotebook(
 	if req == nil {
 		return nil, errors.New("invalid update request")
 	}
+	if err := validateEnvVars(req.EnvVars); err != nil {
+		return nil, err
+	}
 
 	// The repository will enforce ownership.
 	updated, err := m.repo.UpdateNotebook(ctx, id, req, userID)
@@ -107,6 +519,16 @@ func (m *NotebookModule) UpdateNotebook(
 	return updated, nil
 }
 
+// validateEnvVars checks that every notebook environment variable name can be set in a kernel.
+func validateEnvVars(env map[string]string) error {
+	for name := range env {
+		if !envVarName.MatchString(name) {
+			return fmt.Errorf("%w: '%s' must contain only letters, digits and underscores and not start with a digit", ErrInvalidEnvVar, name)
+		}
+	}
+	return nil
+}
+
 // DeleteNotebook handles the business logic for deleting a notebook.
 func (m *NotebookModule) DeleteNotebook(
 	ctx context.Context,
@@ -114,9 +536,11 @@ func (m *NotebookModule) DeleteNotebook(
 	userID string,
 ) error {
 	// The repository will enforce ownership.
-	err := m.repo.DeleteNotebook(ctx, id, userID)
+	outputs, err := m.repo.DeleteNotebook(ctx, id, userID)
 	if err != nil {
 		return err
 	}
+	// The notebook is gone; blobs that fail to delete are merely orphaned.
+	_ = deleteOutputsBlobs(ctx, m.Blobs, outputs)
 	return nil
 }
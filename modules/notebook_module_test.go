@@ -0,0 +1,281 @@
+package modules_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+)
+
+// memoryNotebookRepo keeps imported notebooks in memory; methods the tests do not need are left to
+// the embedded interface.
+type memoryNotebookRepo struct {
+	repository.NotebookRepository
+	notebooks map[string]*models.Notebook
+	// importErr fails ImportNotebook.
+	importErr error
+}
+
+func (r *memoryNotebookRepo) ImportNotebook(ctx context.Context, req *models.CreateNotebookRequest, cells []models.Cell, widgetState json.RawMessage) (*models.Notebook, error) {
+	if r.importErr != nil {
+		return nil, r.importErr
+	}
+	nb := &models.Notebook{ID: uuid.NewString(), Title: req.Title, ProblemStatementID: req.ProblemStatementID, Cells: cells, WidgetState: widgetState}
+	if req.Requirements != nil {
+		nb.Requirements.String, nb.Requirements.Valid = *req.Requirements, true
+	}
+	r.notebooks[nb.ID] = nb
+	return nb, nil
+}
+
+func (r *memoryNotebookRepo) GetNotebookByID(ctx context.Context, id string, userID string) (*models.Notebook, error) {
+	nb, ok := r.notebooks[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return nb, nil
+}
+
+// ownedProblems answers GetProblemByID for problem statements created by one user.
+type ownedProblems struct {
+	repository.ProblemRepository
+	problemID string
+	userID    uuid.UUID
+}
+
+func (r ownedProblems) GetProblemByID(ctx context.Context, problemID string) (*models.ProblemStatement, error) {
+	if problemID != r.problemID {
+		return nil, nil
+	}
+	return &models.ProblemStatement{CreatedBy: r.userID}, nil
+}
+
+func (r *memorySessionRepo) GetLatestSessionByNotebookID(ctx context.Context, notebookID uuid.UUID) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest *models.Session
+	for _, session := range r.sessions {
+		if session.NotebookID == notebookID && (latest == nil || session.LastActiveAt.After(latest.LastActiveAt)) {
+			latest = session
+		}
+	}
+	if latest == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return latest, nil
+}
+
+func TestNotebookModuleImportExport(t *testing.T) {
+	gateway := fakegateway.New("")
+	t.Cleanup(gateway.Close)
+
+	userID, problemID := uuid.New(), uuid.NewString()
+	notebooks := &memoryNotebookRepo{notebooks: make(map[string]*models.Notebook)}
+	sessions := newMemorySessionRepo()
+	blobs, err := blobstore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	module := modules.NewNotebookModule(notebooks, ownedProblems{problemID: problemID, userID: userID}).
+		WithBlobs(blobs).
+		WithKernels(sessions, gateway.Client())
+
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG fake image"))
+	file := `{
+		"nbformat": 4, "nbformat_minor": 4,
+		"metadata": {"title": "Iris", "requirements": "numpy",
+			"widgets": {"application/vnd.jupyter.widget-state+json": {"state": {}, "version_major": 2}}},
+		"cells": [
+			{"cell_type": "markdown", "metadata": {}, "source": ["# Iris\n", "Notes"],
+			 "attachments": {"a.png": {"image/png": "` + png + `"}}},
+			{"cell_type": "code", "metadata": {"name": "load", "tags": ["setup"]}, "execution_count": 2, "source": ["x = 1\n", "x"],
+			 "outputs": [
+				{"output_type": "stream", "name": "stdout", "text": ["a\n", "b\n"]},
+				{"output_type": "execute_result", "execution_count": 2, "metadata": {"image/png": {"width": 320}},
+				 "data": {"text/plain": "1", "image/png": "` + png + `", "application/json": {"x": 1}}},
+				{"output_type": "error", "ename": "ValueError", "evalue": "bad", "traceback": ["line 1"]},
+				{"output_type": "pyout"}
+			 ]},
+			{"cell_type": "code", "metadata": {}, "execution_count": null, "source": "", "outputs": []}
+		]
+	}`
+
+	ctx := context.Background()
+	result, err := module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Notebook: json.RawMessage(file)}, userID.String())
+	if err != nil {
+		t.Fatalf("ImportNotebook: %v", err)
+	}
+	if len(result.Unsupported) != 2 {
+		t.Errorf("unsupported = %q, want the attachment and the pyout output", result.Unsupported)
+	}
+	imported := result.Notebook
+	if imported.Title != "Iris" || len(imported.Cells) != 3 {
+		t.Fatalf("imported notebook %q with %d cells, want Iris with 3", imported.Title, len(imported.Cells))
+	}
+	code := imported.Cells[1]
+	if code.CellName.String != "load" || code.ExecutionCount != 2 || code.Source != "x = 1\nx" || len(code.Outputs) != 3 {
+		t.Fatalf("imported code cell = %+v", code)
+	}
+	if code.Outputs[1].MinioURL == "" {
+		t.Error("image output data was not moved to the blob store")
+	}
+	if string(code.Outputs[1].Metadata) != `{"image/png":{"width":320}}` {
+		t.Errorf("execute_result metadata = %s, want the image width", code.Outputs[1].Metadata)
+	}
+
+	sessions.sessions[uuid.New()] = &models.Session{NotebookID: uuid.MustParse(imported.ID), KernelName: fakegateway.DefaultKernelSpec, LastActiveAt: time.Now()}
+	exported, err := module.ExportNotebook(ctx, imported.ID, userID.String())
+	if err != nil {
+		t.Fatalf("ExportNotebook: %v", err)
+	}
+	data, err := exported.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	nb, err := nbformat.Parse(data)
+	if err != nil {
+		t.Fatalf("exported notebook does not parse: %v", err)
+	}
+
+	if nb.NBFormatMinor != nbformat.Minor || nbformat.StringMetadata(nb.Metadata, "requirements") != "numpy" || nb.WidgetState() == nil {
+		t.Errorf("notebook metadata = %s", data)
+	}
+	var kernelspec map[string]string
+	if err := json.Unmarshal(nb.Metadata["kernelspec"], &kernelspec); err != nil || kernelspec["display_name"] != "Python 3" || kernelspec["language"] != "python" {
+		t.Errorf("kernelspec = %s", nb.Metadata["kernelspec"])
+	}
+
+	cell := nb.Cells[1]
+	if cell.ID != code.ID.ToUUID().String() || nbformat.StringMetadata(cell.Metadata, "cell_name") != "load" {
+		t.Errorf("code cell id %q, metadata %v", cell.ID, cell.Metadata)
+	}
+	if cell.ExecutionCount == nil || *cell.ExecutionCount != 2 || len(cell.Outputs) != 3 {
+		t.Fatalf("exported code cell = %+v", cell)
+	}
+	if string(cell.Outputs[0].Text) != "a\nb\n" || cell.Outputs[2].Ename != "ValueError" {
+		t.Errorf("exported outputs = %+v", cell.Outputs)
+	}
+	wantData := map[string]json.RawMessage{
+		"text/plain":       json.RawMessage(`"1"`),
+		"image/png":        json.RawMessage(`"` + png + `"`),
+		"application/json": json.RawMessage(`{"x":1}`),
+	}
+	if gotData := cell.Outputs[1].MimeBundle(); !reflect.DeepEqual(compactBundle(gotData), wantData) {
+		t.Errorf("execute_result data = %s", cell.Outputs[1].Data)
+	}
+	if width := string(compactBundle(cell.Outputs[1].Metadata)["image/png"]); width != `{"width":320}` {
+		t.Errorf("execute_result metadata = %v, want the image width", cell.Outputs[1].Metadata)
+	}
+	if count := cell.Outputs[1].ExecutionCount; count == nil || *count != 2 {
+		t.Errorf("execute_result execution_count = %v, want 2", count)
+	}
+
+	var unrun struct {
+		ExecutionCount *int              `json:"execution_count"`
+		Outputs        []json.RawMessage `json:"outputs"`
+	}
+	raw, _ := json.Marshal(nb.Cells[2])
+	if err := json.Unmarshal(raw, &unrun); err != nil || unrun.ExecutionCount != nil || unrun.Outputs == nil {
+		t.Errorf("unrun code cell = %s", raw)
+	}
+
+	// Importing the export again gives back the same cells.
+	again, err := module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Notebook: data}, userID.String())
+	if err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	if len(again.Unsupported) != 0 {
+		t.Errorf("re-import dropped %q", again.Unsupported)
+	}
+	for i, cell := range again.Notebook.Cells {
+		before := imported.Cells[i]
+		if cell.CellType != before.CellType || cell.Source != before.Source || cell.CellName != before.CellName ||
+			cell.ExecutionCount != before.ExecutionCount || len(cell.Outputs) != len(before.Outputs) {
+			t.Errorf("cell %d changed on the round trip: %+v, was %+v", i, cell, before)
+		}
+	}
+}
+
+func TestNotebookModuleImportRejects(t *testing.T) {
+	userID, problemID := uuid.New(), uuid.NewString()
+	module := modules.NewNotebookModule(&memoryNotebookRepo{notebooks: make(map[string]*models.Notebook)}, ownedProblems{problemID: problemID, userID: userID})
+	ctx := context.Background()
+
+	_, err := module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Notebook: json.RawMessage(`{"nbformat": 3, "cells": []}`)}, userID.String())
+	if !errors.Is(err, nbformat.ErrInvalid) {
+		t.Errorf("nbformat 3: err = %v, want ErrInvalid", err)
+	}
+	_, err = module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Notebook: json.RawMessage(`{"nbformat": 4, "cells": []}`)}, uuid.NewString())
+	if !errors.Is(err, modules.ErrProblemNotOwned) {
+		t.Errorf("other user's problem: err = %v, want ErrProblemNotOwned", err)
+	}
+	_, err = module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Format: "rmd"}, userID.String())
+	if !errors.Is(err, nbformat.ErrInvalid) {
+		t.Errorf("unknown format: err = %v, want ErrInvalid", err)
+	}
+
+	// An empty script is an empty notebook, not a missing notebook file.
+	result, err := module.ImportNotebook(ctx, &models.ImportNotebookRequest{ProblemStatementID: &problemID, Format: "py"}, userID.String())
+	if err != nil || len(result.Notebook.Cells) != 0 {
+		t.Errorf("empty script: result = %+v, err = %v, want an empty notebook", result, err)
+	}
+}
+
+func TestNotebookModuleImportDeletesBlobsOnFailure(t *testing.T) {
+	userID, problemID := uuid.New(), uuid.NewString()
+	dir := t.TempDir()
+	blobs, err := blobstore.NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	repo := &memoryNotebookRepo{notebooks: make(map[string]*models.Notebook), importErr: errors.New("connection reset")}
+	module := modules.NewNotebookModule(repo, ownedProblems{problemID: problemID, userID: userID}).WithBlobs(blobs)
+
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG fake image"))
+	file := `{"nbformat": 4, "nbformat_minor": 4, "metadata": {}, "cells": [
+		{"cell_type": "code", "metadata": {}, "execution_count": 1, "source": "plot()",
+		 "outputs": [{"output_type": "display_data", "metadata": {}, "data": {"image/png": "` + png + `"}}]}
+	]}`
+	if _, err := module.ImportNotebook(context.Background(), &models.ImportNotebookRequest{ProblemStatementID: &problemID, Notebook: json.RawMessage(file)}, userID.String()); err == nil {
+		t.Fatal("ImportNotebook succeeded although the notebook was not stored")
+	}
+
+	var left []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			left = append(left, path)
+		}
+		return nil
+	})
+	if len(left) != 0 {
+		t.Errorf("blobs left behind by the failed import: %q", left)
+	}
+}
+
+// compactBundle strips the insignificant whitespace of JSON entries so bundles compare by content.
+func compactBundle(bundle map[string]json.RawMessage) map[string]json.RawMessage {
+	compacted := make(map[string]json.RawMessage, len(bundle))
+	for mime, value := range bundle {
+		var v any
+		if err := json.Unmarshal(value, &v); err != nil {
+			compacted[mime] = value
+			continue
+		}
+		compacted[mime], _ = json.Marshal(v)
+	}
+	return compacted
+}
@@ -0,0 +1,255 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+	"github.com/google/uuid"
+)
+
+// outputInlineLimit is the encoded size past which a mime bundle entry is moved to the blob store.
+const outputInlineLimit = 64 << 10
+
+// ErrOutputDataNotFound is returned when an output has no data of the requested mime type.
+var ErrOutputDataNotFound = errors.New("output has no data of this mime type")
+
+// outputDataPrefix is the blob store key under which the offloaded mime bundle entries of an output
+// are kept, one object per mime type. It is recorded in the output's minio_url.
+func outputDataPrefix(outputID uuid.UUID) string {
+	return fmt.Sprintf("outputs/%s/data", outputID)
+}
+
+// isBinaryMime reports whether Jupyter sends data of the mime type base64-encoded.
+func isBinaryMime(mime string) bool {
+	switch {
+	case strings.HasPrefix(mime, "text/"), nbformat.IsJSONMime(mime), mime == "image/svg+xml", mime == "application/javascript":
+		return false
+	}
+	return strings.HasPrefix(mime, "image/") || strings.HasPrefix(mime, "audio/") || strings.HasPrefix(mime, "video/") ||
+		mime == "application/pdf" || mime == "application/octet-stream"
+}
+
+// offloadOutputData moves the binary and oversized entries of a display_data or execute_result
+// output's mime bundle to the blob store. Each moved entry is left as null in the bundle, and the
+// key prefix of the blobs is recorded in MinioURL. Entries that fail to upload stay inline.
+func offloadOutputData(ctx context.Context, blobs blobstore.Store, output *models.CellOutput) error {
+	if blobs == nil || (output.Type != "display_data" && output.Type != "execute_result") {
+		return nil
+	}
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(output.DataJSON, &bundle); err != nil {
+		return fmt.Errorf("failed to decode mime bundle: %w", err)
+	}
+
+	prefix := outputDataPrefix(output.ID)
+	var offloaded bool
+	var firstErr error
+	for mime, value := range bundle {
+		if string(value) == "null" || (!isBinaryMime(mime) && len(value) <= outputInlineLimit) {
+			continue
+		}
+		content := mimeContent(mime, value)
+		if err := blobs.Put(ctx, prefix+"/"+mime, bytes.NewReader(content), int64(len(content)), mime); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to store %s data: %w", mime, err)
+			}
+			continue
+		}
+		bundle[mime] = json.RawMessage("null")
+		offloaded = true
+	}
+	if !offloaded {
+		return firstErr
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to encode mime bundle: %w", err)
+	}
+	output.DataJSON = data
+	output.MinioURL = prefix
+	return firstErr
+}
+
+// mimeContent returns the bytes a mime bundle entry stands for: the decoded data of binary types,
+// the document of JSON types and the text of the others.
+func mimeContent(mime string, value json.RawMessage) []byte {
+	if nbformat.IsJSONMime(mime) {
+		return value
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return value
+	}
+	if isBinaryMime(mime) {
+		// Base64 data may be wrapped over several lines.
+		if decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), "")); err == nil {
+			return decoded
+		}
+	}
+	return []byte(text)
+}
+
+// openOutputData returns the content of one mime bundle entry of an output, from the blob store
+// when it was offloaded.
+func openOutputData(ctx context.Context, blobs blobstore.Store, output *models.CellOutput, mime string) (io.ReadCloser, error) {
+	if output.Type != "display_data" && output.Type != "execute_result" {
+		return nil, ErrOutputDataNotFound
+	}
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(output.DataJSON, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to decode mime bundle: %w", err)
+	}
+	value, ok := bundle[mime]
+	if !ok {
+		return nil, ErrOutputDataNotFound
+	}
+	if string(value) != "null" {
+		return io.NopCloser(bytes.NewReader(mimeContent(mime, value))), nil
+	}
+
+	if output.MinioURL == "" || blobs == nil {
+		return nil, ErrOutputDataNotFound
+	}
+	r, err := blobs.Get(ctx, output.MinioURL+"/"+mime)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, ErrOutputDataNotFound
+	}
+	return r, err
+}
+
+// inlineOutputData returns the mime bundle of a display_data or execute_result output with its
+// offloaded entries read back from the blob store, encoded as Jupyter sends them: base64 for binary
+// types, the document for JSON types and a string for the others. Entries whose blob is gone are left out.
+func inlineOutputData(ctx context.Context, blobs blobstore.Store, output *models.CellOutput) (map[string]json.RawMessage, error) {
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(output.DataJSON, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to decode mime bundle: %w", err)
+	}
+	for mime, value := range bundle {
+		if string(value) != "null" {
+			continue
+		}
+		content, err := readOutputBlob(ctx, blobs, output, mime)
+		if errors.Is(err, ErrOutputDataNotFound) {
+			delete(bundle, mime)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case nbformat.IsJSONMime(mime) && json.Valid(content):
+			bundle[mime] = content
+		case isBinaryMime(mime):
+			bundle[mime], err = json.Marshal(base64.StdEncoding.EncodeToString(content))
+		default:
+			bundle[mime], err = json.Marshal(string(content))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+// readOutputBlob reads the offloaded data of one mime type of an output.
+func readOutputBlob(ctx context.Context, blobs blobstore.Store, output *models.CellOutput, mime string) ([]byte, error) {
+	if output.MinioURL == "" || blobs == nil {
+		return nil, ErrOutputDataNotFound
+	}
+	r, err := blobs.Get(ctx, output.MinioURL+"/"+mime)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, ErrOutputDataNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s data: %w", mime, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s data: %w", mime, err)
+	}
+	return content, nil
+}
+
+// deleteOutputBlobs removes the buffers and offloaded data of an output from the blob store.
+func deleteOutputBlobs(ctx context.Context, blobs blobstore.Store, output *models.CellOutput) error {
+	if blobs == nil {
+		return nil
+	}
+	keys := append([]string(nil), output.BufferKeys...)
+	for _, mime := range offloadedMimes(output) {
+		keys = append(keys, output.MinioURL+"/"+mime)
+	}
+	return deleteBlobs(ctx, blobs, keys)
+}
+
+// deleteOutputsBlobs removes the blobs of several outputs, returning the first error.
+func deleteOutputsBlobs(ctx context.Context, blobs blobstore.Store, outputs []*models.CellOutput) error {
+	var firstErr error
+	for _, output := range outputs {
+		if err := deleteOutputBlobs(ctx, blobs, output); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// deleteStaleOutputData removes the blobs of the entries an output had offloaded, listed in
+// previous, that its new data keeps inline or no longer has.
+func deleteStaleOutputData(ctx context.Context, blobs blobstore.Store, output *models.CellOutput, previous []string) error {
+	if blobs == nil || len(previous) == 0 {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, mime := range offloadedMimes(output) {
+		current[mime] = true
+	}
+	var keys []string
+	for _, mime := range previous {
+		if !current[mime] {
+			keys = append(keys, outputDataPrefix(output.ID)+"/"+mime)
+		}
+	}
+	return deleteBlobs(ctx, blobs, keys)
+}
+
+// offloadedMimes returns the mime types of the entries of an output's mime bundle kept in the blob store.
+func offloadedMimes(output *models.CellOutput) []string {
+	if output.MinioURL == "" {
+		return nil
+	}
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(output.DataJSON, &bundle); err != nil {
+		return nil
+	}
+	var mimes []string
+	for mime, value := range bundle {
+		if string(value) == "null" {
+			mimes = append(mimes, mime)
+		}
+	}
+	return mimes
+}
+
+// deleteBlobs removes blobs, returning the first error.
+func deleteBlobs(ctx context.Context, blobs blobstore.Store, keys []string) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := blobs.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,153 @@
+package modules
+
+import (
+	"encoding/json"
+
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+)
+
+// maxCoalescedStream is the text size past which stream chunks start a new output.
+const maxCoalescedStream = 64 << 10
+
+// outputList applies the output messages of an execution to a cell's outputs the way a Jupyter
+// frontend does: consecutive stream chunks of the same name are merged, clear_output empties the
+// cell, right away or when the next output arrives, and update_display_data replaces the data of
+// the outputs showing its display_id.
+type outputList struct {
+	cellID uuid.UUID
+	// keepAll keeps every output. Otherwise only the last output and the outputs with a display_id,
+	// which later messages may change, are kept.
+	keepAll bool
+
+	outputs []*models.CellOutput
+	// next is the index of the next output.
+	next int
+	// stream is the content of the last output while it is a stream output further chunks can be
+	// merged into. Its text is copied to the output's data by sync.
+	stream *jupyterclient.StreamContent
+	// clearOnNext is set by clear_output with wait, until the next output arrives.
+	clearOnNext bool
+}
+
+// outputChange describes what applying one message did to an outputList.
+type outputChange struct {
+	// cleared reports that the cell's earlier outputs were removed.
+	cleared bool
+	// added is the output the message appended.
+	added *models.CellOutput
+	// updated are the existing outputs the message changed.
+	updated []*models.CellOutput
+	// displayID and display are set by an update_display_data for a display the list does not hold,
+	// which may be shown by another cell of the notebook.
+	displayID string
+	display   json.RawMessage
+}
+
+func newOutputList(cellID uuid.UUID, keepAll bool) *outputList {
+	return &outputList{cellID: cellID, keepAll: keepAll}
+}
+
+// apply applies a kernel message to the outputs. Messages that do not affect outputs change nothing.
+func (l *outputList) apply(msg *jupyterclient.Message) outputChange {
+	var change outputChange
+
+	switch msg.Header.MsgType {
+	case "clear_output":
+		var content jupyterclient.ClearOutputContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return change
+		}
+		if content.Wait {
+			l.clearOnNext = true
+			return change
+		}
+		l.clear()
+		change.cleared = true
+		return change
+
+	case "update_display_data":
+		var content jupyterclient.DisplayDataContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return change
+		}
+		displayID := content.DisplayID()
+		data, err := json.Marshal(content.Data)
+		if displayID == "" || err != nil {
+			return change
+		}
+		for _, output := range l.outputs {
+			if output.DisplayID == displayID {
+				output.DataJSON = data
+				change.updated = append(change.updated, output)
+			}
+		}
+		if len(change.updated) == 0 {
+			change.displayID, change.display = displayID, data
+		}
+		return change
+	}
+
+	output, err := CellOutputFromMessage(msg)
+	if err != nil {
+		return change
+	}
+	if l.clearOnNext {
+		l.clear()
+		change.cleared = true
+	}
+
+	var stream *jupyterclient.StreamContent
+	if output.Type == "stream" && len(msg.Buffers) == 0 {
+		stream = &jupyterclient.StreamContent{}
+		if err := json.Unmarshal(output.DataJSON, stream); err != nil {
+			stream = nil
+		}
+	}
+	if stream != nil && l.stream != nil && l.stream.Name == stream.Name &&
+		len(l.stream.Text)+len(stream.Text) <= maxCoalescedStream {
+		l.stream.Text += stream.Text
+		change.updated = append(change.updated, l.outputs[len(l.outputs)-1])
+		return change
+	}
+
+	l.sync()
+	if !l.keepAll && len(l.outputs) > 0 && l.outputs[len(l.outputs)-1].DisplayID == "" {
+		l.outputs = l.outputs[:len(l.outputs)-1]
+	}
+	output.CellID = models.StringUUID(l.cellID)
+	output.OutputIndex = l.next
+	l.next++
+	l.outputs = append(l.outputs, output)
+	l.stream = stream
+	change.added = output
+	return change
+}
+
+// sync copies the text merged into the last stream output to its data.
+func (l *outputList) sync() {
+	if l.stream == nil {
+		return
+	}
+	if data, err := json.Marshal(l.stream); err == nil {
+		l.outputs[len(l.outputs)-1].DataJSON = data
+	}
+}
+
+// Outputs returns the outputs of a list created with keepAll, in order.
+func (l *outputList) Outputs() []models.CellOutput {
+	l.sync()
+	outputs := make([]models.CellOutput, 0, len(l.outputs))
+	for _, output := range l.outputs {
+		outputs = append(outputs, *output)
+	}
+	return outputs
+}
+
+func (l *outputList) clear() {
+	l.outputs = nil
+	l.next = 0
+	l.stream = nil
+	l.clearOnNext = false
+}
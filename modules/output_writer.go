@@ -0,0 +1,291 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// Budgets of the output writer.
+const (
+	// outputBatchSize is how many outputs are buffered before they are written.
+	outputBatchSize = 64
+	// outputFlushInterval is how long an output may stay buffered before it is written.
+	outputFlushInterval = 200 * time.Millisecond
+	// outputQueueSize is how many messages may wait for the writer before Write blocks.
+	outputQueueSize = 256
+	// outputWriteTimeout bounds each database call of the writer.
+	outputWriteTimeout = 30 * time.Second
+	// outputClearAttempts is how many flushes try to clear the cell's stored outputs before the
+	// writer gives up on saving the execution's outputs.
+	outputClearAttempts = 3
+)
+
+// outputWriter persists the outputs of one execution of a cell, which replace the cell's earlier
+// outputs. It applies the execution's messages to an outputList, so the stored outputs match what
+// a frontend shows, and saves the outputs that changed in batches from its own goroutine. Write
+// blocks while the writer is outputQueueSize messages behind, so a kernel flooding its output slows
+// its frontends down instead of piling up in memory.
+//
+// Write, Flush and Close must not be called concurrently with Close.
+type outputWriter struct {
+	cellID uuid.UUID
+	repo   repository.CellRepository
+	blobs  blobstore.Store
+	logger zerolog.Logger
+
+	ops  chan outputOp
+	done chan struct{}
+
+	// The fields below are owned by the run goroutine until done is closed.
+
+	list    *outputList
+	pending []*models.CellOutput
+	// clearStored reports whether the cell's stored outputs must be deleted before pending is saved.
+	clearStored bool
+	// clearFailures counts the failed attempts to clear the stored outputs; once it reaches
+	// outputClearAttempts the writer drops the outputs instead of saving them.
+	clearFailures int
+	// offloaded holds the mime types each saved output keeps in the blob store, by output ID.
+	offloaded map[uuid.UUID][]string
+	// err is the first error that lost outputs.
+	err error
+}
+
+// outputOp is a message to write or a flush request.
+type outputOp struct {
+	msg   *jupyterclient.Message
+	flush bool
+}
+
+// newOutputWriter starts a writer for a new execution of the cell. With keepOutputs the writer
+// keeps all outputs in memory, to be returned by Outputs.
+func newOutputWriter(cellID uuid.UUID, repo repository.CellRepository, blobs blobstore.Store, logger zerolog.Logger, keepOutputs bool) *outputWriter {
+	w := &outputWriter{
+		cellID:      cellID,
+		repo:        repo,
+		blobs:       blobs,
+		logger:      logger,
+		ops:         make(chan outputOp, outputQueueSize),
+		done:        make(chan struct{}),
+		list:        newOutputList(cellID, keepOutputs),
+		clearStored: true,
+		offloaded:   make(map[uuid.UUID][]string),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a kernel message. Messages that do not affect outputs are ignored.
+func (w *outputWriter) Write(msg *jupyterclient.Message) {
+	w.ops <- outputOp{msg: msg}
+}
+
+// Flush has every output written so far saved without waiting for the next batch. It does not
+// wait for the save.
+func (w *outputWriter) Flush() {
+	w.ops <- outputOp{flush: true}
+}
+
+// Close saves the remaining outputs and stops the writer. It returns the first error that lost outputs.
+func (w *outputWriter) Close() error {
+	close(w.ops)
+	<-w.done
+	return w.err
+}
+
+// Outputs returns the outputs of a closed writer created with keepOutputs, in order.
+func (w *outputWriter) Outputs() []models.CellOutput {
+	return w.list.Outputs()
+}
+
+func (w *outputWriter) run() {
+	defer close(w.done)
+
+	var flushTimer <-chan time.Time
+	for {
+		select {
+		case op, ok := <-w.ops:
+			if !ok {
+				for w.flush() {
+					time.Sleep(outputFlushInterval)
+				}
+				return
+			}
+			if op.msg != nil {
+				w.apply(op.msg)
+			}
+			// While the clear is failing, the pending outputs wait for the timer to retry it.
+			if w.clearFailures == 0 && (op.flush || len(w.pending) >= outputBatchSize) {
+				w.flush()
+			}
+		case <-flushTimer:
+			flushTimer = nil
+			w.flush()
+		}
+
+		switch {
+		case len(w.pending) == 0 && !w.clearStored:
+			flushTimer = nil
+		case flushTimer == nil:
+			flushTimer = time.After(outputFlushInterval)
+		}
+	}
+}
+
+// apply applies a message to the outputs and queues what it changed.
+func (w *outputWriter) apply(msg *jupyterclient.Message) {
+	change := w.list.apply(msg)
+	if change.cleared {
+		w.pending = w.pending[:0]
+		w.clearStored = true
+	}
+	if change.added != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), outputWriteTimeout)
+		err := storeOutputBuffers(ctx, w.blobs, change.added, msg.Buffers)
+		cancel()
+		if err != nil {
+			// The output is still worth keeping without its buffers.
+			w.logger.Error().Err(err).Str("cell_id", w.cellID.String()).Msg("failed to store output buffers")
+		}
+		w.queue(change.added)
+	}
+	for _, output := range change.updated {
+		w.queue(output)
+	}
+
+	if change.displayID != "" {
+		w.updateDisplay(change.displayID, change.display)
+	}
+}
+
+// updateDisplay replaces the data of the stored outputs showing a display_id in the cell's notebook.
+func (w *outputWriter) updateDisplay(displayID string, data json.RawMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), outputWriteTimeout)
+	defer cancel()
+	outputs, err := w.repo.GetDisplayOutputs(ctx, w.cellID, displayID)
+	if err != nil {
+		w.logger.Error().Err(err).Str("display_id", displayID).Msg("failed to list display outputs")
+		return
+	}
+	for _, output := range outputs {
+		previous := offloadedMimes(output)
+		output.DataJSON, output.MinioURL = data, ""
+		if err := offloadOutputData(ctx, w.blobs, output); err != nil {
+			w.logger.Error().Err(err).Str("output_id", output.ID.String()).Msg("failed to offload output data")
+		}
+		if err := w.repo.UpdateCellOutputData(ctx, output.ID, output.DataJSON, output.MinioURL); err != nil {
+			w.logger.Error().Err(err).Str("display_id", displayID).Msg("failed to update display data")
+			continue
+		}
+		if err := deleteStaleOutputData(ctx, w.blobs, output, previous); err != nil {
+			w.logger.Warn().Err(err).Str("output_id", output.ID.String()).Msg("failed to delete output blobs")
+		}
+	}
+}
+
+// queue adds an output to the next batch, unless it is in it already.
+func (w *outputWriter) queue(output *models.CellOutput) {
+	for _, pending := range w.pending {
+		if pending == output {
+			return
+		}
+	}
+	w.pending = append(w.pending, output)
+}
+
+// flush deletes the cell's stored outputs if they were cleared, then saves the pending outputs in one
+// batch. It reports whether the clear failed and is to be retried with the pending outputs.
+func (w *outputWriter) flush() (retry bool) {
+	if w.clearFailures >= outputClearAttempts {
+		// The writer gave up; the outputs are dropped.
+		w.pending = w.pending[:0]
+		w.clearStored = false
+		return false
+	}
+	if len(w.pending) == 0 && !w.clearStored {
+		return false
+	}
+	w.list.sync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), outputWriteTimeout)
+	defer cancel()
+	if w.clearStored {
+		w.deleteStoredBlobs(ctx)
+		if err := w.repo.DeleteCellOutputsByCellID(ctx, w.cellID); err != nil {
+			// Saving the new outputs next to the stale ones would mix two executions, so the pending
+			// outputs wait for the clear to be retried on the next flush.
+			w.clearFailures++
+			w.logger.Error().Err(err).Str("cell_id", w.cellID.String()).Int("count", len(w.pending)).Int("attempt", w.clearFailures).Msg("failed to clear cell outputs")
+			if w.clearFailures < outputClearAttempts {
+				return true
+			}
+			w.fail(err)
+			w.pending = w.pending[:0]
+			w.clearStored = false
+			return false
+		}
+		w.clearFailures = 0
+		w.clearStored = false
+		clear(w.offloaded)
+	}
+	// The outputs of the list keep their data inline, so a copy of each is offloaded and saved.
+	batch := make([]*models.CellOutput, 0, len(w.pending))
+	for _, output := range w.pending {
+		saved := *output
+		if err := offloadOutputData(ctx, w.blobs, &saved); err != nil {
+			// What could not be offloaded is kept inline.
+			w.logger.Error().Err(err).Str("output_id", output.ID.String()).Msg("failed to offload output data")
+		}
+		batch = append(batch, &saved)
+	}
+	if err := w.repo.SaveCellOutputs(ctx, batch); err != nil {
+		w.logger.Error().Err(err).Str("cell_id", w.cellID.String()).Int("count", len(batch)).Msg("failed to save cell outputs")
+		w.fail(err)
+	} else if len(batch) > 0 {
+		w.logger.Debug().Str("cell_id", w.cellID.String()).Int("count", len(batch)).Msg("saved cell outputs")
+		for _, saved := range batch {
+			if err := deleteStaleOutputData(ctx, w.blobs, saved, w.offloaded[saved.ID]); err != nil {
+				w.logger.Warn().Err(err).Str("output_id", saved.ID.String()).Msg("failed to delete output blobs")
+			}
+			if mimes := offloadedMimes(saved); mimes != nil {
+				w.offloaded[saved.ID] = mimes
+			} else {
+				delete(w.offloaded, saved.ID)
+			}
+		}
+	}
+	w.pending = w.pending[:0]
+	return false
+}
+
+// deleteStoredBlobs removes the blobs of the cell's stored outputs, which are about to be cleared.
+func (w *outputWriter) deleteStoredBlobs(ctx context.Context) {
+	if w.blobs == nil {
+		return
+	}
+	stored, err := w.repo.GetCellOutputsByCellID(ctx, w.cellID)
+	if err != nil {
+		w.logger.Error().Err(err).Str("cell_id", w.cellID.String()).Msg("failed to list cell outputs to delete their blobs")
+		return
+	}
+	for _, output := range stored {
+		if err := deleteOutputBlobs(ctx, w.blobs, output); err != nil {
+			w.logger.Warn().Err(err).Str("output_id", output.ID.String()).Msg("failed to delete output blobs")
+		}
+	}
+}
+
+// fail records the first error that lost outputs.
+func (w *outputWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
@@ -2,27 +2,70 @@ package modules
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
 	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/warmpool"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/rs/zerolog"
 )
 
+// kernelSpecCacheTTL bounds how long the gateway kernelspecs are reused before being fetched again.
+const kernelSpecCacheTTL = 30 * time.Second
+
+// Environment variables every session kernel is started with.
+const (
+	kernelEnvWorkingDir = jupyterclient.KernelEnvPrefix + "WORKING_DIR"
+	kernelEnvSessionID  = jupyterclient.KernelEnvPrefix + "SESSION_ID"
+	kernelEnvNotebookID = jupyterclient.KernelEnvPrefix + "NOTEBOOK_ID"
+)
+
+// kernelEnvTimeout bounds applying a session's environment to a kernel taken from the warm pool.
+const kernelEnvTimeout = 30 * time.Second
+
+// ErrUnknownKernelSpec is returned when a session is requested for a language the gateway does not offer.
+var ErrUnknownKernelSpec = errors.New("unknown kernelspec")
+
+// ErrEventsUnavailable is returned when session events are requested but no event bus is configured.
+var ErrEventsUnavailable = errors.New("session events are not available")
+
+// ErrKernelAccessDenied is returned when a user asks for a kernel that does not belong to one of their sessions.
+var ErrKernelAccessDenied = errors.New("user not authorized to access this kernel")
+
 // SessionModule encapsulates the business logic for sessions.
 type SessionModule struct {
 	Repo         repository.SessionRepository
-	Jupyter      *jupyterclient.Client
+	Jupyter      jupyterclient.Gateway
 	Logger       zerolog.Logger
 	NotebookRepo repository.NotebookRepository // Added NotebookRepo
+	// WarmPool, when set, hands out pre-started kernels so sessions do not wait for a kernel to boot.
+	WarmPool *warmpool.Pool
+	// Events carries the lifecycle events of sessions and their kernels.
+	Events *events.Bus
+	// UserDataDir holds a directory per session for its uploaded files (USER_DATA_DIR); session
+	// kernels start in their session's directory. Empty leaves kernels in the gateway's default one.
+	UserDataDir string
+
+	specsMu       sync.Mutex
+	specsCache    *jupyterclient.GetKernelSpecsResponse
+	specsCachedAt time.Time
 }
 
 // NewSessionModule creates and returns a new SessionModule.
-func NewSessionModule(repo repository.SessionRepository, jupyter *jupyterclient.Client, logger zerolog.Logger, notebookRepo repository.NotebookRepository) *SessionModule {
+func NewSessionModule(repo repository.SessionRepository, jupyter jupyterclient.Gateway, logger zerolog.Logger, notebookRepo repository.NotebookRepository) *SessionModule {
 	return &SessionModule{
 		Repo:         repo,
 		Jupyter:      jupyter,
@@ -31,6 +74,24 @@ func NewSessionModule(repo repository.SessionRepository, jupyter *jupyterclient.
 	}
 }
 
+// WithWarmPool makes new sessions take their kernel from the warm pool when one is ready.
+func (m *SessionModule) WithWarmPool(pool *warmpool.Pool) *SessionModule {
+	m.WarmPool = pool
+	return m
+}
+
+// WithEvents makes the module publish session status changes on bus and serve its events to subscribers.
+func (m *SessionModule) WithEvents(bus *events.Bus) *SessionModule {
+	m.Events = bus
+	return m
+}
+
+// WithUserDataDir makes session kernels start in their session's directory under dir.
+func (m *SessionModule) WithUserDataDir(dir string) *SessionModule {
+	m.UserDataDir = dir
+	return m
+}
+
 // CreateSession starts a new kernel and creates a session record in the database.
 func (m *SessionModule) CreateSession(ctx context.Context, userIDStr string, notebookIDStr string, language string) (*models.Session, error) {
 	if m.Jupyter == nil {
@@ -56,8 +117,12 @@ func (m *SessionModule) CreateSession(ctx context.Context, userIDStr string, not
 		return nil, errors.New("notebook not found or not owned by user")
 	}
 
-	// TODO: Should check if the language is supported by the jupyter kernelspecs
-	kernel, err := m.Jupyter.StartKernel(ctx, language)
+	if err := m.validateKernelSpec(ctx, language); err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New()
+	kernel, err := m.startKernel(ctx, language, m.kernelEnv(sessionID, notebookID, notebook.EnvVars))
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +133,12 @@ func (m *SessionModule) CreateSession(ctx context.Context, userIDStr string, not
 	}
 
 	newSession := &models.Session{
-		ID:              uuid.New(),
+		ID:              sessionID,
 		UserID:          userID, // Assign the parsed userID
 		NotebookID:      notebookID,
 		CurrentKernelID: kernelID,
-		Status:          "active",
+		KernelName:      language,
+		Status:          models.SessionStatusActive,
 		LastActiveAt:    time.Now().UTC(),
 	}
 
@@ -89,6 +155,102 @@ func (m *SessionModule) CreateSession(ctx context.Context, userIDStr string, not
 	return createdSession, nil
 }
 
+// KernelEnv returns the environment a kernel of the session is started with, for kernels that
+// replace the session's original one.
+func (m *SessionModule) KernelEnv(ctx context.Context, session *models.Session) (map[string]string, error) {
+	notebookEnv, err := m.NotebookRepo.GetNotebookEnvVars(ctx, session.NotebookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notebook environment variables: %w", err)
+	}
+	return m.kernelEnv(session.ID, session.NotebookID, notebookEnv), nil
+}
+
+// kernelEnv builds a session kernel's environment: the notebook's variables, prefixed so the gateway
+// passes them on, then the session's IDs and working directory, which the notebook cannot override.
+func (m *SessionModule) kernelEnv(sessionID uuid.UUID, notebookID uuid.UUID, notebookEnv map[string]string) map[string]string {
+	env := make(map[string]string, len(notebookEnv)+3)
+	for name, value := range notebookEnv {
+		if !strings.HasPrefix(name, jupyterclient.KernelEnvPrefix) {
+			name = jupyterclient.KernelEnvPrefix + name
+		}
+		env[name] = value
+	}
+	env[kernelEnvSessionID] = sessionID.String()
+	env[kernelEnvNotebookID] = notebookID.String()
+
+	if m.UserDataDir != "" {
+		// The directory is the one FileModule uploads the session's files to; it must exist for the kernel to start in it.
+		dir := filepath.Join(m.UserDataDir, sessionID.String())
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			m.Logger.Warn().Err(err).Str("dir", dir).Msg("failed to create session directory, kernel starts in the default directory")
+		} else {
+			env[kernelEnvWorkingDir] = dir
+		}
+	}
+	return env
+}
+
+// startKernel takes a kernel from the warm pool, or starts one when none is ready. A pooled kernel
+// was started before the session existed, so the session's environment is applied to it by running
+// Python; kernels of other languages are always started fresh.
+func (m *SessionModule) startKernel(ctx context.Context, language string, env map[string]string) (*jupyterclient.Kernel, error) {
+	if m.WarmPool != nil && m.isPythonKernelSpec(ctx, language) {
+		if kernel, ok := m.WarmPool.Acquire(ctx, language); ok {
+			err := m.applyKernelEnv(ctx, kernel.ID, env)
+			if err == nil {
+				return kernel, nil
+			}
+			m.Logger.Warn().Err(err).Str("kernel_id", kernel.ID).Msg("failed to set up warm kernel, starting a new one")
+			if deleteErr := m.Jupyter.DeleteKernel(context.Background(), kernel.ID); deleteErr != nil {
+				m.Logger.Error().Err(deleteErr).Str("kernel_id", kernel.ID).Msg("failed to delete warm kernel")
+			}
+		}
+	}
+	return m.Jupyter.StartKernel(ctx, language, env)
+}
+
+// isPythonKernelSpec reports whether the kernelspec runs Python.
+func (m *SessionModule) isPythonKernelSpec(ctx context.Context, language string) bool {
+	specs, err := m.GetKernelSpecs(ctx)
+	if err != nil {
+		return false
+	}
+	return specs.KernelSpecs[language].Spec.Language == "python"
+}
+
+// applyKernelEnv sets the environment variables in a running Python kernel and moves it to the
+// working directory, as the gateway does for a kernel started with them.
+func (m *SessionModule) applyKernelEnv(ctx context.Context, kernelID string, env map[string]string) error {
+	// JSON objects of strings are valid Python dict literals.
+	vars, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	code := "import os as _os\n_os.environ.update(" + string(vars) + ")\n"
+	if dir, ok := env[kernelEnvWorkingDir]; ok {
+		quoted, err := json.Marshal(dir)
+		if err != nil {
+			return err
+		}
+		code += "_os.chdir(" + string(quoted) + ")\n"
+	}
+	code += "del _os\n"
+
+	ctx, cancel := context.WithTimeout(ctx, kernelEnvTimeout)
+	defer cancel()
+	result, err := m.Jupyter.Execute(ctx, kernelID, code, jupyterclient.ExecuteOptions{Silent: true})
+	if err != nil {
+		return err
+	}
+	if result.Status != "ok" {
+		if result.Error != nil {
+			return fmt.Errorf("environment setup failed: %s: %s", result.Error.Ename, result.Error.Evalue)
+		}
+		return fmt.Errorf("environment setup failed with status %q", result.Status)
+	}
+	return nil
+}
+
 // ListSessions retrieves all sessions for a given user.
 func (m *SessionModule) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
 	sessions, err := m.Repo.ListSessions(ctx, userID)
@@ -118,6 +280,7 @@ func (m *SessionModule) UpdateSessionStatus(ctx context.Context, id uuid.UUID, u
 		m.Logger.Error().Err(err).Msg("failed to update session status in repo")
 		return nil, err
 	}
+	m.Events.Publish(events.Event{Type: events.SessionStatus, SessionID: id.String(), KernelID: session.CurrentKernelID.String(), Status: status})
 
 	return session, nil
 }
@@ -129,6 +292,136 @@ func (m *SessionModule) DeleteSession(ctx context.Context, id uuid.UUID, userID
 		m.Logger.Error().Err(err).Msg("failed to delete session from repo")
 		return err
 	}
+	m.Events.Publish(events.Event{Type: events.SessionStatus, SessionID: id.String(), Status: "deleted"})
 
 	return nil
 }
+
+// SubscribeEvents subscribes to the events of a user's session and its kernel, following the session
+// onto replacement kernels. It also returns the kernel's current status, read after subscribing so
+// that no change in between is missed, or nil when the gateway cannot tell.
+func (m *SessionModule) SubscribeEvents(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*events.Subscription, *events.Event, error) {
+	if m.Events == nil {
+		return nil, nil, ErrEventsUnavailable
+	}
+	session, err := m.Repo.GetSessionByID(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sessionID := session.ID.String()
+	var kernelID atomic.Value
+	kernelID.Store(session.CurrentKernelID.String())
+	sub := m.Events.Subscribe(func(event events.Event) bool {
+		if event.SessionID == sessionID {
+			if event.Type == events.KernelReplaced {
+				kernelID.Store(event.KernelID)
+			}
+			return true
+		}
+		return event.KernelID != "" && event.KernelID == kernelID.Load().(string)
+	})
+
+	kernel, err := m.Jupyter.GetKernelInfo(ctx, session.CurrentKernelID.String())
+	if err != nil {
+		m.Logger.Debug().Err(err).Str("session_id", sessionID).Msg("failed to read kernel status for session events")
+		return sub, nil, nil
+	}
+	return sub, &events.Event{
+		Type:           events.KernelStatus,
+		SessionID:      sessionID,
+		KernelID:       kernel.ID,
+		ExecutionState: kernel.ExecutionState,
+		Time:           time.Now().UTC(),
+	}, nil
+}
+
+// AuthorizeKernel checks that the kernel belongs to one of the user's sessions.
+// Kernels that are not attached to any session are only reachable by admins.
+func (m *SessionModule) AuthorizeKernel(ctx context.Context, kernelIDStr string, userIDStr string) error {
+	kernelID, err := uuid.Parse(kernelIDStr)
+	if err != nil {
+		return ErrKernelAccessDenied
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return errors.New("invalid user ID format")
+	}
+
+	ownerID, err := m.Repo.GetKernelOwnerID(ctx, kernelID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrKernelAccessDenied
+		}
+		return fmt.Errorf("failed to look up kernel owner: %w", err)
+	}
+	if ownerID != userID {
+		return ErrKernelAccessDenied
+	}
+	return nil
+}
+
+// KernelRestarted records that a kernel was restarted, losing its state, so kernel recovery only
+// replays what ran on it afterwards, and announces the restart on the event bus.
+func (m *SessionModule) KernelRestarted(ctx context.Context, kernelID string, reason string) {
+	if kernelUUID, err := uuid.Parse(kernelID); err == nil {
+		if err := m.Repo.MarkKernelRestarted(ctx, kernelUUID, time.Now().UTC()); err != nil {
+			m.Logger.Warn().Err(err).Str("kernel_id", kernelID).Msg("failed to record kernel restart")
+		}
+	}
+	m.Events.Publish(events.Event{Type: events.KernelRestarted, KernelID: kernelID, Reason: reason})
+}
+
+// ListKernelIDs returns the IDs of the kernels currently attached to the user's sessions.
+func (m *SessionModule) ListKernelIDs(ctx context.Context, userID uuid.UUID) (map[string]struct{}, error) {
+	sessions, err := m.Repo.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	kernelIDs := make(map[string]struct{}, len(sessions))
+	for _, session := range sessions {
+		kernelIDs[session.CurrentKernelID.String()] = struct{}{}
+	}
+	return kernelIDs, nil
+}
+
+// GetKernelSpecs returns the gateway kernelspecs, reusing a cached copy for kernelSpecCacheTTL.
+func (m *SessionModule) GetKernelSpecs(ctx context.Context) (*jupyterclient.GetKernelSpecsResponse, error) {
+	m.specsMu.Lock()
+	defer m.specsMu.Unlock()
+
+	if m.specsCache != nil && time.Since(m.specsCachedAt) < kernelSpecCacheTTL {
+		return m.specsCache, nil
+	}
+
+	specs, err := m.Jupyter.GetKernelSpecs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch kernelspecs from gateway: %w", err)
+	}
+	m.specsCache = specs
+	m.specsCachedAt = time.Now()
+	return specs, nil
+}
+
+// validateKernelSpec checks that the requested language is one of the gateway's kernelspecs.
+func (m *SessionModule) validateKernelSpec(ctx context.Context, language string) error {
+	if language == "" {
+		return fmt.Errorf("%w: language is required", ErrUnknownKernelSpec)
+	}
+
+	specs, err := m.GetKernelSpecs(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := specs.KernelSpecs[language]; ok {
+		return nil
+	}
+
+	available := make([]string, 0, len(specs.KernelSpecs))
+	for name := range specs.KernelSpecs {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+	return fmt.Errorf("%w: '%s' (available: %s)", ErrUnknownKernelSpec, language, strings.Join(available, ", "))
+}
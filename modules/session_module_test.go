@@ -1,7 +1,254 @@
 package modules_test
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/warmpool"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// memorySessionRepo keeps sessions in memory; methods the tests do not need are left to the embedded interface.
+type memorySessionRepo struct {
+	repository.SessionRepository
+
+	mu        sync.Mutex
+	sessions  map[uuid.UUID]*models.Session
+	createErr error
+}
+
+func newMemorySessionRepo() *memorySessionRepo {
+	return &memorySessionRepo{sessions: make(map[uuid.UUID]*models.Session)}
+}
+
+func (r *memorySessionRepo) CreateSession(ctx context.Context, session *models.Session) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.createErr != nil {
+		return nil, r.createErr
+	}
+	stored := *session
+	r.sessions[session.ID] = &stored
+	return session, nil
+}
+
+func (r *memorySessionRepo) GetKernelOwnerID(ctx context.Context, kernelID uuid.UUID) (uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.CurrentKernelID == kernelID {
+			return session.UserID, nil
+		}
+	}
+	return uuid.Nil, pgx.ErrNoRows
+}
+
+// ownedNotebooks answers GetNotebookByID for the notebooks each user owns.
+type ownedNotebooks struct {
+	repository.NotebookRepository
+	owners map[string]string
+	env    map[string]string
+}
+
+func (r ownedNotebooks) GetNotebookByID(ctx context.Context, id string, userID string) (*models.Notebook, error) {
+	if r.owners[id] != userID {
+		return nil, pgx.ErrNoRows
+	}
+	return &models.Notebook{ID: id, EnvVars: r.env}, nil
+}
+
+type sessionModuleFixture struct {
+	gateway    *fakegateway.Server
+	repo       *memorySessionRepo
+	module     *modules.SessionModule
+	userID     string
+	notebookID string
+	dataDir    string
+}
+
+// silenceLogger is set once, as background goroutines such as the warm pool's may outlive a test.
+var silenceLogger sync.Once
+
+func newSessionModuleFixture(t *testing.T) *sessionModuleFixture {
+	t.Helper()
+	logger := zerolog.Nop()
+	silenceLogger.Do(func() { pkg.Logger = &logger })
+
+	gateway := fakegateway.New("")
+	t.Cleanup(gateway.Close)
+
+	f := &sessionModuleFixture{
+		gateway:    gateway,
+		repo:       newMemorySessionRepo(),
+		userID:     uuid.NewString(),
+		notebookID: uuid.NewString(),
+		dataDir:    t.TempDir(),
+	}
+	notebooks := ownedNotebooks{
+		owners: map[string]string{f.notebookID: f.userID},
+		env:    map[string]string{"DATASET": "iris", "KERNEL_SEED": "42"},
+	}
+	f.module = modules.NewSessionModule(f.repo, gateway.Client(), logger, notebooks).WithUserDataDir(f.dataDir)
+	return f
+}
 
 func TestSessionModule(t *testing.T) {
-	// TODO: Write unit tests for the SessionModule
+	t.Run("CreateSession starts a kernel and records it", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+
+		session, err := f.module.CreateSession(context.Background(), f.userID, f.notebookID, fakegateway.DefaultKernelSpec)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+
+		kernel, ok := f.gateway.Kernel(session.CurrentKernelID.String())
+		if !ok {
+			t.Fatalf("kernel %s was not started on the gateway", session.CurrentKernelID)
+		}
+		if kernel.Name != fakegateway.DefaultKernelSpec || session.KernelName != fakegateway.DefaultKernelSpec {
+			t.Errorf("kernel name = %q, session kernel name = %q, want %q", kernel.Name, session.KernelName, fakegateway.DefaultKernelSpec)
+		}
+		if session.Status != models.SessionStatusActive {
+			t.Errorf("status = %q, want %q", session.Status, models.SessionStatusActive)
+		}
+		if _, stored := f.repo.sessions[session.ID]; !stored {
+			t.Error("session was not stored")
+		}
+	})
+
+	t.Run("CreateSession starts the kernel with the session environment", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+
+		session, err := f.module.CreateSession(context.Background(), f.userID, f.notebookID, fakegateway.DefaultKernelSpec)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+
+		want := map[string]string{
+			"KERNEL_WORKING_DIR": filepath.Join(f.dataDir, session.ID.String()),
+			"KERNEL_SESSION_ID":  session.ID.String(),
+			"KERNEL_NOTEBOOK_ID": f.notebookID,
+			"KERNEL_DATASET":     "iris",
+			"KERNEL_SEED":        "42",
+		}
+		env := f.gateway.Env(session.CurrentKernelID.String())
+		if len(env) != len(want) {
+			t.Errorf("env = %v, want %v", env, want)
+		}
+		for name, value := range want {
+			if env[name] != value {
+				t.Errorf("env[%s] = %q, want %q", name, env[name], value)
+			}
+		}
+	})
+
+	t.Run("CreateSession takes a warmed-up kernel from the warm pool", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pool := warmpool.New(f.gateway.Client(), warmpool.Config{
+			Sizes:         map[string]int{fakegateway.DefaultKernelSpec: 1},
+			Warmup:        map[string]string{fakegateway.DefaultKernelSpec: "import numpy"},
+			CheckInterval: time.Minute,
+		})
+		pool.Start(ctx)
+		f.module.WithWarmPool(pool)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for pool.Status()[0].Ready != 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("warm pool never became ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		warm := f.gateway.Kernels()[0]
+		if !pool.Owns(warm.ID) {
+			t.Fatalf("pool does not own its kernel %s", warm.ID)
+		}
+
+		session, err := f.module.CreateSession(ctx, f.userID, f.notebookID, fakegateway.DefaultKernelSpec)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		if session.CurrentKernelID.String() != warm.ID {
+			t.Errorf("session kernel = %s, want the warm kernel %s", session.CurrentKernelID, warm.ID)
+		}
+		if pool.Owns(warm.ID) {
+			t.Error("pool still owns the kernel handed to the session")
+		}
+		executed := f.gateway.Executed(warm.ID)
+		if len(executed) != 2 || executed[0] != "import numpy" {
+			t.Fatalf("warm kernel executed %q, want the warm-up code then the session setup", executed)
+		}
+		if dir := filepath.Join(f.dataDir, session.ID.String()); !strings.Contains(executed[1], "_os.chdir(\""+dir+"\")") {
+			t.Errorf("session setup %q does not move to %s", executed[1], dir)
+		}
+	})
+
+	t.Run("CreateSession rejects unknown kernelspecs", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+
+		_, err := f.module.CreateSession(context.Background(), f.userID, f.notebookID, "julia-1.9")
+		if !errors.Is(err, modules.ErrUnknownKernelSpec) {
+			t.Fatalf("err = %v, want ErrUnknownKernelSpec", err)
+		}
+		if kernels := f.gateway.Kernels(); len(kernels) != 0 {
+			t.Errorf("%d kernels started, want none", len(kernels))
+		}
+	})
+
+	t.Run("CreateSession refuses notebooks of other users", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+
+		if _, err := f.module.CreateSession(context.Background(), uuid.NewString(), f.notebookID, fakegateway.DefaultKernelSpec); err == nil {
+			t.Fatal("CreateSession succeeded on another user's notebook")
+		}
+		if kernels := f.gateway.Kernels(); len(kernels) != 0 {
+			t.Errorf("%d kernels started, want none", len(kernels))
+		}
+	})
+
+	t.Run("CreateSession deletes the kernel when the session cannot be stored", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+		f.repo.createErr = errors.New("database is down")
+
+		if _, err := f.module.CreateSession(context.Background(), f.userID, f.notebookID, fakegateway.DefaultKernelSpec); err == nil {
+			t.Fatal("CreateSession succeeded without storing the session")
+		}
+		if kernels := f.gateway.Kernels(); len(kernels) != 0 {
+			t.Errorf("%d orphaned kernels left on the gateway, want none", len(kernels))
+		}
+	})
+
+	t.Run("AuthorizeKernel only allows the session owner", func(t *testing.T) {
+		f := newSessionModuleFixture(t)
+		session, err := f.module.CreateSession(context.Background(), f.userID, f.notebookID, fakegateway.DefaultKernelSpec)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		kernelID := session.CurrentKernelID.String()
+
+		if err := f.module.AuthorizeKernel(context.Background(), kernelID, f.userID); err != nil {
+			t.Errorf("owner denied: %v", err)
+		}
+		if err := f.module.AuthorizeKernel(context.Background(), kernelID, uuid.NewString()); !errors.Is(err, modules.ErrKernelAccessDenied) {
+			t.Errorf("other user: err = %v, want ErrKernelAccessDenied", err)
+		}
+		orphan := f.gateway.StartKernel(fakegateway.DefaultKernelSpec)
+		if err := f.module.AuthorizeKernel(context.Background(), orphan.ID, f.userID); !errors.Is(err, modules.ErrKernelAccessDenied) {
+			t.Errorf("kernel without session: err = %v, want ErrKernelAccessDenied", err)
+		}
+	})
 }
@@ -0,0 +1,210 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+)
+
+// Bounds of a variable preview page.
+const (
+	defaultVariablePreviewLimit = 50
+	maxVariablePreviewLimit     = 500
+)
+
+var (
+	// ErrVariableNotFound is returned when the kernel has no user variable with the requested name.
+	ErrVariableNotFound = errors.New("variable not found")
+	// ErrInvalidVariableName is returned when the requested name is not a Python identifier.
+	ErrInvalidVariableName = errors.New("variable name must be a Python identifier")
+	// ErrUnsupportedKernel is returned when the variable explorer is used on a non-Python kernel.
+	ErrUnsupportedKernel = errors.New("the variable explorer only supports python kernels")
+)
+
+// variableExplorerSnippet defines the helper the variable explorer calls through user expressions.
+// It lives under a private name so it does not show up in the listing itself, and every value it
+// returns has a JSON repr so the result can be read back from the text/plain of the reply.
+const variableExplorerSnippet = `
+class _EvocVariableExplorer:
+    _hidden = {"In", "Out", "exit", "quit", "get_ipython"}
+
+    class _Result:
+        def __init__(self, payload):
+            import json
+            self._json = json.dumps(payload, default=str)
+        def __repr__(self):
+            return self._json
+
+    def _repr(self, value, limit=200):
+        import reprlib
+        r = reprlib.Repr()
+        r.maxstring = limit
+        r.maxother = limit
+        try:
+            text = r.repr(value)
+        except Exception as e:
+            text = "<repr failed: %s>" % type(e).__name__
+        return text if len(text) <= limit else text[:limit - 3] + "..."
+
+    def _describe(self, name, value):
+        entry = {"name": name, "type": type(value).__name__, "repr": self._repr(value)}
+        shape = getattr(value, "shape", None)
+        if isinstance(shape, tuple) and all(isinstance(d, int) for d in shape):
+            entry["shape"] = list(shape)
+        try:
+            entry["size"] = len(value)
+        except Exception:
+            pass
+        return entry
+
+    def _user_variables(self):
+        import types
+        ns = get_ipython().user_ns
+        for name, value in list(ns.items()):
+            if name.startswith("_") or name in self._hidden or isinstance(value, types.ModuleType):
+                continue
+            yield name, value
+
+    def list(self):
+        return self._Result(sorted((self._describe(n, v) for n, v in self._user_variables()), key=lambda e: e["name"]))
+
+    def preview(self, name, offset, limit):
+        ns = get_ipython().user_ns
+        if name.startswith("_") or name in self._hidden or name not in ns:
+            return self._Result({"error": "not_found"})
+        value = ns[name]
+        page = self._describe(name, value)
+        page.update({"offset": offset, "limit": limit, "items": []})
+        if type(value).__name__ == "DataFrame" and hasattr(value, "iloc"):
+            import json
+            split = json.loads(value.iloc[offset:offset + limit].to_json(orient="split", default_handler=str))
+            page.update({"kind": "dataframe", "total": len(value), "columns": [str(c) for c in split["columns"]],
+                         "index": split["index"], "items": split["data"]})
+        elif hasattr(value, "shape") and hasattr(value, "ndim") and hasattr(value, "__getitem__"):
+            total = value.shape[0] if value.ndim > 0 else 1
+            rows = value[offset:offset + limit] if value.ndim > 0 else [value]
+            page.update({"kind": "ndarray", "total": total, "items": [self._repr(row, 1000) for row in rows]})
+        elif isinstance(value, dict):
+            items = list(value.items())[offset:offset + limit]
+            page.update({"kind": "mapping", "total": len(value),
+                         "items": [[self._repr(k), self._repr(v, 1000)] for k, v in items]})
+        elif hasattr(value, "__len__") and hasattr(value, "__iter__") and not isinstance(value, (str, bytes)):
+            # Lists, tuples, sets and sized containers such as a DEAP HallOfFame.
+            items = list(value)[offset:offset + limit]
+            page.update({"kind": "sequence", "total": len(value), "items": [self._repr(v, 1000) for v in items]})
+        else:
+            page.update({"kind": "object", "total": 1, "repr": self._repr(value, 10000)})
+        return self._Result(page)
+
+_evoc_variable_explorer = _EvocVariableExplorer()
+del _EvocVariableExplorer
+`
+
+// ListVariables returns the user variables currently defined in the session's kernel.
+func (m *IntrospectionModule) ListVariables(ctx context.Context, sessionID uuid.UUID, userID string) ([]models.KernelVariable, error) {
+	variables := []models.KernelVariable{}
+	if err := m.exploreVariables(ctx, sessionID, userID, "_evoc_variable_explorer.list()", &variables); err != nil {
+		return nil, err
+	}
+	return variables, nil
+}
+
+// PreviewVariable returns one page of the items of a user variable, such as the rows of a DataFrame
+// or the individuals of a population.
+func (m *IntrospectionModule) PreviewVariable(ctx context.Context, sessionID uuid.UUID, userID string, name string, offset int, limit int) (*models.VariablePreview, error) {
+	if !pythonIdentifier.MatchString(name) {
+		return nil, ErrInvalidVariableName
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultVariablePreviewLimit
+	}
+	if limit > maxVariablePreviewLimit {
+		limit = maxVariablePreviewLimit
+	}
+
+	// The name is a plain identifier, so it can be quoted into the expression as is.
+	expression := fmt.Sprintf("_evoc_variable_explorer.preview(%q, %d, %d)", name, offset, limit)
+	var preview struct {
+		models.VariablePreview
+		Error string `json:"error"`
+	}
+	if err := m.exploreVariables(ctx, sessionID, userID, expression, &preview); err != nil {
+		return nil, err
+	}
+	if preview.Error == "not_found" {
+		return nil, ErrVariableNotFound
+	}
+	return &preview.VariablePreview, nil
+}
+
+// exploreVariables defines the explorer helper in the session kernel without touching its history
+// or execution count, evaluates the expression and decodes its JSON result into out.
+func (m *IntrospectionModule) exploreVariables(ctx context.Context, sessionID uuid.UUID, userID string, expression string, out any) error {
+	session, err := m.loadSession(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if err := m.requirePythonKernel(ctx, session); err != nil {
+		return err
+	}
+
+	kc, err := m.Jupyter.ConnectKernel(ctx, session.CurrentKernelID.String())
+	if err != nil {
+		return fmt.Errorf("failed to connect to session kernel: %w", err)
+	}
+	defer kc.Close()
+
+	result, err := kc.Execute(ctx, variableExplorerSnippet, jupyterclient.ExecuteOptions{
+		Silent:          true,
+		StoreHistory:    false,
+		UserExpressions: map[string]string{"result": expression},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run variable explorer: %w", err)
+	}
+	if result.Status != "ok" {
+		return fmt.Errorf("variable explorer failed with status %q", result.Status)
+	}
+
+	value, ok := result.UserExpressions["result"]
+	if !ok {
+		return errors.New("kernel did not return the variable explorer result")
+	}
+	if value.Status != "ok" {
+		return fmt.Errorf("variable explorer failed: %s: %s", value.Ename, value.Evalue)
+	}
+	text, ok := value.Data["text/plain"].(string)
+	if !ok {
+		return errors.New("variable explorer result has no text/plain representation")
+	}
+	if err := json.Unmarshal([]byte(text), out); err != nil {
+		return fmt.Errorf("failed to decode variable explorer result: %w", err)
+	}
+	return nil
+}
+
+// requirePythonKernel checks the session runs a Python kernel, which the explorer snippet is written for.
+func (m *IntrospectionModule) requirePythonKernel(ctx context.Context, session *models.Session) error {
+	specs, err := m.Jupyter.GetKernelSpecs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get kernelspecs: %w", err)
+	}
+	kernelName := session.KernelName
+	if kernelName == "" {
+		kernelName = specs.Default
+	}
+	spec, ok := specs.KernelSpecs[kernelName]
+	if ok && !strings.EqualFold(spec.Spec.Language, "python") {
+		return ErrUnsupportedKernel
+	}
+	return nil
+}
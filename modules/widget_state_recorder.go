@@ -0,0 +1,299 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/db/repository"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// widgetCommTarget is the comm target ipywidgets opens its models on.
+const widgetCommTarget = "jupyter.widget"
+
+// widgetStateSaveDelay batches the bursts of updates widgets send, e.g. a slider being dragged or a
+// progress bar advancing every generation, into one write.
+const widgetStateSaveDelay = 2 * time.Second
+
+// WidgetStateRecorder follows the ipywidgets comm traffic passing through the kernel hubs and saves
+// the state of the live widget models of each notebook, so they can be rendered again after a reload.
+type WidgetStateRecorder struct {
+	Repo        repository.WidgetStateRepository
+	SessionRepo repository.SessionRepository
+	Logger      zerolog.Logger
+
+	mu      sync.Mutex
+	kernels map[string]*kernelWidgets
+}
+
+// kernelWidgets is the widget state of one kernel. It is guarded by WidgetStateRecorder.mu.
+type kernelWidgets struct {
+	models map[string]*models.WidgetModel
+	// restored is set once the state saved before a controller restart has been merged in.
+	restored  bool
+	saveTimer *time.Timer
+	// saving is set while save runs, and gone once the kernel died or left the hub, so the entry is
+	// dropped after its last save.
+	saving bool
+	gone   bool
+}
+
+// NewWidgetStateRecorder creates and returns a new WidgetStateRecorder.
+func NewWidgetStateRecorder(repo repository.WidgetStateRepository, sessionRepo repository.SessionRepository, logger zerolog.Logger) *WidgetStateRecorder {
+	return &WidgetStateRecorder{
+		Repo:        repo,
+		SessionRepo: sessionRepo,
+		Logger:      logger,
+		kernels:     make(map[string]*kernelWidgets),
+	}
+}
+
+// FromClient applies the state changes made in a frontend, e.g. a slider moved by the user.
+func (r *WidgetStateRecorder) FromClient(kernelID string, msg *jupyterclient.Message) {
+	r.apply(kernelID, msg)
+}
+
+// FromKernel applies the widget models the kernel opens, updates and closes.
+func (r *WidgetStateRecorder) FromKernel(kernelID string, msg *jupyterclient.Message) {
+	if msg.Header.MsgType == "status" {
+		var status jupyterclient.StatusContent
+		if err := json.Unmarshal(msg.Content, &status); err == nil &&
+			(status.ExecutionState == "restarting" || status.ExecutionState == "dead") {
+			// The kernel lost its widget models; keeping their state would render widgets that no longer exist.
+			r.reset(kernelID)
+			if status.ExecutionState == "dead" {
+				r.KernelGone(kernelID)
+			}
+		}
+		return
+	}
+	r.apply(kernelID, msg)
+}
+
+func (r *WidgetStateRecorder) apply(kernelID string, msg *jupyterclient.Message) {
+	switch msg.Header.MsgType {
+	case "comm_open":
+		var content jupyterclient.CommOpenContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil || content.TargetName != widgetCommTarget {
+			return
+		}
+		var data struct {
+			State map[string]any `json:"state"`
+		}
+		if err := json.Unmarshal(content.Data, &data); err != nil {
+			return
+		}
+		model := &models.WidgetModel{State: map[string]any{}}
+		mergeWidgetState(model, data.State)
+
+		r.mu.Lock()
+		r.kernel(kernelID).models[content.CommID] = model
+		r.scheduleSave(kernelID)
+		r.mu.Unlock()
+
+	case "comm_msg":
+		var content jupyterclient.CommMsgContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return
+		}
+		var data struct {
+			Method string         `json:"method"`
+			State  map[string]any `json:"state"`
+		}
+		if err := json.Unmarshal(content.Data, &data); err != nil {
+			return
+		}
+		if data.Method != "update" && data.Method != "echo_update" {
+			return
+		}
+
+		r.mu.Lock()
+		if model, ok := r.kernel(kernelID).models[content.CommID]; ok {
+			mergeWidgetState(model, data.State)
+			r.scheduleSave(kernelID)
+		}
+		r.mu.Unlock()
+
+	case "comm_close":
+		var content jupyterclient.CommMsgContent
+		if err := json.Unmarshal(msg.Content, &content); err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		kw := r.kernel(kernelID)
+		if _, ok := kw.models[content.CommID]; ok {
+			delete(kw.models, content.CommID)
+			r.scheduleSave(kernelID)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// reset drops every widget model of a kernel.
+func (r *WidgetStateRecorder) reset(kernelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kw := r.kernel(kernelID)
+	if len(kw.models) == 0 {
+		return
+	}
+	kw.models = make(map[string]*models.WidgetModel)
+	r.scheduleSave(kernelID)
+}
+
+// KernelGone saves the widget state of a kernel that died or left the kernel hub right away, and
+// forgets the kernel. A hub opened on the kernel again restores the saved state.
+func (r *WidgetStateRecorder) KernelGone(kernelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kw, ok := r.kernels[kernelID]
+	if !ok {
+		return
+	}
+	kw.gone = true
+	if kw.saveTimer != nil && kw.saveTimer.Stop() {
+		go r.saveAndDrop(kernelID)
+		return
+	}
+	if !kw.saving {
+		delete(r.kernels, kernelID)
+	}
+}
+
+// kernel returns the widget state of a kernel, creating it on first use. r.mu must be held.
+func (r *WidgetStateRecorder) kernel(kernelID string) *kernelWidgets {
+	kw, ok := r.kernels[kernelID]
+	if !ok {
+		kw = &kernelWidgets{models: make(map[string]*models.WidgetModel)}
+		r.kernels[kernelID] = kw
+	}
+	// Messages of the kernel arrived again, so it is back on a hub.
+	kw.gone = false
+	return kw
+}
+
+// scheduleSave saves the kernel's widget state once no update arrived for widgetStateSaveDelay. r.mu must be held.
+func (r *WidgetStateRecorder) scheduleSave(kernelID string) {
+	kw := r.kernel(kernelID)
+	if kw.saveTimer != nil {
+		kw.saveTimer.Stop()
+	}
+	kw.saveTimer = time.AfterFunc(widgetStateSaveDelay, func() {
+		r.saveAndDrop(kernelID)
+	})
+}
+
+// saveAndDrop saves the kernel's widget state, then forgets the kernel if it is gone.
+func (r *WidgetStateRecorder) saveAndDrop(kernelID string) {
+	r.mu.Lock()
+	kw, ok := r.kernels[kernelID]
+	if ok {
+		kw.saving = true
+	}
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	r.save(kernelID, kw)
+
+	r.mu.Lock()
+	kw.saving = false
+	if kw.gone && r.kernels[kernelID] == kw {
+		delete(r.kernels, kernelID)
+	}
+	r.mu.Unlock()
+}
+
+func (r *WidgetStateRecorder) save(kernelID string, kw *kernelWidgets) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return
+	}
+	session, err := r.SessionRepo.GetSessionByKernelID(ctx, kernelUUID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			r.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("failed to look up session for widget state")
+		}
+		return
+	}
+
+	r.mu.Lock()
+	restored := kw.restored
+	r.mu.Unlock()
+	if !restored {
+		r.restore(ctx, kw, kernelUUID, session.NotebookID)
+	}
+
+	r.mu.Lock()
+	state := models.WidgetState{VersionMajor: 2, VersionMinor: 0, State: make(map[string]models.WidgetModel, len(kw.models))}
+	for commID, model := range kw.models {
+		state.State[commID] = *model
+	}
+	payload, err := json.Marshal(state)
+	r.mu.Unlock()
+	if err != nil {
+		r.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("failed to encode widget state")
+		return
+	}
+
+	if err := r.Repo.SaveWidgetState(ctx, session.NotebookID, kernelUUID, payload); err != nil {
+		r.Logger.Error().Err(err).Str("notebook_id", session.NotebookID.String()).Msg("failed to save widget state")
+		return
+	}
+	r.Logger.Debug().Str("notebook_id", session.NotebookID.String()).Int("models", len(state.State)).Msg("saved widget state")
+}
+
+// restore merges the state saved from the same kernel before the controller restarted, so models
+// opened back then are not lost; models seen since take precedence.
+func (r *WidgetStateRecorder) restore(ctx context.Context, kw *kernelWidgets, kernelUUID uuid.UUID, notebookID uuid.UUID) {
+	saved, savedKernelID, err := r.Repo.GetWidgetState(ctx, notebookID)
+	var state models.WidgetState
+	if err == nil && savedKernelID == kernelUUID {
+		if err := json.Unmarshal(saved, &state); err != nil {
+			r.Logger.Warn().Err(err).Str("notebook_id", notebookID.String()).Msg("ignoring unreadable saved widget state")
+		}
+	} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		r.Logger.Error().Err(err).Str("notebook_id", notebookID.String()).Msg("failed to load saved widget state")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for commID, model := range state.State {
+		if _, ok := kw.models[commID]; !ok {
+			model := model
+			kw.models[commID] = &model
+		}
+	}
+	kw.restored = true
+}
+
+// mergeWidgetState applies a (partial) state update to a model, keeping its identity fields in sync.
+func mergeWidgetState(model *models.WidgetModel, update map[string]any) {
+	for key, value := range update {
+		model.State[key] = value
+	}
+	if name, ok := model.State["_model_name"].(string); ok {
+		model.ModelName = name
+	}
+	if module, ok := model.State["_model_module"].(string); ok {
+		model.ModelModule = module
+	}
+	if version, ok := model.State["_model_module_version"].(string); ok {
+		model.ModelModuleVersion = version
+	}
+}
@@ -0,0 +1,52 @@
+// Package blobstore stores binary objects, such as the buffers of kernel messages, outside of the database.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned when no object is stored under the requested key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps objects under slash-separated keys such as "outputs/<output_id>/buffers/0".
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the object's content, which the caller must close.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromEnv creates the store configured by the environment. When BLOB_STORE_S3_ENDPOINT is set,
+// objects go to the S3-compatible store there, in the BLOB_STORE_S3_BUCKET bucket, which is created
+// if missing, with the BLOB_STORE_S3_REGION, BLOB_STORE_S3_ACCESS_KEY and BLOB_STORE_S3_SECRET_KEY
+// settings. Otherwise they go to a LocalStore rooted at BLOB_STORE_DIR.
+func NewFromEnv() (Store, error) {
+	if endpoint := os.Getenv("BLOB_STORE_S3_ENDPOINT"); endpoint != "" {
+		store, err := NewS3Store(S3Config{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("BLOB_STORE_S3_BUCKET"),
+			Region:    os.Getenv("BLOB_STORE_S3_REGION"),
+			AccessKey: os.Getenv("BLOB_STORE_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("BLOB_STORE_S3_SECRET_KEY"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := store.EnsureBucket(ctx); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	dir := os.Getenv("BLOB_STORE_DIR")
+	if dir == "" {
+		dir = "/mnt/blobs"
+	}
+	return NewLocalStore(dir)
+}
@@ -0,0 +1,247 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is sent as the payload hash, so objects are streamed without hashing them first.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config locates a bucket of an S3-compatible object store, such as MinIO.
+type S3Config struct {
+	// Endpoint is the store's base URL, e.g. "http://minio:9000". Buckets are addressed by path.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Store keeps objects in a bucket of an S3-compatible object store, signing its requests with
+// AWS Signature Version 4.
+type S3Store struct {
+	endpoint *url.URL
+	config   S3Config
+	client   *http.Client
+}
+
+var _ Store = (*S3Store)(nil)
+
+// NewS3Store returns a store writing to the configured bucket. The region defaults to us-east-1.
+func NewS3Store(config S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket configured")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3Store{endpoint: endpoint, config: config, client: &http.Client{}}, nil
+}
+
+// EnsureBucket creates the bucket unless it exists.
+func (s *S3Store) EnsureBucket(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("failed to check bucket %s: status %d", s.config.Bucket, resp.StatusCode)
+	}
+
+	resp, err = s.do(ctx, http.MethodPut, "", nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return responseError("create bucket "+s.config.Bucket, resp)
+	}
+	return nil
+}
+
+// Put uploads the object. A negative size reads the whole object into memory first.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read blob: %w", err)
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("put "+key, resp)
+	}
+	return nil
+}
+
+// Get downloads the object.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, responseError("get "+key, resp)
+	}
+}
+
+// Delete removes the object; deleting a missing object is not an error.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError("delete "+key, resp)
+	}
+	return nil
+}
+
+// do sends a signed request for the object under key, or for the bucket when key is empty.
+func (s *S3Store) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	path := "/" + s.config.Bucket
+	if key != "" {
+		path += "/" + strings.TrimPrefix(key, "/")
+	}
+	escapedPath := s.endpoint.EscapedPath() + uriEncode(path)
+
+	if body != nil && size == 0 {
+		// A zero length with a body would be sent chunked.
+		body = http.NoBody
+	}
+	target := *s.endpoint
+	target.Path = ""
+	target.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, method, target.String()+escapedPath, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, escapedPath, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request without query parameters.
+func (s *S3Store) sign(req *http.Request, escapedPath string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	canonical, signedHeaders := canonicalRequest(req.Method, escapedPath, map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}, unsignedPayload)
+	scope, signature := signatureV4(s.config.SecretKey, s.config.Region, "s3", now, canonical)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// amzDateFormat is the layout of the X-Amz-Date header.
+const amzDateFormat = "20060102T150405Z"
+
+// canonicalRequest returns the Signature Version 4 canonical form of a request without query
+// parameters, and the list of its signed headers. headers maps lowercase names to values.
+func canonicalRequest(method, escapedPath string, headers map[string]string, payloadHash string) (canonical, signedHeaders string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(method + "\n" + escapedPath + "\n\n")
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+	b.WriteString("\n" + signedHeaders + "\n" + payloadHash)
+	return b.String(), signedHeaders
+}
+
+// signatureV4 signs a canonical request made at now, returning the credential scope and the signature.
+func signatureV4(secretKey, region, service string, now time.Time, canonical string) (scope, signature string) {
+	date := now.Format("20060102")
+	scope = date + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode percent-encodes every byte of a path but the unreserved characters and slashes, as
+// Signature Version 4 requires.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// responseError describes a failed S3 response, including the start of its error document.
+func responseError(operation string, resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s failed: status %d: %s", operation, resp.StatusCode, strings.TrimSpace(string(detail)))
+}
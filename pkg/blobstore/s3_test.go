@@ -0,0 +1,258 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// emptyPayload is the SHA-256 of an empty payload.
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// TestSignatureV4 checks the signer against the examples AWS publishes: the Signature Version 4
+// test suite and the GET and PUT object examples of the S3 documentation.
+func TestSignatureV4(t *testing.T) {
+	const (
+		suiteSecret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+		s3Secret    = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+	)
+	suiteTime := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	s3Time := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name          string
+		method, path  string
+		headers       map[string]string
+		payloadHash   string
+		secret        string
+		service       string
+		now           time.Time
+		wantSigned    string
+		wantSignature string
+	}{
+		{
+			name:   "get-vanilla",
+			method: http.MethodGet, path: "/",
+			headers:     map[string]string{"host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			payloadHash: emptyPayload, secret: suiteSecret, service: "service", now: suiteTime,
+			wantSigned:    "host;x-amz-date",
+			wantSignature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "post-vanilla",
+			method: http.MethodPost, path: "/",
+			headers:     map[string]string{"host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			payloadHash: emptyPayload, secret: suiteSecret, service: "service", now: suiteTime,
+			wantSigned:    "host;x-amz-date",
+			wantSignature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:   "get-utf8",
+			method: http.MethodGet, path: uriEncode("/ሴ"),
+			headers:     map[string]string{"host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			payloadHash: emptyPayload, secret: suiteSecret, service: "service", now: suiteTime,
+			wantSigned:    "host;x-amz-date",
+			wantSignature: "8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85",
+		},
+		{
+			name:   "s3 get object",
+			method: http.MethodGet, path: "/test.txt",
+			headers: map[string]string{
+				"host":                 "examplebucket.s3.amazonaws.com",
+				"range":                "bytes=0-9",
+				"x-amz-content-sha256": emptyPayload,
+				"x-amz-date":           "20130524T000000Z",
+			},
+			payloadHash: emptyPayload, secret: s3Secret, service: "s3", now: s3Time,
+			wantSigned:    "host;range;x-amz-content-sha256;x-amz-date",
+			wantSignature: "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41",
+		},
+		{
+			name:   "s3 put object",
+			method: http.MethodPut, path: uriEncode("/test$file.text"),
+			headers: map[string]string{
+				"date":                 "Fri, 24 May 2013 00:00:00 GMT",
+				"host":                 "examplebucket.s3.amazonaws.com",
+				"x-amz-content-sha256": "44ce7dd67c959e0d3524ffac1771dfbba87d2b6b4b4e99e42034a8b803f8b072",
+				"x-amz-date":           "20130524T000000Z",
+				"x-amz-storage-class":  "REDUCED_REDUNDANCY",
+			},
+			payloadHash: "44ce7dd67c959e0d3524ffac1771dfbba87d2b6b4b4e99e42034a8b803f8b072",
+			secret:      s3Secret, service: "s3", now: s3Time,
+			wantSigned:    "date;host;x-amz-content-sha256;x-amz-date;x-amz-storage-class",
+			wantSignature: "98ad721746da40c64f1a55b78f14c238d841ea1380cd77a1b5971af0ece108bd",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, signed := canonicalRequest(tc.method, tc.path, tc.headers, tc.payloadHash)
+			if signed != tc.wantSigned {
+				t.Errorf("signed headers = %q, want %q", signed, tc.wantSigned)
+			}
+			_, signature := signatureV4(tc.secret, "us-east-1", tc.service, tc.now, canonical)
+			if signature != tc.wantSignature {
+				t.Errorf("signature = %s, want %s\ncanonical request:\n%s", signature, tc.wantSignature, canonical)
+			}
+		})
+	}
+}
+
+func TestURIEncode(t *testing.T) {
+	for in, want := range map[string]string{
+		"/bucket/outputs/a1.png": "/bucket/outputs/a1.png",
+		"/test$file.text":        "/test%24file.text",
+		"/a b+c~d_e-f":           "/a%20b%2Bc~d_e-f",
+		"/ሴ":                     "/%E1%88%B4",
+	} {
+		if got := uriEncode(in); got != want {
+			t.Errorf("uriEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// fakeS3 is an S3 endpoint keeping objects in memory. It rejects requests whose signature does not
+// match the one it computes for them.
+type fakeS3 struct {
+	config S3Config
+
+	mu       sync.Mutex
+	buckets  map[string]bool
+	objects  map[string][]byte
+	rejected []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		http.Error(w, "missing X-Amz-Date", http.StatusForbidden)
+		return
+	}
+	canonical, signedHeaders := canonicalRequest(r.Method, r.URL.EscapedPath(), map[string]string{
+		"host":                 r.Host,
+		"x-amz-content-sha256": r.Header.Get("X-Amz-Content-Sha256"),
+		"x-amz-date":           r.Header.Get("X-Amz-Date"),
+	}, r.Header.Get("X-Amz-Content-Sha256"))
+	scope, signature := signatureV4(f.config.SecretKey, f.config.Region, "s3", now, canonical)
+	want := "AWS4-HMAC-SHA256 Credential=" + f.config.AccessKey + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := r.Header.Get("Authorization"); got != want {
+		f.rejected = append(f.rejected, r.Method+" "+r.URL.EscapedPath()+": "+got)
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if !f.buckets[bucket] {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	config := S3Config{Bucket: "outputs", AccessKey: "minio", SecretKey: "minio-secret"}
+	fake := &fakeS3{config: config, buckets: make(map[string]bool), objects: make(map[string][]byte)}
+	fake.config.Region = "us-east-1"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config.Endpoint = server.URL
+	store, err := NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// The bucket is created once and found afterwards.
+	for i := 0; i < 2; i++ {
+		if err := store.EnsureBucket(ctx); err != nil {
+			t.Fatalf("EnsureBucket #%d: %v", i+1, err)
+		}
+	}
+	if !fake.buckets["outputs"] {
+		t.Fatal("EnsureBucket did not create the bucket")
+	}
+
+	// Keys are escaped, and an unknown size is read before the upload.
+	const key = "cells/a b+c/buffer.bin"
+	data := []byte("\x00\x01binary payload")
+	if err := store.Put(ctx, key, bytes.NewReader(data), -1, "application/octet-stream"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, "empty", bytes.NewReader(nil), 0, ""); err != nil {
+		t.Fatalf("Put of an empty object: %v", err)
+	}
+
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %q, %v; want %q", got, err, data)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+
+	if len(fake.rejected) > 0 {
+		t.Fatalf("requests with a bad signature: %q", fake.rejected)
+	}
+
+	// A store with the wrong credentials is refused.
+	config.SecretKey = "wrong"
+	bad, err := NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bad.Put(ctx, "denied", strings.NewReader("x"), 1, ""); err == nil {
+		t.Error("Put with the wrong secret succeeded")
+	}
+}
@@ -2,33 +2,113 @@ package culler
 
 import (
 	"context"
-	"time"
+	"errors"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
-	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 )
 
-// StartCuller starts a background process that periodically removes idle kernels.
-func StartCuller(ctx context.Context, jupyterClient *jupyterclient.Client) {
-	logger := pkg.Logger
+// SessionStore is the session data the culler needs; it is satisfied by repository.SessionRepository.
+type SessionStore interface {
+	GetSessionByKernelID(ctx context.Context, kernelID uuid.UUID) (*models.Session, error)
+	SetSessionStatus(ctx context.Context, id uuid.UUID, status string) error
+}
+
+// Config holds the culler's timings.
+type Config struct {
+	// Interval is the time between two culling runs.
+	Interval time.Duration
+	// IdleThreshold is how long a session kernel may stay idle before it is culled.
+	IdleThreshold time.Duration
+	// MaxBusyRuntime caps how long a kernel may stay busy; zero disables the cap.
+	MaxBusyRuntime time.Duration
+	// OrphanGrace is how long a kernel without a session is left alone, so that kernels
+	// which are still being attached to a new session are not reaped.
+	OrphanGrace time.Duration
+}
 
-	// Load intervals from env (with fallback defaults)
+// ConfigFromEnv loads the culler configuration from the environment, with fallback defaults.
+func ConfigFromEnv() Config {
 	cullIntervalMin, _ := strconv.Atoi(getEnvOrDefault("CULL_INTERVAL_MINUTES", "10"))
 	idleThresholdMin, _ := strconv.Atoi(getEnvOrDefault("IDLE_THRESHOLD_MINUTES", "30"))
+	maxBusyRuntimeMin, _ := strconv.Atoi(getEnvOrDefault("MAX_BUSY_RUNTIME_MINUTES", "360"))
+	orphanGraceMin, _ := strconv.Atoi(getEnvOrDefault("ORPHAN_GRACE_MINUTES", "5"))
+
+	return Config{
+		Interval:       time.Duration(cullIntervalMin) * time.Minute,
+		IdleThreshold:  time.Duration(idleThresholdMin) * time.Minute,
+		MaxBusyRuntime: time.Duration(maxBusyRuntimeMin) * time.Minute,
+		OrphanGrace:    time.Duration(orphanGraceMin) * time.Minute,
+	}
+}
+
+// Stats describes the outcome of the most recent culling run.
+type Stats struct {
+	LastRunAt             time.Time `json:"last_run_at"`
+	DurationMS            int64     `json:"duration_ms"`
+	KernelsChecked        int       `json:"kernels_checked"`
+	IdleCulled            int       `json:"idle_culled"`
+	BusySkipped           int       `json:"busy_skipped"`
+	OverRuntime           int       `json:"over_runtime_culled"`
+	OrphansReaped         int       `json:"orphans_reaped"`
+	SessionsCulled        int       `json:"sessions_marked_culled"`
+	PooledSkipped         int       `json:"pooled_skipped"`
+	Errors                []string  `json:"errors,omitempty"`
+	IntervalMinutes       float64   `json:"interval_minutes"`
+	IdleThresholdMinutes  float64   `json:"idle_threshold_minutes"`
+	MaxBusyRuntimeMinutes float64   `json:"max_busy_runtime_minutes"`
+}
+
+// Culler periodically shuts down kernels that are idle, stuck busy, or not attached to any session,
+// and marks the sessions that owned them as culled.
+type Culler struct {
+	client   jupyterclient.Gateway
+	sessions SessionStore
+	config   Config
+
+	// skip reports kernels the culler must leave alone, such as the warm pool's idle kernels.
+	skip func(kernelID string) bool
+	// events receives a KernelCulled event for every kernel the culler deletes.
+	events *events.Bus
+
+	// busySince remembers when each kernel was first seen busy; it is only touched by the culling loop.
+	busySince map[string]time.Time
+
+	mu    sync.RWMutex
+	stats Stats
+}
 
-	cullInterval := time.Duration(cullIntervalMin) * time.Minute
-	idleThreshold := time.Duration(idleThresholdMin) * time.Minute
+// New creates a Culler that uses the configured gateway client.
+func New(client jupyterclient.Gateway, sessions SessionStore, config Config) *Culler {
+	return &Culler{
+		client:    client,
+		sessions:  sessions,
+		config:    config,
+		busySince: make(map[string]time.Time),
+	}
+}
+
+// Start runs the culler in the background until ctx is cancelled.
+func (c *Culler) Start(ctx context.Context) {
+	logger := pkg.Logger
 
-	ticker := time.NewTicker(cullInterval)
-	logger.Info().Msgf("[CULLER]: Started. Checking every %d minutes, idle threshold = %d minutes", cullIntervalMin, idleThresholdMin)
+	ticker := time.NewTicker(c.config.Interval)
+	logger.Info().Msgf("[CULLER]: Started. Checking every %s, idle threshold = %s, max busy runtime = %s",
+		c.config.Interval, c.config.IdleThreshold, c.config.MaxBusyRuntime)
 
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				cullIdleKernels(ctx, jupyterClient, idleThreshold)
+				c.cullIdleKernels(ctx)
 			case <-ctx.Done():
 				logger.Warn().Msg("[CULLER]: Context cancelled, stopping culler.")
 				ticker.Stop()
@@ -38,42 +118,185 @@ func StartCuller(ctx context.Context, jupyterClient *jupyterclient.Client) {
 	}()
 }
 
-func cullIdleKernels(ctx context.Context, client *jupyterclient.Client, threshold time.Duration) {
+// SkipKernels makes the culler leave alone the kernels for which skip returns true, e.g. kernels
+// kept idle on purpose by a warm pool. It must be called before Start.
+func (c *Culler) SkipKernels(skip func(kernelID string) bool) {
+	c.skip = skip
+}
+
+// PublishEvents makes the culler publish the kernels it deletes on bus. It must be called before Start.
+func (c *Culler) PublishEvents(bus *events.Bus) {
+	c.events = bus
+}
+
+// Stats returns the statistics of the last culling run.
+func (c *Culler) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := c.stats
+	stats.Errors = append([]string(nil), c.stats.Errors...)
+	return stats
+}
+
+// RunOnce performs a single culling run immediately and returns its statistics.
+// It must not be called concurrently with the background loop started by Start.
+func (c *Culler) RunOnce(ctx context.Context) Stats {
+	c.cullIdleKernels(ctx)
+	return c.Stats()
+}
+
+func (c *Culler) cullIdleKernels(ctx context.Context) {
 	logger := pkg.Logger
 	logger.Info().Msg("[CULLER]: Running idle kernel check...")
 
-	kernels, err := client.GetKernels(ctx)
-	if err != nil {
-		logger.Error().Err(err).Msg("[CULLER]: Failed to get kernels, attempting reconnect...")
+	start := time.Now().UTC()
+	stats := Stats{
+		LastRunAt:             start,
+		IntervalMinutes:       c.config.Interval.Minutes(),
+		IdleThresholdMinutes:  c.config.IdleThreshold.Minutes(),
+		MaxBusyRuntimeMinutes: c.config.MaxBusyRuntime.Minutes(),
+	}
+	defer func() {
+		stats.DurationMS = time.Since(start).Milliseconds()
+		c.mu.Lock()
+		c.stats = stats
+		c.mu.Unlock()
+	}()
 
-		// Attempt to reinitialize the Jupyter client connection
-		newClient, connErr := jupyterclient.NewClient("http://localhost:8888", "YOUR_SECRET_TOKEN")
-		if connErr != nil {
-			logger.Error().Err(connErr).Msg("[CULLER]: Reconnection to Jupyter Gateway failed.")
-			return
-		}
-		logger.Info().Msg("[CULLER]: Reconnected to Jupyter Gateway successfully.")
-		*client = *newClient
+	kernels, err := c.client.GetKernels(ctx)
+	if err != nil {
+		// The client is shared with the rest of the controller; the next run simply tries again.
+		logger.Error().Err(err).Msg("[CULLER]: Failed to get kernels from the gateway")
+		stats.Errors = append(stats.Errors, err.Error())
 		return
 	}
 
-	now := time.Now().UTC()
+	seen := make(map[string]struct{}, len(*kernels))
 	for _, k := range *kernels {
-		idleDuration := now.Sub(k.LastActivity)
-		if idleDuration > threshold {
-			logger.Warn().Str("kernel_id", k.ID).Str("language", k.Name).Dur("idle_for", idleDuration).
-				Msg("[CULLER]: Kernel exceeded idle threshold. Deleting...")
-
-			if err := client.DeleteKernel(ctx, k.ID); err != nil {
-				logger.Error().Err(err).Str("kernel_id", k.ID).Msg("Failed to delete idle kernel")
-				continue
+		seen[k.ID] = struct{}{}
+		stats.KernelsChecked++
+		if c.skip != nil && c.skip(k.ID) {
+			delete(c.busySince, k.ID)
+			stats.PooledSkipped++
+			continue
+		}
+
+		session, err := c.lookupSession(ctx, k.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("kernel_id", k.ID).Msg("[CULLER]: Failed to look up session for kernel")
+			stats.Errors = append(stats.Errors, err.Error())
+			continue
+		}
+
+		reason := c.cullReason(k, session, start)
+		switch reason {
+		case "":
+			if k.ExecutionState == "busy" {
+				stats.BusySkipped++
 			}
+			continue
+		case reasonIdle:
+			stats.IdleCulled++
+		case reasonOverRuntime:
+			stats.OverRuntime++
+		case reasonOrphan:
+			stats.OrphansReaped++
+		}
+
+		logger.Warn().Str("kernel_id", k.ID).Str("language", k.Name).Str("reason", reason).
+			Dur("idle_for", start.Sub(k.LastActivity)).
+			Msg("[CULLER]: Deleting kernel...")
+
+		if err := c.client.DeleteKernel(ctx, k.ID); err != nil {
+			logger.Error().Err(err).Str("kernel_id", k.ID).Msg("Failed to delete idle kernel")
+			stats.Errors = append(stats.Errors, err.Error())
+			continue
+		}
+		delete(c.busySince, k.ID)
+		logger.Info().Str("kernel_id", k.ID).Msg("[CULLER]: Kernel deleted successfully.")
 
-			logger.Info().Str("kernel_id", k.ID).Msg("[CULLER]: Kernel deleted successfully.")
+		event := events.Event{Type: events.KernelCulled, KernelID: k.ID, Reason: reason}
+		if session != nil {
+			event.SessionID = session.ID.String()
+		}
+		c.events.Publish(event)
+
+		if session == nil {
+			continue
+		}
+		if err := c.sessions.SetSessionStatus(ctx, session.ID, models.SessionStatusCulled); err != nil {
+			logger.Error().Err(err).Str("session_id", session.ID.String()).Msg("[CULLER]: Failed to mark session as culled")
+			stats.Errors = append(stats.Errors, err.Error())
+			continue
+		}
+		stats.SessionsCulled++
+	}
+
+	for kernelID := range c.busySince {
+		if _, ok := seen[kernelID]; !ok {
+			delete(c.busySince, kernelID)
 		}
 	}
 
-	logger.Info().Msg("[CULLER]: Idle kernel check complete.")
+	logger.Info().
+		Int("checked", stats.KernelsChecked).
+		Int("idle_culled", stats.IdleCulled).
+		Int("over_runtime_culled", stats.OverRuntime).
+		Int("orphans_reaped", stats.OrphansReaped).
+		Int("busy_skipped", stats.BusySkipped).
+		Int("pooled_skipped", stats.PooledSkipped).
+		Msg("[CULLER]: Idle kernel check complete.")
+}
+
+// Reasons for deleting a kernel.
+const (
+	reasonIdle        = "idle"
+	reasonOverRuntime = "max_runtime_exceeded"
+	reasonOrphan      = "orphaned"
+)
+
+// cullReason decides whether a kernel should be deleted, returning an empty reason to keep it.
+func (c *Culler) cullReason(k jupyterclient.Kernel, session *models.Session, now time.Time) string {
+	if k.ExecutionState == "busy" {
+		since, ok := c.busySince[k.ID]
+		if !ok {
+			c.busySince[k.ID] = now
+			since = now
+		}
+		if c.config.MaxBusyRuntime > 0 && now.Sub(since) > c.config.MaxBusyRuntime {
+			return reasonOverRuntime
+		}
+		return ""
+	}
+	delete(c.busySince, k.ID)
+
+	idleFor := now.Sub(k.LastActivity)
+	if session == nil {
+		if idleFor > c.config.OrphanGrace {
+			return reasonOrphan
+		}
+		return ""
+	}
+	if idleFor > c.config.IdleThreshold {
+		return reasonIdle
+	}
+	return ""
+}
+
+// lookupSession returns the session running the kernel, or nil when the kernel is orphaned.
+func (c *Culler) lookupSession(ctx context.Context, kernelID string) (*models.Session, error) {
+	kernelUUID, err := uuid.Parse(kernelID)
+	if err != nil {
+		return nil, nil
+	}
+	session, err := c.sessions.GetSessionByKernelID(ctx, kernelUUID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
 }
 
 // TODO: Useful function. Can be seperated and used elsewhere if needed
@@ -0,0 +1,120 @@
+package culler_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/culler"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog"
+)
+
+// memorySessions is a culler.SessionStore keeping sessions in memory.
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*models.Session
+}
+
+func (s *memorySessions) add(kernelID string) *models.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := &models.Session{ID: uuid.New(), CurrentKernelID: uuid.MustParse(kernelID), Status: models.SessionStatusActive}
+	s.sessions[session.ID] = session
+	return session
+}
+
+func (s *memorySessions) status(id uuid.UUID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id].Status
+}
+
+func (s *memorySessions) GetSessionByKernelID(ctx context.Context, kernelID uuid.UUID) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		if session.CurrentKernelID == kernelID {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (s *memorySessions) SetSessionStatus(ctx context.Context, id uuid.UUID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	session.Status = status
+	return nil
+}
+
+func TestCullerRunOnce(t *testing.T) {
+	logger := zerolog.Nop()
+	pkg.Logger = &logger
+
+	gateway := fakegateway.New("")
+	defer gateway.Close()
+	sessions := &memorySessions{sessions: make(map[uuid.UUID]*models.Session)}
+	c := culler.New(gateway.Client(), sessions, culler.Config{
+		Interval:       time.Minute,
+		IdleThreshold:  30 * time.Minute,
+		MaxBusyRuntime: time.Hour,
+		OrphanGrace:    5 * time.Minute,
+	})
+	now := time.Now().UTC()
+
+	idle := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	idleSession := sessions.add(idle.ID)
+	gateway.SetKernelState(idle.ID, "idle", now.Add(-time.Hour))
+
+	active := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	activeSession := sessions.add(active.ID)
+	gateway.SetKernelState(active.ID, "idle", now.Add(-time.Minute))
+
+	busy := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	sessions.add(busy.ID)
+	gateway.SetKernelState(busy.ID, "busy", now.Add(-2*time.Hour))
+
+	newOrphan := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	gateway.SetKernelState(newOrphan.ID, "idle", now.Add(-time.Minute))
+
+	oldOrphan := gateway.StartKernel(fakegateway.DefaultKernelSpec)
+	gateway.SetKernelState(oldOrphan.ID, "idle", now.Add(-10*time.Minute))
+
+	stats := c.RunOnce(context.Background())
+
+	if stats.KernelsChecked != 5 || stats.IdleCulled != 1 || stats.OrphansReaped != 1 || stats.BusySkipped != 1 || stats.SessionsCulled != 1 {
+		t.Errorf("stats = %+v, want 5 checked, 1 idle culled, 1 orphan reaped, 1 busy skipped, 1 session culled", stats)
+	}
+	if len(stats.Errors) != 0 {
+		t.Errorf("errors = %v", stats.Errors)
+	}
+
+	for _, kept := range []string{active.ID, busy.ID, newOrphan.ID} {
+		if _, ok := gateway.Kernel(kept); !ok {
+			t.Errorf("kernel %s was culled, want it kept", kept)
+		}
+	}
+	for _, culled := range []string{idle.ID, oldOrphan.ID} {
+		if _, ok := gateway.Kernel(culled); ok {
+			t.Errorf("kernel %s was kept, want it culled", culled)
+		}
+	}
+
+	if status := sessions.status(idleSession.ID); status != models.SessionStatusCulled {
+		t.Errorf("idle session status = %q, want %q", status, models.SessionStatusCulled)
+	}
+	if status := sessions.status(activeSession.ID); status != models.SessionStatusActive {
+		t.Errorf("active session status = %q, want %q", status, models.SessionStatusActive)
+	}
+}
@@ -0,0 +1,112 @@
+// Package events is an in-process publish/subscribe bus for session and kernel lifecycle events,
+// such as kernel status changes, restarts, culls and executions, which the UI follows over SSE.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+)
+
+// Event types.
+const (
+	// KernelStatus reports a kernel's execution state: starting, busy, idle, restarting or dead.
+	KernelStatus = "kernel_status"
+	// KernelRestarted reports that a kernel was restarted in place and lost its state.
+	KernelRestarted = "kernel_restarted"
+	// KernelReplaced reports that a session now runs on a new kernel, given by KernelID.
+	KernelReplaced = "kernel_replaced"
+	// KernelCulled reports that the culler shut a kernel down; Reason says why.
+	KernelCulled = "kernel_culled"
+	// ExecutionStarted reports that a kernel started running an execute_request.
+	ExecutionStarted = "execution_started"
+	// ExecutionFinished reports that a kernel replied to an execute_request; Status is its reply status.
+	ExecutionFinished = "execution_finished"
+	// SessionStatus reports that a session's status changed to Status.
+	SessionStatus = "session_status"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it starts missing events.
+const subscriberBuffer = 64
+
+// Event is one lifecycle event. Publishers fill in the IDs they know; subscribers match on them.
+type Event struct {
+	Type           string `json:"type"`
+	SessionID      string `json:"session_id,omitempty"`
+	KernelID       string `json:"kernel_id,omitempty"`
+	ExecutionState string `json:"execution_state,omitempty"`
+	// MsgID is the msg_id of the execute_request an execution event is about.
+	MsgID  string    `json:"msg_id,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Bus delivers every published event to the subscribers whose filter matches it.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives matching events on C until it is closed.
+type Subscription struct {
+	// C delivers the events. It is closed by Close.
+	C <-chan Event
+
+	ch    chan Event
+	match func(Event) bool
+	bus   *Bus
+}
+
+// NewBus creates an empty Bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers the event to the matching subscribers without blocking; a subscriber that has
+// fallen behind misses it. Publishing on a nil Bus does nothing, so publishers may leave it unset.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			pkg.Logger.Debug().Str("type", event.Type).Str("kernel_id", event.KernelID).Msg("[EVENTS]: Dropping event for a slow subscriber")
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the events match returns true for. match runs on the
+// publisher's goroutine, possibly concurrently, and must not block.
+func (b *Bus) Subscribe(match func(Event) bool) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, match: match, bus: b}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Close unregisters the subscription and closes C. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subscribers[s]; !ok {
+		return
+	}
+	delete(s.bus.subscribers, s)
+	close(s.ch)
+}
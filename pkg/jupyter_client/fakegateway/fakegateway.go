@@ -0,0 +1,623 @@
+// Package fakegateway is an in-process Jupyter Kernel Gateway for tests. It serves the REST API the
+// controller uses and a kernel channels websocket whose execute_request replies are scripted by the
+// test, so kernels, sessions and the culler can be exercised without a real gateway.
+package fakegateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultToken is the token the fake gateway expects when none is given to New.
+const DefaultToken = "fake-gateway-token"
+
+// DefaultKernelSpec is the kernelspec the fake gateway offers, and its default.
+const DefaultKernelSpec = "python3"
+
+// Output is one iopub message an execution publishes, such as a stream or an execute_result.
+type Output struct {
+	MsgType string
+	Content any
+}
+
+// Stream returns a stream output of the given name ("stdout" or "stderr").
+func Stream(name, text string) Output {
+	return Output{MsgType: "stream", Content: jupyterclient.StreamContent{Name: name, Text: text}}
+}
+
+// Display returns a display_data output.
+func Display(data map[string]any) Output {
+	return Output{MsgType: "display_data", Content: jupyterclient.DisplayDataContent{
+		Data:      data,
+		Metadata:  map[string]any{},
+		Transient: map[string]any{},
+	}}
+}
+
+// Result returns an execute_result output; its execution count is filled in by the kernel.
+func Result(data map[string]any) Output {
+	return Output{MsgType: "execute_result", Content: jupyterclient.ExecuteResultContent{
+		Data:     data,
+		Metadata: map[string]any{},
+	}}
+}
+
+// Reply scripts what the kernel does for one execute_request.
+type Reply struct {
+	// Outputs are published on iopub, in order, while the kernel is busy.
+	Outputs []Output
+	// Error, when set, is published after the outputs and makes the execute_reply status "error".
+	Error *jupyterclient.ErrorContent
+	// Delay keeps the kernel busy before it replies. Interrupting the kernel cuts it short with a
+	// KeyboardInterrupt error.
+	Delay time.Duration
+}
+
+// Server is a fake Jupyter Kernel Gateway listening on a local port.
+type Server struct {
+	// URL is the base URL of the gateway, to be given to jupyterclient.NewClient.
+	URL   string
+	Token string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	specs    jupyterclient.GetKernelSpecsResponse
+	kernels  map[string]*kernel
+	replies  map[string]Reply
+	executor func(code string) Reply
+	// unavailable makes every request fail, see SetAvailable.
+	unavailable bool
+}
+
+type kernel struct {
+	info           jupyterclient.Kernel
+	env            map[string]string
+	session        string
+	executionCount int
+	executed       []string
+	interrupts     int
+	conns          map[*channelConn]struct{}
+	interrupt      chan struct{}
+	// execMu runs the kernel's executions one at a time, like a real kernel.
+	execMu sync.Mutex
+}
+
+// New starts a fake gateway offering the python3 kernelspec. token may be empty to use DefaultToken.
+func New(token string) *Server {
+	if token == "" {
+		token = DefaultToken
+	}
+	s := &Server{
+		Token:    token,
+		upgrader: websocket.Upgrader{Subprotocols: []string{jupyterclient.ProtocolV1}},
+		specs: jupyterclient.GetKernelSpecsResponse{
+			Default: DefaultKernelSpec,
+			KernelSpecs: map[string]jupyterclient.KernelSpecEntry{
+				DefaultKernelSpec: {
+					Name: DefaultKernelSpec,
+					Spec: jupyterclient.KernelSpecFile{Language: "python", DisplayName: "Python 3", Argv: []string{"python"}},
+				},
+			},
+		},
+		kernels: make(map[string]*kernel),
+		replies: make(map[string]Reply),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api", s.handleAPI)
+	mux.HandleFunc("GET /api/kernelspecs", s.handleKernelSpecs)
+	mux.HandleFunc("GET /api/kernels", s.handleListKernels)
+	mux.HandleFunc("POST /api/kernels", s.handleStartKernel)
+	mux.HandleFunc("GET /api/kernels/{id}", s.handleGetKernel)
+	mux.HandleFunc("DELETE /api/kernels/{id}", s.handleDeleteKernel)
+	mux.HandleFunc("POST /api/kernels/{id}/interrupt", s.handleInterruptKernel)
+	mux.HandleFunc("POST /api/kernels/{id}/restart", s.handleRestartKernel)
+	mux.HandleFunc("GET /api/kernels/{id}/channels", s.handleChannels)
+
+	s.srv = httptest.NewServer(s.authorize(mux))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the gateway down, dropping every kernel connection.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, k := range s.kernels {
+		for c := range k.conns {
+			c.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// Client returns a gateway client for the fake gateway.
+func (s *Server) Client() *jupyterclient.Client {
+	client, err := jupyterclient.NewClient(s.URL, s.Token)
+	if err != nil {
+		panic(fmt.Sprintf("fakegateway: %v", err))
+	}
+	return client
+}
+
+// AddKernelSpec offers another kernelspec.
+func (s *Server) AddKernelSpec(name, language string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs.KernelSpecs[name] = jupyterclient.KernelSpecEntry{
+		Name: name,
+		Spec: jupyterclient.KernelSpecFile{Language: language, DisplayName: name, Argv: []string{name}},
+	}
+}
+
+// OnExecute scripts the reply to an execute_request for exactly this code.
+func (s *Server) OnExecute(code string, reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[code] = reply
+}
+
+// SetExecutor scripts the reply to every execute_request whose code has no OnExecute reply.
+// Without one, such code runs successfully without output.
+func (s *Server) SetExecutor(executor func(code string) Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.executor = executor
+}
+
+// SetAvailable makes the gateway answer every request with 503 Service Unavailable while false,
+// like a gateway that is overloaded or restarting. Its kernels are kept.
+func (s *Server) SetAvailable(available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = !available
+}
+
+// StartKernel starts a kernel directly, e.g. one that belongs to no session.
+func (s *Server) StartKernel(name string) jupyterclient.Kernel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startKernel(name).info
+}
+
+// Kernels returns the running kernels, ordered by ID.
+func (s *Server) Kernels() []jupyterclient.Kernel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kernels := make([]jupyterclient.Kernel, 0, len(s.kernels))
+	for _, k := range s.kernels {
+		kernels = append(kernels, k.info)
+	}
+	sort.Slice(kernels, func(i, j int) bool { return kernels[i].ID < kernels[j].ID })
+	return kernels
+}
+
+// Kernel returns a running kernel.
+func (s *Server) Kernel(id string) (jupyterclient.Kernel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.kernels[id]
+	if !ok {
+		return jupyterclient.Kernel{}, false
+	}
+	return k.info, true
+}
+
+// SetKernelState overrides what the gateway reports about a kernel's activity, e.g. to make it
+// look idle for an hour. It returns false if the kernel is not running.
+func (s *Server) SetKernelState(id string, executionState string, lastActivity time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.kernels[id]
+	if !ok {
+		return false
+	}
+	k.info.ExecutionState = executionState
+	k.info.LastActivity = lastActivity
+	return true
+}
+
+// Env returns the environment variables the kernel was started with.
+func (s *Server) Env(id string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.kernels[id]; ok {
+		return k.env
+	}
+	return nil
+}
+
+// Executed returns the code of every execute_request the kernel received, in order.
+func (s *Server) Executed(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.kernels[id]; ok {
+		return append([]string(nil), k.executed...)
+	}
+	return nil
+}
+
+// Interrupts returns how many times the kernel was interrupted.
+func (s *Server) Interrupts(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.kernels[id]; ok {
+		return k.interrupts
+	}
+	return 0
+}
+
+// authorize rejects requests without the gateway token, like the real gateway does, and every
+// request while the gateway is unavailable.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		unavailable := s.unavailable
+		s.mu.Unlock()
+		if unavailable {
+			writeError(w, http.StatusServiceUnavailable, "Service Unavailable", "the gateway is unavailable")
+			return
+		}
+		if r.Header.Get("Authorization") != "token "+s.Token {
+			writeError(w, http.StatusForbidden, "Forbidden", "invalid or missing token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jupyterclient.ApiInfo{Version: "fake"})
+}
+
+func (s *Server) handleKernelSpecs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.specs)
+}
+
+func (s *Server) handleListKernels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Kernels())
+}
+
+func (s *Server) handleStartKernel(w http.ResponseWriter, r *http.Request) {
+	var req jupyterclient.StartKernelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := req.Name
+	if name == "" {
+		name = s.specs.Default
+	}
+	if _, ok := s.specs.KernelSpecs[name]; !ok {
+		writeError(w, http.StatusInternalServerError, "Internal Server Error", fmt.Sprintf("No such kernel named %s", name))
+		return
+	}
+	k := s.startKernel(name)
+	k.env = req.Env
+	writeJSON(w, http.StatusCreated, k.info)
+}
+
+// startKernel registers a new idle kernel. s.mu must be held.
+func (s *Server) startKernel(name string) *kernel {
+	k := &kernel{
+		info: jupyterclient.Kernel{
+			ID:             uuid.NewString(),
+			Name:           name,
+			LastActivity:   time.Now().UTC(),
+			ExecutionState: "idle",
+		},
+		session:   uuid.NewString(),
+		conns:     make(map[*channelConn]struct{}),
+		interrupt: make(chan struct{}, 1),
+	}
+	s.kernels[k.info.ID] = k
+	return k
+}
+
+func (s *Server) handleGetKernel(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.Kernel(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Kernel does not exist: "+r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleDeleteKernel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	k, ok := s.kernels[r.PathValue("id")]
+	if ok {
+		delete(s.kernels, k.info.ID)
+		for c := range k.conns {
+			c.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Kernel does not exist: "+r.PathValue("id"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleInterruptKernel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	k, ok := s.kernels[r.PathValue("id")]
+	if ok {
+		k.interrupts++
+		select {
+		case k.interrupt <- struct{}{}:
+		default:
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Kernel does not exist: "+r.PathValue("id"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRestartKernel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	k, ok := s.kernels[r.PathValue("id")]
+	if ok {
+		k.executionCount = 0
+		k.info.ExecutionState = "idle"
+		k.info.LastActivity = time.Now().UTC()
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Kernel does not exist: "+r.PathValue("id"))
+		return
+	}
+	info, _ := s.Kernel(r.PathValue("id"))
+	writeJSON(w, http.StatusOK, info)
+}
+
+// channelConn is one websocket attached to a kernel's channels.
+type channelConn struct {
+	conn     *websocket.Conn
+	protocol string
+	writeMu  sync.Mutex
+}
+
+func (c *channelConn) send(msg *jupyterclient.Message) error {
+	messageType, data, err := jupyterclient.EncodeMessage(c.protocol, msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	k, ok := s.kernels[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found", "Kernel does not exist: "+r.PathValue("id"))
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &channelConn{conn: conn, protocol: conn.Subprotocol()}
+
+	s.mu.Lock()
+	k.conns[c] = struct{}{}
+	k.info.Connections = len(k.conns)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(k.conns, c)
+		k.info.Connections = len(k.conns)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg, err := jupyterclient.DecodeMessage(c.protocol, messageType, data)
+		if err != nil {
+			continue
+		}
+
+		switch msg.Header.MsgType {
+		case "execute_request":
+			// Executions run in the background so the connection keeps reading, e.g. interrupts
+			// sent on the control channel or other requests queued behind a long execution.
+			go s.execute(k, c, msg)
+		case "kernel_info_request":
+			s.reply(k, c, msg, "kernel_info_reply", map[string]any{
+				"status":           "ok",
+				"protocol_version": "5.3",
+				"implementation":   "fakegateway",
+				"language_info":    map[string]any{"name": "python", "version": "3", "file_extension": ".py"},
+			})
+		case "complete_request":
+			var content jupyterclient.CompleteRequestContent
+			_ = json.Unmarshal(msg.Content, &content)
+			s.reply(k, c, msg, "complete_reply", jupyterclient.CompleteReplyContent{
+				Status:      "ok",
+				Matches:     []string{},
+				CursorStart: content.CursorPos,
+				CursorEnd:   content.CursorPos,
+				Metadata:    map[string]any{},
+			})
+		case "inspect_request":
+			s.reply(k, c, msg, "inspect_reply", jupyterclient.InspectReplyContent{
+				Status:   "ok",
+				Data:     map[string]any{},
+				Metadata: map[string]any{},
+			})
+		default:
+			if strings.HasSuffix(msg.Header.MsgType, "_request") {
+				s.reply(k, c, msg, strings.TrimSuffix(msg.Header.MsgType, "_request")+"_reply", map[string]any{
+					"status": "error",
+					"ename":  "NotImplementedError",
+					"evalue": "the fake gateway does not implement " + msg.Header.MsgType,
+				})
+			}
+		}
+	}
+}
+
+// execute runs one execute_request: busy, execute_input, the scripted outputs, execute_reply, idle.
+func (s *Server) execute(k *kernel, c *channelConn, request *jupyterclient.Message) {
+	var content jupyterclient.ExecuteRequestContent
+	if err := json.Unmarshal(request.Content, &content); err != nil {
+		return
+	}
+
+	k.execMu.Lock()
+	defer k.execMu.Unlock()
+
+	s.mu.Lock()
+	reply, ok := s.replies[content.Code]
+	if !ok && s.executor != nil {
+		reply = s.executor(content.Code)
+	}
+	k.executed = append(k.executed, content.Code)
+	if !content.Silent {
+		k.executionCount++
+	}
+	count := k.executionCount
+	k.info.ExecutionState = "busy"
+	k.info.LastActivity = time.Now().UTC()
+	// A stale interrupt must not cut this execution short.
+	select {
+	case <-k.interrupt:
+	default:
+	}
+	s.mu.Unlock()
+
+	s.publish(k, request, "status", jupyterclient.StatusContent{ExecutionState: "busy"})
+	s.publish(k, request, "execute_input", map[string]any{"code": content.Code, "execution_count": count})
+
+	execErr := reply.Error
+	interrupted := false
+	if reply.Delay > 0 {
+		timer := time.NewTimer(reply.Delay)
+		select {
+		case <-timer.C:
+		case <-k.interrupt:
+			timer.Stop()
+			interrupted = true
+			execErr = &jupyterclient.ErrorContent{Ename: "KeyboardInterrupt", Evalue: "", Traceback: []string{"KeyboardInterrupt"}}
+		}
+	}
+
+	if !interrupted {
+		for _, output := range reply.Outputs {
+			outputContent := output.Content
+			if result, ok := outputContent.(jupyterclient.ExecuteResultContent); ok {
+				result.ExecutionCount = count
+				outputContent = result
+			}
+			s.publish(k, request, output.MsgType, outputContent)
+		}
+	}
+
+	replyContent := jupyterclient.ExecuteReplyContent{Status: "ok", ExecutionCount: count}
+	if execErr != nil {
+		s.publish(k, request, "error", execErr)
+		replyContent.Status = "error"
+		replyContent.Ename = execErr.Ename
+		replyContent.Evalue = execErr.Evalue
+		replyContent.Traceback = execErr.Traceback
+	}
+	if len(content.UserExpressions) > 0 {
+		replyContent.UserExpressions = make(map[string]jupyterclient.UserExpressionResult, len(content.UserExpressions))
+		for name := range content.UserExpressions {
+			replyContent.UserExpressions[name] = jupyterclient.UserExpressionResult{
+				Status: "error",
+				Ename:  "NotImplementedError",
+				Evalue: "the fake gateway does not evaluate user expressions",
+			}
+		}
+	}
+	s.reply(k, c, request, "execute_reply", replyContent)
+
+	s.mu.Lock()
+	k.info.ExecutionState = "idle"
+	k.info.LastActivity = time.Now().UTC()
+	s.mu.Unlock()
+	s.publish(k, request, "status", jupyterclient.StatusContent{ExecutionState: "idle"})
+}
+
+// publish sends an iopub message to every connection of the kernel.
+func (s *Server) publish(k *kernel, parent *jupyterclient.Message, msgType string, content any) {
+	msg, err := s.newMessage(k, jupyterclient.ChannelIOPub, parent, msgType, content)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	conns := make([]*channelConn, 0, len(k.conns))
+	for c := range k.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		_ = c.send(msg)
+	}
+}
+
+// reply sends a reply on the request's channel to the connection that sent the request.
+func (s *Server) reply(k *kernel, c *channelConn, request *jupyterclient.Message, msgType string, content any) {
+	channel := request.Channel
+	if channel == "" {
+		channel = jupyterclient.ChannelShell
+	}
+	msg, err := s.newMessage(k, channel, request, msgType, content)
+	if err != nil {
+		return
+	}
+	_ = c.send(msg)
+}
+
+func (s *Server) newMessage(k *kernel, channel string, parent *jupyterclient.Message, msgType string, content any) (*jupyterclient.Message, error) {
+	msg, err := jupyterclient.NewMessage(channel, msgType, k.session, content)
+	if err != nil {
+		return nil, err
+	}
+	msg.Header.Username = "kernel"
+	msg.ParentHeader = parent.Header
+	return msg, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, reason, message string) {
+	writeJSON(w, status, jupyterclient.ErrorResponse{Reason: reason, Message: message})
+}
@@ -0,0 +1,27 @@
+package jupyterclient
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// Gateway is the set of kernel operations the controller performs against Jupyter.
+// It is implemented by a single gateway Client and by a Pool of them.
+type Gateway interface {
+	GetKernelSpecs(ctx context.Context) (*GetKernelSpecsResponse, error)
+	StartKernel(ctx context.Context, language string, env map[string]string) (*Kernel, error)
+	GetKernels(ctx context.Context) (*[]Kernel, error)
+	GetKernelInfo(ctx context.Context, kernelID string) (*Kernel, error)
+	InterruptKernel(ctx context.Context, kernelID string) error
+	RestartKernel(ctx context.Context, kernelID string) (*Kernel, error)
+	DeleteKernel(ctx context.Context, kernelID string) error
+	DialKernelChannels(ctx context.Context, kernelID string) (*websocket.Conn, error)
+	ConnectKernel(ctx context.Context, kernelID string) (*KernelConnection, error)
+	Execute(ctx context.Context, kernelID string, code string, opts ExecuteOptions) (*ExecutionResult, error)
+}
+
+var (
+	_ Gateway = (*Client)(nil)
+	_ Gateway = (*Pool)(nil)
+)
@@ -0,0 +1,372 @@
+package jupyterclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// protocolVersion is the Jupyter messaging protocol version stamped on outgoing messages.
+const protocolVersion = "5.3"
+
+// Channel names used by the Jupyter websocket protocol.
+const (
+	ChannelShell   = "shell"
+	ChannelIOPub   = "iopub"
+	ChannelStdin   = "stdin"
+	ChannelControl = "control"
+)
+
+// ErrConnectionClosed is returned when the kernel websocket closes while a request is in flight.
+var ErrConnectionClosed = errors.New("kernel connection closed")
+
+// MarshalJSON encodes an empty header as {} so that request messages carry an empty parent_header.
+func (h Header) MarshalJSON() ([]byte, error) {
+	if h == (Header{}) {
+		return []byte("{}"), nil
+	}
+	type header Header
+	return json.Marshal(header(h))
+}
+
+// NewMessage builds a message for the given channel with a fresh header.
+func NewMessage(channel, msgType, session string, content any) (*Message, error) {
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s content: %w", msgType, err)
+	}
+
+	return &Message{
+		Channel: channel,
+		Header: Header{
+			MsgID:    uuid.NewString(),
+			MsgType:  msgType,
+			Username: "controller",
+			Session:  session,
+			Date:     time.Now().UTC(),
+			Version:  protocolVersion,
+		},
+		Metadata: json.RawMessage("{}"),
+		Content:  rawContent,
+	}, nil
+}
+
+// KernelChannelsURL returns the websocket URL of a kernel's channels endpoint on the gateway.
+func (c *Client) KernelChannelsURL(kernelID string) string {
+	wsURL := "ws" + strings.TrimPrefix(c.baseURL, "http")
+	return fmt.Sprintf("%s/api/kernels/%s/channels", wsURL, kernelID)
+}
+
+// DialKernelChannels opens a websocket to the channels endpoint of the given kernel, offering the v1
+// kernel websocket protocol. The protocol in use is the connection's Subprotocol().
+func (c *Client) DialKernelChannels(ctx context.Context, kernelID string) (*websocket.Conn, error) {
+	if kernelID == "" {
+		return nil, fmt.Errorf("kernel ID cannot be empty")
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "token "+c.token)
+
+	// Gateways that do not know the v1 protocol negotiate none and fall back to the legacy one.
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{ProtocolV1}
+	conn, _, err := dialer.DialContext(ctx, c.KernelChannelsURL(kernelID), headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial kernel channels: %w", err)
+	}
+	return conn, nil
+}
+
+// ExecuteOptions controls how code is run by KernelConnection.Execute.
+type ExecuteOptions struct {
+	Silent       bool
+	StoreHistory bool
+	StopOnError  bool
+	// Metadata is sent as the execute_request metadata, e.g. the cell_id being run.
+	Metadata map[string]any
+	// UserExpressions are evaluated after the code; their results come back in the execute_reply
+	// rather than on iopub, so other frontends of the kernel do not see them.
+	UserExpressions map[string]string
+	// OnMessage, if set, is called for every message belonging to the execution as it arrives.
+	OnMessage func(*Message)
+	// OnInput answers the kernel's input_requests, e.g. from input(). When it is nil the request is
+	// sent with allow_stdin false, so the kernel raises instead of waiting. An error from OnInput
+	// aborts Execute while the kernel is still blocked on input; callers should interrupt it.
+	OnInput func(req InputRequestContent) (string, error)
+}
+
+// ExecutionResult is everything the kernel published in response to one execute_request.
+type ExecutionResult struct {
+	MsgID          string
+	Status         string
+	ExecutionCount int
+	// Outputs holds the iopub output messages (stream, display_data, execute_result, error, ...) in arrival order.
+	Outputs []*Message
+	Error   *ErrorContent
+	// UserExpressions holds the results of ExecuteOptions.UserExpressions.
+	UserExpressions map[string]UserExpressionResult
+}
+
+// outputMsgTypes are the iopub message types collected into ExecutionResult.Outputs.
+var outputMsgTypes = map[string]struct{}{
+	"stream":              {},
+	"display_data":        {},
+	"update_display_data": {},
+	"execute_result":      {},
+	"error":               {},
+	"clear_output":        {},
+}
+
+// KernelConnection is a controller-owned websocket to a kernel that correlates replies by parent msg_id.
+type KernelConnection struct {
+	conn     *websocket.Conn
+	protocol string
+	session  string
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	waiters map[string]*waiter
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+type waiter struct {
+	ch   chan *Message
+	done chan struct{}
+}
+
+// ConnectKernel dials the kernel's channels and starts routing incoming messages to pending requests.
+func (c *Client) ConnectKernel(ctx context.Context, kernelID string) (*KernelConnection, error) {
+	conn, err := c.DialKernelChannels(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+
+	kc := &KernelConnection{
+		conn:     conn,
+		protocol: conn.Subprotocol(),
+		session:  uuid.NewString(),
+		waiters:  make(map[string]*waiter),
+		closed:   make(chan struct{}),
+	}
+	go kc.readLoop()
+	return kc, nil
+}
+
+// Session returns the Jupyter session id used in the headers of messages sent on this connection.
+func (kc *KernelConnection) Session() string {
+	return kc.session
+}
+
+// Close closes the underlying websocket.
+func (kc *KernelConnection) Close() error {
+	kc.shutdown(ErrConnectionClosed)
+	return kc.conn.Close()
+}
+
+// Err returns the reason the connection closed, or nil while it is open.
+func (kc *KernelConnection) Err() error {
+	select {
+	case <-kc.closed:
+		return kc.err
+	default:
+		return nil
+	}
+}
+
+// Send writes a single message to the kernel.
+func (kc *KernelConnection) Send(msg *Message) error {
+	messageType, data, err := EncodeMessage(kc.protocol, msg)
+	if err != nil {
+		return err
+	}
+
+	kc.writeMu.Lock()
+	defer kc.writeMu.Unlock()
+	if err := kc.conn.WriteMessage(messageType, data); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msg.Header.MsgType, err)
+	}
+	return nil
+}
+
+// Execute sends an execute_request and collects everything it produces until both the
+// execute_reply and the kernel's return to idle have been received.
+func (kc *KernelConnection) Execute(ctx context.Context, code string, opts ExecuteOptions) (*ExecutionResult, error) {
+	userExpressions := make(map[string]any, len(opts.UserExpressions))
+	for name, expr := range opts.UserExpressions {
+		userExpressions[name] = expr
+	}
+	msg, err := NewMessage(ChannelShell, "execute_request", kc.session, ExecuteRequestContent{
+		Code:            code,
+		Silent:          opts.Silent,
+		StoreHistory:    opts.StoreHistory,
+		UserExpressions: userExpressions,
+		AllowStdin:      opts.OnInput != nil,
+		StopOnError:     opts.StopOnError,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Metadata != nil {
+		if msg.Metadata, err = json.Marshal(opts.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to marshal execute_request metadata: %w", err)
+		}
+	}
+
+	w := kc.subscribe(msg.Header.MsgID)
+	defer kc.unsubscribe(msg.Header.MsgID)
+
+	if err := kc.Send(msg); err != nil {
+		return nil, err
+	}
+
+	result := &ExecutionResult{MsgID: msg.Header.MsgID}
+	var replied, idle bool
+	for !replied || !idle {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-kc.closed:
+			return result, fmt.Errorf("%w: %v", ErrConnectionClosed, kc.err)
+		case m := <-w.ch:
+			if opts.OnMessage != nil {
+				opts.OnMessage(m)
+			}
+			switch m.Header.MsgType {
+			case "input_request":
+				if opts.OnInput == nil {
+					continue
+				}
+				if err := kc.answerInput(m, opts.OnInput); err != nil {
+					return result, err
+				}
+			case "execute_reply":
+				var reply ExecuteReplyContent
+				if err := json.Unmarshal(m.Content, &reply); err != nil {
+					return result, fmt.Errorf("failed to decode execute_reply: %w", err)
+				}
+				result.Status = reply.Status
+				result.ExecutionCount = reply.ExecutionCount
+				result.UserExpressions = reply.UserExpressions
+				replied = true
+			case "status":
+				var status StatusContent
+				if err := json.Unmarshal(m.Content, &status); err == nil && status.ExecutionState == "idle" {
+					idle = true
+				}
+			default:
+				if _, ok := outputMsgTypes[m.Header.MsgType]; !ok {
+					continue
+				}
+				result.Outputs = append(result.Outputs, m)
+				if m.Header.MsgType == "error" {
+					var content ErrorContent
+					if err := json.Unmarshal(m.Content, &content); err == nil {
+						result.Error = &content
+					}
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// answerInput replies to an input_request with the value supplied by onInput.
+func (kc *KernelConnection) answerInput(req *Message, onInput func(InputRequestContent) (string, error)) error {
+	var content InputRequestContent
+	if err := json.Unmarshal(req.Content, &content); err != nil {
+		return fmt.Errorf("failed to decode input_request: %w", err)
+	}
+	value, err := onInput(content)
+	if err != nil {
+		return err
+	}
+
+	reply, err := NewMessage(ChannelStdin, "input_reply", kc.session, InputReplyContent{Value: value})
+	if err != nil {
+		return err
+	}
+	reply.ParentHeader = req.Header
+	return kc.Send(reply)
+}
+
+// Execute is a convenience wrapper that connects to the kernel, runs the code and disconnects.
+func (c *Client) Execute(ctx context.Context, kernelID string, code string, opts ExecuteOptions) (*ExecutionResult, error) {
+	kc, err := c.ConnectKernel(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	defer kc.Close()
+
+	return kc.Execute(ctx, code, opts)
+}
+
+func (kc *KernelConnection) subscribe(msgID string) *waiter {
+	w := &waiter{
+		ch:   make(chan *Message, 64),
+		done: make(chan struct{}),
+	}
+	kc.mu.Lock()
+	kc.waiters[msgID] = w
+	kc.mu.Unlock()
+	return w
+}
+
+func (kc *KernelConnection) unsubscribe(msgID string) {
+	kc.mu.Lock()
+	w, ok := kc.waiters[msgID]
+	delete(kc.waiters, msgID)
+	kc.mu.Unlock()
+	if ok {
+		close(w.done)
+	}
+}
+
+func (kc *KernelConnection) readLoop() {
+	for {
+		messageType, data, err := kc.conn.ReadMessage()
+		if err != nil {
+			kc.shutdown(err)
+			return
+		}
+		msg, err := DecodeMessage(kc.protocol, messageType, data)
+		if err != nil {
+			pkg.Logger.Warn().Err(err).Msg("failed to decode message from kernel")
+			continue
+		}
+
+		kc.mu.Lock()
+		w, ok := kc.waiters[msg.ParentHeader.MsgID]
+		kc.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		select {
+		case w.ch <- msg:
+		case <-w.done:
+		case <-kc.closed:
+			return
+		}
+	}
+}
+
+func (kc *KernelConnection) shutdown(err error) {
+	kc.closeOnce.Do(func() {
+		kc.err = err
+		close(kc.closed)
+	})
+}
@@ -11,17 +11,46 @@ import (
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
 )
 
-func (c *Client) StartKernel(ctx context.Context, language string) (*Kernel, error) {
-	// hardcoded for temporary reasons
-	knownKernels := map[string]bool{
-		"python3": true,
-		"tfenv":   true,
+// GetKernelSpecs retrieves the kernelspecs available on the Jupyter Gateway.
+func (c *Client) GetKernelSpecs(ctx context.Context) (*GetKernelSpecsResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/kernelspecs", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", c.token))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	if !knownKernels[language] {
-		return nil, fmt.Errorf("language '%s' is not a known kernel", language)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&errResp); err != nil {
+			return nil, fmt.Errorf("API returned non-200 status: %d, unable to parse error body: %w", res.StatusCode, err)
+		}
+		return nil, fmt.Errorf("API returned non-200 status: %d, reason: %s", res.StatusCode, errResp.Reason)
+	}
+
+	var specs GetKernelSpecsResponse
+	if err := json.NewDecoder(res.Body).Decode(&specs); err != nil {
+		return nil, fmt.Errorf("failed to decode kernelspecs response: %w", err)
+	}
+
+	return &specs, nil
+}
+
+// StartKernel starts a new kernel of the given kernelspec name on the gateway, with env added to
+// its environment (KERNEL_WORKING_DIR sets its working directory). env may be nil.
+// The name is not validated here; callers should check it against GetKernelSpecs.
+func (c *Client) StartKernel(ctx context.Context, language string, env map[string]string) (*Kernel, error) {
+	if language == "" {
+		return nil, fmt.Errorf("kernel name cannot be empty")
 	}
 
-	requestBody := StartKernelRequest{Name: language}
+	requestBody := StartKernelRequest{Name: language, Env: env}
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal start kernel request: %w", err)
@@ -17,11 +17,14 @@ type Header struct {
 }
 
 type Message struct {
+	Channel      string          `json:"channel,omitempty"`
 	Header       Header          `json:"header"`
 	ParentHeader Header          `json:"parent_header"`
 	Metadata     json.RawMessage `json:"metadata"`
 	Content      json.RawMessage `json:"content"`
-	Buffers      []json.RawMessage `json:"buffers"`
+	// Buffers are the binary parts of the message, e.g. widget image data. They never appear in its JSON
+	// form; EncodeMessage and DecodeMessage carry them in the binary framing of the websocket protocol.
+	Buffers [][]byte `json:"-"`
 }
 
 type StreamContent struct {
@@ -35,8 +38,20 @@ type DisplayDataContent struct {
 	Transient map[string]any `json:"transient"`
 }
 
+// DisplayID returns the display_id of a display_data or update_display_data message, if any.
+func (c DisplayDataContent) DisplayID() string {
+	displayID, _ := c.Transient["display_id"].(string)
+	return displayID
+}
+
+// ClearOutputContent is the content of a clear_output message. With Wait, the outputs are cleared
+// when the next output arrives rather than right away, which avoids flicker.
+type ClearOutputContent struct {
+	Wait bool `json:"wait"`
+}
+
 type ExecuteResultContent struct {
-	ExecutionCount int                    `json:"execution_count"`
+	ExecutionCount int            `json:"execution_count"`
 	Data           map[string]any `json:"data"`
 	Metadata       map[string]any `json:"metadata"`
 }
@@ -46,3 +61,86 @@ type ErrorContent struct {
 	Evalue    string   `json:"evalue"`
 	Traceback []string `json:"traceback"`
 }
+
+type ExecuteRequestContent struct {
+	Code            string         `json:"code"`
+	Silent          bool           `json:"silent"`
+	StoreHistory    bool           `json:"store_history"`
+	UserExpressions map[string]any `json:"user_expressions"`
+	AllowStdin      bool           `json:"allow_stdin"`
+	StopOnError     bool           `json:"stop_on_error"`
+}
+
+type ExecuteReplyContent struct {
+	Status         string   `json:"status"` // "ok", "error" or "aborted"
+	ExecutionCount int      `json:"execution_count"`
+	Ename          string   `json:"ename,omitempty"`
+	Evalue         string   `json:"evalue,omitempty"`
+	Traceback      []string `json:"traceback,omitempty"`
+	// UserExpressions maps each requested expression to its UserExpressionResult.
+	UserExpressions map[string]UserExpressionResult `json:"user_expressions,omitempty"`
+}
+
+// UserExpressionResult is the evaluation of one user expression of an execute_request.
+type UserExpressionResult struct {
+	Status   string         `json:"status"` // "ok" or "error"
+	Data     map[string]any `json:"data,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Ename    string         `json:"ename,omitempty"`
+	Evalue   string         `json:"evalue,omitempty"`
+}
+
+type StatusContent struct {
+	ExecutionState string `json:"execution_state"` // "busy", "idle" or "starting"
+}
+
+type CompleteRequestContent struct {
+	Code      string `json:"code"`
+	CursorPos int    `json:"cursor_pos"`
+}
+
+type CompleteReplyContent struct {
+	Status      string         `json:"status"` // "ok" or "error"
+	Matches     []string       `json:"matches"`
+	CursorStart int            `json:"cursor_start"`
+	CursorEnd   int            `json:"cursor_end"`
+	Metadata    map[string]any `json:"metadata"`
+	Ename       string         `json:"ename,omitempty"`
+	Evalue      string         `json:"evalue,omitempty"`
+}
+
+type InspectRequestContent struct {
+	Code        string `json:"code"`
+	CursorPos   int    `json:"cursor_pos"`
+	DetailLevel int    `json:"detail_level"` // 0 or 1
+}
+
+type InspectReplyContent struct {
+	Status   string         `json:"status"` // "ok" or "error"
+	Found    bool           `json:"found"`
+	Data     map[string]any `json:"data"`
+	Metadata map[string]any `json:"metadata"`
+	Ename    string         `json:"ename,omitempty"`
+	Evalue   string         `json:"evalue,omitempty"`
+}
+
+type InputRequestContent struct {
+	Prompt   string `json:"prompt"`
+	Password bool   `json:"password"`
+}
+
+type InputReplyContent struct {
+	Value string `json:"value"`
+}
+
+type CommOpenContent struct {
+	CommID     string          `json:"comm_id"`
+	TargetName string          `json:"target_name"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// CommMsgContent is the content of both comm_msg and comm_close.
+type CommMsgContent struct {
+	CommID string          `json:"comm_id"`
+	Data   json.RawMessage `json:"data"`
+}
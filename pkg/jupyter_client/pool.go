@@ -0,0 +1,558 @@
+package jupyterclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/gorilla/websocket"
+)
+
+// Placement strategies choosing the gateway a new kernel starts on.
+const (
+	// StrategyLeastKernels picks the gateway running the fewest kernels.
+	StrategyLeastKernels = "least_kernels"
+	// StrategyRoundRobin cycles through the gateways.
+	StrategyRoundRobin = "round_robin"
+	// StrategyTagged places a kernelspec on the gateways tagged with it, and other kernelspecs on the
+	// untagged gateways, picking the least loaded one.
+	StrategyTagged = "tagged"
+)
+
+// healthCheckTimeout bounds a single gateway health check.
+const healthCheckTimeout = 5 * time.Second
+
+var (
+	// ErrNoHealthyGateway is returned when no healthy gateway can run the requested kernelspec.
+	ErrNoHealthyGateway = errors.New("no healthy Jupyter gateway available for this kernelspec")
+	// ErrKernelNotFound is returned when no gateway of the pool knows the kernel.
+	ErrKernelNotFound = errors.New("kernel not found on any Jupyter gateway")
+)
+
+// GatewayConfig describes one gateway of a pool.
+type GatewayConfig struct {
+	// ID identifies the gateway in the persisted kernel placements; it defaults to the URL.
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Tags are the kernelspec names the gateway is reserved for under the tagged strategy.
+	Tags []string `json:"tags"`
+}
+
+// PoolConfig holds the gateways of a pool and how kernels are spread over them.
+type PoolConfig struct {
+	Gateways []GatewayConfig
+	Strategy string
+	// HealthInterval is the time between two health checks of every gateway.
+	HealthInterval time.Duration
+	// MaxFailures is how many consecutive failed health checks remove a gateway from placement.
+	MaxFailures int
+}
+
+// PoolConfigFromEnv loads the pool configuration from the environment. JUPYTER_GATEWAYS holds a JSON
+// list of gateways; without it the pool has the single gateway of JUPYTER_GATEWAY_URL and JUPYTER_AUTH_TOKEN.
+func PoolConfigFromEnv() (PoolConfig, error) {
+	healthIntervalSec, _ := strconv.Atoi(getEnvOrDefault("JUPYTER_GATEWAY_HEALTH_INTERVAL_SECONDS", "30"))
+	maxFailures, _ := strconv.Atoi(getEnvOrDefault("JUPYTER_GATEWAY_MAX_FAILURES", "3"))
+
+	config := PoolConfig{
+		Strategy:       getEnvOrDefault("JUPYTER_GATEWAY_STRATEGY", StrategyLeastKernels),
+		HealthInterval: time.Duration(healthIntervalSec) * time.Second,
+		MaxFailures:    maxFailures,
+	}
+
+	if raw := os.Getenv("JUPYTER_GATEWAYS"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &config.Gateways); err != nil {
+			return PoolConfig{}, fmt.Errorf("invalid JUPYTER_GATEWAYS: %w", err)
+		}
+	} else {
+		config.Gateways = []GatewayConfig{{
+			URL:   os.Getenv("JUPYTER_GATEWAY_URL"),
+			Token: os.Getenv("JUPYTER_AUTH_TOKEN"),
+		}}
+	}
+	return config, nil
+}
+
+// PlacementStore persists which gateway runs each kernel, so routing survives controller restarts.
+type PlacementStore interface {
+	SaveKernelGateway(ctx context.Context, kernelID string, gatewayID string) error
+	// GetKernelGateway returns an empty ID when the kernel's gateway is not known.
+	GetKernelGateway(ctx context.Context, kernelID string) (string, error)
+	DeleteKernelGateway(ctx context.Context, kernelID string) error
+}
+
+// GatewayStatus is the health and load of one gateway as last seen by the pool.
+type GatewayStatus struct {
+	ID                  string    `json:"id"`
+	URL                 string    `json:"url"`
+	Tags                []string  `json:"tags,omitempty"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Kernels             int       `json:"kernels"`
+	LastCheckedAt       time.Time `json:"last_checked_at"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// poolMember is one gateway of the pool. Everything but config and client is guarded by Pool.mu.
+type poolMember struct {
+	config GatewayConfig
+	client *Client
+
+	healthy       bool
+	failures      int
+	kernels       int
+	specs         *GetKernelSpecsResponse
+	lastCheckedAt time.Time
+	lastError     string
+}
+
+func (m *poolMember) offers(kernelName string) bool {
+	if m.specs == nil {
+		// Not checked yet; let the gateway itself refuse an unknown kernelspec.
+		return true
+	}
+	_, ok := m.specs.KernelSpecs[kernelName]
+	return ok
+}
+
+// Pool spreads kernels over several Jupyter gateways and routes every kernel operation to the
+// gateway running that kernel. Gateways failing their health checks stop receiving new kernels.
+type Pool struct {
+	members []*poolMember
+	byID    map[string]*poolMember
+	config  PoolConfig
+	store   PlacementStore
+
+	mu         sync.RWMutex
+	placements map[string]*poolMember
+	next       int
+}
+
+// NewPool creates a Pool of the configured gateways. store may be nil, in which case placements are
+// only kept in memory and rediscovered by asking every gateway.
+func NewPool(config PoolConfig, store PlacementStore) (*Pool, error) {
+	if len(config.Gateways) == 0 {
+		return nil, errors.New("at least one Jupyter gateway must be configured")
+	}
+	switch config.Strategy {
+	case StrategyLeastKernels, StrategyRoundRobin, StrategyTagged:
+	case "":
+		config.Strategy = StrategyLeastKernels
+	default:
+		return nil, fmt.Errorf("unknown gateway placement strategy %q", config.Strategy)
+	}
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = 30 * time.Second
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = 3
+	}
+
+	p := &Pool{
+		byID:       make(map[string]*poolMember, len(config.Gateways)),
+		config:     config,
+		store:      store,
+		placements: make(map[string]*poolMember),
+	}
+	for _, gw := range config.Gateways {
+		client, err := NewClient(gw.URL, gw.Token)
+		if err != nil {
+			return nil, fmt.Errorf("gateway %q: %w", gw.URL, err)
+		}
+		if gw.ID == "" {
+			gw.ID = gw.URL
+		}
+		if _, dup := p.byID[gw.ID]; dup {
+			return nil, fmt.Errorf("duplicate gateway id %q", gw.ID)
+		}
+		m := &poolMember{config: gw, client: client, healthy: true}
+		p.members = append(p.members, m)
+		p.byID[gw.ID] = m
+	}
+	return p, nil
+}
+
+// Start checks every gateway once, then keeps checking them in the background until ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	p.checkHealth(ctx)
+
+	go func() {
+		ticker := time.NewTicker(p.config.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkHealth(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Status returns the state of every gateway of the pool.
+func (p *Pool) Status() []GatewayStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]GatewayStatus, 0, len(p.members))
+	for _, m := range p.members {
+		statuses = append(statuses, GatewayStatus{
+			ID:                  m.config.ID,
+			URL:                 m.config.URL,
+			Tags:                m.config.Tags,
+			Healthy:             m.healthy,
+			ConsecutiveFailures: m.failures,
+			Kernels:             m.kernels,
+			LastCheckedAt:       m.lastCheckedAt,
+			LastError:           m.lastError,
+		})
+	}
+	return statuses
+}
+
+// checkHealth probes all gateways concurrently and updates their health, load and kernelspecs.
+func (p *Pool) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, m := range p.members {
+		wg.Add(1)
+		go func(m *poolMember) {
+			defer wg.Done()
+			p.checkMember(ctx, m)
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) checkMember(ctx context.Context, m *poolMember) {
+	logger := pkg.Logger
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	kernels, err := m.client.GetKernels(ctx)
+	var specs *GetKernelSpecsResponse
+	if err == nil {
+		specs, err = m.client.GetKernelSpecs(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m.lastCheckedAt = time.Now().UTC()
+
+	if err != nil {
+		m.failures++
+		m.lastError = err.Error()
+		if m.healthy && m.failures >= p.config.MaxFailures {
+			m.healthy = false
+			logger.Error().Err(err).Str("gateway", m.config.ID).Int("failures", m.failures).
+				Msg("[GATEWAY POOL]: Gateway failed its health checks, removing it from placement")
+		}
+		return
+	}
+
+	if !m.healthy {
+		logger.Info().Str("gateway", m.config.ID).Msg("[GATEWAY POOL]: Gateway is healthy again, restoring it to placement")
+	}
+	m.healthy = true
+	m.failures = 0
+	m.lastError = ""
+	m.kernels = len(*kernels)
+	m.specs = specs
+	for _, k := range *kernels {
+		p.placements[k.ID] = m
+	}
+}
+
+// candidates returns the healthy gateways that may run the kernelspec, most preferred first.
+func (p *Pool) candidates(kernelName string) []*poolMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var eligible []*poolMember
+	for _, m := range p.members {
+		if m.healthy && m.offers(kernelName) {
+			eligible = append(eligible, m)
+		}
+	}
+
+	switch p.config.Strategy {
+	case StrategyRoundRobin:
+		if len(eligible) == 0 {
+			return nil
+		}
+		start := p.next % len(eligible)
+		p.next++
+		ordered := make([]*poolMember, 0, len(eligible))
+		ordered = append(ordered, eligible[start:]...)
+		return append(ordered, eligible[:start]...)
+	case StrategyTagged:
+		var tagged, untagged []*poolMember
+		for _, m := range eligible {
+			switch {
+			case slices.Contains(m.config.Tags, kernelName):
+				tagged = append(tagged, m)
+			case len(m.config.Tags) == 0:
+				untagged = append(untagged, m)
+			}
+		}
+		eligible = tagged
+		if len(eligible) == 0 {
+			eligible = untagged
+		}
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].kernels < eligible[j].kernels
+	})
+	return eligible
+}
+
+// remember records that a kernel runs on a gateway, persisting it when the kernel is new.
+func (p *Pool) remember(ctx context.Context, kernelID string, m *poolMember, started bool) {
+	p.mu.Lock()
+	p.placements[kernelID] = m
+	if started {
+		m.kernels++
+	}
+	p.mu.Unlock()
+
+	if p.store == nil {
+		return
+	}
+	if err := p.store.SaveKernelGateway(ctx, kernelID, m.config.ID); err != nil {
+		pkg.Logger.Error().Err(err).Str("kernel_id", kernelID).Str("gateway", m.config.ID).
+			Msg("[GATEWAY POOL]: Failed to persist kernel placement")
+	}
+}
+
+// forget drops the placement of a deleted kernel.
+func (p *Pool) forget(ctx context.Context, kernelID string) {
+	p.mu.Lock()
+	if m, ok := p.placements[kernelID]; ok {
+		delete(p.placements, kernelID)
+		if m.kernels > 0 {
+			m.kernels--
+		}
+	}
+	p.mu.Unlock()
+
+	if p.store == nil {
+		return
+	}
+	if err := p.store.DeleteKernelGateway(ctx, kernelID); err != nil {
+		pkg.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("[GATEWAY POOL]: Failed to delete kernel placement")
+	}
+}
+
+// route returns the gateway running the kernel, looking in memory, then in the store, then asking every gateway.
+func (p *Pool) route(ctx context.Context, kernelID string) (*poolMember, error) {
+	if len(p.members) == 1 {
+		return p.members[0], nil
+	}
+
+	p.mu.RLock()
+	m, ok := p.placements[kernelID]
+	p.mu.RUnlock()
+	if ok {
+		return m, nil
+	}
+
+	if p.store != nil {
+		gatewayID, err := p.store.GetKernelGateway(ctx, kernelID)
+		if err != nil {
+			pkg.Logger.Error().Err(err).Str("kernel_id", kernelID).Msg("[GATEWAY POOL]: Failed to load kernel placement")
+		} else if m, ok := p.byID[gatewayID]; ok {
+			p.mu.Lock()
+			p.placements[kernelID] = m
+			p.mu.Unlock()
+			return m, nil
+		}
+	}
+
+	for _, m := range p.members {
+		if _, err := m.client.GetKernelInfo(ctx, kernelID); err == nil {
+			p.remember(ctx, kernelID, m, false)
+			return m, nil
+		}
+	}
+	return nil, ErrKernelNotFound
+}
+
+// GetKernelSpecs returns the kernelspecs offered by the healthy gateways. The default kernelspec is
+// the one of the first gateway that answers.
+func (p *Pool) GetKernelSpecs(ctx context.Context) (*GetKernelSpecsResponse, error) {
+	merged := &GetKernelSpecsResponse{KernelSpecs: make(map[string]KernelSpecEntry)}
+	var lastErr error
+	answered := false
+	for _, m := range p.healthyMembers() {
+		specs, err := m.client.GetKernelSpecs(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered = true
+		if merged.Default == "" {
+			merged.Default = specs.Default
+		}
+		for name, spec := range specs.KernelSpecs {
+			if _, ok := merged.KernelSpecs[name]; !ok {
+				merged.KernelSpecs[name] = spec
+			}
+		}
+	}
+	if !answered {
+		if lastErr == nil {
+			lastErr = ErrNoHealthyGateway
+		}
+		return nil, lastErr
+	}
+	return merged, nil
+}
+
+// StartKernel starts the kernel on the gateway chosen by the placement strategy, falling back to
+// the next candidate when a gateway refuses it.
+func (p *Pool) StartKernel(ctx context.Context, language string, env map[string]string) (*Kernel, error) {
+	candidates := p.candidates(language)
+	if len(candidates) == 0 {
+		return nil, ErrNoHealthyGateway
+	}
+
+	var lastErr error
+	for _, m := range candidates {
+		kernel, err := m.client.StartKernel(ctx, language, env)
+		if err != nil {
+			pkg.Logger.Warn().Err(err).Str("gateway", m.config.ID).Msg("[GATEWAY POOL]: Failed to start kernel on gateway")
+			lastErr = err
+			continue
+		}
+		p.remember(ctx, kernel.ID, m, true)
+		return kernel, nil
+	}
+	return nil, lastErr
+}
+
+// GetKernels lists the kernels of every healthy gateway. A gateway that fails to answer is skipped
+// unless none answers.
+func (p *Pool) GetKernels(ctx context.Context) (*[]Kernel, error) {
+	all := []Kernel{}
+	var lastErr error
+	answered := false
+	for _, m := range p.healthyMembers() {
+		kernels, err := m.client.GetKernels(ctx)
+		if err != nil {
+			pkg.Logger.Warn().Err(err).Str("gateway", m.config.ID).Msg("[GATEWAY POOL]: Failed to list kernels on gateway")
+			lastErr = err
+			continue
+		}
+		answered = true
+
+		p.mu.Lock()
+		m.kernels = len(*kernels)
+		for _, k := range *kernels {
+			p.placements[k.ID] = m
+		}
+		p.mu.Unlock()
+		all = append(all, *kernels...)
+	}
+	if !answered && lastErr != nil {
+		return nil, lastErr
+	}
+	return &all, nil
+}
+
+// GetKernelInfo returns the kernel as reported by the gateway running it.
+func (p *Pool) GetKernelInfo(ctx context.Context, kernelID string) (*Kernel, error) {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client.GetKernelInfo(ctx, kernelID)
+}
+
+// InterruptKernel interrupts the kernel on the gateway running it.
+func (p *Pool) InterruptKernel(ctx context.Context, kernelID string) error {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return err
+	}
+	return m.client.InterruptKernel(ctx, kernelID)
+}
+
+// RestartKernel restarts the kernel on the gateway running it.
+func (p *Pool) RestartKernel(ctx context.Context, kernelID string) (*Kernel, error) {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client.RestartKernel(ctx, kernelID)
+}
+
+// DeleteKernel deletes the kernel on the gateway running it and forgets its placement.
+func (p *Pool) DeleteKernel(ctx context.Context, kernelID string) error {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return err
+	}
+	if err := m.client.DeleteKernel(ctx, kernelID); err != nil {
+		return err
+	}
+	p.forget(ctx, kernelID)
+	return nil
+}
+
+// DialKernelChannels opens a websocket to the kernel's channels on the gateway running it.
+func (p *Pool) DialKernelChannels(ctx context.Context, kernelID string) (*websocket.Conn, error) {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client.DialKernelChannels(ctx, kernelID)
+}
+
+// ConnectKernel opens a controller-owned connection to the kernel on the gateway running it.
+func (p *Pool) ConnectKernel(ctx context.Context, kernelID string) (*KernelConnection, error) {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client.ConnectKernel(ctx, kernelID)
+}
+
+// Execute runs code on the kernel through the gateway running it.
+func (p *Pool) Execute(ctx context.Context, kernelID string, code string, opts ExecuteOptions) (*ExecutionResult, error) {
+	m, err := p.route(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client.Execute(ctx, kernelID, code, opts)
+}
+
+func (p *Pool) healthyMembers() []*poolMember {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	healthy := make([]*poolMember, 0, len(p.members))
+	for _, m := range p.members {
+		if m.healthy {
+			healthy = append(healthy, m)
+		}
+	}
+	return healthy
+}
+
+func getEnvOrDefault(key, def string) string {
+	if val, exists := os.LookupEnv(key); exists && val != "" {
+		return val
+	}
+	return def
+}
@@ -0,0 +1,296 @@
+package jupyterclient_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client/fakegateway"
+	"github.com/rs/zerolog"
+)
+
+// silenceLogger is set once, as the health checks of a pool may outlive a test.
+var silenceLogger sync.Once
+
+// memoryPlacementStore keeps kernel placements in memory and counts the lookups.
+type memoryPlacementStore struct {
+	mu         sync.Mutex
+	placements map[string]string
+	lookups    int
+}
+
+func (s *memoryPlacementStore) SaveKernelGateway(ctx context.Context, kernelID string, gatewayID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.placements[kernelID] = gatewayID
+	return nil
+}
+
+func (s *memoryPlacementStore) GetKernelGateway(ctx context.Context, kernelID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	return s.placements[kernelID], nil
+}
+
+func (s *memoryPlacementStore) DeleteKernelGateway(ctx context.Context, kernelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.placements, kernelID)
+	return nil
+}
+
+// testGateways starts a fake gateway per ID.
+func testGateways(t *testing.T, ids ...string) map[string]*fakegateway.Server {
+	t.Helper()
+	logger := zerolog.Nop()
+	silenceLogger.Do(func() { pkg.Logger = &logger })
+
+	gateways := make(map[string]*fakegateway.Server, len(ids))
+	for _, id := range ids {
+		gateway := fakegateway.New("")
+		t.Cleanup(gateway.Close)
+		gateways[id] = gateway
+	}
+	return gateways
+}
+
+// gatewayConfig describes the fake gateway as a member of a pool.
+func gatewayConfig(id string, gateway *fakegateway.Server, tags ...string) jupyterclient.GatewayConfig {
+	return jupyterclient.GatewayConfig{ID: id, URL: gateway.URL, Token: gateway.Token, Tags: tags}
+}
+
+// startPool creates the pool and runs its health checks until the test ends.
+func startPool(t *testing.T, config jupyterclient.PoolConfig, store jupyterclient.PlacementStore) *jupyterclient.Pool {
+	t.Helper()
+	pool, err := jupyterclient.NewPool(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	pool.Start(ctx)
+	return pool
+}
+
+// placedOn returns the ID of the fake gateway running the kernel.
+func placedOn(gateways map[string]*fakegateway.Server, kernelID string) string {
+	for id, gateway := range gateways {
+		if _, ok := gateway.Kernel(kernelID); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// gatewayStatus returns the pool's status of a gateway.
+func gatewayStatus(pool *jupyterclient.Pool, id string) jupyterclient.GatewayStatus {
+	for _, status := range pool.Status() {
+		if status.ID == id {
+			return status
+		}
+	}
+	return jupyterclient.GatewayStatus{}
+}
+
+func TestPoolPlacement(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		strategy string
+		// tags are the tags of gateways a, b and c; c is unavailable.
+		tags    map[string][]string
+		kernels []string
+		want    []string
+	}{
+		{
+			// a already runs two kernels and b one; b catches up before they alternate.
+			name:     "least kernels",
+			strategy: jupyterclient.StrategyLeastKernels,
+			kernels:  []string{"python3", "python3", "python3"},
+			want:     []string{"b", "a", "b"},
+		},
+		{
+			name:     "round robin",
+			strategy: jupyterclient.StrategyRoundRobin,
+			kernels:  []string{"python3", "python3", "python3", "python3"},
+			want:     []string{"a", "b", "a", "b"},
+		},
+		{
+			// Kernelspecs go to their tagged gateways however loaded they are, untagged ones to the
+			// untagged gateways, and a kernelspec whose tagged gateways are all down falls back to
+			// the untagged ones.
+			name:     "tagged",
+			strategy: jupyterclient.StrategyTagged,
+			tags:     map[string][]string{"a": {"ir"}, "c": {"julia"}},
+			kernels:  []string{"ir", "python3", "ir", "julia"},
+			want:     []string{"a", "b", "a", "b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gateways := testGateways(t, "a", "b", "c")
+			for _, gateway := range gateways {
+				gateway.AddKernelSpec("ir", "R")
+				gateway.AddKernelSpec("julia", "julia")
+			}
+			gateways["a"].StartKernel("python3")
+			gateways["a"].StartKernel("python3")
+			gateways["b"].StartKernel("python3")
+			gateways["c"].SetAvailable(false)
+
+			config := jupyterclient.PoolConfig{Strategy: tc.strategy, HealthInterval: time.Hour, MaxFailures: 1}
+			for _, id := range []string{"a", "b", "c"} {
+				config.Gateways = append(config.Gateways, gatewayConfig(id, gateways[id], tc.tags[id]...))
+			}
+			pool := startPool(t, config, nil)
+			if gatewayStatus(pool, "c").Healthy {
+				t.Fatal("the unavailable gateway is still healthy after failing its health check")
+			}
+
+			for i, name := range tc.kernels {
+				kernel, err := pool.StartKernel(context.Background(), name, nil)
+				if err != nil {
+					t.Fatalf("starting kernel %d (%s): %v", i, name, err)
+				}
+				if got := placedOn(gateways, kernel.ID); got != tc.want[i] {
+					t.Errorf("kernel %d (%s) started on gateway %q, want %q", i, name, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPoolPlacementWithoutHealthyGateway(t *testing.T) {
+	gateways := testGateways(t, "a", "b")
+	gateways["a"].AddKernelSpec("ir", "R")
+	gateways["a"].SetAvailable(false)
+	pool := startPool(t, jupyterclient.PoolConfig{
+		Gateways:       []jupyterclient.GatewayConfig{gatewayConfig("a", gateways["a"], "ir"), gatewayConfig("b", gateways["b"], "python3")},
+		Strategy:       jupyterclient.StrategyTagged,
+		HealthInterval: time.Hour,
+		MaxFailures:    1,
+	}, nil)
+
+	// b only offers python3 and is reserved for it, so nothing can run ir.
+	if _, err := pool.StartKernel(context.Background(), "ir", nil); !errors.Is(err, jupyterclient.ErrNoHealthyGateway) {
+		t.Errorf("StartKernel = %v, want ErrNoHealthyGateway", err)
+	}
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	gateways := testGateways(t, "a", "b")
+	gateways["a"].StartKernel("python3")
+	gateways["a"].StartKernel("python3")
+	gateways["b"].SetAvailable(false)
+	pool := startPool(t, jupyterclient.PoolConfig{
+		Gateways:       []jupyterclient.GatewayConfig{gatewayConfig("a", gateways["a"]), gatewayConfig("b", gateways["b"])},
+		HealthInterval: 50 * time.Millisecond,
+		MaxFailures:    3,
+	}, nil)
+
+	waitForStatus := func(done func(status jupyterclient.GatewayStatus) bool) jupyterclient.GatewayStatus {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status := gatewayStatus(pool, "b")
+			if done(status) || time.Now().After(deadline) {
+				return status
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// A single failure does not remove the gateway; MaxFailures consecutive ones do.
+	if status := gatewayStatus(pool, "b"); !status.Healthy || status.ConsecutiveFailures == 0 || status.LastError == "" {
+		t.Errorf("status after the first failed check = %+v, want still healthy with the failure and its error", status)
+	}
+	status := waitForStatus(func(status jupyterclient.GatewayStatus) bool { return !status.Healthy })
+	if status.Healthy || status.ConsecutiveFailures < 3 {
+		t.Fatalf("status = %+v, want b removed after 3 failed checks", status)
+	}
+	kernel, err := pool.StartKernel(context.Background(), "python3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := placedOn(gateways, kernel.ID); got != "a" {
+		t.Errorf("kernel started on %q while b is unhealthy, want a", got)
+	}
+
+	// A passing check restores the gateway, which is then preferred as the least loaded one.
+	gateways["b"].SetAvailable(true)
+	status = waitForStatus(func(status jupyterclient.GatewayStatus) bool { return status.Healthy })
+	if !status.Healthy || status.ConsecutiveFailures != 0 || status.LastError != "" {
+		t.Fatalf("status = %+v, want b restored with its failures reset", status)
+	}
+	kernel, err = pool.StartKernel(context.Background(), "python3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := placedOn(gateways, kernel.ID); got != "b" {
+		t.Errorf("kernel started on %q after b recovered, want b", got)
+	}
+}
+
+func TestPoolRouting(t *testing.T) {
+	gateways := testGateways(t, "down", "a", "b")
+	gateways["down"].SetAvailable(false)
+	config := jupyterclient.PoolConfig{
+		Gateways: []jupyterclient.GatewayConfig{
+			gatewayConfig("down", gateways["down"]), gatewayConfig("a", gateways["a"]), gatewayConfig("b", gateways["b"]),
+		},
+		Strategy: jupyterclient.StrategyRoundRobin,
+	}
+	store := &memoryPlacementStore{placements: make(map[string]string)}
+	ctx := context.Background()
+
+	// Kernels started through the pool are remembered and persisted.
+	first, err := jupyterclient.NewPool(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round robin begins with the unavailable gateway, which refuses the kernel, so it starts on a.
+	started, err := first.StartKernel(ctx, "python3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.placements[started.ID] != "a" || placedOn(gateways, started.ID) != "a" {
+		t.Fatalf("kernel placed on %q and stored as on %q, want a", placedOn(gateways, started.ID), store.placements[started.ID])
+	}
+	if _, err := first.GetKernelInfo(ctx, started.ID); err != nil {
+		t.Errorf("GetKernelInfo from memory: %v", err)
+	}
+	if store.lookups != 0 {
+		t.Errorf("the store was read %d times for a kernel placed in memory", store.lookups)
+	}
+
+	// A new pool, e.g. after a restart, reads the placement from the store once.
+	second, err := jupyterclient.NewPool(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := second.GetKernelInfo(ctx, started.ID); err != nil {
+			t.Fatalf("GetKernelInfo from the store: %v", err)
+		}
+	}
+	if store.lookups != 1 {
+		t.Errorf("the store was read %d times, want once", store.lookups)
+	}
+
+	// A kernel the store does not know is found by asking every gateway, then persisted.
+	unknown := gateways["b"].StartKernel("python3")
+	info, err := second.GetKernelInfo(ctx, unknown.ID)
+	if err != nil {
+		t.Fatalf("GetKernelInfo by probing: %v", err)
+	}
+	if info.ID != unknown.ID || store.placements[unknown.ID] != "b" {
+		t.Errorf("probed kernel %s placed on %q, want %s on b", info.ID, store.placements[unknown.ID], unknown.ID)
+	}
+
+	if _, err := second.GetKernelInfo(ctx, "missing"); !errors.Is(err, jupyterclient.ErrKernelNotFound) {
+		t.Errorf("GetKernelInfo of a missing kernel = %v, want ErrKernelNotFound", err)
+	}
+}
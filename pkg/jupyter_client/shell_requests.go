@@ -0,0 +1,61 @@
+package jupyterclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Request sends a shell request and decodes the content of its matching reply into reply.
+// Only the <type>_reply on the shell channel completes the request; iopub traffic it causes is ignored.
+func (kc *KernelConnection) Request(ctx context.Context, msgType string, content any, reply any) error {
+	msg, err := NewMessage(ChannelShell, msgType, kc.session, content)
+	if err != nil {
+		return err
+	}
+	replyType := strings.TrimSuffix(msgType, "_request") + "_reply"
+
+	w := kc.subscribe(msg.Header.MsgID)
+	defer kc.unsubscribe(msg.Header.MsgID)
+
+	if err := kc.Send(msg); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-kc.closed:
+			return fmt.Errorf("%w: %v", ErrConnectionClosed, kc.err)
+		case m := <-w.ch:
+			if m.Header.MsgType != replyType {
+				continue
+			}
+			if err := json.Unmarshal(m.Content, reply); err != nil {
+				return fmt.Errorf("failed to decode %s: %w", replyType, err)
+			}
+			return nil
+		}
+	}
+}
+
+// Complete asks the kernel for the completions of the code at the cursor position.
+func (kc *KernelConnection) Complete(ctx context.Context, code string, cursorPos int) (*CompleteReplyContent, error) {
+	var reply CompleteReplyContent
+	if err := kc.Request(ctx, "complete_request", CompleteRequestContent{Code: code, CursorPos: cursorPos}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Inspect asks the kernel for information about the object at the cursor position.
+func (kc *KernelConnection) Inspect(ctx context.Context, code string, cursorPos int, detailLevel int) (*InspectReplyContent, error) {
+	var reply InspectReplyContent
+	content := InspectRequestContent{Code: code, CursorPos: cursorPos, DetailLevel: detailLevel}
+	if err := kc.Request(ctx, "inspect_request", content, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
@@ -33,8 +33,13 @@ type HelpLink struct {
 	URL  string `json:"url"`
 }
 
+// KernelEnvPrefix is the prefix of the environment variables the gateway passes on to new kernels.
+const KernelEnvPrefix = "KERNEL_"
+
 type StartKernelRequest struct {
 	Name string `json:"name"`
+	// Env holds environment variables for the kernel; the gateway drops names without KernelEnvPrefix.
+	Env map[string]string `json:"env,omitempty"`
 }
 
 type Kernel struct {
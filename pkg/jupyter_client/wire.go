@@ -0,0 +1,220 @@
+package jupyterclient
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Websocket protocols a kernel channels connection can speak, as negotiated through Sec-WebSocket-Protocol.
+const (
+	// ProtocolLegacy is used when no subprotocol was negotiated: messages are JSON text frames, and only
+	// messages carrying buffers are sent as binary frames with the JSON message as their first part.
+	ProtocolLegacy = ""
+	// ProtocolV1 sends every message as a binary frame holding the channel, the four JSON parts of the
+	// message and its buffers, so buffers never go through JSON.
+	ProtocolV1 = "v1.kernel.websocket.jupyter.org"
+)
+
+// ErrMalformedFrame is returned when a binary websocket frame does not follow the framing of its protocol.
+var ErrMalformedFrame = errors.New("malformed kernel websocket frame")
+
+// wireMessage is the JSON form of a message in the legacy protocol, where buffers travel outside of it.
+type wireMessage struct {
+	Channel      string          `json:"channel,omitempty"`
+	Header       Header          `json:"header"`
+	ParentHeader Header          `json:"parent_header"`
+	Metadata     json.RawMessage `json:"metadata"`
+	Content      json.RawMessage `json:"content"`
+	Buffers      []struct{}      `json:"buffers"`
+}
+
+// EncodeMessage encodes a message as a websocket frame of the given protocol and returns the frame type
+// and payload.
+func EncodeMessage(protocol string, msg *Message) (int, []byte, error) {
+	if protocol == ProtocolV1 {
+		data, err := encodeV1(msg)
+		return websocket.BinaryMessage, data, err
+	}
+
+	data, err := json.Marshal(wireMessage{
+		Channel:      msg.Channel,
+		Header:       msg.Header,
+		ParentHeader: msg.ParentHeader,
+		Metadata:     orEmptyObject(msg.Metadata),
+		Content:      orEmptyObject(msg.Content),
+		Buffers:      []struct{}{},
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to marshal %s: %w", msg.Header.MsgType, err)
+	}
+	if len(msg.Buffers) == 0 {
+		return websocket.TextMessage, data, nil
+	}
+	return websocket.BinaryMessage, encodeLegacyBinary(append([][]byte{data}, msg.Buffers...)), nil
+}
+
+// DecodeMessage decodes a websocket frame received on a connection of the given protocol. Text frames
+// are JSON in both protocols.
+func DecodeMessage(protocol string, messageType int, data []byte) (*Message, error) {
+	if messageType == websocket.TextMessage {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+	if messageType != websocket.BinaryMessage {
+		return nil, ErrMalformedFrame
+	}
+
+	if protocol == ProtocolV1 {
+		return decodeV1(data)
+	}
+	parts, err := decodeLegacyBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := json.Unmarshal(parts[0], &msg); err != nil {
+		return nil, err
+	}
+	msg.Buffers = parts[1:]
+	return &msg, nil
+}
+
+// encodeV1 lays out a frame as: the number of offsets n, n offsets (all little-endian uint64), then
+// the channel, header, parent_header, metadata, content and buffers, each starting at its offset.
+func encodeV1(msg *Message) ([]byte, error) {
+	header, err := json.Marshal(msg.Header)
+	if err != nil {
+		return nil, err
+	}
+	parentHeader, err := json.Marshal(msg.ParentHeader)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([][]byte{
+		[]byte(msg.Channel),
+		header,
+		parentHeader,
+		orEmptyObject(msg.Metadata),
+		orEmptyObject(msg.Content),
+	}, msg.Buffers...)
+
+	count := len(parts) + 1
+	offset := 8 * (count + 1)
+	size := offset
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	data := make([]byte, 8*(count+1), size)
+	binary.LittleEndian.PutUint64(data, uint64(count))
+	for i, part := range parts {
+		binary.LittleEndian.PutUint64(data[8*(i+1):], uint64(offset))
+		offset += len(part)
+	}
+	binary.LittleEndian.PutUint64(data[8*count:], uint64(offset))
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data, nil
+}
+
+func decodeV1(data []byte) (*Message, error) {
+	if len(data) < 8 {
+		return nil, ErrMalformedFrame
+	}
+	count := binary.LittleEndian.Uint64(data)
+	// The channel and the four JSON parts need six offsets; each offset takes eight bytes.
+	if count < 6 || count > uint64(len(data)/8-1) {
+		return nil, ErrMalformedFrame
+	}
+	offsets := make([]int, count)
+	for i := range offsets {
+		offsets[i] = int(binary.LittleEndian.Uint64(data[8*(i+1):]))
+		if offsets[i] > len(data) || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, ErrMalformedFrame
+		}
+	}
+	if offsets[0] < 8*(int(count)+1) {
+		return nil, ErrMalformedFrame
+	}
+	part := func(i int) []byte { return data[offsets[i]:offsets[i+1]] }
+
+	msg := &Message{
+		Channel:  string(part(0)),
+		Metadata: json.RawMessage(part(3)),
+		Content:  json.RawMessage(part(4)),
+	}
+	if err := json.Unmarshal(part(1), &msg.Header); err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	if err := json.Unmarshal(part(2), &msg.ParentHeader); err != nil {
+		return nil, fmt.Errorf("failed to decode parent_header: %w", err)
+	}
+	for i := 5; i < len(offsets)-1; i++ {
+		msg.Buffers = append(msg.Buffers, part(i))
+	}
+	return msg, nil
+}
+
+// encodeLegacyBinary lays out a frame as: the number of parts n, n offsets (all big-endian uint32), then
+// the parts, each starting at its offset and running to the next one.
+func encodeLegacyBinary(parts [][]byte) []byte {
+	offset := 4 * (len(parts) + 1)
+	size := offset
+	for _, part := range parts {
+		size += len(part)
+	}
+
+	data := make([]byte, offset, size)
+	binary.BigEndian.PutUint32(data, uint32(len(parts)))
+	for i, part := range parts {
+		binary.BigEndian.PutUint32(data[4*(i+1):], uint32(offset))
+		offset += len(part)
+	}
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func decodeLegacyBinary(data []byte) ([][]byte, error) {
+	if len(data) < 4 {
+		return nil, ErrMalformedFrame
+	}
+	count := binary.BigEndian.Uint32(data)
+	if count == 0 || count > uint32(len(data)/4-1) {
+		return nil, ErrMalformedFrame
+	}
+	offsets := make([]int, count+1)
+	for i := 0; i < int(count); i++ {
+		offsets[i] = int(binary.BigEndian.Uint32(data[4*(i+1):]))
+		if offsets[i] > len(data) || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, ErrMalformedFrame
+		}
+	}
+	if offsets[0] < 4*(int(count)+1) {
+		return nil, ErrMalformedFrame
+	}
+	offsets[count] = len(data)
+
+	parts := make([][]byte, count)
+	for i := range parts {
+		parts[i] = data[offsets[i]:offsets[i+1]]
+	}
+	return parts, nil
+}
+
+// orEmptyObject returns {} for a missing JSON part, which the protocol requires to be an object.
+func orEmptyObject(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
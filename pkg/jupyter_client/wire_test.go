@@ -0,0 +1,151 @@
+package jupyterclient_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/gorilla/websocket"
+)
+
+// serializeV1 builds a frame the way jupyter_server's serialize_msg_to_ws_v1 does: the number of
+// offsets, the offsets of the channel and of each part followed by the end of the frame (all
+// little-endian uint64), then the channel and the parts.
+func serializeV1(channel string, parts ...[]byte) []byte {
+	offsets := []uint64{uint64(8 * (1 + 1 + len(parts) + 1))}
+	offsets = append(offsets, offsets[len(offsets)-1]+uint64(len(channel)))
+	for _, part := range parts {
+		offsets = append(offsets, offsets[len(offsets)-1]+uint64(len(part)))
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint64(len(offsets)))
+	_ = binary.Write(&b, binary.LittleEndian, offsets)
+	b.WriteString(channel)
+	for _, part := range parts {
+		b.Write(part)
+	}
+	return b.Bytes()
+}
+
+// serializeLegacyBinary builds a frame the way jupyter_server's serialize_binary_message does: the
+// number of parts and the offset of each (all big-endian uint32), then the JSON message and the buffers.
+func serializeLegacyBinary(parts ...[]byte) []byte {
+	offsets := []uint32{uint32(len(parts)), uint32(4 * (len(parts) + 1))}
+	for _, part := range parts[:len(parts)-1] {
+		offsets = append(offsets, offsets[len(offsets)-1]+uint32(len(part)))
+	}
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.BigEndian, offsets)
+	for _, part := range parts {
+		b.Write(part)
+	}
+	return b.Bytes()
+}
+
+// putOffset overwrites the i-th offset of a v1 frame, or its count when i is -1.
+func putOffset(frame []byte, i int, offset uint64) []byte {
+	frame = bytes.Clone(frame)
+	binary.LittleEndian.PutUint64(frame[8*(i+1):], offset)
+	return frame
+}
+
+const (
+	testHeader       = `{"msg_id":"m1","session":"s1","username":"u","date":"2024-05-01T12:00:00.123456Z","msg_type":"comm_msg","version":"5.3"}`
+	testParentHeader = `{"msg_id":"p1","session":"s1","username":"u","date":"2024-05-01T11:59:59.5Z","msg_type":"execute_request","version":"5.3"}`
+	testContent      = `{"comm_id":"c1","data":{"method":"update"}}`
+)
+
+func TestDecodeV1Frame(t *testing.T) {
+	frame := serializeV1("iopub", []byte(testHeader), []byte(testParentHeader), []byte(`{}`), []byte(testContent),
+		[]byte{0, 1, 2}, []byte{})
+
+	msg, err := jupyterclient.DecodeMessage(jupyterclient.ProtocolV1, websocket.BinaryMessage, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Channel != "iopub" || msg.Header.MsgID != "m1" || msg.Header.MsgType != "comm_msg" || msg.ParentHeader.MsgID != "p1" {
+		t.Errorf("decoded %s %s (%s) in reply to %s, want iopub m1 (comm_msg) in reply to p1",
+			msg.Channel, msg.Header.MsgID, msg.Header.MsgType, msg.ParentHeader.MsgID)
+	}
+	if string(msg.Metadata) != `{}` || string(msg.Content) != testContent {
+		t.Errorf("metadata = %s, content = %s", msg.Metadata, msg.Content)
+	}
+	if len(msg.Buffers) != 2 || !bytes.Equal(msg.Buffers[0], []byte{0, 1, 2}) || len(msg.Buffers[1]) != 0 {
+		t.Errorf("buffers = %v, want [0 1 2] and an empty one", msg.Buffers)
+	}
+
+	// Encoding the message again lays it out exactly as jupyter_server does.
+	header, _ := json.Marshal(msg.Header)
+	parentHeader, _ := json.Marshal(msg.ParentHeader)
+	want := serializeV1("iopub", header, parentHeader, []byte(`{}`), []byte(testContent), []byte{0, 1, 2}, []byte{})
+	messageType, encoded, err := jupyterclient.EncodeMessage(jupyterclient.ProtocolV1, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType != websocket.BinaryMessage || !bytes.Equal(encoded, want) {
+		t.Errorf("encoded frame (type %d):\n%q\nwant:\n%q", messageType, encoded, want)
+	}
+}
+
+func TestDecodeLegacyBinaryFrame(t *testing.T) {
+	message := `{"channel":"shell","header":` + testHeader + `,"parent_header":` + testParentHeader +
+		`,"metadata":{},"content":` + testContent + `,"buffers":[]}`
+	frame := serializeLegacyBinary([]byte(message), []byte("buffer"))
+
+	msg, err := jupyterclient.DecodeMessage(jupyterclient.ProtocolLegacy, websocket.BinaryMessage, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Channel != "shell" || msg.Header.MsgID != "m1" || string(msg.Content) != testContent {
+		t.Errorf("decoded %s %s with content %s", msg.Channel, msg.Header.MsgID, msg.Content)
+	}
+	if len(msg.Buffers) != 1 || string(msg.Buffers[0]) != "buffer" {
+		t.Errorf("buffers = %q, want [buffer]", msg.Buffers)
+	}
+
+	// Messages with buffers are encoded in the same layout.
+	messageType, encoded, err := jupyterclient.EncodeMessage(jupyterclient.ProtocolLegacy, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jupyterclient.DecodeMessage(jupyterclient.ProtocolLegacy, messageType, encoded)
+	if err != nil || messageType != websocket.BinaryMessage || len(decoded.Buffers) != 1 || string(decoded.Buffers[0]) != "buffer" {
+		t.Errorf("re-encoded frame (type %d) decodes to %+v, %v", messageType, decoded, err)
+	}
+}
+
+func TestDecodeMalformedFrames(t *testing.T) {
+	v1 := serializeV1("shell", []byte(testHeader), []byte(testParentHeader), []byte(`{}`), []byte(`{}`))
+	// Five offsets, one short of the channel and four parts.
+	tooFewParts := serializeV1("shell", []byte(testHeader), []byte(testParentHeader), []byte(`{}`))
+	legacy := serializeLegacyBinary([]byte(`{}`), []byte("a"), []byte("b"))
+
+	for _, tc := range []struct {
+		name     string
+		protocol string
+		frame    []byte
+	}{
+		{"v1 shorter than its count", jupyterclient.ProtocolV1, []byte{6, 0, 0}},
+		{"v1 count below 6", jupyterclient.ProtocolV1, tooFewParts},
+		{"v1 count larger than the frame", jupyterclient.ProtocolV1, putOffset(v1, -1, 1000)},
+		{"v1 offsets decreasing", jupyterclient.ProtocolV1, putOffset(v1, 2, 60)},
+		{"v1 offset past the end", jupyterclient.ProtocolV1, putOffset(v1, 5, uint64(len(v1)+1))},
+		{"v1 offset inside the offsets", jupyterclient.ProtocolV1, putOffset(v1, 0, 8)},
+		{"legacy shorter than its count", jupyterclient.ProtocolLegacy, []byte{0, 0}},
+		{"legacy without parts", jupyterclient.ProtocolLegacy, []byte{0, 0, 0, 0}},
+		{"legacy offsets decreasing", jupyterclient.ProtocolLegacy, append(bytes.Clone(legacy[:8]), append([]byte{0, 0, 0, 1}, legacy[12:]...)...)},
+		{"legacy offset past the end", jupyterclient.ProtocolLegacy, append(bytes.Clone(legacy[:12]), append([]byte{0, 0, 1, 0}, legacy[16:]...)...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := jupyterclient.DecodeMessage(tc.protocol, websocket.BinaryMessage, tc.frame)
+			if !errors.Is(err, jupyterclient.ErrMalformedFrame) {
+				t.Errorf("DecodeMessage = %v, want ErrMalformedFrame", err)
+			}
+		})
+	}
+}
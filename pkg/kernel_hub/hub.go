@@ -0,0 +1,670 @@
+// Package kernelhub shares one upstream gateway websocket per kernel between every frontend
+// attached to that kernel.
+package kernelhub
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+)
+
+// clientSendBuffer is how many frames may queue for a frontend before it is considered too slow and dropped.
+const clientSendBuffer = 256
+
+// Observer is notified once per message passing through a hub, however many frontends are attached.
+// Implementations run on the hub's read loops, before the message is passed on, so time spent in
+// them holds up the kernel's messages; an observer may block briefly to apply backpressure.
+type Observer interface {
+	FromClient(kernelID string, msg *jupyterclient.Message)
+	FromKernel(kernelID string, msg *jupyterclient.Message)
+}
+
+// KernelObserver is an Observer that keeps state per kernel. KernelGone is called once a hub stops
+// reading the kernel's messages, because the kernel died or its last frontend left, so requests
+// still pending will not be seen to finish.
+type KernelObserver interface {
+	Observer
+	KernelGone(kernelID string)
+}
+
+// MsgTypeKernelDied is the iopub message type the hub broadcasts to frontends when their kernel dies.
+// It is not part of the Jupyter protocol; its content is a KernelDiedContent.
+const MsgTypeKernelDied = "kernel_died"
+
+// KernelDiedContent is the content of a kernel_died message.
+type KernelDiedContent struct {
+	KernelID string `json:"kernel_id"`
+	// AutoRestarted is true when the gateway already restarted the kernel, so it keeps its ID but lost its state.
+	AutoRestarted bool `json:"auto_restarted"`
+}
+
+// Message types the hub broadcasts to frontends while the kernel waits on stdin. Like kernel_died
+// they are not part of the Jupyter protocol.
+const (
+	// MsgTypeInputRequested announces a pending input_request; its content is an InputRequestedContent.
+	MsgTypeInputRequested = "input_requested"
+	// MsgTypeInputResolved withdraws a pending input_request; its content is an InputResolvedContent.
+	MsgTypeInputResolved = "input_resolved"
+)
+
+// InputRequestedContent is the content of an input_requested message. Any attached frontend may
+// answer it with an input_reply whose parent_header is Request's header.
+type InputRequestedContent struct {
+	InputMsgID  string                 `json:"input_msg_id"`
+	ParentMsgID string                 `json:"parent_msg_id"`
+	Prompt      string                 `json:"prompt"`
+	Password    bool                   `json:"password"`
+	Request     *jupyterclient.Message `json:"request"`
+}
+
+// InputResolvedContent is the content of an input_resolved message.
+type InputResolvedContent struct {
+	InputMsgID string `json:"input_msg_id"`
+	// Reason is "answered" when a frontend replied, or "cancelled" when the execution ended without a reply.
+	Reason string `json:"reason"`
+}
+
+// DeathHandler is called when a kernel with attached frontends dies.
+type DeathHandler func(kernelID string, autoRestarted bool)
+
+// Manager owns the hubs of all kernels that currently have frontends attached.
+type Manager struct {
+	client   jupyterclient.Gateway
+	observer Observer
+	logger   zerolog.Logger
+	onDeath  DeathHandler
+
+	mu   sync.Mutex
+	hubs map[string]*Hub
+}
+
+// NewManager creates and returns a new Manager. observer may be nil.
+func NewManager(client jupyterclient.Gateway, observer Observer, logger zerolog.Logger) *Manager {
+	return &Manager{
+		client:   client,
+		observer: observer,
+		logger:   logger,
+		hubs:     make(map[string]*Hub),
+	}
+}
+
+// OnKernelDeath registers the handler called when a hub detects that its kernel died.
+// It must be set before the manager serves any frontend.
+func (m *Manager) OnKernelDeath(handler DeathHandler) {
+	m.onDeath = handler
+}
+
+// Serve attaches a frontend websocket to the kernel's hub, dialing the gateway if it is the first
+// frontend, and blocks until the frontend or the upstream connection goes away.
+func (m *Manager) Serve(ctx context.Context, kernelID string, conn *websocket.Conn) error {
+	h, err := m.acquire(ctx, kernelID)
+	if err != nil {
+		return err
+	}
+	defer m.release(h)
+
+	c := h.join(conn)
+	defer h.leave(c)
+
+	go c.writeLoop()
+	h.readClient(c)
+	return nil
+}
+
+// acquire returns the running hub for the kernel, creating it and dialing the gateway when needed.
+func (m *Manager) acquire(ctx context.Context, kernelID string) (*Hub, error) {
+	m.mu.Lock()
+	h, ok := m.hubs[kernelID]
+	if ok && h.isClosed() {
+		ok = false
+	}
+	if !ok {
+		h = newHub(m, kernelID)
+		m.hubs[kernelID] = h
+	}
+	h.refs++
+	m.mu.Unlock()
+
+	if !ok {
+		upstream, err := m.client.DialKernelChannels(ctx, kernelID)
+		h.upstream, h.err = upstream, err
+		if err == nil {
+			h.protocol = upstream.Subprotocol()
+		}
+		close(h.ready)
+		if err == nil {
+			m.logger.Info().Str("kernel_id", kernelID).Str("protocol", h.protocol).Msg("kernel hub connected to gateway")
+			go h.readUpstream()
+		}
+	}
+
+	select {
+	case <-h.ready:
+	case <-ctx.Done():
+		m.release(h)
+		return nil, ctx.Err()
+	}
+	if h.err != nil {
+		m.release(h)
+		return nil, h.err
+	}
+	return h, nil
+}
+
+// release drops a reference to the hub and closes it once the last frontend has left.
+func (m *Manager) release(h *Hub) {
+	m.mu.Lock()
+	h.refs--
+	last := h.refs == 0
+	if last && m.hubs[h.kernelID] == h {
+		delete(m.hubs, h.kernelID)
+	}
+	m.mu.Unlock()
+
+	if last {
+		h.shutdown()
+	}
+}
+
+// Hub multiplexes the frontends of one kernel over a single upstream connection.
+type Hub struct {
+	manager  *Manager
+	kernelID string
+	observer Observer
+	logger   zerolog.Logger
+
+	// refs is guarded by Manager.mu.
+	refs int
+
+	ready    chan struct{}
+	err      error
+	upstream *websocket.Conn
+	// protocol is the websocket protocol negotiated with the gateway.
+	protocol string
+	writeMu  sync.Mutex
+
+	mu       sync.Mutex
+	clients  map[*client]struct{}
+	sessions map[string]*client
+	// inputs are the input_requests the kernel is blocked on, by input_request msg_id.
+	inputs map[string]*InputRequestedContent
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newHub(manager *Manager, kernelID string) *Hub {
+	return &Hub{
+		manager:  manager,
+		kernelID: kernelID,
+		observer: manager.observer,
+		logger:   manager.logger.With().Str("kernel_id", kernelID).Logger(),
+		ready:    make(chan struct{}),
+		clients:  make(map[*client]struct{}),
+		sessions: make(map[string]*client),
+		inputs:   make(map[string]*InputRequestedContent),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (h *Hub) isClosed() bool {
+	select {
+	case <-h.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Hub) join(conn *websocket.Conn) *client {
+	c := newClient(conn, h.logger)
+
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	count := len(h.clients)
+	// A frontend attaching while the kernel waits for input, e.g. a reloaded tab, can answer it too.
+	keepingUp := true
+	for _, input := range h.inputs {
+		if f, err := eventFrame(MsgTypeInputRequested, input); err == nil {
+			keepingUp = c.enqueue(f) && keepingUp
+		}
+	}
+	h.mu.Unlock()
+
+	if h.isClosed() || !keepingUp {
+		c.close()
+	}
+	h.logger.Info().Int("clients", count).Msg("frontend attached to kernel hub")
+	return c
+}
+
+func (h *Hub) leave(c *client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	for session, owner := range h.sessions {
+		if owner == c {
+			delete(h.sessions, session)
+		}
+	}
+	count := len(h.clients)
+	h.mu.Unlock()
+
+	c.close()
+	h.logger.Info().Int("clients", count).Msg("frontend detached from kernel hub")
+}
+
+// readClient forwards a frontend's messages upstream, remembering which Jupyter sessions it speaks for.
+func (h *Hub) readClient(c *client) {
+	for {
+		messageType, p, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) && !c.isClosed() {
+				h.logger.Warn().Err(err).Msg("error reading from frontend, detaching")
+			}
+			return
+		}
+		f := frame{protocol: c.protocol, messageType: messageType, data: p}
+
+		if msg, err := jupyterclient.DecodeMessage(c.protocol, messageType, p); err == nil {
+			f.msg = msg
+			if msg.Header.Session != "" {
+				h.mu.Lock()
+				h.sessions[msg.Header.Session] = c
+				h.mu.Unlock()
+			}
+			if msg.Channel == jupyterclient.ChannelStdin && msg.Header.MsgType == "input_reply" {
+				h.resolveInput(msg.ParentHeader.MsgID, "answered")
+			}
+			if h.observer != nil {
+				h.observer.FromClient(h.kernelID, msg)
+			}
+		}
+
+		// The frontend and the gateway may have negotiated different protocols.
+		messageType, p, err = f.encode(h.protocol)
+		if err != nil {
+			h.logger.Warn().Err(err).Msg("failed to re-encode frontend message for the kernel gateway, dropping it")
+			continue
+		}
+		h.logger.Trace().Str("direction", "FE->KG").Int("size", len(p)).Msg("proxied message")
+		h.writeMu.Lock()
+		err = h.upstream.WriteMessage(messageType, p)
+		h.writeMu.Unlock()
+		if err != nil {
+			h.logger.Warn().Err(err).Msg("error writing to kernel gateway, detaching")
+			return
+		}
+	}
+}
+
+// readUpstream delivers kernel messages: iopub is broadcast to every frontend, while replies on
+// the other channels only go to the frontend whose session sent the request.
+func (h *Hub) readUpstream() {
+	defer func() {
+		h.shutdown()
+		if observer, ok := h.observer.(KernelObserver); ok {
+			observer.KernelGone(h.kernelID)
+		}
+	}()
+
+	deadSeen := false
+	for {
+		messageType, p, err := h.upstream.ReadMessage()
+		if err != nil {
+			if h.isClosed() {
+				return
+			}
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.logger.Warn().Err(err).Msg("error reading from kernel gateway, closing hub")
+			}
+			if !deadSeen && h.kernelIsDead() {
+				h.kernelDied(false)
+			}
+			return
+		}
+		f := frame{protocol: h.protocol, messageType: messageType, data: p}
+
+		msg, err := jupyterclient.DecodeMessage(h.protocol, messageType, p)
+		if err != nil {
+			h.broadcast(f)
+			continue
+		}
+		f.msg = msg
+		if h.observer != nil {
+			h.observer.FromKernel(h.kernelID, msg)
+		}
+		if msg.Channel == jupyterclient.ChannelIOPub && msg.Header.MsgType == "status" {
+			var status jupyterclient.StatusContent
+			if err := json.Unmarshal(msg.Content, &status); err == nil {
+				switch status.ExecutionState {
+				case "idle":
+					// The execution is over, so any input it asked for will never be read.
+					h.cancelInputs(msg.ParentHeader.MsgID)
+				case "restarting":
+					// The gateway's restarter brought the kernel back after a crash; its state is gone.
+					h.broadcast(f)
+					h.kernelDied(true)
+					continue
+				case "dead":
+					deadSeen = true
+					h.broadcast(f)
+					h.kernelDied(false)
+					continue
+				}
+			}
+		}
+
+		if msg.Channel == jupyterclient.ChannelStdin && msg.Header.MsgType == "input_request" {
+			h.requestInput(msg)
+		}
+
+		if msg.Channel == "" || msg.Channel == jupyterclient.ChannelIOPub || msg.ParentHeader.Session == "" {
+			h.broadcast(f)
+			continue
+		}
+
+		h.mu.Lock()
+		requester, ok := h.sessions[msg.ParentHeader.Session]
+		h.mu.Unlock()
+		if !ok {
+			h.logger.Debug().
+				Str("channel", msg.Channel).
+				Str("msg_type", msg.Header.MsgType).
+				Msg("dropping reply for a frontend that is no longer attached")
+			continue
+		}
+		if !requester.enqueue(f) {
+			requester.close()
+		}
+	}
+}
+
+// kernelIsDead asks the gateway whether the kernel is dead after its connection dropped.
+// A kernel that no longer exists was shut down on purpose and is not reported as dead.
+func (h *Hub) kernelIsDead() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := h.manager.client.GetKernelInfo(ctx, h.kernelID)
+	if err != nil {
+		h.logger.Debug().Err(err).Msg("could not get kernel info after the connection dropped")
+		return false
+	}
+	return info.ExecutionState == "dead"
+}
+
+// kernelDied tells every frontend that the kernel died and hands the kernel to the death handler.
+func (h *Hub) kernelDied(autoRestarted bool) {
+	h.logger.Warn().Bool("auto_restarted", autoRestarted).Msg("kernel died")
+
+	h.mu.Lock()
+	h.inputs = make(map[string]*InputRequestedContent)
+	h.mu.Unlock()
+	h.broadcastEvent(MsgTypeKernelDied, KernelDiedContent{
+		KernelID:      h.kernelID,
+		AutoRestarted: autoRestarted,
+	})
+
+	if h.manager.onDeath != nil {
+		go h.manager.onDeath(h.kernelID, autoRestarted)
+	}
+}
+
+// requestInput records an input_request the kernel is now blocked on and announces it to every frontend.
+func (h *Hub) requestInput(msg *jupyterclient.Message) {
+	var content jupyterclient.InputRequestContent
+	if err := json.Unmarshal(msg.Content, &content); err != nil {
+		h.logger.Warn().Err(err).Msg("failed to decode input_request")
+		return
+	}
+	input := &InputRequestedContent{
+		InputMsgID:  msg.Header.MsgID,
+		ParentMsgID: msg.ParentHeader.MsgID,
+		Prompt:      content.Prompt,
+		Password:    content.Password,
+		Request:     msg,
+	}
+
+	h.mu.Lock()
+	h.inputs[input.InputMsgID] = input
+	h.mu.Unlock()
+	h.logger.Debug().Str("input_msg_id", input.InputMsgID).Msg("kernel is waiting for input")
+	h.broadcastEvent(MsgTypeInputRequested, input)
+}
+
+// resolveInput forgets a pending input_request and tells the frontends it no longer needs an answer.
+func (h *Hub) resolveInput(inputMsgID string, reason string) {
+	h.mu.Lock()
+	_, ok := h.inputs[inputMsgID]
+	delete(h.inputs, inputMsgID)
+	h.mu.Unlock()
+	if ok {
+		h.broadcastEvent(MsgTypeInputResolved, InputResolvedContent{InputMsgID: inputMsgID, Reason: reason})
+	}
+}
+
+// cancelInputs resolves the input_requests left unanswered by a finished execution.
+func (h *Hub) cancelInputs(parentMsgID string) {
+	h.mu.Lock()
+	var cancelled []string
+	for id, input := range h.inputs {
+		if input.ParentMsgID == parentMsgID {
+			cancelled = append(cancelled, id)
+		}
+	}
+	h.mu.Unlock()
+	for _, id := range cancelled {
+		h.resolveInput(id, "cancelled")
+	}
+}
+
+// broadcastEvent sends a controller-generated iopub message to every frontend.
+func (h *Hub) broadcastEvent(msgType string, content any) {
+	f, err := eventFrame(msgType, content)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("msg_type", msgType).Msg("failed to build hub event")
+		return
+	}
+	h.broadcast(f)
+}
+
+// eventFrame builds the frame of a controller-generated iopub message. It is encoded for each
+// frontend as it is written.
+func eventFrame(msgType string, content any) (frame, error) {
+	msg, err := jupyterclient.NewMessage(jupyterclient.ChannelIOPub, msgType, "", content)
+	if err != nil {
+		return frame{}, err
+	}
+	return frame{msg: msg}, nil
+}
+
+// broadcast queues a frame for every frontend. Frontends that are not keeping up are closed once
+// h.mu is released, as closing writes to their socket; their read loops then detach them.
+func (h *Hub) broadcast(f frame) {
+	var slow []*client
+	h.mu.Lock()
+	for c := range h.clients {
+		if !c.enqueue(f) {
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range slow {
+		c.close()
+	}
+}
+
+// shutdown closes the upstream connection and disconnects every frontend.
+func (h *Hub) shutdown() {
+	h.closeOnce.Do(func() {
+		close(h.closed)
+		if h.upstream != nil {
+			h.upstream.Close()
+		}
+
+		h.mu.Lock()
+		for c := range h.clients {
+			c.drain()
+		}
+		h.mu.Unlock()
+		h.logger.Info().Msg("kernel hub closed")
+	})
+}
+
+// frame is one message passing through the hub, as received in the protocol of the connection it came from.
+type frame struct {
+	// msg is the decoded message, or nil if the frame could not be decoded; it is then passed on as is.
+	msg         *jupyterclient.Message
+	protocol    string
+	messageType int
+	data        []byte
+}
+
+// encode returns the frame in the given protocol, re-encoding it only when the protocols differ.
+func (f frame) encode(protocol string) (int, []byte, error) {
+	if f.msg == nil || (f.data != nil && f.protocol == protocol) {
+		return f.messageType, f.data, nil
+	}
+	return jupyterclient.EncodeMessage(protocol, f.msg)
+}
+
+// client is one attached frontend. Frames are queued and written by a dedicated goroutine so
+// a slow browser cannot stall the others.
+type client struct {
+	conn *websocket.Conn
+	// protocol is the websocket protocol negotiated with the frontend.
+	protocol string
+	logger   zerolog.Logger
+
+	send      chan frame
+	done      chan struct{}
+	closeOnce sync.Once
+	// draining asks the writer to flush what is queued and then close.
+	draining  chan struct{}
+	drainOnce sync.Once
+}
+
+func newClient(conn *websocket.Conn, logger zerolog.Logger) *client {
+	return &client{
+		conn:     conn,
+		protocol: conn.Subprotocol(),
+		logger:   logger,
+		send:     make(chan frame, clientSendBuffer),
+		done:     make(chan struct{}),
+		draining: make(chan struct{}),
+	}
+}
+
+// enqueue queues a frame for the writer without blocking. It reports false when the frontend's
+// buffer is full; the caller must then close it, without holding the hub's lock.
+func (c *client) enqueue(f frame) bool {
+	select {
+	case c.send <- f:
+	case <-c.done:
+	default:
+		c.logger.Warn().Msg("frontend is not keeping up with kernel messages, disconnecting it")
+		return false
+	}
+	return true
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case f := <-c.send:
+			if err := c.write(f); err != nil {
+				c.logger.Warn().Err(err).Msg("error writing to frontend, detaching")
+				c.close()
+				return
+			}
+		case <-c.draining:
+			for {
+				select {
+				case f := <-c.send:
+					if err := c.write(f); err != nil {
+						c.close()
+						return
+					}
+				default:
+					c.close()
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write sends a frame to the frontend in the protocol it negotiated. Frames that cannot be encoded
+// for it are skipped.
+func (c *client) write(f frame) error {
+	messageType, data, err := f.encode(c.protocol)
+	if err != nil {
+		c.logger.Warn().Err(err).Msg("failed to encode kernel message for frontend, dropping it")
+		return nil
+	}
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	c.logger.Trace().Str("direction", "KG->FE").Int("size", len(data)).Msg("proxied message")
+	return nil
+}
+
+// drain closes the client once the frames already queued for it have been written.
+func (c *client) drain() {
+	c.drainOnce.Do(func() {
+		close(c.draining)
+	})
+}
+
+func (c *client) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the writer and closes the socket, which also ends the frontend's read loop.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "kernel connection closed")
+		_ = c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		c.conn.Close()
+	})
+}
+
+// Observers fans a hub's messages out to several observers, in order.
+type Observers []Observer
+
+// FromClient implements Observer.
+func (o Observers) FromClient(kernelID string, msg *jupyterclient.Message) {
+	for _, observer := range o {
+		observer.FromClient(kernelID, msg)
+	}
+}
+
+// FromKernel implements Observer.
+func (o Observers) FromKernel(kernelID string, msg *jupyterclient.Message) {
+	for _, observer := range o {
+		observer.FromKernel(kernelID, msg)
+	}
+}
+
+// KernelGone implements KernelObserver, forwarding to the observers that keep state per kernel.
+func (o Observers) KernelGone(kernelID string) {
+	for _, observer := range o {
+		if observer, ok := observer.(KernelObserver); ok {
+			observer.KernelGone(kernelID)
+		}
+	}
+}
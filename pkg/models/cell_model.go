@@ -9,15 +9,38 @@ import (
 
 // Cell represents a single cell within a notebook.
 type Cell struct {
-	ID             StringUUID     `json:"id"`
-	NotebookID     uuid.UUID      `json:"notebook_id"`
-	CellIndex      int            `json:"cell_index"`
-	CellName       sql.NullString `json:"cell_name"`
-	CellType       string         `json:"cell_type"`
-	Source         string         `json:"source"`
-	ExecutionCount int            `json:"execution_count"`
-	Outputs        []CellOutput   `json:"outputs,omitempty"`
-	EvolutionRuns  []EvolutionRun `json:"evolution_runs,omitempty"`
+	ID             StringUUID      `json:"id"`
+	NotebookID     uuid.UUID       `json:"notebook_id"`
+	CellIndex      int             `json:"cell_index"`
+	CellName       sql.NullString  `json:"cell_name"`
+	CellType       string          `json:"cell_type"`
+	Source         string          `json:"source"`
+	ExecutionCount int             `json:"execution_count"`
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
+	Outputs        []CellOutput    `json:"outputs,omitempty"`
+	EvolutionRuns  []EvolutionRun  `json:"evolution_runs,omitempty"`
+}
+
+// CellTagParameters marks the cell whose variables are overridden by parameterized executions.
+const CellTagParameters = "parameters"
+
+// HasTag reports whether the cell's metadata lists the given tag under "tags".
+func (c *Cell) HasTag(tag string) bool {
+	if len(c.Metadata) == 0 {
+		return false
+	}
+	var metadata struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(c.Metadata, &metadata); err != nil {
+		return false
+	}
+	for _, t := range metadata.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // CellOutput represents the output of a cell execution.
@@ -29,32 +52,40 @@ type CellOutput struct {
 	DataJSON       json.RawMessage `json:"data_json"`
 	MinioURL       string          `json:"minio_url"`
 	ExecutionCount int             `json:"execution_count"`
+	// BufferKeys are the blob store keys of the binary buffers of the output message.
+	BufferKeys []string `json:"buffer_keys,omitempty"`
+	// DisplayID is the display_id of a display_data output, which update_display_data messages target.
+	DisplayID string `json:"display_id,omitempty"`
+	// Metadata is the metadata of a display_data or execute_result output, such as image sizes.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
 // CreateCellRequest defines the structure for a request to create a new cell.
 type CreateCellRequest struct {
-	NotebookID uuid.UUID `json:"notebook_id" binding:"required"`
-	CellIndex  int       `json:"cell_index" binding:"required"`
-	CellName   string    `json:"cell_name"`
-	CellType   string    `json:"cell_type" binding:"required"`
-	Source     string    `json:"source"`
+	NotebookID uuid.UUID       `json:"notebook_id" binding:"required"`
+	CellIndex  int             `json:"cell_index" binding:"required"`
+	CellName   string          `json:"cell_name"`
+	CellType   string          `json:"cell_type" binding:"required"`
+	Source     string          `json:"source"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
 }
 
 // UpdateCellRequest defines the structure for a request to update a cell.
 type UpdateCellRequest struct {
-	CellIndex      *int    `json:"cell_index,omitempty"`
-	CellName       *string `json:"cell_name,omitempty"`
-	CellType       *string `json:"cell_type,omitempty"`
-	Source         *string `json:"source,omitempty"`
-	ExecutionCount *int    `json:"execution_count,omitempty"`
+	CellIndex      *int            `json:"cell_index,omitempty"`
+	CellName       *string         `json:"cell_name,omitempty"`
+	CellType       *string         `json:"cell_type,omitempty"`
+	Source         *string         `json:"source,omitempty"`
+	ExecutionCount *int            `json:"execution_count,omitempty"`
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
 }
 
 // UpdateCellsRequest defines the. structure for a bulk cell update request.
 type UpdateCellsRequest struct {
-	UpdatedOrder  []StringUUID                  `json:"updated_order"`
-	CellsToDelete []StringUUID                  `json:"cells_to_delete"`
+	UpdatedOrder  []StringUUID                 `json:"updated_order"`
+	CellsToDelete []StringUUID                 `json:"cells_to_delete"`
 	CellsToUpsert map[string]CellDataForUpsert `json:"cells_to_upsert"`
-	Requirements  *string                       `json:"requirements,omitempty"`
+	Requirements  *string                      `json:"requirements,omitempty"`
 }
 
 // CellDataForUpsert represents the data for a cell to be upserted.
@@ -73,4 +104,4 @@ type CreateCellOutputRequest struct {
 	Type        string          `json:"type"`
 	DataJSON    json.RawMessage `json:"data_json"`
 	MinioURL    string          `json:"minio_url"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Statuses of a recorded execution. A request is queued until the kernel reports busy for it,
+// and takes the status of its execute_reply when it finishes.
+const (
+	ExecutionStatusQueued  = "queued"
+	ExecutionStatusRunning = "running"
+	ExecutionStatusOK      = "ok"
+	ExecutionStatusError   = "error"
+	ExecutionStatusAborted = "aborted"
+)
+
+// Execution is the history record of one execute_request sent for a cell.
+type Execution struct {
+	ID             uuid.UUID  `json:"id"`
+	NotebookID     uuid.UUID  `json:"notebook_id"`
+	CellID         *uuid.UUID `json:"cell_id,omitempty"`
+	SessionID      *uuid.UUID `json:"session_id,omitempty"`
+	KernelID       uuid.UUID  `json:"kernel_id"`
+	MsgID          string     `json:"msg_id"`
+	Source         string     `json:"source"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	Status         string     `json:"status"`
+	ExecutionCount *int       `json:"execution_count,omitempty"`
+}
+
+// ExecutionMessage is one entry of an execution's message log: the execute_request, or a kernel
+// message sent in reply to it, in the order the controller saw them.
+type ExecutionMessage struct {
+	ExecutionID uuid.UUID       `json:"execution_id"`
+	Seq         int             `json:"seq"`
+	Channel     string          `json:"channel"`
+	MsgType     string          `json:"msg_type"`
+	Content     json.RawMessage `json:"content,omitempty"`
+	ReceivedAt  time.Time       `json:"received_at"`
+}
+
+// CellExecutionResult is the outcome of running a single cell on a session's kernel.
+type CellExecutionResult struct {
+	CellID         StringUUID   `json:"cell_id"`
+	SessionID      uuid.UUID    `json:"session_id"`
+	KernelID       uuid.UUID    `json:"kernel_id"`
+	MsgID          string       `json:"msg_id"`
+	Status         string       `json:"status"`
+	ExecutionCount int          `json:"execution_count"`
+	Outputs        []CellOutput `json:"outputs"`
+}
+
+// ExecuteCellRequest is the optional body of a cell execution request.
+type ExecuteCellRequest struct {
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Inputs answer the cell's input() calls in order. Asking for more input than queued fails the execution.
+	Inputs []string `json:"inputs,omitempty"`
+}
+
+// Run modes accepted by RunNotebookRequest.
+const (
+	RunModeAll       = "all"
+	RunModeFromCell  = "from_cell"
+	RunModeUntilCell = "until_cell"
+)
+
+// RunNotebookRequest describes which cells of a session's notebook the controller should run.
+type RunNotebookRequest struct {
+	Mode        string      `json:"mode"`
+	CellID      *StringUUID `json:"cell_id,omitempty"`
+	StopOnError *bool       `json:"stop_on_error,omitempty"`
+	Restart     bool        `json:"restart,omitempty"`
+	// Inputs answer the input() calls of the run's cells in order, across cells.
+	Inputs []string `json:"inputs,omitempty"`
+}
+
+// Run event types streamed while a notebook run is in progress.
+const (
+	RunEventStarted      = "run_started"
+	RunEventCellStarted  = "cell_started"
+	RunEventCellFinished = "cell_finished"
+	RunEventFinished     = "run_finished"
+)
+
+// RunEvent is a single progress update of a notebook run.
+type RunEvent struct {
+	Type           string       `json:"type"`
+	SessionID      uuid.UUID    `json:"session_id"`
+	CellID         *StringUUID  `json:"cell_id,omitempty"`
+	CellIndex      *int         `json:"cell_index,omitempty"`
+	Position       int          `json:"position,omitempty"`
+	Total          int          `json:"total"`
+	Status         string       `json:"status,omitempty"`
+	ExecutionCount int          `json:"execution_count,omitempty"`
+	Outputs        []CellOutput `json:"outputs,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notebook execution statuses.
+const (
+	NotebookExecutionRunning   = "running"
+	NotebookExecutionCompleted = "completed"
+	NotebookExecutionFailed    = "failed"
+)
+
+// NotebookExecution is the record of a headless, parameterized run of a notebook.
+// Notebook holds the executed copy, including the injected parameters cell and all outputs.
+type NotebookExecution struct {
+	ID         uuid.UUID       `json:"id"`
+	NotebookID uuid.UUID       `json:"notebook_id"`
+	CreatedBy  uuid.UUID       `json:"created_by"`
+	KernelName string          `json:"kernel_name"`
+	Parameters json.RawMessage `json:"parameters"`
+	Status     string          `json:"status"`
+	Error      *string         `json:"error,omitempty"`
+	Notebook   json.RawMessage `json:"notebook,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// CreateNotebookExecutionRequest is the payload to launch a parameterized notebook run.
+type CreateNotebookExecutionRequest struct {
+	Parameters  map[string]json.RawMessage `json:"parameters"`
+	KernelName  string                     `json:"kernel_name,omitempty"`
+	StopOnError *bool                      `json:"stop_on_error,omitempty"`
+}
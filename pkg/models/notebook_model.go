@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
@@ -14,14 +15,19 @@ type Notebook struct {
 	ProblemStatementID *string        `json:"problem_statement_id,omitempty"`
 	CreatedAt          time.Time      `json:"created_at"`
 	LastModifiedAt     time.Time      `json:"last_modified_at"`
-	Cells              []Cell         `json:"cells,omitempty"`
+	// EnvVars are set in the environment of the notebook's session kernels, each name prefixed with KERNEL_.
+	EnvVars map[string]string `json:"env_vars,omitempty"`
+	Cells   []Cell            `json:"cells,omitempty"`
+	// WidgetState is the saved ipywidgets state (application/vnd.jupyter.widget-state+json), if any.
+	WidgetState json.RawMessage `json:"widget_state,omitempty"`
 }
 
 // CreateNotebookRequest is the payload to create a notebook.
 type CreateNotebookRequest struct {
-	Title              string  `json:"title" binding:"required"`
-	Requirements       *string `json:"requirements,omitempty"`
-	ProblemStatementID *string `json:"problem_statement_id,omitempty"`
+	Title              string            `json:"title" binding:"required"`
+	Requirements       *string           `json:"requirements,omitempty"`
+	ProblemStatementID *string           `json:"problem_statement_id,omitempty"`
+	EnvVars            map[string]string `json:"env_vars,omitempty"`
 }
 
 // UpdateNotebookRequest defines updatable fields.
@@ -29,4 +35,27 @@ type UpdateNotebookRequest struct {
 	Title              *string `json:"title,omitempty"`
 	Requirements       *string `json:"requirements,omitempty"`
 	ProblemStatementID *string `json:"problem_statement_id,omitempty"`
+	// EnvVars replaces the notebook's environment variables when set; an empty object clears them.
+	EnvVars map[string]string `json:"env_vars,omitempty"`
+}
+
+// ImportNotebookRequest is the payload to import a Jupyter notebook file.
+type ImportNotebookRequest struct {
+	// Title defaults to the notebook's "title" metadata.
+	Title              string  `json:"title,omitempty"`
+	ProblemStatementID *string `json:"problem_statement_id,omitempty"`
+	// Notebook is the content of the .ipynb file, in nbformat 4.
+	Notebook json.RawMessage `json:"notebook,omitempty"`
+	// Script is a percent-format Python script ("# %%" cells) to import instead of Notebook.
+	Script string `json:"script,omitempty"`
+	// Format is "ipynb" or "py". It defaults to "py" when Script is set, so only an empty script
+	// needs it.
+	Format string `json:"format,omitempty"`
+}
+
+// NotebookImportResult is the notebook created by an import, with the content it could not keep.
+type NotebookImportResult struct {
+	Notebook *Notebook `json:"notebook"`
+	// Unsupported describes each piece of the file that was dropped, e.g. cell attachments.
+	Unsupported []string `json:"unsupported"`
 }
@@ -6,18 +6,69 @@ import (
 	"github.com/google/uuid"
 )
 
+// Session statuses.
+const (
+	SessionStatusActive = "active"
+	// SessionStatusCulled marks a session whose kernel was shut down by the culler.
+	SessionStatusCulled = "culled"
+)
+
 // Session represents a user's session in the database.
 type Session struct {
 	ID              uuid.UUID `json:"id"`
 	UserID          uuid.UUID `json:"user_id"`
 	NotebookID      uuid.UUID `json:"notebook_id"`
 	CurrentKernelID uuid.UUID `json:"current_kernel_id"`
+	KernelName      string    `json:"kernel_name"`
 	Status          string    `json:"status"`
 	LastActiveAt    time.Time `json:"last_active_at"`
+	// KernelStartedAt is when the current kernel was started or last restarted, losing its state.
+	KernelStartedAt time.Time `json:"kernel_started_at"`
 }
 
 // CreateSessionRequest is the struct for the request body to create a new session.
 type CreateSessionRequest struct {
 	NotebookID string `json:"notebook_id" binding:"required"`
-	Language string `json:"language" binding:"required"`
+	Language   string `json:"language" binding:"required"`
+}
+
+// Kernel recovery modes.
+const (
+	// RecoveryModeRestart restarts the dead kernel in place, keeping its ID.
+	RecoveryModeRestart = "restart"
+	// RecoveryModeNewKernel starts a replacement kernel and points the session at it.
+	RecoveryModeNewKernel = "new_kernel"
+)
+
+// RecoverSessionRequest asks the controller to bring a session's kernel back after it died.
+type RecoverSessionRequest struct {
+	Mode string `json:"mode"`
+	// Replay re-executes, in their original order, the cells that ran successfully on the dead kernel.
+	Replay bool `json:"replay"`
+}
+
+// RecoverSessionResult describes what a recovery did.
+type RecoverSessionResult struct {
+	SessionID        uuid.UUID `json:"session_id"`
+	PreviousKernelID uuid.UUID `json:"previous_kernel_id"`
+	KernelID         uuid.UUID `json:"kernel_id"`
+	Mode             string    `json:"mode"`
+	Replayed         int       `json:"replayed"`
+	ReplayError      string    `json:"replay_error,omitempty"`
+}
+
+// CompleteRequest asks the session kernel for completions at a cursor position.
+type CompleteRequest struct {
+	Code string `json:"code"`
+	// CursorPos is in unicode code points, as in the Jupyter protocol; it defaults to the end of the code.
+	CursorPos *int `json:"cursor_pos,omitempty"`
+}
+
+// InspectRequest asks the session kernel about the object at a cursor position.
+type InspectRequest struct {
+	Code string `json:"code"`
+	// CursorPos is in unicode code points, as in the Jupyter protocol; it defaults to the end of the code.
+	CursorPos *int `json:"cursor_pos,omitempty"`
+	// DetailLevel is 0 for the signature and docstring, 1 to also include the source when available.
+	DetailLevel int `json:"detail_level"`
 }
@@ -0,0 +1,38 @@
+package models
+
+import "encoding/json"
+
+// KernelVariable describes one user variable defined in a session kernel.
+type KernelVariable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Size is the len() of the value, when it has one.
+	Size *int `json:"size,omitempty"`
+	// Shape is set for array-like values such as ndarrays and DataFrames.
+	Shape []int `json:"shape,omitempty"`
+	// Repr is a short, truncated representation of the value.
+	Repr string `json:"repr"`
+}
+
+// Kinds of variable previews.
+const (
+	VariableKindDataFrame = "dataframe"
+	VariableKindNDArray   = "ndarray"
+	VariableKindMapping   = "mapping"
+	VariableKindSequence  = "sequence"
+	VariableKindObject    = "object"
+)
+
+// VariablePreview is one page of the contents of a kernel variable.
+type VariablePreview struct {
+	KernelVariable
+	Kind   string `json:"kind"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	// Columns and Index are set for DataFrames, whose Items are rows of cell values.
+	Columns []string          `json:"columns,omitempty"`
+	Index   []json.RawMessage `json:"index,omitempty"`
+	// Items are the reprs of the elements of the page; [key, value] reprs for mappings.
+	Items []json.RawMessage `json:"items"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// WidgetStateMimeType is the mimetype of saved ipywidgets state, as stored in notebook metadata.
+const WidgetStateMimeType = "application/vnd.jupyter.widget-state+json"
+
+// WidgetState is the saved state of the ipywidgets of a notebook, in the
+// application/vnd.jupyter.widget-state+json format.
+type WidgetState struct {
+	VersionMajor int                    `json:"version_major"`
+	VersionMinor int                    `json:"version_minor"`
+	State        map[string]WidgetModel `json:"state"`
+}
+
+// WidgetModel is the state of one widget model, keyed by its comm id in WidgetState.
+type WidgetModel struct {
+	ModelName          string         `json:"model_name"`
+	ModelModule        string         `json:"model_module"`
+	ModelModuleVersion string         `json:"model_module_version"`
+	State              map[string]any `json:"state"`
+}
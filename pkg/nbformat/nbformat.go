@@ -0,0 +1,253 @@
+// Package nbformat reads and writes Jupyter notebooks in the nbformat 4 JSON format (.ipynb files)
+// and as percent-format Python scripts.
+package nbformat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalid is returned, wrapped with the reason, for documents that are not nbformat 4 notebooks.
+var ErrInvalid = errors.New("invalid notebook")
+
+// Minor is the nbformat 4 minor version of the notebooks written by Marshal. Cells have ids from 4.5 on.
+const Minor = 5
+
+// WidgetStateMimeType is the key of the ipywidgets state in a notebook's "widgets" metadata.
+const WidgetStateMimeType = "application/vnd.jupyter.widget-state+json"
+
+// Notebook is an nbformat 4 notebook.
+type Notebook struct {
+	NBFormat      int                        `json:"nbformat"`
+	NBFormatMinor int                        `json:"nbformat_minor"`
+	Metadata      map[string]json.RawMessage `json:"metadata"`
+	Cells         []Cell                     `json:"cells"`
+}
+
+// Cell is a code, markdown or raw cell of a notebook. When written, code cells always have
+// execution_count, null when the cell has not run, and outputs, and other cells have neither.
+type Cell struct {
+	ID             string                     `json:"id,omitempty"`
+	CellType       string                     `json:"cell_type"`
+	Source         MultilineString            `json:"source"`
+	Metadata       map[string]json.RawMessage `json:"metadata"`
+	ExecutionCount *int                       `json:"execution_count,omitempty"`
+	Outputs        []Output                   `json:"outputs,omitempty"`
+	Attachments    json.RawMessage            `json:"attachments,omitempty"`
+}
+
+// Output is one output of a code cell. Which fields are set depends on OutputType: stream outputs
+// have Name and Text, display_data and execute_result outputs have Data and Metadata, and error
+// outputs have Ename, Evalue and Traceback.
+type Output struct {
+	OutputType     string                     `json:"output_type"`
+	Name           string                     `json:"name,omitempty"`
+	Text           MultilineString            `json:"text,omitempty"`
+	Data           map[string]json.RawMessage `json:"data,omitempty"`
+	Metadata       map[string]json.RawMessage `json:"metadata,omitempty"`
+	ExecutionCount *int                       `json:"execution_count,omitempty"`
+	Ename          string                     `json:"ename,omitempty"`
+	Evalue         string                     `json:"evalue,omitempty"`
+	Traceback      []string                   `json:"traceback,omitempty"`
+}
+
+// MultilineString is a notebook string, which files store either whole or as a list of lines.
+type MultilineString string
+
+// UnmarshalJSON accepts a string or a list of strings, which are joined.
+func (s *MultilineString) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*s = MultilineString(text)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return errors.New("must be a string or a list of strings")
+	}
+	*s = MultilineString(strings.Join(lines, ""))
+	return nil
+}
+
+// New returns an empty notebook of the version written by this package.
+func New() *Notebook {
+	return &Notebook{NBFormat: 4, NBFormatMinor: Minor, Metadata: map[string]json.RawMessage{}, Cells: []Cell{}}
+}
+
+// Parse decodes and validates an nbformat 4 notebook.
+func Parse(data []byte) (*Notebook, error) {
+	var nb Notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if nb.NBFormat != 4 {
+		return nil, fmt.Errorf("%w: nbformat %d is not supported, only nbformat 4", ErrInvalid, nb.NBFormat)
+	}
+	if nb.Cells == nil {
+		return nil, fmt.Errorf("%w: missing cells", ErrInvalid)
+	}
+	for i, cell := range nb.Cells {
+		switch cell.CellType {
+		case "code", "markdown", "raw":
+		default:
+			return nil, fmt.Errorf("%w: cell %d has unknown cell_type '%s'", ErrInvalid, i, cell.CellType)
+		}
+		for j, output := range cell.Outputs {
+			if output.OutputType == "" {
+				return nil, fmt.Errorf("%w: output %d of cell %d has no output_type", ErrInvalid, j, i)
+			}
+		}
+	}
+	return &nb, nil
+}
+
+// MimeBundle returns the output's data with each text entry stored as a list of lines joined into
+// one string. JSON entries are returned as they are.
+func (o Output) MimeBundle() map[string]json.RawMessage {
+	bundle := make(map[string]json.RawMessage, len(o.Data))
+	for mime, value := range o.Data {
+		if !IsJSONMime(mime) {
+			var text MultilineString
+			if err := json.Unmarshal(value, &text); err == nil {
+				if joined, err := json.Marshal(string(text)); err == nil {
+					value = joined
+				}
+			}
+		}
+		bundle[mime] = value
+	}
+	return bundle
+}
+
+// IsJSONMime reports whether data of the mime type is a JSON document rather than a string.
+func IsJSONMime(mime string) bool {
+	return mime == "application/json" || strings.HasSuffix(mime, "+json")
+}
+
+// WidgetState returns the ipywidgets state saved in the notebook's metadata, if any.
+func (nb *Notebook) WidgetState() json.RawMessage {
+	var widgets map[string]json.RawMessage
+	if err := json.Unmarshal(nb.Metadata["widgets"], &widgets); err != nil {
+		return nil
+	}
+	return widgets[WidgetStateMimeType]
+}
+
+// StringMetadata returns a string entry of metadata, or "" when it is missing or not a string.
+func StringMetadata(metadata map[string]json.RawMessage, key string) string {
+	var value string
+	if err := json.Unmarshal(metadata[key], &value); err != nil {
+		return ""
+	}
+	return value
+}
+
+// SetWidgetState saves ipywidgets state in the notebook's metadata, replacing any saved state.
+func (nb *Notebook) SetWidgetState(state json.RawMessage) {
+	widgets, err := json.Marshal(map[string]json.RawMessage{WidgetStateMimeType: state})
+	if err != nil {
+		return
+	}
+	if nb.Metadata == nil {
+		nb.Metadata = map[string]json.RawMessage{}
+	}
+	nb.Metadata["widgets"] = widgets
+}
+
+// Marshal encodes the notebook with the one-space indentation Jupyter writes notebooks with.
+func (nb *Notebook) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// MarshalJSON writes the fields nbformat requires for the notebook, with empty metadata and cells
+// rather than null.
+func (nb Notebook) MarshalJSON() ([]byte, error) {
+	type notebook Notebook
+	if nb.Metadata == nil {
+		nb.Metadata = map[string]json.RawMessage{}
+	}
+	if nb.Cells == nil {
+		nb.Cells = []Cell{}
+	}
+	return json.Marshal(notebook(nb))
+}
+
+// MarshalJSON writes the fields nbformat requires for the cell's type.
+func (c Cell) MarshalJSON() ([]byte, error) {
+	metadata := c.Metadata
+	if metadata == nil {
+		metadata = map[string]json.RawMessage{}
+	}
+	if c.CellType != "code" {
+		return json.Marshal(struct {
+			ID          string                     `json:"id,omitempty"`
+			CellType    string                     `json:"cell_type"`
+			Metadata    map[string]json.RawMessage `json:"metadata"`
+			Source      MultilineString            `json:"source"`
+			Attachments json.RawMessage            `json:"attachments,omitempty"`
+		}{c.ID, c.CellType, metadata, c.Source, c.Attachments})
+	}
+	outputs := c.Outputs
+	if outputs == nil {
+		outputs = []Output{}
+	}
+	return json.Marshal(struct {
+		ID             string                     `json:"id,omitempty"`
+		CellType       string                     `json:"cell_type"`
+		Metadata       map[string]json.RawMessage `json:"metadata"`
+		Source         MultilineString            `json:"source"`
+		ExecutionCount *int                       `json:"execution_count"`
+		Outputs        []Output                   `json:"outputs"`
+	}{c.ID, c.CellType, metadata, c.Source, c.ExecutionCount, outputs})
+}
+
+// MarshalJSON writes the fields nbformat requires for the output's type.
+func (o Output) MarshalJSON() ([]byte, error) {
+	data, metadata := o.Data, o.Metadata
+	if data == nil {
+		data = map[string]json.RawMessage{}
+	}
+	if metadata == nil {
+		metadata = map[string]json.RawMessage{}
+	}
+	switch o.OutputType {
+	case "stream":
+		return json.Marshal(struct {
+			OutputType string          `json:"output_type"`
+			Name       string          `json:"name"`
+			Text       MultilineString `json:"text"`
+		}{o.OutputType, o.Name, o.Text})
+	case "display_data":
+		return json.Marshal(struct {
+			OutputType string                     `json:"output_type"`
+			Data       map[string]json.RawMessage `json:"data"`
+			Metadata   map[string]json.RawMessage `json:"metadata"`
+		}{o.OutputType, data, metadata})
+	case "execute_result":
+		return json.Marshal(struct {
+			OutputType     string                     `json:"output_type"`
+			ExecutionCount *int                       `json:"execution_count"`
+			Data           map[string]json.RawMessage `json:"data"`
+			Metadata       map[string]json.RawMessage `json:"metadata"`
+		}{o.OutputType, o.ExecutionCount, data, metadata})
+	case "error":
+		traceback := o.Traceback
+		if traceback == nil {
+			traceback = []string{}
+		}
+		return json.Marshal(struct {
+			OutputType string   `json:"output_type"`
+			Ename      string   `json:"ename"`
+			Evalue     string   `json:"evalue"`
+			Traceback  []string `json:"traceback"`
+		}{o.OutputType, o.Ename, o.Evalue, traceback})
+	}
+	type output Output
+	return json.Marshal(output(o))
+}
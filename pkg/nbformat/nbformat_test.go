@@ -0,0 +1,69 @@
+package nbformat_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+)
+
+func TestParseJoinsListStrings(t *testing.T) {
+	nb, err := nbformat.Parse([]byte(`{
+		"nbformat": 4, "nbformat_minor": 5,
+		"metadata": {"title": "Demo", "widgets": {"application/vnd.jupyter.widget-state+json": {"state": {}}}},
+		"cells": [
+			{"cell_type": "markdown", "metadata": {}, "source": ["# Title\n", "text"]},
+			{"cell_type": "code", "metadata": {"name": "fit"}, "execution_count": 3, "source": "x = 1",
+			 "outputs": [
+				{"output_type": "stream", "name": "stdout", "text": ["a\n", "b\n"]},
+				{"output_type": "display_data", "metadata": {},
+				 "data": {"text/plain": ["line 1\n", "line 2"], "application/json": {"a": [1, 2]}}}
+			 ]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := string(nb.Cells[0].Source); got != "# Title\ntext" {
+		t.Errorf("markdown source = %q", got)
+	}
+	code := nb.Cells[1]
+	if code.ExecutionCount == nil || *code.ExecutionCount != 3 {
+		t.Errorf("execution count = %v, want 3", code.ExecutionCount)
+	}
+	if got := nbformat.StringMetadata(code.Metadata, "name"); got != "fit" {
+		t.Errorf("cell name = %q, want fit", got)
+	}
+	if got := string(code.Outputs[0].Text); got != "a\nb\n" {
+		t.Errorf("stream text = %q", got)
+	}
+	bundle := code.Outputs[1].MimeBundle()
+	if got := string(bundle["text/plain"]); got != `"line 1\nline 2"` {
+		t.Errorf("text/plain = %s", got)
+	}
+	if got := string(bundle["application/json"]); got != `{"a": [1, 2]}` {
+		t.Errorf("application/json = %s", got)
+	}
+	if got := nbformat.StringMetadata(nb.Metadata, "title"); got != "Demo" {
+		t.Errorf("title = %q, want Demo", got)
+	}
+	if nb.WidgetState() == nil {
+		t.Error("widget state was not read")
+	}
+}
+
+func TestParseRejectsInvalidNotebooks(t *testing.T) {
+	for name, doc := range map[string]string{
+		"not json":          `{"cells": [`,
+		"nbformat 3":        `{"nbformat": 3, "cells": []}`,
+		"missing cells":     `{"nbformat": 4}`,
+		"unknown cell type": `{"nbformat": 4, "cells": [{"cell_type": "heading", "source": ""}]}`,
+		"bad source":        `{"nbformat": 4, "cells": [{"cell_type": "code", "source": 1}]}`,
+		"untyped output":    `{"nbformat": 4, "cells": [{"cell_type": "code", "source": "", "outputs": [{}]}]}`,
+	} {
+		if _, err := nbformat.Parse([]byte(doc)); !errors.Is(err, nbformat.ErrInvalid) {
+			t.Errorf("%s: err = %v, want ErrInvalid", name, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package nbformat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// percentMarker starts each cell of a percent-format script.
+const percentMarker = "# %%"
+
+// markerLike matches the lines of a cell that would read as a "# %%" line, and those lines already
+// escaped. FormatPercent escapes them with another "# ", which ParsePercent removes.
+var markerLike = regexp.MustCompile(`^(# )+%%( |$)`)
+
+// PercentCell is a cell of a percent-format Python script, the format jupytext calls "percent":
+// each cell starts with a "# %%" line carrying the cell's name and, for markdown and raw cells,
+// its type in brackets. The lines of markdown and raw cells are commented out.
+type PercentCell struct {
+	CellType string
+	Name     string
+	Source   string
+}
+
+// percentTypes maps the bracketed cell types of a header line to cell types.
+var percentTypes = map[string]string{"[markdown]": "markdown", "[md]": "markdown", "[raw]": "raw"}
+
+// FormatPercent writes cells as a percent-format script, separating them with a blank line. Lines
+// of a cell that would start a new cell are escaped.
+func FormatPercent(cells []PercentCell) []byte {
+	var b strings.Builder
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(percentMarker)
+		if name := strings.Join(strings.Fields(cell.Name), " "); name != "" {
+			b.WriteString(" " + name)
+		}
+		if cell.CellType == "markdown" || cell.CellType == "raw" {
+			b.WriteString(" [" + cell.CellType + "]")
+		}
+		b.WriteString("\n")
+
+		source := strings.TrimRight(cell.Source, "\n")
+		if source == "" {
+			continue
+		}
+		for _, line := range strings.Split(source, "\n") {
+			if cell.CellType == "markdown" || cell.CellType == "raw" {
+				if line == "" {
+					line = "#"
+				} else {
+					line = "# " + line
+				}
+			}
+			if markerLike.MatchString(line) {
+				line = "# " + line
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// ParsePercent reads the cells of a percent-format script. Lines before the first "# %%" line form
+// a code cell, apart from a jupytext "# ---" header, which is skipped, and blank lines. Trailing
+// blank lines of each cell are dropped. A script that is not UTF-8 text is rejected with an error
+// wrapping ErrInvalid.
+func ParsePercent(data []byte) ([]PercentCell, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: script is not UTF-8 text", ErrInvalid)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	lines = skipPercentHeader(lines)
+
+	var cells []PercentCell
+	current := &PercentCell{CellType: "code"}
+	var body []string
+	implicit := true
+	flush := func() {
+		for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+			body = body[:len(body)-1]
+		}
+		// Blank lines before the first marker, such as those after the header, are not code.
+		for implicit && len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+			body = body[1:]
+		}
+		if !implicit || len(body) > 0 {
+			current.Source = strings.Join(body, "\n")
+			cells = append(cells, *current)
+		}
+	}
+	for _, line := range lines {
+		if header, ok := percentHeader(line); ok {
+			flush()
+			current, body, implicit = header, nil, false
+			continue
+		}
+		if markerLike.MatchString(line) {
+			// Not a header, so an escaped line.
+			line = line[2:]
+		}
+		if current.CellType != "code" {
+			line = uncomment(line)
+		}
+		body = append(body, line)
+	}
+	flush()
+	return cells, nil
+}
+
+// percentHeader parses a "# %%" line into an empty cell of its name and type.
+func percentHeader(line string) (*PercentCell, bool) {
+	if line != percentMarker && !strings.HasPrefix(line, percentMarker+" ") {
+		return nil, false
+	}
+	cell := &PercentCell{CellType: "code"}
+	var name []string
+	for _, field := range strings.Fields(strings.TrimPrefix(line, percentMarker)) {
+		if cellType, ok := percentTypes[field]; ok && cell.CellType == "code" {
+			cell.CellType = cellType
+			continue
+		}
+		name = append(name, field)
+	}
+	cell.Name = strings.Join(name, " ")
+	return cell, true
+}
+
+// skipPercentHeader drops the commented YAML header jupytext writes at the top of scripts.
+func skipPercentHeader(lines []string) []string {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "# ---" {
+		return lines
+	}
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "# ---" {
+			return lines[i+1:]
+		}
+		if !strings.HasPrefix(lines[i], "#") {
+			break
+		}
+	}
+	return lines
+}
+
+// uncomment removes the comment prefix from a line of a markdown or raw cell.
+func uncomment(line string) string {
+	switch {
+	case strings.HasPrefix(line, "# "):
+		return line[2:]
+	case strings.HasPrefix(line, "#"):
+		return line[1:]
+	}
+	return line
+}
@@ -0,0 +1,80 @@
+package nbformat_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/nbformat"
+)
+
+func TestPercentRoundTrip(t *testing.T) {
+	cells := []nbformat.PercentCell{
+		{CellType: "markdown", Name: "intro", Source: "# Iris\n\nLoad the *data*."},
+		{CellType: "code", Name: "load data", Source: "import numpy as np\n\nx = np.arange(3)"},
+		{CellType: "code", Source: ""},
+		{CellType: "raw", Source: "%%raw"},
+	}
+	script := nbformat.FormatPercent(cells)
+
+	want := "# %% intro [markdown]\n# # Iris\n#\n# Load the *data*.\n\n" +
+		"# %% load data\nimport numpy as np\n\nx = np.arange(3)\n\n" +
+		"# %%\n\n" +
+		"# %% [raw]\n# %%raw\n"
+	if string(script) != want {
+		t.Errorf("FormatPercent =\n%s\nwant\n%s", script, want)
+	}
+
+	parsed, err := nbformat.ParsePercent(script)
+	if err != nil {
+		t.Fatalf("ParsePercent: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, cells) {
+		t.Errorf("round trip = %+v, want %+v", parsed, cells)
+	}
+}
+
+func TestParsePercentScripts(t *testing.T) {
+	script := "# ---\r\n# jupyter:\r\n#   kernelspec:\r\n#     name: python3\r\n# ---\r\n\r\n" +
+		"import os\r\n\r\n" +
+		"# %% [md] Setup notes\r\n# Some text\r\n\r\n\r\n" +
+		"# %%\r\nprint(os.getcwd())\r\n"
+	cells, err := nbformat.ParsePercent([]byte(script))
+	if err != nil {
+		t.Fatalf("ParsePercent: %v", err)
+	}
+	want := []nbformat.PercentCell{
+		{CellType: "code", Source: "import os"},
+		{CellType: "markdown", Name: "Setup notes", Source: "Some text"},
+		{CellType: "code", Source: "print(os.getcwd())"},
+	}
+	if !reflect.DeepEqual(cells, want) {
+		t.Errorf("ParsePercent = %+v, want %+v", cells, want)
+	}
+
+	if _, err := nbformat.ParsePercent([]byte{0xff, 0xfe}); !errors.Is(err, nbformat.ErrInvalid) {
+		t.Errorf("binary script: err = %v, want ErrInvalid", err)
+	}
+}
+
+func TestPercentEscapesMarkers(t *testing.T) {
+	cells := []nbformat.PercentCell{
+		{CellType: "code", Source: "x = 1\n# %% not a cell\n# # %%"},
+		{CellType: "markdown", Source: "%% still markdown"},
+	}
+	script := nbformat.FormatPercent(cells)
+
+	want := "# %%\nx = 1\n# # %% not a cell\n# # # %%\n\n" +
+		"# %% [markdown]\n# # %% still markdown\n"
+	if string(script) != want {
+		t.Errorf("FormatPercent =\n%s\nwant\n%s", script, want)
+	}
+
+	parsed, err := nbformat.ParsePercent(script)
+	if err != nil {
+		t.Fatalf("ParsePercent: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, cells) {
+		t.Errorf("round trip = %+v, want %+v", parsed, cells)
+	}
+}
@@ -0,0 +1,335 @@
+// Package warmpool keeps idle kernels started ahead of time, so new sessions do not wait for a
+// kernel to boot and import its libraries.
+package warmpool
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+)
+
+// Timeouts of the calls made to prepare and hand out kernels.
+const (
+	startTimeout  = 2 * time.Minute
+	warmupTimeout = 5 * time.Minute
+	checkTimeout  = 10 * time.Second
+)
+
+// Config holds the pool sizes and how pooled kernels are prepared.
+type Config struct {
+	// Sizes is how many idle kernels are kept ready for each kernelspec.
+	Sizes map[string]int
+	// Warmup is code run on each new kernel of a kernelspec before it is handed out, e.g. imports.
+	Warmup map[string]string
+	// CheckInterval is the time between two checks that pooled kernels are still alive.
+	CheckInterval time.Duration
+}
+
+// nonAlphanumeric matches what is replaced by underscores to derive environment variable names from kernelspec names.
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// ConfigFromEnv loads the pool configuration from the environment. WARM_KERNEL_POOL lists the pool
+// sizes as "python3=2,ir=1"; the warm-up code of a kernelspec is read from WARM_KERNEL_WARMUP_<NAME>,
+// e.g. WARM_KERNEL_WARMUP_PYTHON3. The pool is disabled when WARM_KERNEL_POOL is empty.
+func ConfigFromEnv() Config {
+	config := Config{
+		Sizes:  make(map[string]int),
+		Warmup: make(map[string]string),
+	}
+	checkSeconds, err := strconv.Atoi(os.Getenv("WARM_KERNEL_CHECK_INTERVAL_SECONDS"))
+	if err != nil || checkSeconds <= 0 {
+		checkSeconds = 60
+	}
+	config.CheckInterval = time.Duration(checkSeconds) * time.Second
+
+	for _, entry := range strings.Split(os.Getenv("WARM_KERNEL_POOL"), ",") {
+		name, size, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || n <= 0 {
+			pkg.Logger.Warn().Str("entry", entry).Msg("[WARMPOOL]: Ignoring invalid WARM_KERNEL_POOL entry")
+			continue
+		}
+		name = strings.TrimSpace(name)
+		config.Sizes[name] = n
+		envName := "WARM_KERNEL_WARMUP_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(name, "_"))
+		if code := os.Getenv(envName); code != "" {
+			config.Warmup[name] = code
+		}
+	}
+	return config
+}
+
+// Pool keeps the configured number of idle kernels per kernelspec and refills itself in the
+// background as kernels are handed out.
+type Pool struct {
+	client jupyterclient.Gateway
+	config Config
+
+	mu sync.Mutex
+	// ready holds the IDs of the kernels that can be handed out, oldest first.
+	ready map[string][]string
+	// warming counts the kernels of each kernelspec being started or warmed up.
+	warming map[string]int
+	// members holds every kernel the pool owns, ready or warming.
+	members map[string]struct{}
+
+	wake chan struct{}
+}
+
+// SpecStatus describes the pool of one kernelspec.
+type SpecStatus struct {
+	KernelSpec string `json:"kernelspec"`
+	Size       int    `json:"size"`
+	Ready      int    `json:"ready"`
+	Warming    int    `json:"warming"`
+}
+
+// New creates a pool that starts its kernels on the given gateway.
+func New(client jupyterclient.Gateway, config Config) *Pool {
+	return &Pool{
+		client:  client,
+		config:  config,
+		ready:   make(map[string][]string),
+		warming: make(map[string]int),
+		members: make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Start fills the pool and keeps it filled in the background until ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) {
+	if len(p.config.Sizes) == 0 {
+		return
+	}
+	pkg.Logger.Info().Interface("sizes", p.config.Sizes).Msg("[WARMPOOL]: Started.")
+
+	go func() {
+		ticker := time.NewTicker(p.config.CheckInterval)
+		defer ticker.Stop()
+
+		p.refill(ctx)
+		for {
+			select {
+			case <-p.wake:
+				p.refill(ctx)
+			case <-ticker.C:
+				p.prune(ctx)
+				p.refill(ctx)
+			case <-ctx.Done():
+				pkg.Logger.Warn().Msg("[WARMPOOL]: Context cancelled, stopping warm kernel pool.")
+				return
+			}
+		}
+	}()
+}
+
+// Acquire hands out a ready kernel of the kernelspec, checking it is still alive, and returns false
+// when none is ready. The kernel no longer belongs to the pool once it is returned.
+func (p *Pool) Acquire(ctx context.Context, kernelSpec string) (*jupyterclient.Kernel, bool) {
+	if p == nil || p.config.Sizes[kernelSpec] == 0 {
+		return nil, false
+	}
+	defer p.signal()
+
+	for {
+		p.mu.Lock()
+		ids := p.ready[kernelSpec]
+		if len(ids) == 0 {
+			p.mu.Unlock()
+			return nil, false
+		}
+		kernelID := ids[0]
+		p.ready[kernelSpec] = ids[1:]
+		delete(p.members, kernelID)
+		p.mu.Unlock()
+
+		kernel, err := p.touch(ctx, kernelID)
+		if err == nil {
+			pkg.Logger.Info().Str("kernel_id", kernelID).Str("kernelspec", kernelSpec).Msg("[WARMPOOL]: Handed out warm kernel")
+			return kernel, true
+		}
+		pkg.Logger.Warn().Err(err).Str("kernel_id", kernelID).Msg("[WARMPOOL]: Discarding unresponsive warm kernel")
+		p.discard(kernelID)
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// Owns reports whether the kernel is held by the pool. The culler uses it to leave pooled kernels alone.
+func (p *Pool) Owns(kernelID string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.members[kernelID]
+	return ok
+}
+
+// Status returns the state of the pool of each configured kernelspec, ordered by name.
+func (p *Pool) Status() []SpecStatus {
+	if p == nil {
+		return []SpecStatus{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]SpecStatus, 0, len(p.config.Sizes))
+	for name, size := range p.config.Sizes {
+		status = append(status, SpecStatus{
+			KernelSpec: name,
+			Size:       size,
+			Ready:      len(p.ready[name]),
+			Warming:    p.warming[name],
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].KernelSpec < status[j].KernelSpec })
+	return status
+}
+
+// signal asks the background loop to refill the pool.
+func (p *Pool) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// refill starts as many kernels as are missing from each kernelspec's pool.
+func (p *Pool) refill(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, size := range p.config.Sizes {
+		for missing := size - len(p.ready[name]) - p.warming[name]; missing > 0; missing-- {
+			p.warming[name]++
+			go p.warm(ctx, name)
+		}
+	}
+}
+
+// warm starts one kernel, runs the kernelspec's warm-up code on it and makes it ready.
+func (p *Pool) warm(ctx context.Context, kernelSpec string) {
+	// A failed kernel is only replaced on the next check, so a failing gateway is not hammered.
+	defer func() {
+		p.mu.Lock()
+		p.warming[kernelSpec]--
+		p.mu.Unlock()
+	}()
+
+	startCtx, cancel := context.WithTimeout(ctx, startTimeout)
+	kernel, err := p.client.StartKernel(startCtx, kernelSpec, nil)
+	cancel()
+	if err != nil {
+		pkg.Logger.Error().Err(err).Str("kernelspec", kernelSpec).Msg("[WARMPOOL]: Failed to start warm kernel")
+		return
+	}
+	p.mu.Lock()
+	p.members[kernel.ID] = struct{}{}
+	p.mu.Unlock()
+
+	if code := p.config.Warmup[kernelSpec]; code != "" {
+		warmupCtx, cancel := context.WithTimeout(ctx, warmupTimeout)
+		result, err := p.client.Execute(warmupCtx, kernel.ID, code, jupyterclient.ExecuteOptions{Silent: true})
+		cancel()
+		switch {
+		case err != nil:
+			pkg.Logger.Error().Err(err).Str("kernel_id", kernel.ID).Msg("[WARMPOOL]: Failed to warm up kernel, discarding it")
+			p.discard(kernel.ID)
+			return
+		case result.Status != "ok":
+			// The kernel works; the warm-up code is at fault, e.g. a library that is not installed.
+			evt := pkg.Logger.Warn().Str("kernel_id", kernel.ID).Str("status", result.Status)
+			if result.Error != nil {
+				evt = evt.Str("ename", result.Error.Ename).Str("evalue", result.Error.Evalue)
+			}
+			evt.Msg("[WARMPOOL]: Warm-up code failed, keeping the kernel")
+		}
+	}
+
+	p.mu.Lock()
+	p.ready[kernelSpec] = append(p.ready[kernelSpec], kernel.ID)
+	p.mu.Unlock()
+	pkg.Logger.Debug().Str("kernel_id", kernel.ID).Str("kernelspec", kernelSpec).Msg("[WARMPOOL]: Warm kernel ready")
+}
+
+// prune drops the ready kernels the gateway no longer runs or reports as dead.
+func (p *Pool) prune(ctx context.Context) {
+	p.mu.Lock()
+	var ids []string
+	for _, ready := range p.ready {
+		ids = append(ids, ready...)
+	}
+	p.mu.Unlock()
+
+	for _, kernelID := range ids {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		info, err := p.client.GetKernelInfo(checkCtx, kernelID)
+		cancel()
+		if err == nil && info.ExecutionState != "dead" {
+			continue
+		}
+		pkg.Logger.Warn().Err(err).Str("kernel_id", kernelID).Msg("[WARMPOOL]: Dropping dead warm kernel")
+		p.remove(kernelID)
+		p.discard(kernelID)
+	}
+}
+
+// touch checks a kernel answers on its channels before it is handed out. The round trip also
+// refreshes the kernel's last activity, so a kernel that waited long in the pool is not culled as
+// idle right after it joins a session.
+func (p *Pool) touch(ctx context.Context, kernelID string) (*jupyterclient.Kernel, error) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	kc, err := p.client.ConnectKernel(ctx, kernelID)
+	if err != nil {
+		return nil, err
+	}
+	var reply map[string]any
+	err = kc.Request(ctx, "kernel_info_request", struct{}{}, &reply)
+	kc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return p.client.GetKernelInfo(ctx, kernelID)
+}
+
+// remove forgets a ready kernel.
+func (p *Pool) remove(kernelID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.members, kernelID)
+	for name, ids := range p.ready {
+		kept := ids[:0]
+		for _, id := range ids {
+			if id != kernelID {
+				kept = append(kept, id)
+			}
+		}
+		p.ready[name] = kept
+	}
+}
+
+// discard deletes a kernel the pool gave up on.
+func (p *Pool) discard(kernelID string) {
+	p.mu.Lock()
+	delete(p.members, kernelID)
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if err := p.client.DeleteKernel(ctx, kernelID); err != nil {
+		pkg.Logger.Debug().Err(err).Str("kernel_id", kernelID).Msg("[WARMPOOL]: Failed to delete discarded kernel")
+	}
+}
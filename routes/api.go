@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/Thanus-Kumaar/controller_microservice_v2/controllers"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/db"
@@ -10,10 +11,15 @@ import (
 	"github.com/Thanus-Kumaar/controller_microservice_v2/middleware"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/modules"
 	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/blobstore"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/culler"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/events"
 	jupyterclient "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/jupyter_client"
+	kernelhub "github.com/Thanus-Kumaar/controller_microservice_v2/pkg/kernel_hub"
+	"github.com/Thanus-Kumaar/controller_microservice_v2/pkg/warmpool"
 )
 
-func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
+func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Pool, kernelCuller *culler.Culler, warmPool *warmpool.Pool, eventBus *events.Bus, blobs blobstore.Store, recoveryConfig modules.KernelRecoveryConfig) {
 
 	// Initialize Repositories
 	notebookRepo := repository.NewNotebookRepository(db.Pool)
@@ -21,6 +27,9 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 	sessionRepo := repository.NewSessionRepository(db.Pool)
 	problemRepo := repository.NewProblemRepository(db.Pool).WithLogger(*pkg.Logger)
 	cellRepo := repository.NewCellRepository(db.Pool, *pkg.Logger)
+	notebookExecutionRepo := repository.NewNotebookExecutionRepository(db.Pool)
+	executionRepo := repository.NewExecutionRepository(db.Pool)
+	widgetStateRepo := repository.NewWidgetStateRepository(db.Pool)
 
 	userDataDir := os.Getenv("USER_DATA_DIR")
 	if userDataDir == "" {
@@ -29,11 +38,24 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 	fileModule := modules.NewFileModule(userDataDir)
 
 	// Initialize Modules
-	notebookModule := modules.NewNotebookModule(notebookRepo, problemRepo)
+	notebookModule := modules.NewNotebookModule(notebookRepo, problemRepo).WithBlobs(blobs).WithKernels(sessionRepo, c)
 	llmModule := modules.NewLlmModule(llmRepo)
-	sessionModule := modules.NewSessionModule(sessionRepo, c, *pkg.Logger, notebookRepo)
+	sessionModule := modules.NewSessionModule(sessionRepo, c, *pkg.Logger, notebookRepo).WithWarmPool(warmPool).WithUserDataDir(userDataDir).WithEvents(eventBus)
 	problemModule := modules.NewProblemModule(problemRepo, notebookRepo, fileModule, *pkg.Logger) // Pass the logger here
-	cellModule := modules.NewCellModule(cellRepo, *pkg.Logger)
+	cellModule := modules.NewCellModule(cellRepo, blobs, *pkg.Logger)
+	cellOutputRecorder := modules.NewCellOutputRecorder(cellRepo, blobs, *pkg.Logger)
+	executionRecorder := modules.NewExecutionRecorder(executionRepo, sessionRepo, *pkg.Logger)
+	widgetStateRecorder := modules.NewWidgetStateRecorder(widgetStateRepo, sessionRepo, *pkg.Logger)
+	kernelEventPublisher := modules.NewKernelEventPublisher(eventBus)
+	kernelHubs := kernelhub.NewManager(c, kernelhub.Observers{cellOutputRecorder, executionRecorder, widgetStateRecorder, kernelEventPublisher}, *pkg.Logger)
+	executionModule := modules.NewExecutionModule(sessionRepo, cellRepo, executionRepo, notebookRepo, c, blobs, *pkg.Logger)
+	executionModule.Events = eventBus
+	introspectionModule := modules.NewIntrospectionModule(sessionRepo, c, *pkg.Logger)
+	kernelRecoveryModule := modules.NewKernelRecoveryModule(sessionRepo, executionRepo, c, recoveryConfig, *pkg.Logger)
+	kernelRecoveryModule.KernelEnv = sessionModule.KernelEnv
+	kernelRecoveryModule.Events = eventBus
+	kernelHubs.OnKernelDeath(kernelRecoveryModule.HandleKernelDeath)
+	notebookExecutionModule := modules.NewNotebookExecutionModule(notebookExecutionRepo, notebookRepo, c, *pkg.Logger)
 
 	// Initialize Controllers
 	notebookController := controllers.NewNotebookController(notebookModule, pkg.Logger)
@@ -41,8 +63,13 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 	llmController := controllers.NewLlmController(llmModule, *pkg.Logger)
 	problemController := controllers.NewProblemController(problemModule, *pkg.Logger)
 	cellController := controllers.NewCellController(cellModule, *pkg.Logger, notebookModule)
-	kernelController := controllers.NewKernelController(c, *pkg.Logger, cellRepo)
+	kernelController := controllers.NewKernelController(c, *pkg.Logger, kernelHubs, sessionModule, strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ","))
 	fileController := controllers.NewFileController(fileModule, *pkg.Logger)
+	executionController := controllers.NewExecutionController(executionModule, *pkg.Logger)
+	adminController := controllers.NewAdminController(kernelCuller, warmPool, c, *pkg.Logger)
+	notebookExecutionController := controllers.NewNotebookExecutionController(notebookExecutionModule, *pkg.Logger)
+	kernelRecoveryController := controllers.NewKernelRecoveryController(kernelRecoveryModule, *pkg.Logger)
+	introspectionController := controllers.NewIntrospectionController(introspectionModule, *pkg.Logger)
 
 	// Register the handler functions with API versioning (v1)
 
@@ -67,10 +94,14 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 	// Notebook Routes
 	mux.Handle("POST /api/v1/notebooks",
 		middleware.AuthMiddleware(http.HandlerFunc(notebookController.CreateNotebookHandler)))
+	mux.Handle("POST /api/v1/notebooks/import",
+		middleware.AuthMiddleware(http.HandlerFunc(notebookController.ImportNotebookHandler)))
 	mux.Handle("GET /api/v1/notebooks",
 		middleware.AuthMiddleware(http.HandlerFunc(notebookController.ListNotebooksHandler)))
 	mux.Handle("GET /api/v1/notebooks/{id}",
 		middleware.AuthMiddleware(http.HandlerFunc(notebookController.GetNotebookByIDHandler)))
+	mux.Handle("GET /api/v1/notebooks/{id}/export",
+		middleware.AuthMiddleware(http.HandlerFunc(notebookController.ExportNotebookHandler)))
 	mux.Handle("PUT /api/v1/notebooks/{id}",
 		middleware.AuthMiddleware(http.HandlerFunc(notebookController.UpdateNotebookByIDHandler)))
 	mux.Handle("DELETE /api/v1/notebooks/{id}",
@@ -89,6 +120,38 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 		middleware.AuthMiddleware(http.HandlerFunc(sessionController.UpdateSessionByIDHandler)))
 	mux.Handle("DELETE /api/v1/sessions/{id}",
 		middleware.AuthMiddleware(http.HandlerFunc(sessionController.DeleteSessionByIDHandler)))
+	mux.Handle("GET /api/v1/sessions/{id}/events",
+		middleware.AuthMiddleware(http.HandlerFunc(sessionController.SessionEventsHandler)))
+	mux.Handle("POST /api/v1/sessions/{id}/recover",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelRecoveryController.RecoverSessionHandler)))
+
+	// Execution Routes
+	mux.Handle("POST /api/v1/sessions/{id}/cells/{cell_id}/execute",
+		middleware.AuthMiddleware(http.HandlerFunc(executionController.ExecuteCellHandler)))
+	mux.Handle("POST /api/v1/sessions/{id}/run",
+		middleware.AuthMiddleware(http.HandlerFunc(executionController.RunNotebookHandler)))
+	mux.Handle("POST /api/v1/sessions/{id}/complete",
+		middleware.AuthMiddleware(http.HandlerFunc(introspectionController.CompleteHandler)))
+	mux.Handle("POST /api/v1/sessions/{id}/inspect",
+		middleware.AuthMiddleware(http.HandlerFunc(introspectionController.InspectHandler)))
+	mux.Handle("GET /api/v1/sessions/{id}/variables",
+		middleware.AuthMiddleware(http.HandlerFunc(introspectionController.ListVariablesHandler)))
+	mux.Handle("GET /api/v1/sessions/{id}/variables/{name}",
+		middleware.AuthMiddleware(http.HandlerFunc(introspectionController.GetVariableHandler)))
+	mux.Handle("GET /api/v1/cells/{cell_id}/executions",
+		middleware.AuthMiddleware(http.HandlerFunc(executionController.ListCellExecutionsHandler)))
+	mux.Handle("GET /api/v1/notebooks/{id}/executions",
+		middleware.AuthMiddleware(http.HandlerFunc(executionController.ListNotebookExecutionsHandler)))
+	mux.Handle("GET /api/v1/executions/{execution_id}/messages",
+		middleware.AuthMiddleware(http.HandlerFunc(executionController.ListExecutionMessagesHandler)))
+
+	// Headless notebook run Routes
+	mux.Handle("POST /api/v1/notebooks/{id}/runs",
+		middleware.AuthMiddleware(http.HandlerFunc(notebookExecutionController.CreateNotebookExecutionHandler)))
+	mux.Handle("GET /api/v1/notebooks/{id}/runs",
+		middleware.AuthMiddleware(http.HandlerFunc(notebookExecutionController.ListNotebookExecutionsHandler)))
+	mux.Handle("GET /api/v1/notebooks/{id}/runs/{run_id}",
+		middleware.AuthMiddleware(http.HandlerFunc(notebookExecutionController.GetNotebookExecutionHandler)))
 
 	// User file Routes
 	mux.Handle("POST /api/v1/sessions/{session_id}/files",
@@ -99,24 +162,26 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 		middleware.AuthMiddleware(http.HandlerFunc(fileController.DeleteFileHandler)))
 
 	// Cell Routes
-	mux.Handle("POST /api/v1/notebooks/{notebook_id}/cells", 
+	mux.Handle("POST /api/v1/notebooks/{notebook_id}/cells",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.CreateCellHandler)))
-	mux.Handle("GET /api/v1/notebooks/{notebook_id}/cells", 
+	mux.Handle("GET /api/v1/notebooks/{notebook_id}/cells",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.GetCellsByNotebookIDHandler)))
-	mux.Handle("GET /api/v1/cells/{cell_id}", 
+	mux.Handle("GET /api/v1/cells/{cell_id}",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.GetCellByIDHandler)))
-	mux.Handle("PUT /api/v1/cells/{cell_id}", 
+	mux.Handle("PUT /api/v1/cells/{cell_id}",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.UpdateCellHandler)))
-	mux.Handle("DELETE /api/v1/cells/{cell_id}", 
+	mux.Handle("DELETE /api/v1/cells/{cell_id}",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.DeleteCellHandler)))
 
 	// Cell Output Routes
-	mux.Handle("POST /api/v1/cells/{cell_id}/outputs", 
+	mux.Handle("POST /api/v1/cells/{cell_id}/outputs",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.CreateCellOutputHandler)))
-	mux.Handle("GET /api/v1/cells/{cell_id}/outputs", 
+	mux.Handle("GET /api/v1/cells/{cell_id}/outputs",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.GetCellOutputsByCellIDHandler)))
-	mux.Handle("DELETE /api/v1/outputs/{output_id}", 
+	mux.Handle("DELETE /api/v1/outputs/{output_id}",
 		middleware.AuthMiddleware(http.HandlerFunc(cellController.DeleteCellOutputHandler)))
+	mux.Handle("GET /api/v1/outputs/{output_id}/data/{mime...}",
+		middleware.AuthMiddleware(http.HandlerFunc(cellController.GetCellOutputDataHandler)))
 
 	// Llm Routes
 	mux.Handle("POST /api/v1/llm/generate",
@@ -126,12 +191,31 @@ func RegisterAPIRoutes(mux *http.ServeMux, c *jupyterclient.Client) {
 	mux.Handle("POST /api/v1/llm/fix",
 		middleware.AuthMiddleware(http.HandlerFunc(llmController.FixNotebookHandler)))
 
+	// Kernelspec Routes
+	mux.Handle("GET /api/v1/kernelspecs",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.ListKernelSpecsHandler)))
+
 	// Kernel Routes
-	mux.HandleFunc("POST /api/v1/kernels", kernelController.StartKernelHandler)
-	mux.HandleFunc("GET /api/v1/kernels", kernelController.ListKernelsHandler)
-	mux.HandleFunc("GET /api/v1/kernels/{id}", kernelController.GetKernelInfoHandler)
-	mux.HandleFunc("DELETE /api/v1/kernels/{id}", kernelController.DeleteKernelHandler)
-	mux.HandleFunc("POST /api/v1/kernels/{id}/interrupt", kernelController.InterruptKernelHandler)
-	mux.HandleFunc("POST /api/v1/kernels/{id}/restart", kernelController.RestartKernelHandler)
-	mux.HandleFunc("GET /api/v1/kernels/{id}/channels", kernelController.KernelChannelsHandler)
+	mux.Handle("POST /api/v1/kernels",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.StartKernelHandler)))
+	mux.Handle("GET /api/v1/kernels",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.ListKernelsHandler)))
+	mux.Handle("GET /api/v1/kernels/{id}",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.GetKernelInfoHandler)))
+	mux.Handle("DELETE /api/v1/kernels/{id}",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.DeleteKernelHandler)))
+	mux.Handle("POST /api/v1/kernels/{id}/interrupt",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.InterruptKernelHandler)))
+	mux.Handle("POST /api/v1/kernels/{id}/restart",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.RestartKernelHandler)))
+	mux.Handle("GET /api/v1/kernels/{id}/channels",
+		middleware.AuthMiddleware(http.HandlerFunc(kernelController.KernelChannelsHandler)))
+
+	// Admin Routes
+	mux.Handle("GET /api/v1/admin/culler/stats",
+		middleware.AuthMiddleware(http.HandlerFunc(adminController.GetCullerStatsHandler)))
+	mux.Handle("GET /api/v1/admin/warm-pool",
+		middleware.AuthMiddleware(http.HandlerFunc(adminController.GetWarmPoolStatusHandler)))
+	mux.Handle("GET /api/v1/admin/gateways",
+		middleware.AuthMiddleware(http.HandlerFunc(adminController.GetGatewayStatusHandler)))
 }